@@ -33,7 +33,8 @@ fi
 
 # Build Go binary with embedded files
 echo "Building Go binary..."
-go build -o vfio-usb-passthrough -ldflags="-s -w" .
+VERSION="${VERSION:-$(git describe --tags --always --dirty 2>/dev/null || echo dev)}"
+go build -o vfio-usb-passthrough -ldflags="-s -w -X main.version=${VERSION}" .
 
 # Make binary executable
 chmod +x vfio-usb-passthrough
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"runtime"
+	"runtime/debug"
+
+	"vfio_usb_passthrough/internals/handlers"
+	"vfio_usb_passthrough/internals/middleware"
+	"vfio_usb_passthrough/internals/rpc"
+)
+
+// version is the release version, set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// Capabilities describes the binary for tools and install scripts (--print-capabilities)
+type Capabilities struct {
+	Name      string         `json:"name"`
+	Version   string         `json:"version"`
+	Revision  string         `json:"revision,omitempty"`
+	GoVersion string         `json:"goVersion"`
+	Platform  string         `json:"platform"`
+	Features  map[string]any `json:"features"`
+	Defaults  map[string]any `json:"defaults"`
+	EnvVars   []string       `json:"envVars"`
+}
+
+// buildCapabilities describes this build: version, compiled-in features and default configuration
+func buildCapabilities() Capabilities {
+	return Capabilities{
+		Name:      "vfio_usb_passthrough",
+		Version:   version,
+		Revision:  buildRevision(),
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		Features: map[string]any{
+			// libvirt is driven through the virsh CLI, not the libvirt API
+			"libvirtBackend": "exec",
+			"tls":            false,
+			"sshHosts":       true,
+			"basicAuth":      true,
+			"redisRateLimit": true,
+			"mqtt":           true,
+			"webhooks":       true,
+			"auditLog":       true,
+			"metrics":        true,
+			// The gRPC server only starts when GRPC_PORT is set
+			"grpc": rpc.Enabled(),
+		},
+		Defaults: map[string]any{
+			"bindPort":        middleware.DefaultBindPort,
+			"exemptPaths":     middleware.DefaultExemptPaths,
+			"rateLimitMax":    apiRateLimitMax,
+			"rateLimitWindow": apiRateLimitWindow.String(),
+			"maxBodySize":     maxBodySize,
+			"shutdownTimeout": shutdownTimeout.String(),
+		},
+		EnvVars: handlers.ConfigEnvVars(),
+	}
+}
+
+// buildRevision returns the VCS commit stamped by the Go toolchain, or "" if there is none
+func buildRevision() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	return ""
+}
+
+// printCapabilities writes the capabilities document as JSON
+func printCapabilities(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(buildCapabilities())
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"runtime"
+	"slices"
+	"testing"
+
+	"vfio_usb_passthrough/internals/middleware"
+	"vfio_usb_passthrough/internals/rpc"
+)
+
+func TestPrintCapabilities(t *testing.T) {
+	var out bytes.Buffer
+	if err := printCapabilities(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	var capabilities Capabilities
+	if err := json.Unmarshal(out.Bytes(), &capabilities); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, out.String())
+	}
+
+	if capabilities.Name != "vfio_usb_passthrough" || capabilities.Version != version {
+		t.Errorf("name, version = %q, %q; want vfio_usb_passthrough, %q", capabilities.Name, capabilities.Version, version)
+	}
+	if capabilities.GoVersion != runtime.Version() || capabilities.Platform != runtime.GOOS+"/"+runtime.GOARCH {
+		t.Errorf("goVersion, platform = %q, %q", capabilities.GoVersion, capabilities.Platform)
+	}
+	if capabilities.Features["libvirtBackend"] != "exec" {
+		t.Errorf("libvirtBackend = %v, want exec", capabilities.Features["libvirtBackend"])
+	}
+	if port := capabilities.Defaults["bindPort"]; port != middleware.DefaultBindPort {
+		t.Errorf("default bindPort = %v, want %v", port, middleware.DefaultBindPort)
+	}
+	if !slices.Contains(capabilities.EnvVars, "ALLOWED_NETWORKS") {
+		t.Errorf("envVars = %v, want ALLOWED_NETWORKS listed", capabilities.EnvVars)
+	}
+}
+
+func TestCapabilitiesGRPC(t *testing.T) {
+	for _, port := range []string{"", "50051"} {
+		t.Setenv(rpc.GRPCPortEnv, port)
+		if got, want := buildCapabilities().Features["grpc"], port != ""; got != want {
+			t.Errorf("grpc feature with %s=%q = %v, want %v", rpc.GRPCPortEnv, port, got, want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrDeviceAliasNotFound is returned when a device alias does not exist
+var ErrDeviceAliasNotFound = errors.New("device alias not found")
+
+// DeviceAlias is a friendly name for a vendor:product pair (e.g. "yubikey" for 1050:0407)
+type DeviceAlias struct {
+	Name      string    `json:"name"`
+	VendorID  string    `json:"vendorId"`
+	ProductID string    `json:"productId"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// GetDeviceAliases returns all device aliases, by name
+func GetDeviceAliases() ([]DeviceAlias, error) {
+	return store.GetDeviceAliases()
+}
+
+// GetDeviceAliases returns all device aliases, by name
+func (s *sqlStore) GetDeviceAliases() ([]DeviceAlias, error) {
+	rows, err := s.query("SELECT name, vendor_id, product_id, created_at FROM device_aliases ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	aliases := []DeviceAlias{}
+	for rows.Next() {
+		var alias DeviceAlias
+		if err := rows.Scan(&alias.Name, &alias.VendorID, &alias.ProductID, &alias.CreatedAt); err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, alias)
+	}
+	return aliases, rows.Err()
+}
+
+// GetDeviceAlias returns a device alias by name, or ErrDeviceAliasNotFound
+func GetDeviceAlias(name string) (DeviceAlias, error) {
+	return store.GetDeviceAlias(name)
+}
+
+// GetDeviceAlias returns a device alias by name, or ErrDeviceAliasNotFound
+func (s *sqlStore) GetDeviceAlias(name string) (DeviceAlias, error) {
+	var alias DeviceAlias
+	err := s.queryRow(
+		"SELECT name, vendor_id, product_id, created_at FROM device_aliases WHERE name = ?",
+		name,
+	).Scan(&alias.Name, &alias.VendorID, &alias.ProductID, &alias.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DeviceAlias{}, ErrDeviceAliasNotFound
+	}
+	return alias, err
+}
+
+// SetDeviceAlias creates a device alias, or points an existing one to another device
+func SetDeviceAlias(name, vendorID, productID string) error {
+	return store.SetDeviceAlias(name, vendorID, productID)
+}
+
+// SetDeviceAlias creates a device alias, or points an existing one to another device
+func (s *sqlStore) SetDeviceAlias(name, vendorID, productID string) error {
+	_, err := s.execWithRetry(
+		`INSERT INTO device_aliases (name, vendor_id, product_id) VALUES (?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET vendor_id = excluded.vendor_id, product_id = excluded.product_id`,
+		name, vendorID, productID,
+	)
+	return err
+}
+
+// RemoveDeviceAlias removes a device alias, or returns ErrDeviceAliasNotFound
+func RemoveDeviceAlias(name string) error {
+	return store.RemoveDeviceAlias(name)
+}
+
+// RemoveDeviceAlias removes a device alias, or returns ErrDeviceAliasNotFound
+func (s *sqlStore) RemoveDeviceAlias(name string) error {
+	result, err := s.execWithRetry("DELETE FROM device_aliases WHERE name = ?", name)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrDeviceAliasNotFound
+	}
+	return nil
+}
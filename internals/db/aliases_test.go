@@ -0,0 +1,40 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDeviceAliases(t *testing.T) {
+	setupTestDB(t)
+
+	if _, err := GetDeviceAlias("yubikey"); !errors.Is(err, ErrDeviceAliasNotFound) {
+		t.Fatalf("GetDeviceAlias() error = %v, want ErrDeviceAliasNotFound", err)
+	}
+
+	if err := SetDeviceAlias("yubikey", "1050", "0406"); err != nil {
+		t.Fatal(err)
+	}
+	// Setting an existing alias points it to the new device
+	if err := SetDeviceAlias("yubikey", "1050", "0407"); err != nil {
+		t.Fatal(err)
+	}
+	alias, err := GetDeviceAlias("yubikey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alias.VendorID != "1050" || alias.ProductID != "0407" {
+		t.Errorf("alias = %s:%s, want 1050:0407", alias.VendorID, alias.ProductID)
+	}
+
+	if aliases, err := GetDeviceAliases(); err != nil || len(aliases) != 1 {
+		t.Fatalf("GetDeviceAliases() = %v, %v", aliases, err)
+	}
+
+	if err := RemoveDeviceAlias("yubikey"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveDeviceAlias("yubikey"); !errors.Is(err, ErrDeviceAliasNotFound) {
+		t.Errorf("RemoveDeviceAlias() error = %v, want ErrDeviceAliasNotFound", err)
+	}
+}
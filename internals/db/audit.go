@@ -0,0 +1,162 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// AuditRetentionDaysEnv sets how many days of audit log to keep (unset or 0 keeps everything)
+const AuditRetentionDaysEnv = "AUDIT_RETENTION_DAYS"
+
+// auditPruneInterval is how often old audit rows are pruned
+const auditPruneInterval = 1 * time.Hour
+
+// auditColumns are the audit_log columns read by scanAudit, in scan order
+const auditColumns = "id, created_at, action, vm_name, vendor_id, product_id, client, success, error"
+
+// AuditEntry is an attach or detach attempt recorded in the audit log
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Action    string    `json:"action"`
+	VMName    string    `json:"vmName"`
+	VendorID  string    `json:"vendorId"`
+	ProductID string    `json:"productId"`
+	Client    string    `json:"client"`
+	Success   bool      `json:"success"`
+	Error     string    `json:"error"`
+}
+
+// RecordAudit appends an entry to the audit log
+// Timestamps are stored in UTC so range queries compare consistently
+func RecordAudit(entry AuditEntry) error {
+	return store.RecordAudit(entry)
+}
+
+// RecordAudit appends an entry to the audit log
+func (s *sqlStore) RecordAudit(entry AuditEntry) error {
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+	_, err := s.execWithRetry(
+		`INSERT INTO audit_log (created_at, action, vm_name, vendor_id, product_id, client, success, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.Timestamp.UTC(), entry.Action, entry.VMName, entry.VendorID, entry.ProductID, entry.Client, entry.Success, entry.Error,
+	)
+	return err
+}
+
+// IterateAudit calls fn for each audit entry in [from, to), oldest first
+// Zero from/to leave the range open. Rows are streamed, not loaded at once;
+// iteration stops at the first error returned by fn
+func IterateAudit(from, to time.Time, fn func(AuditEntry) error) error {
+	return store.IterateAudit(from, to, fn)
+}
+
+// IterateAudit calls fn for each audit entry in [from, to), oldest first
+func (s *sqlStore) IterateAudit(from, to time.Time, fn func(AuditEntry) error) error {
+	query := "SELECT " + auditColumns + " FROM audit_log WHERE 1 = 1"
+	var args []any
+	if !from.IsZero() {
+		query += " AND created_at >= ?"
+		args = append(args, from.UTC())
+	}
+	if !to.IsZero() {
+		query += " AND created_at < ?"
+		args = append(args, to.UTC())
+	}
+	query += " ORDER BY created_at, id"
+
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	return scanAudit(rows, fn)
+}
+
+// GetDeviceAudit returns the most recent audit entries of a device across VMs, newest first
+func GetDeviceAudit(vendorID, productID string, limit int) ([]AuditEntry, error) {
+	return store.GetDeviceAudit(vendorID, productID, limit)
+}
+
+// GetDeviceAudit returns the most recent audit entries of a device, newest first
+func (s *sqlStore) GetDeviceAudit(vendorID, productID string, limit int) ([]AuditEntry, error) {
+	rows, err := s.query(
+		"SELECT "+auditColumns+` FROM audit_log
+		WHERE vendor_id = ? AND product_id = ? ORDER BY created_at DESC, id DESC LIMIT ?`,
+		vendorID, productID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	err = scanAudit(rows, func(entry AuditEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// scanAudit calls fn for each row of an audit_log query selecting auditColumns,
+// stopping at the first scan error or error returned by fn
+func scanAudit(rows *sql.Rows, fn func(AuditEntry) error) error {
+	for rows.Next() {
+		var entry AuditEntry
+		err := rows.Scan(&entry.ID, &entry.Timestamp, &entry.Action, &entry.VMName, &entry.VendorID, &entry.ProductID, &entry.Client, &entry.Success, &entry.Error)
+		if err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// PruneAudit deletes audit entries recorded before the given time and returns how many were deleted
+func PruneAudit(before time.Time) (int64, error) {
+	return store.PruneAudit(before)
+}
+
+// PruneAudit deletes audit entries recorded before the given time
+func (s *sqlStore) PruneAudit(before time.Time) (int64, error) {
+	result, err := s.execWithRetry("DELETE FROM audit_log WHERE created_at < ?", before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// StartAuditPruner deletes audit entries older than retentionDays at startup and then every hour
+// Does nothing when retentionDays is 0
+func StartAuditPruner(retentionDays int) {
+	if retentionDays <= 0 {
+		return
+	}
+
+	log.Printf("Pruning audit log entries older than %d day(s)", retentionDays)
+	prune := func() {
+		pruned, err := PruneAudit(time.Now().AddDate(0, 0, -retentionDays))
+		if err != nil {
+			log.Printf("Error pruning audit log: %v", err)
+			return
+		}
+		log.Printf("Pruned %d audit log entries", pruned)
+	}
+
+	go func() {
+		prune()
+		ticker := time.NewTicker(auditPruneInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			prune()
+		}
+	}()
+}
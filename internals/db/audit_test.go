@@ -0,0 +1,83 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIterateAuditRange(t *testing.T) {
+	setupTestDB(t)
+
+	base := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		err := RecordAudit(AuditEntry{
+			Timestamp: base.AddDate(0, 0, i),
+			Action:    "attach",
+			VMName:    "win11",
+			VendorID:  "046d",
+			ProductID: "c52b",
+			Success:   true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     int
+	}{
+		{"unbounded", time.Time{}, time.Time{}, 3},
+		{"from", base.AddDate(0, 0, 1), time.Time{}, 2},
+		{"to is exclusive", time.Time{}, base.AddDate(0, 0, 1), 1},
+		{"non-UTC bounds", base.In(time.FixedZone("CET", 3600)), base.AddDate(0, 0, 2), 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var entries []AuditEntry
+			err := IterateAudit(tt.from, tt.to, func(entry AuditEntry) error {
+				entries = append(entries, entry)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != tt.want {
+				t.Fatalf("got %d entries, want %d", len(entries), tt.want)
+			}
+			if !entries[0].Timestamp.Equal(tt.from) && !tt.from.IsZero() {
+				t.Errorf("first timestamp = %v, want %v", entries[0].Timestamp, tt.from)
+			}
+		})
+	}
+}
+
+func TestPruneAudit(t *testing.T) {
+	setupTestDB(t)
+
+	now := time.Now()
+	for _, age := range []int{-40, -31, -1} {
+		if err := RecordAudit(AuditEntry{Timestamp: now.AddDate(0, 0, age), Action: "detach", VMName: "win11", VendorID: "046d", ProductID: "c52b", Success: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pruned, err := PruneAudit(now.AddDate(0, 0, -30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 2 {
+		t.Errorf("pruned = %d, want 2", pruned)
+	}
+
+	var remaining int
+	IterateAudit(time.Time{}, time.Time{}, func(AuditEntry) error {
+		remaining++
+		return nil
+	})
+	if remaining != 1 {
+		t.Errorf("remaining = %d, want 1", remaining)
+	}
+}
@@ -2,65 +2,81 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"log"
 	"os"
-	"path/filepath"
-
-	_ "github.com/mattn/go-sqlite3"
+	"time"
 )
 
+// DB is the database opened by InitDB
 var DB *sql.DB
 
+// DesiredDevice is a device that should stay attached to a VM
+type DesiredDevice struct {
+	VendorID  string `json:"vendorId"`
+	ProductID string `json:"productId"`
+}
+
+// Attachment is a device attached to a VM through this tool
+// Host is the name of the libvirt connection of the VM, "" for the default one
+type Attachment struct {
+	Host       string    `json:"host"`
+	VMName     string    `json:"vmName"`
+	VendorID   string    `json:"vendorId"`
+	ProductID  string    `json:"productId"`
+	Client     string    `json:"client"`
+	AttachedAt time.Time `json:"attachedAt"`
+}
+
+// ErrFavoriteNotFound is returned when updating a favorite that does not exist
+var ErrFavoriteNotFound = errors.New("favorite not found")
+
 // FavoriteDevice represents a favorite USB device
 type FavoriteDevice struct {
 	ID          int    `json:"id"`
 	VendorID    string `json:"vendorId"`
 	ProductID   string `json:"productId"`
 	Description string `json:"description"`
+	Notes       string `json:"notes"`
 }
 
-// InitDB initializes the SQLite database
+// InitDB opens the database selected by DATABASE_URL (local SQLite file by default) and applies pending migrations
 func InitDB() error {
-	// Create data directory if it doesn't exist
-	dataDir := "./data"
-	if _, err := os.Stat(dataDir); os.IsNotExist(err) {
-		err := os.MkdirAll(dataDir, 0755)
-		if err != nil {
-			return err
-		}
-	}
-
-	dbPath := filepath.Join(dataDir, "favorites.db")
-	var err error
-	DB, err = sql.Open("sqlite3", dbPath)
+	s, err := openStore(os.Getenv(DatabaseURLEnv))
 	if err != nil {
 		return err
 	}
+	store = s
+	DB = s.db
+	favoritesCacheEnabled = !s.dialect.shared
+	invalidateFavoritesCache()
 
-	// Create favorites table if it doesn't exist
-	createTableSQL := `
-	CREATE TABLE IF NOT EXISTS favorites (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		vendor_id TEXT NOT NULL,
-		product_id TEXT NOT NULL,
-		description TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		UNIQUE(vendor_id, product_id)
-	);
-	`
+	log.Printf("Database initialized successfully (%s)", s.dialect.name)
+	return nil
+}
 
-	_, err = DB.Exec(createTableSQL)
-	if err != nil {
-		return err
+// GetAllFavorites returns all favorite devices, served from memory until the next favorites write
+// (read from the database every time when it is shared with other instances)
+func GetAllFavorites() ([]FavoriteDevice, error) {
+	if !favoritesCacheEnabled {
+		return store.GetAllFavorites()
+	}
+	if favorites, ok := getCachedFavorites(); ok {
+		return favorites, nil
 	}
 
-	log.Println("Database initialized successfully")
-	return nil
+	generation := favoritesCacheGeneration()
+	favorites, err := store.GetAllFavorites()
+	if err != nil {
+		return nil, err
+	}
+	storeCachedFavorites(favorites, generation)
+	return favorites, nil
 }
 
-// GetAllFavorites returns all favorite devices
-func GetAllFavorites() ([]FavoriteDevice, error) {
-	rows, err := DB.Query("SELECT id, vendor_id, product_id, description FROM favorites ORDER BY created_at DESC")
+// GetAllFavorites reads all favorites from the database
+func (s *sqlStore) GetAllFavorites() ([]FavoriteDevice, error) {
+	rows, err := s.query("SELECT id, vendor_id, product_id, COALESCE(description, ''), notes FROM favorites ORDER BY created_at DESC")
 	if err != nil {
 		return nil, err
 	}
@@ -69,7 +85,7 @@ func GetAllFavorites() ([]FavoriteDevice, error) {
 	var favorites []FavoriteDevice
 	for rows.Next() {
 		var fav FavoriteDevice
-		err := rows.Scan(&fav.ID, &fav.VendorID, &fav.ProductID, &fav.Description)
+		err := rows.Scan(&fav.ID, &fav.VendorID, &fav.ProductID, &fav.Description, &fav.Notes)
 		if err != nil {
 			return nil, err
 		}
@@ -80,17 +96,145 @@ func GetAllFavorites() ([]FavoriteDevice, error) {
 }
 
 // AddFavorite adds a device to favorites
-func AddFavorite(vendorID, productID, description string) error {
-	_, err := DB.Exec(
-		"INSERT OR REPLACE INTO favorites (vendor_id, product_id, description) VALUES (?, ?, ?)",
+// Re-adding an existing favorite updates its description and keeps its notes unless new notes are given
+func AddFavorite(vendorID, productID, description, notes string) error {
+	err := store.AddFavorite(vendorID, productID, description, notes)
+	invalidateFavoritesCache()
+	return err
+}
+
+// AddFavorite adds or updates a favorite
+func (s *sqlStore) AddFavorite(vendorID, productID, description, notes string) error {
+	_, err := s.execWithRetry(
+		`INSERT INTO favorites (vendor_id, product_id, description, notes) VALUES (?, ?, ?, ?)
+		ON CONFLICT(vendor_id, product_id) DO UPDATE SET
+			description = excluded.description,
+			notes = CASE WHEN excluded.notes = '' THEN favorites.notes ELSE excluded.notes END`,
+		vendorID, productID, description, notes,
+	)
+	return err
+}
+
+// EnsureFavorite creates a favorite with a description, or sets the description of an existing one
+// (its notes are kept); created reports which happened
+func EnsureFavorite(vendorID, productID, description string) (created bool, err error) {
+	created, err = store.EnsureFavorite(vendorID, productID, description)
+	invalidateFavoritesCache()
+	return created, err
+}
+
+// EnsureFavorite creates or updates a favorite, reporting whether it was created
+func (s *sqlStore) EnsureFavorite(vendorID, productID, description string) (bool, error) {
+	var created bool
+	err := withRetry(func() error {
+		var err error
+		created, err = s.ensureFavorite(vendorID, productID, description)
+		return err
+	})
+	return created, err
+}
+
+// ensureFavorite inserts the favorite or, if it already exists, updates it in a single transaction
+// The insert is the existence check, so a concurrent ensure cannot make it fail on the unique key
+func (s *sqlStore) ensureFavorite(vendorID, productID, description string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		s.rebind("INSERT INTO favorites (vendor_id, product_id, description, notes) VALUES (?, ?, ?, '') ON CONFLICT(vendor_id, product_id) DO NOTHING"),
 		vendorID, productID, description,
 	)
+	if err != nil {
+		return false, err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if inserted == 0 {
+		_, err := tx.Exec(
+			s.rebind("UPDATE favorites SET description = ? WHERE vendor_id = ? AND product_id = ?"),
+			description, vendorID, productID,
+		)
+		if err != nil {
+			return false, err
+		}
+	}
+	return inserted > 0, tx.Commit()
+}
+
+// UpdateFavoriteDescription updates the description of an existing favorite
+func UpdateFavoriteDescription(vendorID, productID, description string) error {
+	return UpdateFavorite(vendorID, productID, &description, nil)
+}
+
+// UpdateFavorite updates the description and/or notes of an existing favorite
+// Nil fields are left unchanged
+func UpdateFavorite(vendorID, productID string, description, notes *string) error {
+	err := store.UpdateFavorite(vendorID, productID, description, notes)
+	invalidateFavoritesCache()
 	return err
 }
 
+// UpdateFavorite updates a favorite, or returns ErrFavoriteNotFound
+func (s *sqlStore) UpdateFavorite(vendorID, productID string, description, notes *string) error {
+	result, err := s.execWithRetry(
+		"UPDATE favorites SET description = COALESCE(?, description), notes = COALESCE(?, notes) WHERE vendor_id = ? AND product_id = ?",
+		description, notes, vendorID, productID,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrFavoriteNotFound
+	}
+	return nil
+}
+
+// FillFavoriteDescription sets the description of a favorite that has none
+// Returns false if the favorite does not exist or already has a description
+func FillFavoriteDescription(vendorID, productID, description string) (bool, error) {
+	filled, err := store.FillFavoriteDescription(vendorID, productID, description)
+	invalidateFavoritesCache()
+	return filled, err
+}
+
+// FillFavoriteDescription sets the description of a favorite that has none
+func (s *sqlStore) FillFavoriteDescription(vendorID, productID, description string) (bool, error) {
+	result, err := s.execWithRetry(
+		"UPDATE favorites SET description = ? WHERE vendor_id = ? AND product_id = ? AND COALESCE(description, '') = ''",
+		description, vendorID, productID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
 // RemoveFavorite removes a device from favorites
 func RemoveFavorite(vendorID, productID string) error {
-	_, err := DB.Exec(
+	err := store.RemoveFavorite(vendorID, productID)
+	invalidateFavoritesCache()
+	return err
+}
+
+// RemoveFavorite removes a favorite
+func (s *sqlStore) RemoveFavorite(vendorID, productID string) error {
+	_, err := s.execWithRetry(
 		"DELETE FROM favorites WHERE vendor_id = ? AND product_id = ?",
 		vendorID, productID,
 	)
@@ -99,8 +243,13 @@ func RemoveFavorite(vendorID, productID string) error {
 
 // IsFavorite checks if a device is in favorites
 func IsFavorite(vendorID, productID string) (bool, error) {
+	return store.IsFavorite(vendorID, productID)
+}
+
+// IsFavorite checks if a device is in favorites
+func (s *sqlStore) IsFavorite(vendorID, productID string) (bool, error) {
 	var count int
-	err := DB.QueryRow(
+	err := s.queryRow(
 		"SELECT COUNT(*) FROM favorites WHERE vendor_id = ? AND product_id = ?",
 		vendorID, productID,
 	).Scan(&count)
@@ -110,3 +259,163 @@ func IsFavorite(vendorID, productID string) (bool, error) {
 	return count > 0, nil
 }
 
+// GetDesiredDevices returns the declared device set of a VM
+// Desired-state and attachment rows are keyed by host, the name of the libvirt connection of the
+// VM ("" for the default one), since VMs on different connections may share a name
+func GetDesiredDevices(host, vmName string) ([]DesiredDevice, error) {
+	return store.GetDesiredDevices(host, vmName)
+}
+
+// GetDesiredDevices returns the declared device set of a VM, in declaration order
+func (s *sqlStore) GetDesiredDevices(host, vmName string) ([]DesiredDevice, error) {
+	rows, err := s.query("SELECT vendor_id, product_id FROM desired_devices WHERE host = ? AND vm_name = ? ORDER BY created_at, "+s.dialect.insertionOrder, host, vmName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var devices []DesiredDevice
+	for rows.Next() {
+		var device DesiredDevice
+		if err := rows.Scan(&device.VendorID, &device.ProductID); err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+
+	return devices, rows.Err()
+}
+
+// GetAllDesiredDevices returns the declared device sets of all VMs of a host, keyed by VM name
+func GetAllDesiredDevices(host string) (map[string][]DesiredDevice, error) {
+	return store.GetAllDesiredDevices(host)
+}
+
+// GetAllDesiredDevices returns the declared device sets of all VMs of a host, keyed by VM name
+func (s *sqlStore) GetAllDesiredDevices(host string) (map[string][]DesiredDevice, error) {
+	rows, err := s.query("SELECT vm_name, vendor_id, product_id FROM desired_devices WHERE host = ? ORDER BY vm_name, created_at, "+s.dialect.insertionOrder, host)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	desired := make(map[string][]DesiredDevice)
+	for rows.Next() {
+		var vmName string
+		var device DesiredDevice
+		if err := rows.Scan(&vmName, &device.VendorID, &device.ProductID); err != nil {
+			return nil, err
+		}
+		desired[vmName] = append(desired[vmName], device)
+	}
+
+	return desired, rows.Err()
+}
+
+// SetDesiredDevices replaces the declared device set of a VM
+func SetDesiredDevices(host, vmName string, devices []DesiredDevice) error {
+	return store.SetDesiredDevices(host, vmName, devices)
+}
+
+// SetDesiredDevices replaces the declared device set of a VM
+func (s *sqlStore) SetDesiredDevices(host, vmName string, devices []DesiredDevice) error {
+	return withRetry(func() error {
+		return s.setDesiredDevices(host, vmName, devices)
+	})
+}
+
+// setDesiredDevices replaces the declared device set of a VM in a single transaction
+func (s *sqlStore) setDesiredDevices(host, vmName string, devices []DesiredDevice) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(s.rebind("DELETE FROM desired_devices WHERE host = ? AND vm_name = ?"), host, vmName); err != nil {
+		return err
+	}
+
+	for _, device := range devices {
+		_, err := tx.Exec(
+			s.rebind("INSERT INTO desired_devices (host, vm_name, vendor_id, product_id) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING"),
+			host, vmName, device.VendorID, device.ProductID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ClearDesiredDevices removes the declared device set of a VM
+func ClearDesiredDevices(host, vmName string) error {
+	return store.ClearDesiredDevices(host, vmName)
+}
+
+// ClearDesiredDevices removes the declared device set of a VM
+func (s *sqlStore) ClearDesiredDevices(host, vmName string) error {
+	_, err := s.execWithRetry("DELETE FROM desired_devices WHERE host = ? AND vm_name = ?", host, vmName)
+	return err
+}
+
+// RecordAttachment records a device attached to a VM through this tool
+func RecordAttachment(host, vmName, vendorID, productID, client string) error {
+	return store.RecordAttachment(host, vmName, vendorID, productID, client)
+}
+
+// RecordAttachment records a device attached to a VM through this tool
+func (s *sqlStore) RecordAttachment(host, vmName, vendorID, productID, client string) error {
+	_, err := s.execWithRetry(
+		`INSERT INTO attachments (host, vm_name, vendor_id, product_id, client) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(host, vm_name, vendor_id, product_id) DO UPDATE SET
+			client = excluded.client,
+			attached_at = CURRENT_TIMESTAMP`,
+		host, vmName, vendorID, productID, client,
+	)
+	return err
+}
+
+// RemoveAttachment forgets a device detached from a VM
+func RemoveAttachment(host, vmName, vendorID, productID string) error {
+	return store.RemoveAttachment(host, vmName, vendorID, productID)
+}
+
+// RemoveAttachment forgets a device detached from a VM
+func (s *sqlStore) RemoveAttachment(host, vmName, vendorID, productID string) error {
+	_, err := s.execWithRetry(
+		"DELETE FROM attachments WHERE host = ? AND vm_name = ? AND vendor_id = ? AND product_id = ?",
+		host, vmName, vendorID, productID,
+	)
+	return err
+}
+
+// GetAttachments returns the devices attached to a VM through this tool
+func GetAttachments(host, vmName string) ([]Attachment, error) {
+	return store.GetAttachments(host, vmName)
+}
+
+// GetAttachments returns the devices attached to a VM through this tool
+func (s *sqlStore) GetAttachments(host, vmName string) ([]Attachment, error) {
+	rows, err := s.query(
+		"SELECT host, vm_name, vendor_id, product_id, client, attached_at FROM attachments WHERE host = ? AND vm_name = ? ORDER BY attached_at",
+		host, vmName,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attachments []Attachment
+	for rows.Next() {
+		var attachment Attachment
+		err := rows.Scan(&attachment.Host, &attachment.VMName, &attachment.VendorID, &attachment.ProductID, &attachment.Client, &attachment.AttachedAt)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	return attachments, rows.Err()
+}
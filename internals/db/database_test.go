@@ -0,0 +1,50 @@
+package db
+
+import "testing"
+
+func TestRowsScopedByHost(t *testing.T) {
+	setupTestDB(t)
+
+	// The same VM name on two connections
+	if err := SetDesiredDevices("", "win11", []DesiredDevice{{VendorID: "046d", ProductID: "c52b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetDesiredDevices("lab", "win11", []DesiredDevice{{VendorID: "0781", ProductID: "5583"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordAttachment("", "win11", "046d", "c52b", "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordAttachment("lab", "win11", "046d", "c52b", "10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ClearDesiredDevices("lab", "win11"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveAttachment("lab", "win11", "046d", "c52b"); err != nil {
+		t.Fatal(err)
+	}
+
+	local, err := GetAllDesiredDevices("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(local["win11"]) != 1 || local["win11"][0].VendorID != "046d" {
+		t.Errorf("desired devices of the default host = %+v, want 046d:c52b for win11", local)
+	}
+	if lab, err := GetDesiredDevices("lab", "win11"); err != nil || len(lab) != 0 {
+		t.Errorf("desired devices of lab = %+v, %v; want none", lab, err)
+	}
+
+	attachments, err := GetAttachments("", "win11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 1 || attachments[0].Client != "127.0.0.1" {
+		t.Errorf("attachments of the default host = %+v, want the one from 127.0.0.1", attachments)
+	}
+	if lab, err := GetAttachments("lab", "win11"); err != nil || len(lab) != 0 {
+		t.Errorf("attachments of lab = %+v, %v; want none", lab, err)
+	}
+}
@@ -0,0 +1,61 @@
+package db
+
+import "sync"
+
+// favoritesCacheEnabled is false when the database is shared, as other instances may write favorites
+var favoritesCacheEnabled = true
+
+// favoritesCache keeps the favorites list in memory between writes
+// The generation is bumped on every invalidation so a read that raced a write
+// never stores its (possibly stale) result
+var favoritesCache struct {
+	mu         sync.RWMutex
+	favorites  []FavoriteDevice
+	valid      bool
+	generation uint64
+}
+
+// getCachedFavorites returns a copy of the cached favorites, if any
+func getCachedFavorites() ([]FavoriteDevice, bool) {
+	favoritesCache.mu.RLock()
+	defer favoritesCache.mu.RUnlock()
+	if !favoritesCache.valid {
+		return nil, false
+	}
+	return cloneFavorites(favoritesCache.favorites), true
+}
+
+// favoritesCacheGeneration returns the current cache generation
+func favoritesCacheGeneration() uint64 {
+	favoritesCache.mu.RLock()
+	defer favoritesCache.mu.RUnlock()
+	return favoritesCache.generation
+}
+
+// storeCachedFavorites caches favorites read at the given generation, unless a write happened since
+func storeCachedFavorites(favorites []FavoriteDevice, generation uint64) {
+	favoritesCache.mu.Lock()
+	defer favoritesCache.mu.Unlock()
+	if favoritesCache.generation != generation {
+		return
+	}
+	favoritesCache.favorites = cloneFavorites(favorites)
+	favoritesCache.valid = true
+}
+
+// invalidateFavoritesCache drops the cached favorites; called after every favorites write
+func invalidateFavoritesCache() {
+	favoritesCache.mu.Lock()
+	defer favoritesCache.mu.Unlock()
+	favoritesCache.favorites = nil
+	favoritesCache.valid = false
+	favoritesCache.generation++
+}
+
+// cloneFavorites copies a favorites slice so callers cannot modify the cache
+func cloneFavorites(favorites []FavoriteDevice) []FavoriteDevice {
+	if favorites == nil {
+		return nil
+	}
+	return append([]FavoriteDevice(nil), favorites...)
+}
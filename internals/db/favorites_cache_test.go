@@ -0,0 +1,116 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+)
+
+// setupTestDB initializes a fresh database in a temporary directory
+func setupTestDB(tb testing.TB) {
+	tb.Helper()
+	tb.Setenv(DatabaseURLEnv, "")
+	tb.Chdir(tb.TempDir())
+	if err := InitDB(); err != nil {
+		tb.Fatal(err)
+	}
+	invalidateFavoritesCache()
+	tb.Cleanup(func() { DB.Close() })
+}
+
+func TestGetAllFavoritesCacheInvalidation(t *testing.T) {
+	setupTestDB(t)
+
+	if err := AddFavorite("046d", "c52b", "Receiver", ""); err != nil {
+		t.Fatal(err)
+	}
+	favorites, err := GetAllFavorites()
+	if err != nil || len(favorites) != 1 {
+		t.Fatalf("GetAllFavorites() = %v, %v; want 1 favorite", favorites, err)
+	}
+
+	// Callers must not be able to modify the cache
+	favorites[0].Description = "changed"
+	favorites, _ = GetAllFavorites()
+	if favorites[0].Description != "Receiver" {
+		t.Errorf("cached description = %q, want %q", favorites[0].Description, "Receiver")
+	}
+
+	description := "Unifying Receiver"
+	if err := UpdateFavorite("046d", "c52b", &description, nil); err != nil {
+		t.Fatal(err)
+	}
+	favorites, _ = GetAllFavorites()
+	if favorites[0].Description != description {
+		t.Errorf("description after update = %q, want %q", favorites[0].Description, description)
+	}
+
+	if err := RemoveFavorite("046d", "c52b"); err != nil {
+		t.Fatal(err)
+	}
+	favorites, _ = GetAllFavorites()
+	if len(favorites) != 0 {
+		t.Errorf("favorites after remove = %v, want none", favorites)
+	}
+}
+
+// addBenchmarkFavorites fills the favorites table with n devices
+func addBenchmarkFavorites(b *testing.B, n int) {
+	b.Helper()
+	for i := 0; i < n; i++ {
+		if err := AddFavorite(fmt.Sprintf("%04x", i), "0001", fmt.Sprintf("Device %d", i), ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkGetAllFavorites compares cached and uncached reads under parallel load,
+// as produced by clients polling /api/devices-state
+func BenchmarkGetAllFavorites(b *testing.B) {
+	setupTestDB(b)
+	addBenchmarkFavorites(b, 50)
+
+	b.Run("cached", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := GetAllFavorites(); err != nil {
+					b.Error(err)
+				}
+			}
+		})
+	})
+
+	b.Run("uncached", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := store.GetAllFavorites(); err != nil {
+					b.Error(err)
+				}
+			}
+		})
+	})
+}
+
+func TestEnsureFavorite(t *testing.T) {
+	setupTestDB(t)
+
+	created, err := EnsureFavorite("046d", "c52b", "Receiver")
+	if err != nil || !created {
+		t.Fatalf("EnsureFavorite() = %v, %v; want created", created, err)
+	}
+	notes := "desk"
+	if err := UpdateFavorite("046d", "c52b", nil, &notes); err != nil {
+		t.Fatal(err)
+	}
+
+	created, err = EnsureFavorite("046d", "c52b", "Unifying Receiver")
+	if err != nil || created {
+		t.Fatalf("EnsureFavorite() again = %v, %v; want updated", created, err)
+	}
+	favorites, err := GetAllFavorites()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(favorites) != 1 || favorites[0].Description != "Unifying Receiver" || favorites[0].Notes != "desk" {
+		t.Errorf("favorites = %+v, want one with the new description and the notes kept", favorites)
+	}
+}
@@ -0,0 +1,228 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// migration is a schema change, applied once and recorded in schema_migrations
+// up must be idempotent: databases created before schema versioning already have some of the
+// tables, and apply every migration on their first start
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx, d dialect) error
+}
+
+// migrations are applied in order; append new ones, never edit or reorder applied ones
+var migrations = []migration{
+	{1, "create favorites", func(tx *sql.Tx, d dialect) error {
+		return execAll(tx, `CREATE TABLE IF NOT EXISTS favorites (
+			id `+d.autoIncrementKey+`,
+			vendor_id TEXT NOT NULL,
+			product_id TEXT NOT NULL,
+			description TEXT,
+			created_at `+d.timestamp+` DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(vendor_id, product_id)
+		)`)
+	}},
+	{2, "create desired_devices", func(tx *sql.Tx, d dialect) error {
+		return execAll(tx, `CREATE TABLE IF NOT EXISTS desired_devices (
+			`+d.insertionOrderColumn+`
+			vm_name TEXT NOT NULL,
+			vendor_id TEXT NOT NULL,
+			product_id TEXT NOT NULL,
+			created_at `+d.timestamp+` DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(vm_name, vendor_id, product_id)
+		)`)
+	}},
+	{3, "create attachments", func(tx *sql.Tx, d dialect) error {
+		return execAll(tx, `CREATE TABLE IF NOT EXISTS attachments (
+			vm_name TEXT NOT NULL,
+			vendor_id TEXT NOT NULL,
+			product_id TEXT NOT NULL,
+			client TEXT NOT NULL DEFAULT '',
+			attached_at `+d.timestamp+` DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(vm_name, vendor_id, product_id)
+		)`)
+	}},
+	{4, "create audit_log", func(tx *sql.Tx, d dialect) error {
+		return execAll(tx,
+			`CREATE TABLE IF NOT EXISTS audit_log (
+				id `+d.autoIncrementKey+`,
+				created_at `+d.timestamp+` NOT NULL,
+				action TEXT NOT NULL,
+				vm_name TEXT NOT NULL,
+				vendor_id TEXT NOT NULL,
+				product_id TEXT NOT NULL,
+				client TEXT NOT NULL DEFAULT '',
+				success `+d.boolean+` NOT NULL,
+				error TEXT NOT NULL DEFAULT ''
+			)`,
+			"CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log (created_at)",
+			"CREATE INDEX IF NOT EXISTS idx_audit_log_device ON audit_log (vendor_id, product_id)",
+		)
+	}},
+	{5, "add favorites.notes", func(tx *sql.Tx, d dialect) error {
+		return addColumnIfMissing(tx, d, "favorites", "notes", "TEXT NOT NULL DEFAULT ''")
+	}},
+	{6, "create device_aliases", func(tx *sql.Tx, d dialect) error {
+		return execAll(tx, `CREATE TABLE IF NOT EXISTS device_aliases (
+			name TEXT PRIMARY KEY,
+			vendor_id TEXT NOT NULL,
+			product_id TEXT NOT NULL,
+			created_at `+d.timestamp+` DEFAULT CURRENT_TIMESTAMP
+		)`)
+	}},
+	{7, "create vm_groups", func(tx *sql.Tx, d dialect) error {
+		return execAll(tx, `CREATE TABLE IF NOT EXISTS vm_groups (
+			`+d.insertionOrderColumn+`
+			group_name TEXT NOT NULL,
+			vm_name TEXT NOT NULL,
+			created_at `+d.timestamp+` DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(group_name, vm_name)
+		)`)
+	}},
+	{8, "add host to desired_devices and attachments", func(tx *sql.Tx, d dialect) error {
+		// Rows recorded before get the default connection ('')
+		err := recreateTable(tx, "desired_devices", `
+			`+d.insertionOrderColumn+`
+			host TEXT NOT NULL DEFAULT '',
+			vm_name TEXT NOT NULL,
+			vendor_id TEXT NOT NULL,
+			product_id TEXT NOT NULL,
+			created_at `+d.timestamp+` DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(host, vm_name, vendor_id, product_id)`,
+			"vm_name, vendor_id, product_id, created_at", "created_at, "+d.insertionOrder)
+		if err != nil {
+			return err
+		}
+		return recreateTable(tx, "attachments", `
+			host TEXT NOT NULL DEFAULT '',
+			vm_name TEXT NOT NULL,
+			vendor_id TEXT NOT NULL,
+			product_id TEXT NOT NULL,
+			client TEXT NOT NULL DEFAULT '',
+			attached_at `+d.timestamp+` DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(host, vm_name, vendor_id, product_id)`,
+			"vm_name, vendor_id, product_id, client, attached_at", "attached_at")
+	}},
+}
+
+// migrate applies the migrations not recorded in schema_migrations, each in its own transaction
+func (s *sqlStore) migrate() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at ` + s.dialect.timestamp + ` DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		applied, err := s.applyMigration(m)
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+		}
+		if applied {
+			log.Printf("Applied database migration %d (%s)", m.version, m.name)
+		}
+	}
+	return nil
+}
+
+// applyMigration applies a migration unless it is already recorded; returns whether it was applied
+func (s *sqlStore) applyMigration(m migration) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Instances sharing a database wait for each other instead of applying a migration twice
+	if s.dialect.migrationLock != "" {
+		if _, err := tx.Exec(s.dialect.migrationLock); err != nil {
+			return false, err
+		}
+	}
+
+	var count int
+	if err := tx.QueryRow(s.rebind("SELECT COUNT(*) FROM schema_migrations WHERE version = ?"), m.version).Scan(&count); err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return false, nil
+	}
+
+	if err := m.up(tx, s.dialect); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(s.rebind("INSERT INTO schema_migrations (version, name) VALUES (?, ?)"), m.version, m.name); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// schemaVersion returns the highest applied migration (0 for an empty database)
+func (s *sqlStore) schemaVersion() (int, error) {
+	var version sql.NullInt64
+	err := s.db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	return int(version.Int64), err
+}
+
+// execAll executes statements in order, stopping at the first error
+func execAll(tx *sql.Tx, statements ...string) error {
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recreateTable replaces a table by one with the given column definitions, copying columns over in orderBy order
+// Used for changes SQLite cannot make in place, such as changing a UNIQUE constraint
+func recreateTable(tx *sql.Tx, table, definitions, columns, orderBy string) error {
+	return execAll(tx,
+		"CREATE TABLE "+table+"_new ("+definitions+")",
+		"INSERT INTO "+table+"_new ("+columns+") SELECT "+columns+" FROM "+table+" ORDER BY "+orderBy,
+		"DROP TABLE "+table,
+		"ALTER TABLE "+table+"_new RENAME TO "+table,
+	)
+}
+
+// addColumnIfMissing adds a column to a table if it does not exist yet
+func addColumnIfMissing(tx *sql.Tx, d dialect, table, column, definition string) error {
+	if d.name != sqliteDialect.name {
+		_, err := tx.Exec("ALTER TABLE " + table + " ADD COLUMN IF NOT EXISTS " + column + " " + definition)
+		return err
+	}
+
+	// SQLite has no ADD COLUMN IF NOT EXISTS
+	rows, err := tx.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = tx.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// v1Schema is the database created by the first release: favorites only, without notes
+const v1Schema = `
+CREATE TABLE favorites (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	vendor_id TEXT NOT NULL,
+	product_id TEXT NOT NULL,
+	description TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE(vendor_id, product_id)
+);
+INSERT INTO favorites (vendor_id, product_id, description) VALUES ('046d', 'c52b', 'Unifying Receiver');
+`
+
+func TestMigrateV1Database(t *testing.T) {
+	t.Setenv(DatabaseURLEnv, "")
+	t.Chdir(t.TempDir())
+
+	if err := os.Mkdir("data", 0755); err != nil {
+		t.Fatal(err)
+	}
+	v1, err := sql.Open("sqlite3", filepath.Join("data", "favorites.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v1.Exec(v1Schema); err != nil {
+		t.Fatal(err)
+	}
+	v1.Close()
+
+	if err := InitDB(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { DB.Close() })
+	invalidateFavoritesCache()
+
+	s := store.(*sqlStore)
+	version, err := s.schemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := migrations[len(migrations)-1].version; version != want {
+		t.Errorf("schema version = %d, want %d", version, want)
+	}
+
+	// Existing favorites are kept and gain the notes column
+	favorites, err := GetAllFavorites()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(favorites) != 1 || favorites[0].Description != "Unifying Receiver" || favorites[0].Notes != "" {
+		t.Fatalf("favorites after migration = %+v", favorites)
+	}
+	notes := "desk"
+	if err := UpdateFavorite("046d", "c52b", nil, &notes); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tables added after v1 are usable
+	if err := SetDesiredDevices("", "win11", []DesiredDevice{{VendorID: "046d", ProductID: "c52b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordAudit(AuditEntry{Action: "attach", VMName: "win11", VendorID: "046d", ProductID: "c52b", Success: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetDeviceAlias("receiver", "046d", "c52b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Migrating again applies nothing
+	var applied int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&applied); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.migrate(); err != nil {
+		t.Fatal(err)
+	}
+	var reapplied int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&reapplied); err != nil {
+		t.Fatal(err)
+	}
+	if applied != len(migrations) || reapplied != applied {
+		t.Errorf("applied migrations = %d then %d, want %d", applied, reapplied, len(migrations))
+	}
+}
+
+func TestMigrationsOrdered(t *testing.T) {
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("migration %q has version %d, want %d", m.name, m.version, i+1)
+		}
+	}
+}
+
+func TestMigrateAddsHostToExistingRows(t *testing.T) {
+	sqlDB, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "favorites.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	s := &sqlStore{db: sqlDB, dialect: sqliteDialect}
+
+	// A database at version 7, before rows had a host
+	if _, err := sqlDB.Exec("CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, name TEXT NOT NULL, applied_at DATETIME DEFAULT CURRENT_TIMESTAMP)"); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range migrations[:7] {
+		if _, err := s.applyMigration(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = sqlDB.Exec(`INSERT INTO desired_devices (vm_name, vendor_id, product_id) VALUES ('win11', '046d', 'c52b'), ('win11', '0781', '5583');
+		INSERT INTO attachments (vm_name, vendor_id, product_id, client) VALUES ('win11', '046d', 'c52b', '127.0.0.1')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Existing rows belong to the default connection, in the same order
+	devices, err := s.GetDesiredDevices("", "win11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DesiredDevice{{VendorID: "046d", ProductID: "c52b"}, {VendorID: "0781", ProductID: "5583"}}
+	if len(devices) != 2 || devices[0] != want[0] || devices[1] != want[1] {
+		t.Errorf("desired devices after migration = %+v, want %+v", devices, want)
+	}
+	attachments, err := s.GetAttachments("", "win11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 1 || attachments[0].Client != "127.0.0.1" {
+		t.Errorf("attachments after migration = %+v", attachments)
+	}
+}
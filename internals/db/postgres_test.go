@@ -0,0 +1,76 @@
+package db
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// TestPostgresStore runs the store against the Postgres server of DATABASE_URL, which should be a
+// scratch database: migrations are applied to it and rows are written (and removed) under test names
+func TestPostgresStore(t *testing.T) {
+	databaseURL := os.Getenv(DatabaseURLEnv)
+	if databaseURL == "" {
+		t.Skipf("%s is not set", DatabaseURLEnv)
+	}
+	if err := InitDB(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { DB.Close() })
+	s := store.(*sqlStore)
+	if s.dialect.name != postgresDialect.name {
+		t.Fatalf("dialect = %s, want postgres", s.dialect.name)
+	}
+
+	// Migrating again applies nothing
+	if err := s.migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := s.schemaVersion(); err != nil || version != migrations[len(migrations)-1].version {
+		t.Errorf("schema version = %d, %v; want %d", version, err, migrations[len(migrations)-1].version)
+	}
+
+	const host, vmName, vendorID, productID = "pgtest", "pgtest-vm", "ffff", "fff0"
+	t.Cleanup(func() {
+		RemoveFavorite(vendorID, productID)
+		ClearDesiredDevices(host, vmName)
+		RemoveAttachment(host, vmName, vendorID, productID)
+		DB.Exec("DELETE FROM audit_log WHERE vm_name = $1", vmName)
+	})
+
+	if err := AddFavorite(vendorID, productID, "Test device", ""); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := IsFavorite(vendorID, productID); err != nil || !ok {
+		t.Errorf("IsFavorite() = %v, %v; want true", ok, err)
+	}
+
+	desired := []DesiredDevice{{VendorID: vendorID, ProductID: productID}, {VendorID: vendorID, ProductID: "fff1"}}
+	if err := SetDesiredDevices(host, vmName, desired); err != nil {
+		t.Fatal(err)
+	}
+	if devices, err := GetDesiredDevices(host, vmName); err != nil || len(devices) != 2 || devices[1] != desired[1] {
+		t.Errorf("GetDesiredDevices() = %+v, %v; want %+v in order", devices, err, desired)
+	}
+
+	// Recording an attachment twice updates it (ON CONFLICT)
+	for _, client := range []string{"10.0.0.1", "10.0.0.2"} {
+		if err := RecordAttachment(host, vmName, vendorID, productID, client); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if attachments, err := GetAttachments(host, vmName); err != nil || len(attachments) != 1 || attachments[0].Client != "10.0.0.2" {
+		t.Errorf("GetAttachments() = %+v, %v; want one attachment from 10.0.0.2", attachments, err)
+	}
+
+	if err := RecordAudit(AuditEntry{Action: "attach", VMName: vmName, VendorID: vendorID, ProductID: productID, Success: true}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := GetDeviceAudit(vendorID, productID, 10)
+	if err != nil || len(entries) == 0 || entries[0].VMName != vmName || !entries[0].Success {
+		t.Fatalf("GetDeviceAudit() = %+v, %v; want the recorded attach", entries, err)
+	}
+	if entries[0].Timestamp.IsZero() || time.Since(entries[0].Timestamp) > time.Hour {
+		t.Errorf("audit timestamp = %s, want about now", entries[0].Timestamp)
+	}
+}
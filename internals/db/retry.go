@@ -0,0 +1,57 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Writes that hit SQLITE_BUSY/SQLITE_LOCKED are retried with exponential backoff
+const (
+	maxWriteAttempts  = 5
+	writeRetryBackoff = 20 * time.Millisecond
+)
+
+// isBusyError reports whether err means another connection holds the database lock
+func isBusyError(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	return err != nil && strings.Contains(err.Error(), "database is locked")
+}
+
+// withRetry runs a write, retrying it while the database is busy
+// fn must be safe to run again (e.g. a single statement or a whole transaction)
+func withRetry(fn func() error) error {
+	backoff := writeRetryBackoff
+	var err error
+	for attempt := 1; attempt <= maxWriteAttempts; attempt++ {
+		err = fn()
+		if !isBusyError(err) {
+			return err
+		}
+		if attempt < maxWriteAttempts {
+			log.Printf("Database busy (attempt %d/%d), retrying in %v: %v", attempt, maxWriteAttempts, backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return err
+}
+
+// execWithRetry executes a write statement, retrying while the database is busy
+func (s *sqlStore) execWithRetry(query string, args ...any) (sql.Result, error) {
+	query = s.rebind(query)
+	var result sql.Result
+	err := withRetry(func() error {
+		var err error
+		result, err = s.db.Exec(query, args...)
+		return err
+	})
+	return result, err
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestIsBusyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"busy", sqlite3.Error{Code: sqlite3.ErrBusy}, true},
+		{"locked", sqlite3.Error{Code: sqlite3.ErrLocked}, true},
+		{"constraint", sqlite3.Error{Code: sqlite3.ErrConstraint}, false},
+		{"message", errors.New("database is locked"), true},
+		{"other", errors.New("no such table"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isBusyError(tt.err); got != tt.want {
+				t.Errorf("isBusyError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddFavoriteRetriesWhileLocked(t *testing.T) {
+	setupTestDB(t)
+
+	// Fail immediately on lock instead of waiting in SQLite's busy handler
+	DB.SetMaxOpenConns(1)
+	if _, err := DB.Exec("PRAGMA busy_timeout = 0"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hold an exclusive lock from another connection for a short while
+	other, err := sql.Open("sqlite3", filepath.Join("data", "favorites.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	conn, err := other.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(context.Background(), "BEGIN EXCLUSIVE"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A single attempt fails right away
+	if _, err := DB.Exec("DELETE FROM favorites"); !isBusyError(err) {
+		t.Fatalf("write while locked = %v, want busy error", err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		conn.ExecContext(context.Background(), "COMMIT")
+	}()
+
+	if err := AddFavorite("046d", "c52b", "Receiver", ""); err != nil {
+		t.Fatalf("AddFavorite() = %v, want success after retry", err)
+	}
+	if ok, err := IsFavorite("046d", "c52b"); err != nil || !ok {
+		t.Errorf("IsFavorite() = %v, %v; want true", ok, err)
+	}
+}
@@ -0,0 +1,182 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// DatabaseURLEnv selects the database: unset for the local SQLite file (./data/favorites.db),
+// or a postgres:// URL to share state between several instances
+const DatabaseURLEnv = "DATABASE_URL"
+
+// Store persists favorites, desired devices, attachments, the audit log, device aliases and VM groups
+// The package-level functions delegate to the store opened by InitDB
+type Store interface {
+	GetAllFavorites() ([]FavoriteDevice, error)
+	AddFavorite(vendorID, productID, description, notes string) error
+	EnsureFavorite(vendorID, productID, description string) (bool, error)
+	UpdateFavorite(vendorID, productID string, description, notes *string) error
+	FillFavoriteDescription(vendorID, productID, description string) (bool, error)
+	RemoveFavorite(vendorID, productID string) error
+	IsFavorite(vendorID, productID string) (bool, error)
+
+	GetDesiredDevices(host, vmName string) ([]DesiredDevice, error)
+	GetAllDesiredDevices(host string) (map[string][]DesiredDevice, error)
+	SetDesiredDevices(host, vmName string, devices []DesiredDevice) error
+	ClearDesiredDevices(host, vmName string) error
+
+	RecordAttachment(host, vmName, vendorID, productID, client string) error
+	RemoveAttachment(host, vmName, vendorID, productID string) error
+	GetAttachments(host, vmName string) ([]Attachment, error)
+
+	RecordAudit(entry AuditEntry) error
+	IterateAudit(from, to time.Time, fn func(AuditEntry) error) error
+	GetDeviceAudit(vendorID, productID string, limit int) ([]AuditEntry, error)
+	PruneAudit(before time.Time) (int64, error)
+
+	GetDeviceAliases() ([]DeviceAlias, error)
+	GetDeviceAlias(name string) (DeviceAlias, error)
+	SetDeviceAlias(name, vendorID, productID string) error
+	RemoveDeviceAlias(name string) error
+
+	GetVMGroups() (map[string][]string, error)
+	GetVMGroup(name string) ([]string, error)
+	SetVMGroup(name string, vmNames []string) error
+	RemoveVMGroup(name string) error
+
+	Close() error
+}
+
+// store is the store opened by InitDB
+var store Store
+
+// dialect holds what differs between the supported SQL databases
+type dialect struct {
+	name string
+	// numbered placeholders ($1, $2, ...) instead of ?
+	numberedPlaceholders bool
+	// insertionOrder is the column ordering rows of tables without an id by insertion,
+	// added by insertionOrderColumn where the database has no implicit one
+	insertionOrder       string
+	insertionOrderColumn string
+	// shared databases may be written by other instances, so nothing read from them is cached
+	shared bool
+	// column types used by migrations
+	autoIncrementKey string
+	timestamp        string
+	boolean          string
+	// migrationLock is run at the start of each migration transaction to serialize instances
+	migrationLock string
+}
+
+// sqliteDialect is the local SQLite database (the default)
+var sqliteDialect = dialect{
+	name:             "sqlite",
+	insertionOrder:   "rowid",
+	autoIncrementKey: "INTEGER PRIMARY KEY AUTOINCREMENT",
+	timestamp:        "DATETIME",
+	boolean:          "INTEGER",
+}
+
+// postgresDialect is a PostgreSQL database, possibly shared by several instances
+var postgresDialect = dialect{
+	name:                 "postgres",
+	numberedPlaceholders: true,
+	insertionOrder:       "id",
+	insertionOrderColumn: "id BIGSERIAL PRIMARY KEY,",
+	shared:               true,
+	autoIncrementKey:     "BIGSERIAL PRIMARY KEY",
+	timestamp:            "TIMESTAMPTZ",
+	boolean:              "BOOLEAN",
+	migrationLock:        "SELECT pg_advisory_xact_lock(7355608)",
+}
+
+// sqlStore is a Store backed by a database/sql database
+// Queries are written with ? placeholders and rewritten for the dialect
+type sqlStore struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// openStore opens the database selected by databaseURL ("" for the local SQLite file) and migrates its schema
+func openStore(databaseURL string) (*sqlStore, error) {
+	var s *sqlStore
+	if databaseURL == "" {
+		// Create data directory if it doesn't exist
+		dataDir := "./data"
+		if err := os.MkdirAll(dataDir, 0755); err != nil {
+			return nil, err
+		}
+		sqlDB, err := sql.Open("sqlite3", filepath.Join(dataDir, "favorites.db"))
+		if err != nil {
+			return nil, err
+		}
+		s = &sqlStore{db: sqlDB, dialect: sqliteDialect}
+	} else {
+		u, err := url.Parse(databaseURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", DatabaseURLEnv, err)
+		}
+		if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+			return nil, fmt.Errorf("invalid %s: unsupported database %q (expected postgres://)", DatabaseURLEnv, u.Scheme)
+		}
+		sqlDB, err := sql.Open("pgx", databaseURL)
+		if err != nil {
+			return nil, err
+		}
+		if err := sqlDB.Ping(); err != nil {
+			sqlDB.Close()
+			return nil, fmt.Errorf("failed to connect to %s: %w", u.Host, err)
+		}
+		s = &sqlStore{db: sqlDB, dialect: postgresDialect}
+	}
+
+	if err := s.migrate(); err != nil {
+		s.db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close closes the database
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// rebind rewrites the ? placeholders of a query for the dialect
+func (s *sqlStore) rebind(query string) string {
+	if !s.dialect.numberedPlaceholders {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// query runs a query returning rows
+func (s *sqlStore) query(query string, args ...any) (*sql.Rows, error) {
+	return s.db.Query(s.rebind(query), args...)
+}
+
+// queryRow runs a query returning at most one row
+func (s *sqlStore) queryRow(query string, args ...any) *sql.Row {
+	return s.db.QueryRow(s.rebind(query), args...)
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		postgres string
+	}{
+		{"no placeholders", "SELECT COUNT(*) FROM favorites", "SELECT COUNT(*) FROM favorites"},
+		{
+			"update",
+			"UPDATE favorites SET description = ? WHERE vendor_id = ? AND product_id = ?",
+			"UPDATE favorites SET description = $1 WHERE vendor_id = $2 AND product_id = $3",
+		},
+		{
+			"upsert",
+			"INSERT INTO attachments (host, vm_name, vendor_id, product_id, client) VALUES (?, ?, ?, ?, ?) ON CONFLICT(host, vm_name, vendor_id, product_id) DO UPDATE SET client = excluded.client",
+			"INSERT INTO attachments (host, vm_name, vendor_id, product_id, client) VALUES ($1, $2, $3, $4, $5) ON CONFLICT(host, vm_name, vendor_id, product_id) DO UPDATE SET client = excluded.client",
+		},
+		{"more than nine", "VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", "VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)"},
+	}
+
+	sqlite := &sqlStore{dialect: sqliteDialect}
+	postgres := &sqlStore{dialect: postgresDialect}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sqlite.rebind(tt.query); got != tt.query {
+				t.Errorf("sqlite rebind() = %q, want the query unchanged", got)
+			}
+			if got := postgres.rebind(tt.query); got != tt.postgres {
+				t.Errorf("postgres rebind() = %q, want %q", got, tt.postgres)
+			}
+		})
+	}
+}
+
+func TestDialects(t *testing.T) {
+	// Only Postgres may be shared by instances, so only it needs a migration lock and uncached reads
+	if sqliteDialect.shared || sqliteDialect.migrationLock != "" || sqliteDialect.numberedPlaceholders {
+		t.Errorf("sqliteDialect = %+v, want a local database with ? placeholders", sqliteDialect)
+	}
+	if !postgresDialect.shared || postgresDialect.migrationLock == "" || !postgresDialect.numberedPlaceholders {
+		t.Errorf("postgresDialect = %+v, want a shared database with $n placeholders", postgresDialect)
+	}
+
+	// Tables without an id get an insertion order column only where there is no implicit one
+	if sqliteDialect.insertionOrderColumn != "" || !strings.HasPrefix(postgresDialect.insertionOrderColumn, postgresDialect.insertionOrder+" ") {
+		t.Errorf("insertion order columns = %q, %q", sqliteDialect.insertionOrderColumn, postgresDialect.insertionOrderColumn)
+	}
+}
+
+func TestOpenStoreUnsupportedURL(t *testing.T) {
+	for _, url := range []string{"mysql://user@localhost/vfio", "://bad"} {
+		if _, err := openStore(url); err == nil {
+			t.Errorf("openStore(%q) expected an error", url)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package db
+
+import "errors"
+
+// ErrVMGroupNotFound is returned when a VM group has no members
+var ErrVMGroupNotFound = errors.New("VM group not found")
+
+// GetVMGroups returns the members of all VM groups, keyed by group name
+func GetVMGroups() (map[string][]string, error) {
+	return store.GetVMGroups()
+}
+
+// GetVMGroups returns the members of all VM groups, keyed by group name, in insertion order
+func (s *sqlStore) GetVMGroups() (map[string][]string, error) {
+	rows, err := s.query("SELECT group_name, vm_name FROM vm_groups ORDER BY group_name, created_at, " + s.dialect.insertionOrder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := make(map[string][]string)
+	for rows.Next() {
+		var group, vmName string
+		if err := rows.Scan(&group, &vmName); err != nil {
+			return nil, err
+		}
+		groups[group] = append(groups[group], vmName)
+	}
+	return groups, rows.Err()
+}
+
+// GetVMGroup returns the members of a VM group, or ErrVMGroupNotFound
+func GetVMGroup(name string) ([]string, error) {
+	return store.GetVMGroup(name)
+}
+
+// GetVMGroup returns the members of a VM group in insertion order, or ErrVMGroupNotFound
+func (s *sqlStore) GetVMGroup(name string) ([]string, error) {
+	rows, err := s.query("SELECT vm_name FROM vm_groups WHERE group_name = ? ORDER BY created_at, "+s.dialect.insertionOrder, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var vmNames []string
+	for rows.Next() {
+		var vmName string
+		if err := rows.Scan(&vmName); err != nil {
+			return nil, err
+		}
+		vmNames = append(vmNames, vmName)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(vmNames) == 0 {
+		return nil, ErrVMGroupNotFound
+	}
+	return vmNames, nil
+}
+
+// SetVMGroup replaces the members of a VM group, creating it if needed
+func SetVMGroup(name string, vmNames []string) error {
+	return store.SetVMGroup(name, vmNames)
+}
+
+// SetVMGroup replaces the members of a VM group, creating it if needed
+func (s *sqlStore) SetVMGroup(name string, vmNames []string) error {
+	return withRetry(func() error {
+		return s.setVMGroup(name, vmNames)
+	})
+}
+
+// setVMGroup replaces the members of a VM group in a single transaction
+func (s *sqlStore) setVMGroup(name string, vmNames []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(s.rebind("DELETE FROM vm_groups WHERE group_name = ?"), name); err != nil {
+		return err
+	}
+
+	for _, vmName := range vmNames {
+		_, err := tx.Exec(
+			s.rebind("INSERT INTO vm_groups (group_name, vm_name) VALUES (?, ?) ON CONFLICT DO NOTHING"),
+			name, vmName,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RemoveVMGroup removes a VM group, or returns ErrVMGroupNotFound
+func RemoveVMGroup(name string) error {
+	return store.RemoveVMGroup(name)
+}
+
+// RemoveVMGroup removes a VM group, or returns ErrVMGroupNotFound
+func (s *sqlStore) RemoveVMGroup(name string) error {
+	result, err := s.execWithRetry("DELETE FROM vm_groups WHERE group_name = ?", name)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrVMGroupNotFound
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestVMGroups(t *testing.T) {
+	setupTestDB(t)
+
+	if _, err := GetVMGroup("gaming"); !errors.Is(err, ErrVMGroupNotFound) {
+		t.Fatalf("GetVMGroup() error = %v, want ErrVMGroupNotFound", err)
+	}
+
+	if err := SetVMGroup("gaming", []string{"win10", "steamos", "win10"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetVMGroup("work", []string{"ubuntu"}); err != nil {
+		t.Fatal(err)
+	}
+	// Setting a group replaces its members
+	if err := SetVMGroup("gaming", []string{"win11", "steamos"}); err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := GetVMGroup("gaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"win11", "steamos"}; !reflect.DeepEqual(members, want) {
+		t.Errorf("GetVMGroup() = %q, want %q", members, want)
+	}
+
+	groups, err := GetVMGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"gaming": {"win11", "steamos"}, "work": {"ubuntu"}}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("GetVMGroups() = %v, want %v", groups, want)
+	}
+
+	if err := RemoveVMGroup("work"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveVMGroup("work"); !errors.Is(err, ErrVMGroupNotFound) {
+		t.Errorf("RemoveVMGroup() error = %v, want ErrVMGroupNotFound", err)
+	}
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"log"
+	"net"
+	"strings"
+
+	"vfio_usb_passthrough/internals/i18n"
+	"vfio_usb_passthrough/internals/middleware"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ReloadNetworks returns a handler that reloads the allowed networks of the IP filter
+func ReloadNetworks(ipFilter *middleware.IPFilter) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := ipFilter.Reload(); err != nil {
+			log.Printf("Error reloading allowed networks: %v", err)
+			return c.Status(500).JSON(fiber.Map{
+				"error":   i18n.Msg(c, "reload_networks_failed"),
+				"details": err.Error(),
+			})
+		}
+
+		var networks []string
+		for _, network := range ipFilter.Networks() {
+			networks = append(networks, network.String())
+		}
+
+		return c.JSON(fiber.Map{
+			"success":         true,
+			"message":         i18n.Msg(c, "networks_reloaded"),
+			"allowedNetworks": networks,
+		})
+	}
+}
+
+// CheckIP returns a handler that reports whether the IP filter would let an IP (?ip=192.168.1.50)
+// or every address of a CIDR (?ip=192.168.1.0/28) through, and which allowed network matched
+func CheckIP(ipFilter *middleware.IPFilter) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		value := strings.TrimSpace(c.Query("ip"))
+
+		var matched *net.IPNet
+		if strings.Contains(value, "/") {
+			_, network, err := net.ParseCIDR(value)
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{
+					"error": i18n.Msg(c, "invalid_ip_or_cidr", value),
+				})
+			}
+			value = network.String()
+			matched = ipFilter.MatchNetwork(network)
+		} else {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return c.Status(400).JSON(fiber.Map{
+					"error": i18n.Msg(c, "invalid_ip_or_cidr", value),
+				})
+			}
+			value = ip.String()
+			matched = ipFilter.MatchIP(ip)
+		}
+
+		var matchedNetwork *string
+		if matched != nil {
+			network := matched.String()
+			matchedNetwork = &network
+		}
+		return c.JSON(fiber.Map{
+			"ip":             value,
+			"allowed":        matched != nil,
+			"matchedNetwork": matchedNetwork,
+		})
+	}
+}
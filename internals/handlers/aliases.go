@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"vfio_usb_passthrough/internals/db"
+	"vfio_usb_passthrough/internals/i18n"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// deviceAliasPattern matches device alias names; names are case-insensitive and stored lowercase
+var deviceAliasPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+
+// normalizeDeviceAlias returns the stored form of a device alias name
+func normalizeDeviceAlias(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// DeviceAliasRequest represents a request to create or update a device alias
+type DeviceAliasRequest struct {
+	Name      string `json:"name" validate:"required,aliasname"`
+	VendorID  string `json:"vendorId" validate:"required,usbid"`
+	ProductID string `json:"productId" validate:"required,usbid"`
+}
+
+// GetDeviceAliases returns all device aliases
+func GetDeviceAliases(c *fiber.Ctx) error {
+	aliases, err := db.GetDeviceAliases()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "get_device_aliases_failed"),
+			"details": err.Error(),
+		})
+	}
+	if aliases == nil {
+		aliases = []db.DeviceAlias{}
+	}
+
+	return c.JSON(fiber.Map{
+		"aliases": aliases,
+	})
+}
+
+// GetDeviceAlias returns one device alias
+func GetDeviceAlias(c *fiber.Ctx) error {
+	name := normalizeDeviceAlias(c.Params("name"))
+	alias, err := db.GetDeviceAlias(name)
+	if errors.Is(err, db.ErrDeviceAliasNotFound) {
+		return c.Status(404).JSON(fiber.Map{
+			"error": i18n.Msg(c, "device_alias_not_found", name),
+		})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "get_device_aliases_failed"),
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(alias)
+}
+
+// SetDeviceAlias creates a device alias, or points an existing one to another device
+func SetDeviceAlias(c *fiber.Ctx) error {
+	var req DeviceAliasRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "invalid_request_body"),
+			"details": err.Error(),
+		})
+	}
+
+	req.Name = normalizeDeviceAlias(req.Name)
+	if reqErr := validateRequest(c, &req); reqErr != nil {
+		return reqErr.send(c)
+	}
+
+	err := db.SetDeviceAlias(req.Name, normalizeDeviceID(req.VendorID), normalizeDeviceID(req.ProductID))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "set_device_alias_failed"),
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": i18n.Msg(c, "device_alias_saved", req.Name),
+	})
+}
+
+// RemoveDeviceAlias removes a device alias
+func RemoveDeviceAlias(c *fiber.Ctx) error {
+	name := normalizeDeviceAlias(c.Params("name"))
+	err := db.RemoveDeviceAlias(name)
+	if errors.Is(err, db.ErrDeviceAliasNotFound) {
+		return c.Status(404).JSON(fiber.Map{
+			"error": i18n.Msg(c, "device_alias_not_found", name),
+		})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "remove_device_alias_failed"),
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": i18n.Msg(c, "device_alias_removed", name),
+	})
+}
+
+// ErrDeviceAliasWithIDs is returned when a request names a device alias and also gives device IDs
+var ErrDeviceAliasWithIDs = errors.New("a device alias cannot be combined with vendor and product IDs")
+
+// applyDeviceAlias fills in the IDs of an attach/detach request naming a device alias
+// The alias is cleared once resolved, so a request can be applied more than once
+func applyDeviceAlias(req *AttachDetachRequest) error {
+	if strings.TrimSpace(req.DeviceAlias) == "" {
+		return nil
+	}
+	if req.VendorID != "" || req.ProductID != "" {
+		return ErrDeviceAliasWithIDs
+	}
+
+	alias, err := db.GetDeviceAlias(normalizeDeviceAlias(req.DeviceAlias))
+	if err != nil {
+		return err
+	}
+
+	req.VendorID = alias.VendorID
+	req.ProductID = alias.ProductID
+	req.DeviceAlias = ""
+	return nil
+}
+
+// resolveRequestDeviceAlias fills in the IDs of an attach/detach request naming a device alias
+// The resolved IDs are then checked by validateRequest like IDs sent by the client
+func resolveRequestDeviceAlias(c *fiber.Ctx, req *AttachDetachRequest) *requestError {
+	name := normalizeDeviceAlias(req.DeviceAlias)
+	err := applyDeviceAlias(req)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrDeviceAliasWithIDs):
+		return &requestError{400, fiber.Map{
+			"error": i18n.Msg(c, "device_alias_with_ids"),
+		}}
+	case errors.Is(err, db.ErrDeviceAliasNotFound):
+		return &requestError{404, fiber.Map{
+			"error": i18n.Msg(c, "device_alias_not_found", name),
+		}}
+	default:
+		return &requestError{500, fiber.Map{
+			"error":   i18n.Msg(c, "get_device_aliases_failed"),
+			"details": err.Error(),
+		}}
+	}
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+
+	"vfio_usb_passthrough/internals/i18n"
+
+	"github.com/gofiber/fiber/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// maxBulkDevices caps the number of devices accepted in a single bulk request
+const maxBulkDevices = 100
+
+// errUnsupportedMediaType is returned for apply bodies that are neither JSON nor YAML
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
+// ApplyRequest is the desired set of devices for a VM
+type ApplyRequest struct {
+	Devices []AttachDetachRequest `json:"devices" yaml:"devices" validate:"dive"`
+}
+
+// ApplyAction reports one attach or detach performed while applying a desired state
+type ApplyAction struct {
+	Action    string          `json:"action"`
+	VendorID  string          `json:"vendorId"`
+	ProductID string          `json:"productId"`
+	Success   bool            `json:"success"`
+	Error     string          `json:"error,omitempty"`
+	Rollback  *RollbackResult `json:"rollback,omitempty"`
+	// Mounts are the host filesystems that kept a storage device from being attached
+	Mounts []string `json:"mounts,omitempty"`
+}
+
+// ApplyResult is the outcome of applying a desired state to a VM
+type ApplyResult struct {
+	Success   bool                     `json:"success"`
+	Actions   []ApplyAction            `json:"actions"`
+	Unchanged []AttachedDeviceResponse `json:"unchanged"`
+}
+
+// ApplyDevices makes the devices attached to a VM match the desired set in the request
+// Missing devices are attached and extra ones detached; failures are reported per device.
+// The body may be JSON or YAML (Content-Type application/yaml or application/x-yaml).
+func ApplyDevices(c *fiber.Ctx) error {
+	host := hostFromCtx(c)
+
+	// Validate VM name (or resolve a VM UUID to its name)
+	vmName, err := resolveVMName(host, c.Params("vmName"))
+	if err != nil {
+		log.Printf("ApplyDevices: VM validation failed for '%s': %v", c.Params("vmName"), err)
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Localize(c, err),
+		})
+	}
+
+	desired, reqErr := readDesiredDevices(c)
+	if reqErr != nil {
+		return reqErr.send(c)
+	}
+
+	result, err := applyDesiredState(c.UserContext(), host, vmName, desired, true, c.IP())
+	if errors.As(err, new(*DeviceLimitError)) {
+		return sendDeviceCommandError(c, "attach_failed", vmName, err)
+	}
+	if err != nil {
+		log.Printf("Error getting attached devices for %s: %v", vmName, err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "get_attached_devices_failed", vmName),
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(result)
+}
+
+// requestError is an error response to send for an invalid request
+type requestError struct {
+	status int
+	body   fiber.Map
+}
+
+// send writes the error response
+func (e *requestError) send(c *fiber.Ctx) error {
+	return c.Status(e.status).JSON(e.body)
+}
+
+// readDesiredDevices parses and validates the device list of an apply or desired-state request
+// Returned IDs are normalized
+func readDesiredDevices(c *fiber.Ctx) ([]AttachedDeviceResponse, *requestError) {
+	var req ApplyRequest
+	err := parseApplyRequest(c, &req)
+	if errors.Is(err, errUnsupportedMediaType) {
+		return nil, &requestError{fiber.StatusUnsupportedMediaType, fiber.Map{
+			"error": i18n.Msg(c, "unsupported_media_type_apply"),
+		}}
+	}
+	if err != nil {
+		return nil, &requestError{400, fiber.Map{
+			"error":   i18n.Msg(c, "invalid_request_body"),
+			"details": err.Error(),
+		}}
+	}
+
+	if len(req.Devices) > maxBulkDevices {
+		return nil, &requestError{fiber.StatusRequestEntityTooLarge, fiber.Map{
+			"error": i18n.Msg(c, "too_many_devices", maxBulkDevices),
+		}}
+	}
+
+	if reqErr := validateRequest(c, &req); reqErr != nil {
+		return nil, reqErr
+	}
+
+	desired := make([]AttachedDeviceResponse, 0, len(req.Devices))
+	for _, device := range req.Devices {
+		desired = append(desired, AttachedDeviceResponse{
+			VendorID:  normalizeDeviceID(device.VendorID),
+			ProductID: normalizeDeviceID(device.ProductID),
+		})
+	}
+
+	return desired, nil
+}
+
+// parseApplyRequest decodes the request body as YAML or JSON depending on the Content-Type
+func parseApplyRequest(c *fiber.Ctx, req *ApplyRequest) error {
+	mediaType, _, _ := strings.Cut(c.Get(fiber.HeaderContentType), ";")
+	switch strings.ToLower(strings.TrimSpace(mediaType)) {
+	case "application/yaml", "application/x-yaml", "text/yaml":
+		return yaml.Unmarshal(c.Body(), req)
+	case fiber.MIMEApplicationJSON:
+		return json.Unmarshal(c.Body(), req)
+	default:
+		return errUnsupportedMediaType
+	}
+}
+
+// applyDesiredState attaches the desired devices missing from the VM and, if detachExtras is set,
+// detaches the ones that are not desired. client is recorded with the attachments made.
+// Returns an error only if the current attachments cannot be read, or a DeviceLimitError
+// (before any change) if the resulting devices would exceed MAX_DEVICES_PER_VM
+func applyDesiredState(ctx context.Context, host Host, vmName string, desired []AttachedDeviceResponse, detachExtras bool, client string) (ApplyResult, error) {
+	result := ApplyResult{Success: true, Actions: []ApplyAction{}, Unchanged: []AttachedDeviceResponse{}}
+
+	// The devices counted against the limit must not change until the missing ones are attached
+	unlock := lockVMAttach(host, vmName)
+	defer unlock()
+
+	current, err := getAttachedDevicesList(ctx, host, vmName)
+	if err != nil {
+		return result, err
+	}
+
+	// Compare by IDs only
+	desiredSet := make(map[string]bool)
+	for _, device := range desired {
+		desiredSet[device.VendorID+":"+device.ProductID] = true
+	}
+	currentSet := make(map[string]bool)
+	for _, device := range current {
+		currentSet[device.VendorID+":"+device.ProductID] = true
+	}
+
+	// The whole batch must fit the limit, counting the devices left attached
+	kept, missing := 0, make(map[string]bool)
+	for _, device := range current {
+		if desiredSet[device.VendorID+":"+device.ProductID] || !detachExtras {
+			kept++
+		}
+	}
+	for _, device := range desired {
+		if key := device.VendorID + ":" + device.ProductID; !currentSet[key] {
+			missing[key] = true
+		}
+	}
+	if err := checkDeviceLimit(vmName, kept, len(missing)); err != nil {
+		return result, err
+	}
+
+	// Detach extras first to free USB ports for the missing devices
+	for _, device := range current {
+		if desiredSet[device.VendorID+":"+device.ProductID] || !detachExtras {
+			result.Unchanged = append(result.Unchanged, device)
+			continue
+		}
+		result.Actions = append(result.Actions, applyDeviceAction(ctx, host, "detach", vmName, device, client))
+	}
+
+	for _, device := range desired {
+		key := device.VendorID + ":" + device.ProductID
+		if currentSet[key] {
+			continue
+		}
+		// Skip duplicates in the desired list
+		currentSet[key] = true
+		result.Actions = append(result.Actions, applyDeviceAction(ctx, host, "attach", vmName, device, client))
+	}
+
+	for _, action := range result.Actions {
+		if !action.Success {
+			result.Success = false
+		}
+	}
+	if len(result.Actions) > 0 {
+		publishVMAttachments(host, vmName)
+		log.Printf("Applied desired devices to %s: %d action(s), %d unchanged, success=%v", vmName, len(result.Actions), len(result.Unchanged), result.Success)
+	}
+
+	return result, nil
+}
+
+// applyDeviceAction attaches or detaches a single device, treating "already done" virsh errors as success
+// Storage devices with filesystems mounted on the host are not attached
+func applyDeviceAction(ctx context.Context, host Host, action, vmName string, device AttachedDeviceResponse, client string) ApplyAction {
+	if action == "attach" {
+		if err := checkDeviceNotMounted(host, device.VendorID, device.ProductID, nil); err != nil {
+			log.Printf("Refusing to apply attach of %s:%s to %s: %v", device.VendorID, device.ProductID, vmName, err)
+			refused := ApplyAction{
+				Action:    action,
+				VendorID:  device.VendorID,
+				ProductID: device.ProductID,
+				Error:     err.Error(),
+			}
+			var mountedErr *DeviceMountedError
+			if errors.As(err, &mountedErr) {
+				refused.Mounts = mountedErr.Mounts
+			}
+			notifyDeviceEvent(host, action, vmName, device.VendorID, device.ProductID, client, refused.Error)
+			return refused
+		}
+	}
+
+	var output string
+	var rollback *RollbackResult
+	var err error
+	if action == "attach" {
+		output, rollback, err = attachDevice(ctx, host, vmName, device.VendorID, device.ProductID, nil)
+	} else {
+		output, err = runDeviceCommand(ctx, host, action+"-device", vmName, device.VendorID, device.ProductID, nil)
+	}
+	if err != nil && action == "attach" && isDeviceExistsError(output) {
+		err = nil
+	}
+	if err != nil && action == "detach" && isDeviceNotFoundError(output) {
+		err = nil
+	}
+
+	applied := ApplyAction{
+		Action:    action,
+		VendorID:  device.VendorID,
+		ProductID: device.ProductID,
+		Success:   err == nil,
+		Rollback:  rollback,
+	}
+	if err != nil {
+		applied.Error = strings.TrimSpace(output)
+		if applied.Error == "" {
+			applied.Error = err.Error()
+		}
+		log.Printf("Error applying %s of %s:%s on %s: %v, output: %s", action, device.VendorID, device.ProductID, vmName, err, output)
+	}
+
+	if applied.Success && action == "attach" {
+		trackAttach(host, vmName, device.VendorID, device.ProductID, client)
+	} else if applied.Success && action == "detach" {
+		trackDetach(host, vmName, device.VendorID, device.ProductID)
+	}
+
+	notifyDeviceEvent(host, action, vmName, device.VendorID, device.ProductID, client, applied.Error)
+	return applied
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"vfio_usb_passthrough/internals/db"
+	"vfio_usb_passthrough/internals/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// fakeVirshXML is the live XML the fake virsh of TestApplyDevicesYAML reports for win10
+const fakeVirshXML = `<domain type='kvm'>
+  <name>win10</name>
+  <devices>
+    <hostdev mode='subsystem' type='usb' managed='no'>
+      <source>
+        <vendor id='0x046d'/>
+        <product id='0xc077'/>
+      </source>
+    </hostdev>
+  </devices>
+</domain>`
+
+func TestParseApplyRequestYAML(t *testing.T) {
+	app := fiber.New()
+	app.Post("/", func(c *fiber.Ctx) error {
+		var req ApplyRequest
+		if err := parseApplyRequest(c, &req); err != nil {
+			return c.Status(400).SendString(err.Error())
+		}
+		return c.JSON(req)
+	})
+
+	body := "devices:\n  - vendorId: \"046d\"\n    productId: \"c077\"\n  - vendorId: \"0x1234\"\n    productId: \"0x5678\"\n"
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/yaml")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+
+	var decoded ApplyRequest
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Devices) != 2 ||
+		decoded.Devices[0].VendorID != "046d" || decoded.Devices[0].ProductID != "c077" ||
+		decoded.Devices[1].VendorID != "0x1234" || decoded.Devices[1].ProductID != "0x5678" {
+		t.Errorf("decoded devices = %+v, want 046d:c077 and 0x1234:0x5678", decoded.Devices)
+	}
+}
+
+func TestApplyDevicesYAML(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := db.InitDB(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.DB.Close() })
+
+	// virsh is looked up on PATH: win10 runs with 046d:c077 attached, anything else fails
+	bin := t.TempDir()
+	script := "#!/bin/sh\nfor arg in \"$@\"; do\n  case \"$arg\" in\n" +
+		"  list) echo win10; exit 0 ;;\n" +
+		"  dumpxml) cat <<'EOF'\n" + fakeVirshXML + "\nEOF\n    exit 0 ;;\n" +
+		"  esac\ndone\nexit 1\n"
+	if err := os.WriteFile(filepath.Join(bin, "virsh"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	app := fiber.New()
+	app.Post("/vms/:vmName/apply", ApplyDevices)
+
+	req := httptest.NewRequest("POST", "/vms/win10/apply", strings.NewReader("devices:\n  - vendorId: \"046D\"\n    productId: \"0xC077\"\n"))
+	req.Header.Set("Content-Type", "application/yaml")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+
+	var result ApplyResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Success || len(result.Actions) != 0 {
+		t.Errorf("result = %+v, want success without actions", result)
+	}
+	if len(result.Unchanged) != 1 || result.Unchanged[0].VendorID != "046d" || result.Unchanged[0].ProductID != "c077" {
+		t.Errorf("unchanged = %+v, want 046d:c077", result.Unchanged)
+	}
+}
+
+func TestApplyDesiredStateSkipsMountedDevices(t *testing.T) {
+	setupTestDB(t)
+	fakeStorageSysfs(t, "/dev/sdb1 /media/stick vfat rw 0 0\n")
+	calls := filepath.Join(t.TempDir(), "calls")
+	t.Setenv(utils.VirshBinEnv, fakeCommand(t, `case "$1" in
+dumpxml) echo "<domain><name>win10</name><devices></devices></domain>"; exit 0 ;;
+attach-device) grep -o 'product id="0x[0-9a-f]*"' "$3" >> `+calls+`; exit 0 ;;
+esac
+exit 0
+`))
+
+	desired := []AttachedDeviceResponse{{VendorID: "0781", ProductID: "5567"}, {VendorID: "046d", ProductID: "c077"}}
+	result, err := applyDesiredState(context.Background(), defaultHost(), "win10", desired, false, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Success || len(result.Actions) != 2 {
+		t.Fatalf("result = %+v, want two actions and a failure", result)
+	}
+	stick, mouse := result.Actions[0], result.Actions[1]
+	if stick.Success || len(stick.Mounts) != 1 || stick.Mounts[0] != "/dev/sdb1 on /media/stick" {
+		t.Errorf("stick action = %+v, want a failure naming its mount", stick)
+	}
+	if !mouse.Success {
+		t.Errorf("mouse action = %+v, want success", mouse)
+	}
+	data, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(data)); got != `product id="0xc077"` {
+		t.Errorf("attached devices = %q, want only the mouse", got)
+	}
+}
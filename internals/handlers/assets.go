@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// AssetCacheEnv turns HTTP caching of /assets on or off ("on"/"off")
+// It defaults to on in production and off in development
+const AssetCacheEnv = "ASSET_CACHE"
+
+// AssetCacheEnabled reads ASSET_CACHE, returning defaultOn when it is unset or empty
+func AssetCacheEnabled(defaultOn bool) (bool, error) {
+	switch value := strings.ToLower(strings.TrimSpace(os.Getenv(AssetCacheEnv))); value {
+	case "":
+		return defaultOn, nil
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid %s: %q must be on or off", AssetCacheEnv, value)
+	}
+}
+
+// Assets returns a handler serving the files of an asset filesystem under /assets
+// With caching on, responses carry an ETag of their content and browsers revalidate them
+// (asset names are not fingerprinted); with caching off they are never stored
+func Assets(assets fs.FS, cache bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get the file path after /assets/
+		path := strings.TrimPrefix(c.Path(), "/assets/")
+		// Remove leading slash if present
+		path = strings.TrimPrefix(path, "/")
+
+		file, err := assets.Open(path)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).SendString("File not found")
+		}
+		defer file.Close()
+
+		stat, err := file.Stat()
+		if err != nil || stat.IsDir() {
+			return c.Status(fiber.StatusNotFound).SendString("File not found")
+		}
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString("Failed to read file")
+		}
+
+		c.Set(fiber.HeaderContentType, assetContentType(path))
+
+		if !cache {
+			c.Set(fiber.HeaderCacheControl, "no-store")
+			return c.Send(data)
+		}
+
+		sum := sha256.Sum256(data)
+		etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+		c.Set(fiber.HeaderETag, etag)
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+		if etagMatches(c.Get(fiber.HeaderIfNoneMatch), etag) {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+		return c.Send(data)
+	}
+}
+
+// assetContentType returns the content type of an asset from its extension
+// Source maps are JSON, and text types are declared as UTF-8 so the browser does not guess
+func assetContentType(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".map" {
+		return fiber.MIMEApplicationJSONCharsetUTF8
+	}
+	contentType := utils.GetMIME(ext)
+	if (strings.HasPrefix(contentType, "text/") || contentType == "image/svg+xml") && !strings.Contains(contentType, "charset") {
+		contentType += "; charset=utf-8"
+	}
+	return contentType
+}
+
+// etagMatches reports whether an If-None-Match header lists the ETag (or is "*")
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
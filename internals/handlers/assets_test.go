@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func newAssetsApp(cache bool) *fiber.App {
+	app := fiber.New()
+	app.Get("/assets/*", Assets(fstest.MapFS{
+		"bundle.js": {Data: []byte("console.log(1)")},
+	}, cache))
+	return app
+}
+
+func TestAssetsCacheOn(t *testing.T) {
+	app := newAssetsApp(true)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/assets/bundle.js", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := resp.Header.Get(fiber.HeaderETag)
+	if resp.StatusCode != fiber.StatusOK || etag == "" {
+		t.Fatalf("status = %d, ETag = %q, want 200 with an ETag", resp.StatusCode, etag)
+	}
+	if got := resp.Header.Get(fiber.HeaderCacheControl); got != "no-cache" {
+		t.Errorf("Cache-Control = %q, want no-cache", got)
+	}
+
+	// A revalidation with the same ETag is answered without a body
+	req := httptest.NewRequest("GET", "/assets/bundle.js", nil)
+	req.Header.Set(fiber.HeaderIfNoneMatch, etag)
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusNotModified {
+		t.Errorf("status with If-None-Match = %d, want 304", resp.StatusCode)
+	}
+}
+
+func TestAssetsCacheOff(t *testing.T) {
+	app := newAssetsApp(false)
+
+	req := httptest.NewRequest("GET", "/assets/bundle.js", nil)
+	req.Header.Set(fiber.HeaderIfNoneMatch, "*")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+	if got := resp.Header.Get(fiber.HeaderETag); got != "" {
+		t.Errorf("ETag = %q, want none", got)
+	}
+	if got := resp.Header.Get(fiber.HeaderCacheControl); got != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", got)
+	}
+}
+
+func TestAssetsNotFound(t *testing.T) {
+	resp, err := newAssetsApp(true).Test(httptest.NewRequest("GET", "/assets/missing.js", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestAssetContentType(t *testing.T) {
+	tests := map[string]string{
+		"bundle.js":        "text/javascript; charset=utf-8",
+		"styles.css":       "text/css; charset=utf-8",
+		"bundle.js.map":    "application/json; charset=utf-8",
+		"icons/logo.svg":   "image/svg+xml; charset=utf-8",
+		"fonts/font.woff2": "font/woff2",
+		"image.PNG":        "image/png",
+	}
+	for path, want := range tests {
+		if got := assetContentType(path); got != want {
+			t.Errorf("assetContentType(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
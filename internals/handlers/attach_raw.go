@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+
+	"vfio_usb_passthrough/internals/i18n"
+	"vfio_usb_passthrough/internals/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AttachRawRequest carries a libvirt hostdev XML written by the user
+// Force attaches a storage device even if its filesystems are mounted on the host
+type AttachRawRequest struct {
+	XML   string `json:"xml" validate:"required"`
+	Force bool   `json:"force"`
+}
+
+// AttachRawDevice attaches a USB device to a VM from a hostdev XML written by the user
+// It is meant for settings the generated XML lacks (boot order, guest address); the XML is checked by
+// utils.ParseUSBHostdevXML and passed to virsh as is
+// With ?timing=true the time spent in each phase is returned in milliseconds
+func AttachRawDevice(c *fiber.Ctx) error {
+	ctx, timing := requestTiming(c)
+	host := hostFromCtx(c)
+
+	// Validate VM name (or resolve a VM UUID to its name)
+	vmName, err := resolveVMName(host, c.Params("vmName"))
+	if err != nil {
+		log.Printf("AttachRawDevice: VM validation failed for '%s': %v", c.Params("vmName"), err)
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Localize(c, err),
+		})
+	}
+
+	var req AttachRawRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "invalid_request_body"),
+			"details": err.Error(),
+		})
+	}
+
+	if reqErr := validateRequest(c, &req); reqErr != nil {
+		return reqErr.send(c)
+	}
+
+	hostdev, err := utils.ParseUSBHostdevXML(req.XML)
+	if err != nil {
+		log.Printf("AttachRawDevice: Rejected hostdev XML for %s: %v", vmName, err)
+		return c.Status(400).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "invalid_hostdev_xml"),
+			"details": err.Error(),
+		})
+	}
+	vendorID := normalizeDeviceID(hostdev.Source.Vendor.ID)
+	productID := normalizeDeviceID(hostdev.Source.Product.ID)
+	timing.sinceStart(phaseValidate)
+
+	alias := ""
+	if hostdev.Alias != nil {
+		alias = hostdev.Alias.Name
+	}
+	log.Printf("AttachRawDevice: VM=%s, VendorID=%s, ProductID=%s, Alias=%s", vmName, vendorID, productID, alias)
+
+	// The VM must have room left under MAX_DEVICES_PER_VM, until the device is attached
+	unlock := lockVMAttach(host, vmName)
+	defer unlock()
+	if err := checkAttachLimit(ctx, host, vmName); err != nil {
+		log.Printf("AttachRawDevice: Refusing to attach %s:%s to %s: %v", vendorID, productID, vmName, err)
+		return sendDeviceCommandError(c, "attach_failed", vmName, err)
+	}
+
+	// A storage device with mounted filesystems would be pulled from under the host
+	if !req.Force {
+		if err := checkDeviceNotMounted(host, vendorID, productID, hostdev.Source.Address); err != nil {
+			log.Printf("AttachRawDevice: Refusing to attach %s:%s to %s: %v", vendorID, productID, vmName, err)
+			return sendDeviceCommandError(c, "attach_failed", vmName, err)
+		}
+	}
+
+	// Execute virsh attach-device (rolled back with a detach if it times out)
+	output, rollback, err := attachHostdev(ctx, host, vmName, vendorID, productID, req.XML)
+	if errors.Is(err, errCreateTempXML) {
+		return sendDeviceCommandError(c, "attach_failed", vmName, err)
+	}
+	if rollback != nil {
+		notifyDeviceEvent(host, "attach", vmName, vendorID, productID, c.IP(), err.Error())
+		return sendDeviceCommandError(c, "attach_failed", vmName, &DeviceCommandError{Output: output, Err: err, Rollback: rollback})
+	}
+	if err != nil {
+		log.Printf("Error attaching raw hostdev to %s: %v, output: %s", vmName, err, output)
+		notifyDeviceEvent(host, "attach", vmName, vendorID, productID, c.IP(), failureMessage(output, err))
+		return sendDeviceCommandError(c, "attach_failed", vmName, &DeviceCommandError{Output: output, Err: err})
+	}
+
+	trackAttach(host, vmName, vendorID, productID, c.IP())
+	notifyDeviceEvent(host, "attach", vmName, vendorID, productID, c.IP(), "")
+	publishVMAttachments(host, vmName)
+
+	return c.JSON(withTiming(fiber.Map{
+		"success": true,
+		"message": i18n.Msg(c, "device_attached", vendorID, productID, vmName),
+	}, timing))
+}
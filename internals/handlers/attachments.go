@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"vfio_usb_passthrough/internals/db"
+	"vfio_usb_passthrough/internals/utils"
+)
+
+// attachmentPruneInterval is how often recorded attachments that dropped off their VM are forgotten
+const attachmentPruneInterval = 5 * time.Minute
+
+// trackedVM identifies a VM on a libvirt connection
+type trackedVM struct {
+	Host   Host
+	VMName string
+}
+
+// trackedAttachments records the devices attached by this process, per VM
+// (the attachments table also keeps those of previous runs)
+var trackedAttachments = struct {
+	sync.Mutex
+	devices map[trackedVM]map[AttachedDeviceResponse]bool
+}{devices: make(map[trackedVM]map[AttachedDeviceResponse]bool)}
+
+// trackAttach records a device attached to a VM by this process, and in the database
+// so provenance survives restarts (best-effort: database errors are only logged)
+func trackAttach(host Host, vmName, vendorID, productID, client string) {
+	if err := db.RecordAttachment(hostKey(host), vmName, vendorID, productID, client); err != nil {
+		log.Printf("Warning: Failed to record attachment of %s:%s to %s: %v", vendorID, productID, vmName, err)
+	}
+
+	trackedAttachments.Lock()
+	defer trackedAttachments.Unlock()
+
+	vm := trackedVM{Host: host, VMName: vmName}
+	if trackedAttachments.devices[vm] == nil {
+		trackedAttachments.devices[vm] = make(map[AttachedDeviceResponse]bool)
+	}
+	trackedAttachments.devices[vm][AttachedDeviceResponse{VendorID: vendorID, ProductID: productID}] = true
+}
+
+// trackDetach forgets a device detached from a VM
+func trackDetach(host Host, vmName, vendorID, productID string) {
+	if err := db.RemoveAttachment(hostKey(host), vmName, vendorID, productID); err != nil {
+		log.Printf("Warning: Failed to remove attachment of %s:%s from %s: %v", vendorID, productID, vmName, err)
+	}
+
+	trackedAttachments.Lock()
+	defer trackedAttachments.Unlock()
+
+	vm := trackedVM{Host: host, VMName: vmName}
+	delete(trackedAttachments.devices[vm], AttachedDeviceResponse{VendorID: vendorID, ProductID: productID})
+	if len(trackedAttachments.devices[vm]) == 0 {
+		delete(trackedAttachments.devices, vm)
+	}
+}
+
+// DetachTrackedDevices detaches every device this process attached and has not detached since
+// Meant to be called on shutdown; failures are logged and do not stop the remaining detaches
+func DetachTrackedDevices() {
+	trackedAttachments.Lock()
+	pending := make(map[trackedVM][]AttachedDeviceResponse)
+	for vm, devices := range trackedAttachments.devices {
+		for device := range devices {
+			pending[vm] = append(pending[vm], device)
+		}
+	}
+	trackedAttachments.Unlock()
+
+	for vm, devices := range pending {
+		host, vmName := vm.Host, vm.VMName
+		for _, device := range devices {
+			log.Printf("Shutdown: Detaching %s:%s from %s", device.VendorID, device.ProductID, vmName)
+			output, err := runDeviceCommand(context.Background(), host, "detach-device", vmName, device.VendorID, device.ProductID, nil)
+			if err != nil && !isDeviceNotFoundError(output) {
+				log.Printf("Shutdown: Failed to detach %s:%s from %s: %v, output: %s", device.VendorID, device.ProductID, vmName, err, output)
+				continue
+			}
+			trackDetach(host, vmName, device.VendorID, device.ProductID)
+		}
+	}
+}
+
+// StartAttachmentPruner forgets, every attachmentPruneInterval, the recorded attachments of running VMs
+// whose device is no longer attached (detached outside this tool)
+// This is kept out of the read paths, which would otherwise race with attaches in progress
+func StartAttachmentPruner() {
+	go func() {
+		ticker := time.NewTicker(attachmentPruneInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			for _, host := range hosts {
+				pruneStaleAttachments(context.Background(), host)
+			}
+		}
+	}()
+}
+
+// pruneStaleAttachments forgets the recorded attachments of the running VMs of a host that are not in their live XML
+// Attachments recorded less than deviceCommandTimeout ago are kept: their attach may have completed after the
+// XML was read
+func pruneStaleAttachments(ctx context.Context, host Host) {
+	vmNames, err := getRunningVMNames(host)
+	if err != nil {
+		log.Printf("Warning: Failed to list running VMs to prune attachments: %v", err)
+		return
+	}
+
+	for _, vmName := range vmNames {
+		vmXML, err := getVMXML(ctx, host, vmName)
+		if err != nil {
+			log.Printf("Warning: Failed to read the XML of %s to prune attachments: %v", vmName, err)
+			continue
+		}
+		devices, err := utils.ParseVMXML(vmXML)
+		if err != nil {
+			log.Printf("Warning: Failed to parse the XML of %s to prune attachments: %v", vmName, err)
+			continue
+		}
+		attached := make(map[string]bool, len(devices))
+		for _, device := range devices {
+			attached[device.VendorID+":"+device.ProductID] = true
+		}
+
+		attachments, err := db.GetAttachments(hostKey(host), vmName)
+		if err != nil {
+			log.Printf("Warning: Failed to get recorded attachments for %s: %v", vmName, err)
+			continue
+		}
+		for _, attachment := range attachments {
+			key := attachment.VendorID + ":" + attachment.ProductID
+			if attached[key] || time.Since(attachment.AttachedAt) < deviceCommandTimeout {
+				continue
+			}
+			log.Printf("Forgetting attachment of %s to %s: no longer attached", key, vmName)
+			if err := db.RemoveAttachment(hostKey(host), vmName, attachment.VendorID, attachment.ProductID); err != nil {
+				log.Printf("Warning: Failed to remove stale attachment %s from %s: %v", key, vmName, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"vfio_usb_passthrough/internals/db"
+	"vfio_usb_passthrough/internals/utils"
+)
+
+// setupTestDB opens an empty SQLite database in a temporary directory
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	t.Setenv(db.DatabaseURLEnv, "")
+	t.Chdir(t.TempDir())
+	if err := db.InitDB(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.DB.Close() })
+}
+
+// fakeVirshScript returns a virsh script for a host running win10 with the given hostdevs in its live XML;
+// other commands succeed without output
+func fakeVirshScript(hostdevs string) string {
+	return `for arg in "$@"; do
+  case "$arg" in
+  list) echo win10; exit 0 ;;
+  dumpxml) echo "<domain><name>win10</name><devices>` + hostdevs + `</devices></domain>"; exit 0 ;;
+  esac
+done
+exit 0
+`
+}
+
+// usbHostdevXML is the live XML of an attached USB device
+func usbHostdevXML(vendorID, productID string) string {
+	return "<hostdev mode='subsystem' type='usb'><source><vendor id='0x" + vendorID + "'/><product id='0x" + productID + "'/></source></hostdev>"
+}
+
+func TestPruneStaleAttachments(t *testing.T) {
+	setupTestDB(t)
+	t.Setenv(utils.VirshBinEnv, fakeCommand(t, fakeVirshScript(usbHostdevXML("046d", "c077"))))
+	host := defaultHost()
+
+	for _, device := range []string{"c077", "c52b", "c534"} {
+		if err := db.RecordAttachment("", "win10", "046d", device, "127.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A VM of the same name on another connection is not compared with this host's XML
+	if err := db.RecordAttachment("lab", "win10", "046d", "c52b", "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	// c52b was detached outside this tool long ago; c534 is an attach that just completed
+	if _, err := db.DB.Exec("UPDATE attachments SET attached_at = datetime('now', '-1 hour') WHERE product_id IN ('c077', 'c52b')"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reading the attached devices must not forget anything
+	if _, err := getAttachedDevicesList(context.Background(), host, "win10"); err != nil {
+		t.Fatal(err)
+	}
+	if attachments, _ := db.GetAttachments("", "win10"); len(attachments) != 3 {
+		t.Fatalf("attachments after a read = %+v, want all 3", attachments)
+	}
+
+	pruneStaleAttachments(context.Background(), host)
+	attachments, err := db.GetAttachments("", "win10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := make(map[string]bool)
+	for _, attachment := range attachments {
+		kept[attachment.ProductID] = true
+	}
+	if len(kept) != 2 || !kept["c077"] || !kept["c534"] {
+		t.Errorf("attachments after pruning = %+v, want c077 (attached) and c534 (recent)", attachments)
+	}
+	if lab, err := db.GetAttachments("lab", "win10"); err != nil || len(lab) != 1 {
+		t.Errorf("attachments of lab after pruning = %+v, %v; want c52b kept", lab, err)
+	}
+}
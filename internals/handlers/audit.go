@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"vfio_usb_passthrough/internals/db"
+	"vfio_usb_passthrough/internals/i18n"
+	"vfio_usb_passthrough/internals/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// auditCSVHeader lists the exported columns, in audit_log table order
+var auditCSVHeader = []string{"id", "timestamp", "action", "vm_name", "vendor_id", "product_id", "client", "success", "error"}
+
+// ExportAudit streams the audit log as CSV (?format=csv, the only supported format)
+// Optional ?from= and ?to= bound the range (RFC 3339 or YYYY-MM-DD; a date-only "to" is inclusive)
+func ExportAudit(c *fiber.Ctx) error {
+	if format := c.Query("format", "csv"); format != "csv" {
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Msg(c, "unsupported_export_format", format),
+		})
+	}
+
+	from, err := parseAuditTime(c.Query("from"), false)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "invalid_date_range"),
+			"details": err.Error(),
+		})
+	}
+	to, err := parseAuditTime(c.Query("to"), true)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "invalid_date_range"),
+			"details": err.Error(),
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="audit.csv"`)
+
+	// Rows are written as they are read; errors past this point can only be logged
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		writer := csv.NewWriter(w)
+		writer.Write(auditCSVHeader)
+
+		err := db.IterateAudit(from, to, func(entry db.AuditEntry) error {
+			writer.Write([]string{
+				strconv.FormatInt(entry.ID, 10),
+				entry.Timestamp.UTC().Format(time.RFC3339),
+				entry.Action,
+				entry.VMName,
+				entry.VendorID,
+				entry.ProductID,
+				entry.Client,
+				strconv.FormatBool(entry.Success),
+				entry.Error,
+			})
+			return writer.Error()
+		})
+		writer.Flush()
+		if err == nil {
+			err = writer.Error()
+		}
+		if err != nil {
+			log.Printf("Error exporting audit log: %v", err)
+		}
+	})
+
+	return nil
+}
+
+// parseAuditTime parses an RFC 3339 timestamp or a YYYY-MM-DD date (UTC); empty means unbounded
+// With endOfDay, a date-only value covers the whole day
+func parseAuditTime(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q (expected RFC 3339 or YYYY-MM-DD)", value)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// Device history size: default and maximum number of events returned
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+// GetDeviceHistory returns recent attach/detach events of a device across VMs, newest first
+// lastAttached is the latest successful attach among the returned events, or null
+func GetDeviceHistory(c *fiber.Ctx) error {
+	vendorID := normalizeDeviceID(c.Params("vendorId"))
+	productID := normalizeDeviceID(c.Params("productId"))
+	if !utils.IsValidHexID(vendorID) || !utils.IsValidHexID(productID) {
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Msg(c, "invalid_device_id"),
+		})
+	}
+
+	limit := c.QueryInt("limit", defaultHistoryLimit)
+	if limit <= 0 || limit > maxHistoryLimit {
+		limit = defaultHistoryLimit
+	}
+
+	events, err := db.GetDeviceAudit(vendorID, productID, limit)
+	if err != nil {
+		log.Printf("Error getting history of %s:%s: %v", vendorID, productID, err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "get_device_history_failed"),
+			"details": err.Error(),
+		})
+	}
+	if events == nil {
+		events = []db.AuditEntry{}
+	}
+
+	var lastAttached *db.AuditEntry
+	for i := range events {
+		if events[i].Action == "attach" && events[i].Success {
+			lastAttached = &events[i]
+			break
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"vendorId":     vendorID,
+		"productId":    productID,
+		"events":       events,
+		"lastAttached": lastAttached,
+	})
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"vfio_usb_passthrough/internals/i18n"
+	"vfio_usb_passthrough/internals/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// CanAttachResponse is the advisory result of an attach capability probe
+type CanAttachResponse struct {
+	CanAttach bool     `json:"canAttach"`
+	Reasons   []string `json:"reasons"`
+}
+
+// CanAttachDevice checks whether a device could be attached to a VM without attaching it
+// Checks: VM running, device connected to the host, device not attached to any running VM,
+// free USB port on the VM (when the port count is declared in its XML)
+func CanAttachDevice(c *fiber.Ctx) error {
+	host := hostFromCtx(c)
+
+	vendorID := normalizeDeviceID(c.Query("vendorId"))
+	productID := normalizeDeviceID(c.Query("productId"))
+	if vendorID == "" || productID == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Msg(c, "ids_required"),
+		})
+	}
+	if !utils.IsValidHexID(vendorID) || !utils.IsValidHexID(productID) {
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Msg(c, "invalid_device_id"),
+		})
+	}
+
+	response := CanAttachResponse{Reasons: []string{}}
+
+	// VM must be running; the remaining VM checks are meaningless otherwise
+	vmName, vmErr := resolveVMName(host, c.Params("vmName"))
+	if vmErr != nil {
+		response.Reasons = append(response.Reasons, i18n.Localize(c, vmErr))
+	}
+
+	// Device must be connected to the host
+	devices, err := hostUSBDevices(host)
+	if err != nil {
+		log.Printf("CanAttachDevice: failed to list USB devices: %v", err)
+		response.Reasons = append(response.Reasons, i18n.Msg(c, "list_usb_devices_failed"))
+	} else if !containsDevice(devices, vendorID, productID) {
+		response.Reasons = append(response.Reasons, i18n.Msg(c, "device_not_connected"))
+	}
+
+	// Device must not be attached to any running VM
+	runningVMs, err := getRunningVMNames(host)
+	if err != nil {
+		log.Printf("CanAttachDevice: failed to list running VMs: %v", err)
+		response.Reasons = append(response.Reasons, i18n.Msg(c, "list_vms_failed"))
+	}
+	for _, owner := range findDeviceOwners(c.UserContext(), host, runningVMs, vendorID, productID) {
+		if owner == vmName {
+			response.Reasons = append(response.Reasons, i18n.Msg(c, "device_already_attached", vmName))
+		} else {
+			response.Reasons = append(response.Reasons, i18n.Msg(c, "device_attached_elsewhere", owner))
+		}
+	}
+
+	// VM must have a free USB port, when we can tell
+	if vmErr == nil {
+		vmXML, err := getVMXML(c.UserContext(), host, vmName)
+		if err != nil {
+			log.Printf("CanAttachDevice: failed to get XML for %s: %v", vmName, err)
+			response.Reasons = append(response.Reasons, i18n.Msg(c, "get_attached_devices_failed", vmName))
+		} else if free, known, err := utils.CountFreeUSBPorts(vmXML); err != nil {
+			log.Printf("CanAttachDevice: failed to count USB ports for %s: %v", vmName, err)
+		} else if known && free <= 0 {
+			response.Reasons = append(response.Reasons, i18n.Msg(c, "no_free_usb_port", vmName))
+		}
+	}
+
+	response.CanAttach = len(response.Reasons) == 0
+	return c.JSON(response)
+}
+
+// containsDevice checks if a device list contains the given vendor and product IDs
+func containsDevice(devices []USBDeviceResponse, vendorID, productID string) bool {
+	for _, device := range devices {
+		if device.VendorID == vendorID && device.ProductID == productID {
+			return true
+		}
+	}
+	return false
+}
+
+// findDeviceOwners returns the VMs (among vmNames) that have the device attached
+// VMs whose XML cannot be read are skipped
+func findDeviceOwners(ctx context.Context, host Host, vmNames []string, vendorID, productID string) []string {
+	attachedTo := make([]bool, len(vmNames))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentVMQueries)
+	for i, vmName := range vmNames {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			attached, err := getAttachedDevicesList(ctx, host, vmName)
+			if err != nil {
+				log.Printf("Warning: Failed to get attached devices for %s: %v", vmName, err)
+				return
+			}
+			for _, device := range attached {
+				if device.VendorID == vendorID && device.ProductID == productID {
+					attachedTo[i] = true
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var owners []string
+	for i, vmName := range vmNames {
+		if attachedTo[i] {
+			owners = append(owners, vmName)
+		}
+	}
+	return owners
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"vfio_usb_passthrough/internals/middleware"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetCapabilities reports the optional features available with the runtime configuration,
+// so the frontend can hide controls for unsupported ones
+// PCI passthrough, VM power control, persistent attach and read-only mode are not implemented
+func GetCapabilities(c *fiber.Ctx) error {
+	flags := featureFlags()
+	return c.JSON(fiber.Map{
+		"pci_supported":         false,
+		"power_control_enabled": false,
+		"auth_enabled":          middleware.BasicAuthEnabled(),
+		"persistent_attach":     false,
+		"read_only":             false,
+		"multi_host":            flags["multiHost"],
+		"remote_usb":            flags["remoteUSB"],
+		"mqtt_enabled":          flags["mqtt"],
+		"webhooks_enabled":      flags["webhooks"],
+	})
+}
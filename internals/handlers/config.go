@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"vfio_usb_passthrough/internals/db"
+	"vfio_usb_passthrough/internals/middleware"
+	"vfio_usb_passthrough/internals/tracing"
+	"vfio_usb_passthrough/internals/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ServerConfig holds the settings main.go resolves at startup, for diagnostics
+type ServerConfig struct {
+	BindAddr         string
+	RateLimitMax     int
+	RateLimitWindow  time.Duration
+	RateLimitStorage string
+}
+
+// configEnvVars are the environment variables reported by GetEffectiveConfig when set
+var configEnvVars = []string{
+	"ENV", "BIND_PORT", "BIND_INTERFACE",
+	"ALLOWED_NETWORKS", middleware.AllowedNetworksFileEnv, "IP_FILTER_EXEMPT_PATHS", middleware.NetworkRefreshIntervalEnv,
+	"CORS_ORIGINS", "REDIS_URL", "JWT_SECRET", "API_TOKEN", middleware.BasicAuthUserEnv, middleware.BasicAuthPassEnv,
+	LibvirtHostsEnv, USBSSHHostsEnv, ReconcileIntervalEnv, FavoritesEnrichIntervalEnv, "DETACH_ON_SHUTDOWN",
+	"WEBHOOK_URL", "WEBHOOK_SECRET", "WEBHOOK_FORMAT",
+	"MQTT_BROKER", "MQTT_TOPIC_PREFIX", "MQTT_CLIENT_ID", "MQTT_USERNAME", "MQTT_PASSWORD",
+	"AUDIT_RETENTION_DAYS", "USB_IDS_PATH", LogDeviceSerialsEnv, utils.VirshBinEnv, utils.LsusbBinEnv,
+	USBHideIDsEnv, USBHideClassesEnv, EventLogSizeEnv, db.DatabaseURLEnv, "GRPC_PORT", tracing.OTLPEndpointEnv,
+	AssetCacheEnv, middleware.LogFormatEnv, WaitForLibvirtEnv, WaitForLibvirtOnTimeoutEnv,
+	MaxDevicesPerVMEnv, DeviceNamesFileEnv, ThemeCookieSameSiteEnv, ThemeCookieSecureEnv, DevicesPollIntervalEnv,
+}
+
+// ConfigEnvVars returns the names of the environment variables that configure the server
+func ConfigEnvVars() []string {
+	return append([]string(nil), configEnvVars...)
+}
+
+// redactedValue replaces secret values in the effective configuration
+const redactedValue = "[redacted]"
+
+// GetEffectiveConfig returns a handler describing the configuration in effect: bind address,
+// allowlist, libvirt connections, rate limiting and the environment variables that are set
+// Secret values are redacted
+func GetEffectiveConfig(ipFilter *middleware.IPFilter, config ServerConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		networks := []string{}
+		for _, network := range ipFilter.Networks() {
+			networks = append(networks, network.String())
+		}
+
+		env := fiber.Map{}
+		for _, name := range configEnvVars {
+			if value, ok := os.LookupEnv(name); ok {
+				env[name] = redactEnvValue(name, value)
+			}
+		}
+
+		return c.JSON(fiber.Map{
+			"bindAddr": config.BindAddr,
+			"ipFilter": fiber.Map{
+				"allowedNetworks": networks,
+				"exemptPaths":     ipFilter.ExemptPaths(),
+			},
+			"libvirt": fiber.Map{
+				"hosts":   hosts,
+				"default": defaultHost().Name,
+			},
+			"rateLimit": fiber.Map{
+				"max":     config.RateLimitMax,
+				"window":  config.RateLimitWindow.String(),
+				"storage": config.RateLimitStorage,
+			},
+			"env": env,
+		})
+	}
+}
+
+// redactEnvValue hides secrets: credentials are fully redacted, and URLs keep only their
+// scheme and host (webhook URLs carry their token in the path, broker URLs a password)
+func redactEnvValue(name, value string) string {
+	upper := strings.ToUpper(name)
+	for _, marker := range []string{"SECRET", "PASS", "TOKEN", "KEY"} {
+		if strings.Contains(upper, marker) {
+			return redactedValue
+		}
+	}
+
+	if value != "" && (strings.HasSuffix(upper, "_URL") || strings.Contains(value, "://")) {
+		parsed, err := url.Parse(value)
+		if err != nil || parsed.Host == "" {
+			return redactedValue
+		}
+		if parsed.User != nil || parsed.Path != "" || parsed.RawQuery != "" {
+			return parsed.Scheme + "://" + parsed.Host + "/" + redactedValue
+		}
+	}
+	return value
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"strings"
+
+	"vfio_usb_passthrough/internals/i18n"
+	"vfio_usb_passthrough/internals/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ConfigDiffResponse lists the USB devices of a VM by where they are defined
+// LiveOnly devices are gone after a restart; ConfigOnly devices are only attached on the next boot.
+// Transient VMs have no persistent configuration, so all their devices are live-only
+type ConfigDiffResponse struct {
+	VM         string            `json:"vm"`
+	Persistent bool              `json:"persistent"`
+	LiveOnly   []utils.USBDevice `json:"liveOnly"`
+	ConfigOnly []utils.USBDevice `json:"configOnly"`
+	Both       []utils.USBDevice `json:"both"`
+}
+
+// GetConfigDiff compares the USB devices of a VM's live and persistent configurations
+func GetConfigDiff(c *fiber.Ctx) error {
+	host := hostFromCtx(c)
+
+	// Validate VM name (or resolve a VM UUID to its name)
+	vmName, err := resolveVMName(host, c.Params("vmName"))
+	if err != nil {
+		log.Printf("GetConfigDiff: VM validation failed for '%s': %v", c.Params("vmName"), err)
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Localize(c, err),
+		})
+	}
+
+	diff, err := getConfigDiff(c.UserContext(), host, vmName)
+	if err != nil {
+		log.Printf("Error comparing live and persistent configuration of %s: %v", vmName, err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "get_config_diff_failed", vmName),
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(diff)
+}
+
+// getConfigDiff reads both configurations of a VM and splits its USB devices between them
+func getConfigDiff(ctx context.Context, host Host, vmName string) (ConfigDiffResponse, error) {
+	diff := ConfigDiffResponse{
+		VM:         vmName,
+		LiveOnly:   []utils.USBDevice{},
+		ConfigOnly: []utils.USBDevice{},
+		Both:       []utils.USBDevice{},
+	}
+
+	liveXML, err := getVMXML(ctx, host, vmName)
+	if err != nil {
+		return diff, err
+	}
+	live, err := utils.ParseVMXML(liveXML)
+	if err != nil {
+		return diff, err
+	}
+
+	persistent, err := isVMPersistent(ctx, host, vmName)
+	if err != nil {
+		return diff, err
+	}
+	diff.Persistent = persistent
+	if !persistent {
+		diff.LiveOnly = append(diff.LiveOnly, live...)
+		return diff, nil
+	}
+
+	output, err := virshCommand(ctx, host, "dumpxml", "--inactive", vmName).Output()
+	if err != nil {
+		return diff, err
+	}
+	config, err := utils.ParseVMXML(string(output))
+	if err != nil {
+		return diff, err
+	}
+
+	// Devices are matched by vendor:product, counting duplicates: the persistent configuration
+	// usually has no host address, which libvirt only fills in on attach
+	configCounts := make(map[string]int)
+	for _, device := range config {
+		configCounts[device.VendorID+":"+device.ProductID]++
+	}
+	for _, device := range live {
+		key := device.VendorID + ":" + device.ProductID
+		if configCounts[key] > 0 {
+			configCounts[key]--
+			diff.Both = append(diff.Both, device)
+		} else {
+			diff.LiveOnly = append(diff.LiveOnly, device)
+		}
+	}
+	for _, device := range config {
+		key := device.VendorID + ":" + device.ProductID
+		if configCounts[key] > 0 {
+			configCounts[key]--
+			diff.ConfigOnly = append(diff.ConfigOnly, device)
+		}
+	}
+
+	return diff, nil
+}
+
+// isVMPersistent reports whether a VM has a persistent configuration, from virsh dominfo
+func isVMPersistent(ctx context.Context, host Host, vmName string) (bool, error) {
+	output, err := virshCommand(ctx, host, "dominfo", vmName).Output()
+	if err != nil {
+		return false, err
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		name, value, found := strings.Cut(scanner.Text(), ":")
+		if found && strings.TrimSpace(name) == "Persistent" {
+			return strings.TrimSpace(value) == "yes", nil
+		}
+	}
+	// Assume a persistent VM when virsh does not report the field
+	return true, nil
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// MaxDevicesPerVMEnv caps the USB devices attached to a VM (0 or unset means no limit)
+const MaxDevicesPerVMEnv = "MAX_DEVICES_PER_VM"
+
+// maxDevicesPerVM is the limit set from MAX_DEVICES_PER_VM, 0 for none
+var maxDevicesPerVM int
+
+// vmAttachLocks serializes counting a VM's devices against the limit and attaching to it, so
+// concurrent attaches cannot all pass the check for the last free slot
+var vmAttachLocks = struct {
+	sync.Mutex
+	locks map[trackedVM]*sync.Mutex
+}{locks: make(map[trackedVM]*sync.Mutex)}
+
+// SetMaxDevicesPerVM sets the maximum number of USB devices attached to a VM (0 for no limit)
+func SetMaxDevicesPerVM(limit int) {
+	maxDevicesPerVM = limit
+}
+
+// DeviceLimitError is returned when attaching would take a VM over MAX_DEVICES_PER_VM
+type DeviceLimitError struct {
+	VMName string
+	Limit  int
+	// Current is the number of USB devices attached to the VM that stay attached, Adding the number to attach
+	Current int
+	Adding  int
+}
+
+func (e *DeviceLimitError) Error() string {
+	return fmt.Sprintf("attaching %d device(s) to %s would exceed the limit of %d (%d attached)",
+		e.Adding, e.VMName, e.Limit, e.Current)
+}
+
+// checkDeviceLimit returns a DeviceLimitError if a VM with current devices cannot take adding more
+func checkDeviceLimit(vmName string, current, adding int) error {
+	if maxDevicesPerVM <= 0 || adding == 0 || current+adding <= maxDevicesPerVM {
+		return nil
+	}
+	return &DeviceLimitError{VMName: vmName, Limit: maxDevicesPerVM, Current: current, Adding: adding}
+}
+
+// lockVMAttach takes the attach lock of a VM and returns the function releasing it
+// Without a limit nothing is counted, so nothing is locked
+func lockVMAttach(host Host, vmName string) (unlock func()) {
+	if maxDevicesPerVM <= 0 {
+		return func() {}
+	}
+
+	vmAttachLocks.Lock()
+	vm := trackedVM{Host: host, VMName: vmName}
+	lock := vmAttachLocks.locks[vm]
+	if lock == nil {
+		lock = &sync.Mutex{}
+		vmAttachLocks.locks[vm] = lock
+	}
+	vmAttachLocks.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// checkAttachLimit counts the USB devices attached to a VM and checks that one more fits the limit
+// Without a limit nothing is read. Callers hold the VM's attach lock (lockVMAttach) until the attach is done
+func checkAttachLimit(ctx context.Context, host Host, vmName string) error {
+	if maxDevicesPerVM <= 0 {
+		return nil
+	}
+	attached, err := getAttachedDevicesList(ctx, host, vmName)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errReadAttachedDevices, err)
+	}
+	return checkDeviceLimit(vmName, len(attached), 1)
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"vfio_usb_passthrough/internals/utils"
+)
+
+func TestCheckDeviceLimit(t *testing.T) {
+	old := maxDevicesPerVM
+	t.Cleanup(func() { maxDevicesPerVM = old })
+
+	maxDevicesPerVM = 0
+	if err := checkDeviceLimit("win10", 500, 10); err != nil {
+		t.Errorf("no limit: checkDeviceLimit() = %v, want nil", err)
+	}
+
+	maxDevicesPerVM = 4
+	if err := checkDeviceLimit("win10", 3, 1); err != nil {
+		t.Errorf("up to the limit: checkDeviceLimit() = %v, want nil", err)
+	}
+	if err := checkDeviceLimit("win10", 6, 0); err != nil {
+		t.Errorf("nothing to attach: checkDeviceLimit() = %v, want nil", err)
+	}
+
+	err := checkDeviceLimit("win10", 3, 2)
+	var limitErr *DeviceLimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("over the limit: checkDeviceLimit() = %v, want a DeviceLimitError", err)
+	}
+	if limitErr.Limit != 4 || limitErr.Current != 3 || limitErr.Adding != 2 {
+		t.Errorf("error = %+v, want limit 4, current 3, adding 2", limitErr)
+	}
+}
+
+func TestConcurrentAttachesRespectLimit(t *testing.T) {
+	setupTestDB(t)
+	old := maxDevicesPerVM
+	t.Cleanup(func() { maxDevicesPerVM = old })
+	maxDevicesPerVM = 2
+
+	// win10 has as many devices as attach-device calls so far; attaching is slow enough for
+	// unserialized attaches to all read the VM before any of them completes
+	count := filepath.Join(t.TempDir(), "count")
+	t.Setenv(utils.VirshBinEnv, fakeCommand(t, `for arg in "$@"; do
+  case "$arg" in
+  dumpxml)
+    n=$(cat `+count+` 2>/dev/null || echo 0)
+    printf "<domain><name>win10</name><devices>"
+    i=0
+    while [ $i -lt $n ]; do
+      printf "<hostdev mode='subsystem' type='usb'><source><vendor id='0x1234'/><product id='0x%04x'/></source></hostdev>" $i
+      i=$((i + 1))
+    done
+    echo "</devices></domain>"
+    exit 0 ;;
+  attach-device)
+    sleep 0.1
+    n=$(cat `+count+` 2>/dev/null || echo 0)
+    echo $((n + 1)) > `+count+`
+    echo "Device attached successfully"
+    exit 0 ;;
+  esac
+done
+exit 0
+`))
+
+	const attempts = 5
+	errs := make([]error, attempts)
+	var wg sync.WaitGroup
+	for i := range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := AttachDetachRequest{VendorID: "abcd", ProductID: fmt.Sprintf("%04x", i)}
+			_, errs[i] = Devices.Attach(context.Background(), defaultHost(), "win10", req, AttachOptions{Force: true})
+		}()
+	}
+	wg.Wait()
+
+	attached, limited := 0, 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			attached++
+		case errors.As(err, new(*DeviceLimitError)):
+			limited++
+		default:
+			t.Errorf("Attach() = %v, want success or a DeviceLimitError", err)
+		}
+	}
+	if attached != maxDevicesPerVM || limited != attempts-maxDevicesPerVM {
+		t.Errorf("%d attached and %d refused, want %d and %d", attached, limited, maxDevicesPerVM, attempts-maxDevicesPerVM)
+	}
+}
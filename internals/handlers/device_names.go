@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"vfio_usb_passthrough/internals/utils"
+)
+
+// DeviceNamesFileEnv is a JSON file mapping "vendor:product" to a friendly device name,
+// shown instead of the lsusb/usb.ids description (e.g. {"b58e:9e84": "Blue Yeti Mic"})
+const DeviceNamesFileEnv = "DEVICE_NAMES_FILE"
+
+// deviceNameOverrides holds the names of DEVICE_NAMES_FILE, reloaded when the file's mtime changes
+var deviceNameOverrides struct {
+	sync.Mutex
+	path    string
+	modTime time.Time
+	names   map[string]string
+}
+
+// LoadDeviceNames reads DEVICE_NAMES_FILE, if set; later changes to the file are picked up on the fly
+func LoadDeviceNames() error {
+	path := strings.TrimSpace(os.Getenv(DeviceNamesFileEnv))
+
+	deviceNameOverrides.Lock()
+	defer deviceNameOverrides.Unlock()
+	deviceNameOverrides.path = path
+	deviceNameOverrides.modTime = time.Time{}
+	deviceNameOverrides.names = nil
+	if path == "" {
+		return nil
+	}
+	if err := reloadDeviceNames(); err != nil {
+		return fmt.Errorf("invalid %s: %w", DeviceNamesFileEnv, err)
+	}
+	log.Printf("Loaded %d device name(s) from %s", len(deviceNameOverrides.names), path)
+	return nil
+}
+
+// reloadDeviceNames re-reads the names file if it changed since it was last read
+// The caller holds the lock; on error the previous names are kept
+func reloadDeviceNames() error {
+	info, err := os.Stat(deviceNameOverrides.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(deviceNameOverrides.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(deviceNameOverrides.path)
+	if err != nil {
+		return err
+	}
+	names, err := parseDeviceNames(data)
+	if err != nil {
+		return err
+	}
+	deviceNameOverrides.names = names
+	deviceNameOverrides.modTime = info.ModTime()
+	return nil
+}
+
+// parseDeviceNames parses a names file, normalizing its "vendor:product" keys
+func parseDeviceNames(data []byte) (map[string]string, error) {
+	var raw map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]string, len(raw))
+	for key, name := range raw {
+		vendorID, productID, found := strings.Cut(strings.TrimSpace(key), ":")
+		if !found || !utils.IsValidHexID(vendorID) || !utils.IsValidHexID(productID) {
+			return nil, fmt.Errorf("invalid device %q: expected vendor:product with 4-digit hex IDs", key)
+		}
+		if name = strings.TrimSpace(name); name == "" {
+			return nil, fmt.Errorf("empty name for device %q", key)
+		}
+		names[normalizeDeviceID(vendorID)+":"+normalizeDeviceID(productID)] = name
+	}
+	return names, nil
+}
+
+// applyDeviceNames returns the devices with the descriptions of DEVICE_NAMES_FILE, leaving devices intact
+// (remote lists are shared with the cache)
+func applyDeviceNames(devices []USBDeviceResponse) []USBDeviceResponse {
+	deviceNameOverrides.Lock()
+	if deviceNameOverrides.path != "" {
+		if err := reloadDeviceNames(); err != nil {
+			log.Printf("Warning: Failed to reload %s, keeping the previous names: %v", deviceNameOverrides.path, err)
+		}
+	}
+	names := deviceNameOverrides.names
+	deviceNameOverrides.Unlock()
+
+	if len(names) == 0 {
+		return devices
+	}
+	named := make([]USBDeviceResponse, len(devices))
+	copy(named, devices)
+	for i := range named {
+		if name, ok := names[named[i].VendorID+":"+named[i].ProductID]; ok {
+			named[i].Description = name
+		}
+	}
+	return named
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDeviceNames(t *testing.T) {
+	names, err := parseDeviceNames([]byte(`{"B58E:9E84": " Blue Yeti Mic ", "0x05e3:0x0610": "Desk hub"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"b58e:9e84": "Blue Yeti Mic", "05e3:0610": "Desk hub"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("parseDeviceNames() = %v, want %v", names, want)
+	}
+
+	for _, data := range []string{`[]`, `{"b58e": "Mic"}`, `{"b58e:9e8": "Mic"}`, `{"b58e:9e84": " "}`, `{"b58e:9e84": 1}`} {
+		if _, err := parseDeviceNames([]byte(data)); err == nil {
+			t.Errorf("parseDeviceNames(%s) = nil error, want an error", data)
+		}
+	}
+}
+
+func TestApplyDeviceNamesReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "names.json")
+	writeFile(t, path, `{"b58e:9e84": "Blue Yeti Mic"}`)
+	t.Setenv(DeviceNamesFileEnv, path)
+	if err := LoadDeviceNames(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Unsetenv(DeviceNamesFileEnv)
+		LoadDeviceNames()
+	})
+
+	devices := []USBDeviceResponse{
+		{VendorID: "b58e", ProductID: "9e84", Description: "Blue Microphones Yeti Stereo Microphone"},
+		{VendorID: "046d", ProductID: "c077", Description: "Logitech, Inc. Mouse"},
+	}
+	named := applyDeviceNames(devices)
+	if named[0].Description != "Blue Yeti Mic" || named[1].Description != "Logitech, Inc. Mouse" {
+		t.Errorf("applyDeviceNames() = %+v, want the mic renamed only", named)
+	}
+	if devices[0].Description != "Blue Microphones Yeti Stereo Microphone" {
+		t.Error("applyDeviceNames() modified its argument")
+	}
+
+	// A changed file is reloaded; a broken one keeps the previous names
+	writeFile(t, path, `{"046d:c077": "Work mouse"}`)
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if named := applyDeviceNames(devices); named[0].Description != devices[0].Description || named[1].Description != "Work mouse" {
+		t.Errorf("after reload applyDeviceNames() = %+v, want the mouse renamed only", named)
+	}
+
+	writeFile(t, path, `{`)
+	later = later.Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if named := applyDeviceNames(devices); named[1].Description != "Work mouse" {
+		t.Errorf("after a broken reload applyDeviceNames() = %+v, want the previous names", named)
+	}
+}
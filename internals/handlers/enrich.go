@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"vfio_usb_passthrough/internals/db"
+	"vfio_usb_passthrough/internals/i18n"
+	"vfio_usb_passthrough/internals/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// FavoritesEnrichIntervalEnv sets how often missing favorite descriptions are filled in (unset disables)
+const FavoritesEnrichIntervalEnv = "FAVORITES_ENRICH_INTERVAL"
+
+// EnrichResult reports a favorites enrichment run
+type EnrichResult struct {
+	Enriched  int `json:"enriched"`
+	Remaining int `json:"remaining"`
+}
+
+// EnrichFavorites fills in missing favorite descriptions from connected devices and usb.ids
+func EnrichFavorites(c *fiber.Ctx) error {
+	result, err := enrichFavorites()
+	if err != nil {
+		log.Printf("Error enriching favorites: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "enrich_favorites_failed"),
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(result)
+}
+
+// enrichFavorites sets a description on every favorite without one, preferring the name
+// lsusb reports for a connected device over the usb.ids entry
+func enrichFavorites() (EnrichResult, error) {
+	var result EnrichResult
+
+	favorites, err := db.GetAllFavorites()
+	if err != nil {
+		return result, err
+	}
+
+	// Connected devices are optional: usb.ids still covers disconnected ones
+	connected := make(map[string]string)
+	if devices, err := getUSBDevicesList(); err == nil {
+		for _, device := range devices {
+			connected[device.VendorID+":"+device.ProductID] = device.Description
+		}
+	} else {
+		log.Printf("Favorites enrichment: Warning - failed to list USB devices: %v", err)
+	}
+
+	for _, favorite := range favorites {
+		if strings.TrimSpace(favorite.Description) != "" {
+			continue
+		}
+
+		description := connected[favorite.VendorID+":"+favorite.ProductID]
+		if description == "" {
+			description, _ = utils.LookupUSBName(favorite.VendorID, favorite.ProductID)
+		}
+		if description == "" {
+			result.Remaining++
+			continue
+		}
+
+		filled, err := db.FillFavoriteDescription(favorite.VendorID, favorite.ProductID, description)
+		if err != nil {
+			return result, fmt.Errorf("failed to update %s:%s: %w", favorite.VendorID, favorite.ProductID, err)
+		}
+		if filled {
+			result.Enriched++
+		}
+	}
+
+	if result.Enriched > 0 || result.Remaining > 0 {
+		log.Printf("Favorites enrichment: %d description(s) filled in, %d still unknown", result.Enriched, result.Remaining)
+	}
+	return result, nil
+}
+
+// StartFavoritesEnricher fills in missing favorite descriptions periodically
+func StartFavoritesEnricher(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	log.Printf("Enriching favorite descriptions every %s", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if _, err := enrichFavorites(); err != nil {
+				log.Printf("Error enriching favorites: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// EventLogSizeEnv sets how many recent events are kept in memory
+const EventLogSizeEnv = "EVENT_LOG_SIZE"
+
+// defaultEventLogSize is the number of recent events kept when EVENT_LOG_SIZE is unset or 0
+const defaultEventLogSize = 100
+
+// RecentEvent is an action recorded in the in-memory event log
+type RecentEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	Action    string    `json:"action"`
+	VM        string    `json:"vm"`
+	VendorID  string    `json:"vendorId"`
+	ProductID string    `json:"productId"`
+	Client    string    `json:"client,omitempty"`
+	Success   bool      `json:"success"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// eventRing keeps the last events in a fixed-size ring buffer
+type eventRing struct {
+	mu     sync.Mutex
+	events []RecentEvent
+	next   int  // index of the next write
+	full   bool // whether the buffer has wrapped
+}
+
+// newEventRing returns an empty ring buffer holding up to size events
+func newEventRing(size int) *eventRing {
+	return &eventRing{events: make([]RecentEvent, size)}
+}
+
+// add records an event, overwriting the oldest one when the buffer is full
+func (r *eventRing) add(event RecentEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events[r.next] = event
+	r.next = (r.next + 1) % len(r.events)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// recent returns up to limit events, newest first (all of them if limit <= 0)
+func (r *eventRing) recent(limit int) []RecentEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := r.next
+	if r.full {
+		count = len(r.events)
+	}
+	if limit > 0 && limit < count {
+		count = limit
+	}
+
+	events := make([]RecentEvent, 0, count)
+	for i := 1; i <= count; i++ {
+		events = append(events, r.events[(r.next-i+len(r.events))%len(r.events)])
+	}
+	return events
+}
+
+// eventLog holds the recent events, resized by SetEventLogSize
+var eventLog = newEventRing(defaultEventLogSize)
+
+// SetEventLogSize sets how many recent events are kept (defaultEventLogSize if size is 0)
+// Events recorded before the call are dropped
+func SetEventLogSize(size int) {
+	if size <= 0 {
+		size = defaultEventLogSize
+	}
+	eventLog = newEventRing(size)
+}
+
+// recordEvent appends an action to the in-memory event log
+func recordEvent(action, vmName, vendorID, productID, client, errMsg string) {
+	eventLog.add(RecentEvent{
+		Timestamp: time.Now().UTC(),
+		Action:    action,
+		VM:        vmName,
+		VendorID:  vendorID,
+		ProductID: productID,
+		Client:    client,
+		Success:   errMsg == "",
+		Error:     strings.TrimSpace(errMsg),
+	})
+}
+
+// GetRecentEvents returns the recent attach and detach events kept in memory, newest first
+// Optional ?limit= returns only the latest ones. Unlike the audit log, events are lost on restart
+func GetRecentEvents(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"events": eventLog.recent(c.QueryInt("limit")),
+	})
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// eventVMs returns the VM names of events, to compare their order
+func eventVMs(events []RecentEvent) []string {
+	names := []string{}
+	for _, event := range events {
+		names = append(names, event.VM)
+	}
+	return names
+}
+
+func TestEventRingRecent(t *testing.T) {
+	ring := newEventRing(3)
+	if got := ring.recent(0); len(got) != 0 {
+		t.Fatalf("recent() on an empty ring = %v", got)
+	}
+
+	ring.add(RecentEvent{VM: "vm1"})
+	ring.add(RecentEvent{VM: "vm2"})
+	if got, want := eventVMs(ring.recent(0)), []string{"vm2", "vm1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("recent() = %v, want %v", got, want)
+	}
+
+	// The oldest events are overwritten once the ring is full
+	ring.add(RecentEvent{VM: "vm3"})
+	ring.add(RecentEvent{VM: "vm4"})
+	ring.add(RecentEvent{VM: "vm5"})
+	if got, want := eventVMs(ring.recent(0)), []string{"vm5", "vm4", "vm3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("recent() = %v, want %v", got, want)
+	}
+	if got, want := eventVMs(ring.recent(2)), []string{"vm5", "vm4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("recent(2) = %v, want %v", got, want)
+	}
+}
+
+func TestEventRingConcurrent(t *testing.T) {
+	ring := newEventRing(50)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				ring.add(RecentEvent{VM: strconv.Itoa(i)})
+				ring.recent(10)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if got := len(ring.recent(0)); got != 50 {
+		t.Errorf("len(recent()) = %d, want 50", got)
+	}
+}
@@ -1,7 +1,11 @@
 package handlers
 
 import (
+	"errors"
+	"strings"
+
 	"vfio_usb_passthrough/internals/db"
+	"vfio_usb_passthrough/internals/i18n"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -11,10 +15,13 @@ func GetFavorites(c *fiber.Ctx) error {
 	favorites, err := db.GetAllFavorites()
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
-			"error":   "Failed to get favorites",
+			"error":   i18n.Msg(c, "get_favorites_failed"),
 			"details": err.Error(),
 		})
 	}
+	if favorites == nil {
+		favorites = []db.FavoriteDevice{}
+	}
 
 	return c.JSON(fiber.Map{
 		"favorites": favorites,
@@ -23,9 +30,10 @@ func GetFavorites(c *fiber.Ctx) error {
 
 // AddFavoriteRequest represents a request to add a favorite
 type AddFavoriteRequest struct {
-	VendorID    string `json:"vendorId"`
-	ProductID   string `json:"productId"`
+	VendorID    string `json:"vendorId" validate:"required,usbid"`
+	ProductID   string `json:"productId" validate:"required,usbid"`
 	Description string `json:"description"`
+	Notes       string `json:"notes"`
 }
 
 // AddFavorite adds a device to favorites
@@ -33,35 +41,131 @@ func AddFavorite(c *fiber.Ctx) error {
 	var req AddFavoriteRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(400).JSON(fiber.Map{
-			"error":   "Invalid request body",
+			"error":   i18n.Msg(c, "invalid_request_body"),
 			"details": err.Error(),
 		})
 	}
 
-	if req.VendorID == "" || req.ProductID == "" {
+	if reqErr := validateRequest(c, &req); reqErr != nil {
+		return reqErr.send(c)
+	}
+
+	err := db.AddFavorite(req.VendorID, req.ProductID, req.Description, strings.TrimSpace(req.Notes))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "add_favorite_failed"),
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": i18n.Msg(c, "favorite_added"),
+	})
+}
+
+// EnsureFavoriteRequest represents a request to make sure a device is a favorite with a description
+type EnsureFavoriteRequest struct {
+	VendorID    string `json:"vendorId" validate:"required,usbid"`
+	ProductID   string `json:"productId" validate:"required,usbid"`
+	Description string `json:"description" validate:"max=255"`
+}
+
+// EnsureFavorite creates a favorite, or sets the description of an existing one, for provisioning scripts
+// The response tells whether the favorite was created or updated; notes of an existing favorite are kept
+func EnsureFavorite(c *fiber.Ctx) error {
+	var req EnsureFavoriteRequest
+	if err := c.BodyParser(&req); err != nil {
 		return c.Status(400).JSON(fiber.Map{
-			"error": "vendorId and productId are required",
+			"error":   i18n.Msg(c, "invalid_request_body"),
+			"details": err.Error(),
 		})
 	}
 
-	err := db.AddFavorite(req.VendorID, req.ProductID, req.Description)
+	if reqErr := validateRequest(c, &req); reqErr != nil {
+		return reqErr.send(c)
+	}
+
+	vendorID := normalizeDeviceID(req.VendorID)
+	productID := normalizeDeviceID(req.ProductID)
+
+	created, err := db.EnsureFavorite(vendorID, productID, strings.TrimSpace(req.Description))
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
-			"error":   "Failed to add favorite",
+			"error":   i18n.Msg(c, "add_favorite_failed"),
 			"details": err.Error(),
 		})
 	}
 
+	if created {
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"success": true,
+			"created": true,
+			"message": i18n.Msg(c, "favorite_added"),
+		})
+	}
 	return c.JSON(fiber.Map{
 		"success": true,
-		"message": "Device added to favorites",
+		"created": false,
+		"message": i18n.Msg(c, "favorite_updated"),
+	})
+}
+
+// UpdateFavoriteRequest represents a request to update a favorite's description and/or notes
+// Omitted fields are left unchanged
+type UpdateFavoriteRequest struct {
+	VendorID    string  `json:"vendorId" validate:"required,usbid"`
+	ProductID   string  `json:"productId" validate:"required,usbid"`
+	Description *string `json:"description"`
+	Notes       *string `json:"notes"`
+}
+
+// UpdateFavorite updates the description and/or notes of an existing favorite
+func UpdateFavorite(c *fiber.Ctx) error {
+	var req UpdateFavoriteRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "invalid_request_body"),
+			"details": err.Error(),
+		})
+	}
+
+	if reqErr := validateRequest(c, &req); reqErr != nil {
+		return reqErr.send(c)
+	}
+
+	vendorID := normalizeDeviceID(req.VendorID)
+	productID := normalizeDeviceID(req.ProductID)
+
+	if req.Description == nil && req.Notes == nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Msg(c, "no_fields_to_update"),
+		})
+	}
+
+	err := db.UpdateFavorite(vendorID, productID, trimOptional(req.Description), trimOptional(req.Notes))
+	if errors.Is(err, db.ErrFavoriteNotFound) {
+		return c.Status(404).JSON(fiber.Map{
+			"error": i18n.Msg(c, "favorite_not_found"),
+		})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "update_favorite_failed"),
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": i18n.Msg(c, "favorite_updated"),
 	})
 }
 
 // RemoveFavoriteRequest represents a request to remove a favorite
 type RemoveFavoriteRequest struct {
-	VendorID  string `json:"vendorId"`
-	ProductID string `json:"productId"`
+	VendorID  string `json:"vendorId" validate:"required,usbid"`
+	ProductID string `json:"productId" validate:"required,usbid"`
 }
 
 // RemoveFavorite removes a device from favorites
@@ -69,27 +173,34 @@ func RemoveFavorite(c *fiber.Ctx) error {
 	var req RemoveFavoriteRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(400).JSON(fiber.Map{
-			"error":   "Invalid request body",
+			"error":   i18n.Msg(c, "invalid_request_body"),
 			"details": err.Error(),
 		})
 	}
 
-	if req.VendorID == "" || req.ProductID == "" {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "vendorId and productId are required",
-		})
+	if reqErr := validateRequest(c, &req); reqErr != nil {
+		return reqErr.send(c)
 	}
 
 	err := db.RemoveFavorite(req.VendorID, req.ProductID)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
-			"error":   "Failed to remove favorite",
+			"error":   i18n.Msg(c, "remove_favorite_failed"),
 			"details": err.Error(),
 		})
 	}
 
 	return c.JSON(fiber.Map{
 		"success": true,
-		"message": "Device removed from favorites",
+		"message": i18n.Msg(c, "favorite_removed"),
 	})
 }
+
+// trimOptional trims surrounding whitespace of an optional string field
+func trimOptional(value *string) *string {
+	if value == nil {
+		return nil
+	}
+	trimmed := strings.TrimSpace(*value)
+	return &trimmed
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"vfio_usb_passthrough/internals/i18n"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandoffRequest moves a device from one running VM to another
+// The device is given like for an attach (IDs, lsusb string or alias); Force applies to the target attach
+type HandoffRequest struct {
+	FromVM string `json:"fromVm" validate:"required"`
+	ToVM   string `json:"toVm" validate:"required"`
+	AttachDetachRequest
+}
+
+// HandoffError is a handoff whose attach to the target failed after the device was detached from the source
+// The device was re-attached to the source unless RestoreErr is set, in which case it is attached to neither VM
+type HandoffError struct {
+	Err        error
+	RestoreErr error
+}
+
+func (e *HandoffError) Error() string {
+	if e.RestoreErr != nil {
+		return fmt.Sprintf("%v (re-attaching to the source failed: %v)", e.Err, e.RestoreErr)
+	}
+	return e.Err.Error()
+}
+
+func (e *HandoffError) Unwrap() error {
+	return e.Err
+}
+
+// HandoffTargetError is a handoff refused before the detach because of the target VM, e.g. it is at
+// MAX_DEVICES_PER_VM or its devices could not be read; the device was not moved
+type HandoffTargetError struct {
+	Err error
+}
+
+func (e *HandoffTargetError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *HandoffTargetError) Unwrap() error {
+	return e.Err
+}
+
+// Handoff detaches a device from fromVM and attaches it to toVM, re-attaching it to fromVM if the target attach fails
+// Target check errors (HandoffTargetError) and detach errors leave the device where it was
+func (DeviceService) Handoff(ctx context.Context, host Host, fromVM, toVM string, req AttachDetachRequest, opts AttachOptions) (AttachResult, error) {
+	vendorID, productID, err := prepareDeviceRequest(&req)
+	if err != nil {
+		return AttachResult{}, err
+	}
+
+	log.Printf("Handoff: %s:%s from %s to %s", vendorID, productID, fromVM, toVM)
+
+	// Check the target has room before taking the device away from the source, keeping
+	// its attach lock until the device is attached to it
+	unlock := sync.OnceFunc(lockVMAttach(host, toVM))
+	defer unlock()
+	if err := checkAttachLimit(ctx, host, toVM); err != nil {
+		log.Printf("Handoff: Refusing to move %s:%s to %s: %v", vendorID, productID, toVM, err)
+		return AttachResult{}, &HandoffTargetError{Err: err}
+	}
+
+	if _, err := Devices.Detach(ctx, host, fromVM, req, DetachOptions{Client: opts.Client}); err != nil {
+		return AttachResult{}, err
+	}
+
+	result, err := Devices.Attach(ctx, host, toVM, req, AttachOptions{Force: opts.Force, Client: opts.Client, vmLocked: true})
+	unlock()
+	if err == nil {
+		return result, nil
+	}
+
+	// The device was on the source a moment ago, so it is put back without the mount check
+	log.Printf("ROLLBACK: Attach of %s:%s to %s failed, re-attaching it to %s", vendorID, productID, toVM, fromVM)
+	if _, restoreErr := Devices.Attach(ctx, host, fromVM, req, AttachOptions{Force: true, Client: opts.Client}); restoreErr != nil {
+		log.Printf("ROLLBACK FAILED: Device %s:%s is attached to neither %s nor %s: %v", vendorID, productID, fromVM, toVM, restoreErr)
+		return AttachResult{}, &HandoffError{Err: err, RestoreErr: restoreErr}
+	}
+	log.Printf("ROLLBACK: Device %s:%s is back on %s", vendorID, productID, fromVM)
+	return AttachResult{}, &HandoffError{Err: err}
+}
+
+// HandoffDevice moves a USB device from one VM to another as one operation
+// If the attach to the target fails the device is re-attached to the source, so it is not left detached from both
+func HandoffDevice(c *fiber.Ctx) error {
+	host := hostFromCtx(c)
+
+	var req HandoffRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "invalid_request_body"),
+			"details": err.Error(),
+		})
+	}
+
+	if reqErr := resolveRequestDevice(c, &req.AttachDetachRequest); reqErr != nil {
+		return reqErr.send(c)
+	}
+
+	if reqErr := resolveRequestDeviceAlias(c, &req.AttachDetachRequest); reqErr != nil {
+		return reqErr.send(c)
+	}
+
+	if reqErr := validateRequest(c, &req); reqErr != nil {
+		return reqErr.send(c)
+	}
+
+	// Validate both VM names (or resolve VM UUIDs to their names)
+	fromVM, err := resolveVMName(host, req.FromVM)
+	if err != nil {
+		log.Printf("Handoff: VM validation failed for '%s': %v", req.FromVM, err)
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Localize(c, err),
+		})
+	}
+	toVM, err := resolveVMName(host, req.ToVM)
+	if err != nil {
+		log.Printf("Handoff: VM validation failed for '%s': %v", req.ToVM, err)
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Localize(c, err),
+		})
+	}
+	if fromVM == toVM {
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Msg(c, "handoff_same_vm"),
+		})
+	}
+
+	result, err := Devices.Handoff(c.UserContext(), host, fromVM, toVM, req.AttachDetachRequest, AttachOptions{
+		Force:  req.Force,
+		Client: c.IP(),
+	})
+	var handoffErr *HandoffError
+	if errors.As(err, &handoffErr) {
+		vendorID, productID := normalizeDeviceID(req.VendorID), normalizeDeviceID(req.ProductID)
+		if handoffErr.RestoreErr != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"error":          i18n.Msg(c, "handoff_restore_failed", vendorID, productID, toVM, fromVM),
+				"details":        handoffErr.Err.Error(),
+				"restoreDetails": handoffErr.RestoreErr.Error(),
+				"restored":       false,
+			})
+		}
+		return c.Status(500).JSON(fiber.Map{
+			"error":    i18n.Msg(c, "handoff_rolled_back", vendorID, productID, toVM, fromVM),
+			"details":  handoffErr.Err.Error(),
+			"restored": true,
+		})
+	}
+	if errors.As(err, new(*HandoffTargetError)) {
+		return sendDeviceCommandError(c, "attach_failed", toVM, err)
+	}
+	if err != nil {
+		return sendDeviceCommandError(c, "detach_failed", fromVM, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": i18n.Msg(c, "device_handed_off", result.VendorID, result.ProductID, fromVM, toVM),
+	})
+}
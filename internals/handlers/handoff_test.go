@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"vfio_usb_passthrough/internals/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// fakeHandoffVirsh returns a virsh script for a host running win10 (with 046d:c077 attached) and linux,
+// whose attach-device fails for the VMs in failAttach; device commands are appended to calls
+func fakeHandoffVirsh(calls string, failAttach ...string) string {
+	failCases := ""
+	for _, vm := range failAttach {
+		failCases += "    " + vm + ") echo 'error: internal error: unable to attach' >&2; exit 1 ;;\n"
+	}
+	return `case "$1" in
+list) printf 'win10\nlinux\n'; exit 0 ;;
+dumpxml) echo "<domain><name>$2</name><devices>$( [ "$2" = win10 ] && echo "` + usbHostdevXML("046d", "c077") + `" )</devices></domain>"; exit 0 ;;
+attach-device|detach-device)
+  echo "$1 $2" >> ` + calls + `
+  if [ "$1" = attach-device ]; then
+    case "$2" in
+` + failCases + `    esac
+  fi
+  echo "Device updated successfully"; exit 0 ;;
+esac
+exit 0
+`
+}
+
+func postHandoff(t *testing.T) (int, fiber.Map) {
+	t.Helper()
+	app := fiber.New()
+	app.Post("/api/handoff", HandoffDevice)
+
+	req := httptest.NewRequest("POST", "/api/handoff", strings.NewReader(`{"fromVm":"win10","toVm":"linux","vendorId":"046d","productId":"c077","force":true}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body fiber.Map
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, body
+}
+
+func readCalls(t *testing.T, calls string) []string {
+	t.Helper()
+	data, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestHandoffDeviceRollsBack(t *testing.T) {
+	setupTestDB(t)
+	calls := filepath.Join(t.TempDir(), "calls")
+	t.Setenv(utils.VirshBinEnv, fakeCommand(t, fakeHandoffVirsh(calls, "linux")))
+
+	status, body := postHandoff(t)
+	if status != fiber.StatusInternalServerError || body["restored"] != true {
+		t.Errorf("response = %d %v, want 500 with restored true", status, body)
+	}
+	want := []string{"detach-device win10", "attach-device linux", "attach-device win10"}
+	if got := readCalls(t, calls); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("device commands = %q, want %q", got, want)
+	}
+}
+
+func TestHandoffDeviceRestoreFails(t *testing.T) {
+	setupTestDB(t)
+	calls := filepath.Join(t.TempDir(), "calls")
+	t.Setenv(utils.VirshBinEnv, fakeCommand(t, fakeHandoffVirsh(calls, "linux", "win10")))
+
+	status, body := postHandoff(t)
+	if status != fiber.StatusInternalServerError || body["restored"] != false || body["restoreDetails"] == nil {
+		t.Errorf("response = %d %v, want 500 with restored false and the restore error", status, body)
+	}
+	want := []string{"detach-device win10", "attach-device linux", "attach-device win10"}
+	if got := readCalls(t, calls); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("device commands = %q, want %q", got, want)
+	}
+}
+
+func TestHandoffDeviceTargetCheckFails(t *testing.T) {
+	setupTestDB(t)
+	old := maxDevicesPerVM
+	t.Cleanup(func() { maxDevicesPerVM = old })
+	maxDevicesPerVM = 4
+
+	// The devices of the target cannot be read, so the device must stay on win10
+	calls := filepath.Join(t.TempDir(), "calls")
+	t.Setenv(utils.VirshBinEnv, fakeCommand(t, `case "$1" in
+list) printf 'win10\nlinux\n'; exit 0 ;;
+dumpxml) [ "$2" = linux ] && { echo "error: failed to get domain 'linux'" >&2; exit 1; }; echo "<domain><name>$2</name><devices>`+usbHostdevXML("046d", "c077")+`</devices></domain>"; exit 0 ;;
+attach-device|detach-device) echo "$1 $2" >> `+calls+`; exit 0 ;;
+esac
+exit 0
+`))
+
+	status, body := postHandoff(t)
+	if status != fiber.StatusInternalServerError || body["error"] != "Failed to get attached devices for linux" {
+		t.Errorf("response = %d %v, want 500 naming linux", status, body)
+	}
+	if _, err := os.Stat(calls); !os.IsNotExist(err) {
+		t.Errorf("device commands were run for a refused handoff (%v)", err)
+	}
+}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"vfio_usb_passthrough/internals/i18n"
+	"vfio_usb_passthrough/internals/tracing"
+	"vfio_usb_passthrough/internals/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// LibvirtHostsEnv lists the libvirt connections as name=uri pairs, e.g.
+// "local=qemu:///system,lab=qemu+ssh://root@lab.example.com/system"
+// The first one is the default; unset means a single local qemu:///system connection
+const LibvirtHostsEnv = "LIBVIRT_HOSTS"
+
+// USBSSHHostsEnv lists the connections whose USB devices are enumerated over SSH, as names
+// (SSH target taken from the qemu+ssh URI) or name=[user@]host[:port] pairs, e.g. "lab" or "lab=root@lab:2222"
+const USBSSHHostsEnv = "USB_SSH_HOSTS"
+
+// defaultLibvirtURI is the connection used when LIBVIRT_HOSTS is unset
+const defaultLibvirtURI = "qemu:///system"
+
+// ErrUnknownHost is returned when ?host= names a connection that is not configured
+var ErrUnknownHost = i18n.NewError("unknown_host")
+
+// Host is a named libvirt connection
+// USB devices are enumerated on this machine, or over SSH when SSH is set
+type Host struct {
+	Name  string `json:"name"`
+	URI   string `json:"uri"`
+	Local bool   `json:"local"`
+	SSH   string `json:"ssh,omitempty"`
+}
+
+// hosts holds the configured connections, the default first
+var hosts = []Host{{Name: "local", URI: defaultLibvirtURI, Local: true}}
+
+// LoadHosts reads the libvirt connections from LIBVIRT_HOSTS and their SSH targets from USB_SSH_HOSTS
+func LoadHosts() error {
+	if err := loadLibvirtHosts(); err != nil {
+		return err
+	}
+	return loadUSBSSHHosts()
+}
+
+// loadLibvirtHosts reads the libvirt connections from LIBVIRT_HOSTS
+func loadLibvirtHosts() error {
+	value := strings.TrimSpace(os.Getenv(LibvirtHostsEnv))
+	if value == "" {
+		return nil
+	}
+
+	var loaded []Host
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(value, ",") {
+		name, uri, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		name, uri = strings.TrimSpace(name), strings.TrimSpace(uri)
+		if !ok || uri == "" || !isValidVMNameFormat(name) {
+			return fmt.Errorf("invalid %s entry %q (expected name=uri)", LibvirtHostsEnv, entry)
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate %s name %q", LibvirtHostsEnv, name)
+		}
+		seen[name] = true
+		loaded = append(loaded, Host{Name: name, URI: uri, Local: isLocalURI(uri)})
+	}
+
+	hosts = loaded
+	for _, host := range hosts {
+		log.Printf("libvirt connection %s: %s (local=%v)", host.Name, host.URI, host.Local)
+	}
+	return nil
+}
+
+// loadUSBSSHHosts sets the SSH target of the connections listed in USB_SSH_HOSTS
+func loadUSBSSHHosts() error {
+	value := strings.TrimSpace(os.Getenv(USBSSHHostsEnv))
+	if value == "" {
+		return nil
+	}
+
+	for _, entry := range strings.Split(value, ",") {
+		name, target, _ := strings.Cut(strings.TrimSpace(entry), "=")
+		name, target = strings.TrimSpace(name), strings.TrimSpace(target)
+
+		index := -1
+		for i := range hosts {
+			if hosts[i].Name == name {
+				index = i
+			}
+		}
+		if index < 0 {
+			return fmt.Errorf("invalid %s entry %q: unknown host %q", USBSSHHostsEnv, entry, name)
+		}
+
+		if target == "" {
+			target = sshTargetFromURI(hosts[index].URI)
+			if target == "" {
+				return fmt.Errorf("invalid %s entry %q: %s is not a qemu+ssh URI, set name=[user@]host", USBSSHHostsEnv, entry, hosts[index].URI)
+			}
+		}
+		hosts[index].SSH = target
+		log.Printf("USB devices of %s are enumerated over SSH (%s)", name, target)
+	}
+	return nil
+}
+
+// sshTargetFromURI returns the [user@]host[:port] of a qemu+ssh URI, or "" for other URIs
+func sshTargetFromURI(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil || !strings.HasSuffix(parsed.Scheme, "+ssh") || parsed.Host == "" {
+		return ""
+	}
+	target := parsed.Host
+	if parsed.User != nil {
+		target = parsed.User.Username() + "@" + target
+	}
+	return target
+}
+
+// isLocalURI reports whether a libvirt URI points at this machine (no remote hostname)
+func isLocalURI(uri string) bool {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	return parsed.Host == "" || parsed.Hostname() == "localhost"
+}
+
+// defaultHost returns the connection used when none is selected (and by background tasks)
+func defaultHost() Host {
+	return hosts[0]
+}
+
+// hostKey is the name a host's desired-state and attachment rows are stored under: "" for the
+// default connection, so the rows of a single-connection setup survive naming it in LIBVIRT_HOSTS
+func hostKey(host Host) string {
+	if host.Name == defaultHost().Name {
+		return ""
+	}
+	return host.Name
+}
+
+// findHost returns the connection with the given name; an empty name selects the default
+func findHost(name string) (Host, bool) {
+	if name == "" {
+		return defaultHost(), true
+	}
+	for _, host := range hosts {
+		if host.Name == name {
+			return host, true
+		}
+	}
+	return Host{}, false
+}
+
+// ResolveHost selects the libvirt connection of an API request from ?host=
+func ResolveHost(c *fiber.Ctx) error {
+	host, ok := findHost(c.Query("host"))
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Localize(c, ErrUnknownHost),
+		})
+	}
+	c.Locals("host", host)
+	return c.Next()
+}
+
+// hostFromCtx returns the connection selected by ResolveHost, or the default one
+func hostFromCtx(c *fiber.Ctx) Host {
+	if host, ok := c.Locals("host").(Host); ok {
+		return host
+	}
+	return defaultHost()
+}
+
+// virshCmd is a virsh command traced as its own span when run
+type virshCmd struct {
+	*exec.Cmd
+	ctx   context.Context
+	args  []string
+	attrs []attribute.KeyValue
+}
+
+// virshCommand builds a virsh command against a libvirt connection
+func virshCommand(ctx context.Context, host Host, args ...string) *virshCmd {
+	cmd := exec.CommandContext(ctx, utils.VirshBin(), args...)
+	cmd.Env = append(os.Environ(), "LIBVIRT_DEFAULT_URI="+host.URI)
+	return &virshCmd{Cmd: cmd, ctx: ctx, args: args, attrs: []attribute.KeyValue{attribute.String("libvirt.host", host.Name)}}
+}
+
+// withAttributes adds attributes to the span of the command
+func (c *virshCmd) withAttributes(attrs ...attribute.KeyValue) *virshCmd {
+	c.attrs = append(c.attrs, attrs...)
+	return c
+}
+
+// Output runs the command and returns its standard output
+func (c *virshCmd) Output() ([]byte, error) {
+	_, span := tracing.StartExec(c.ctx, "virsh", c.args, c.attrs...)
+	output, err := c.Cmd.Output()
+	tracing.EndExec(span, err)
+	return output, err
+}
+
+// CombinedOutput runs the command and returns its standard output and standard error
+func (c *virshCmd) CombinedOutput() ([]byte, error) {
+	_, span := tracing.StartExec(c.ctx, "virsh", c.args, c.attrs...)
+	output, err := c.Cmd.CombinedOutput()
+	tracing.EndExec(span, err)
+	return output, err
+}
+
+// GetHosts returns the configured libvirt connections, the default first
+func GetHosts(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"hosts":   hosts,
+		"default": defaultHost().Name,
+	})
+}
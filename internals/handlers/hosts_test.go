@@ -0,0 +1,22 @@
+package handlers
+
+import "testing"
+
+func TestSSHTargetFromURI(t *testing.T) {
+	tests := []struct {
+		uri, want string
+	}{
+		{"qemu+ssh://root@lab.example.com/system", "root@lab.example.com"},
+		{"qemu+ssh://lab.example.com:2222/system", "lab.example.com:2222"},
+		{"qemu+ssh://admin@[2001:db8::1]:22/system", "admin@[2001:db8::1]:22"},
+		{"qemu:///system", ""},
+		{"qemu+tcp://lab.example.com/system", ""},
+		{"qemu+ssh:///system", ""},
+		{"://bad", ""},
+	}
+	for _, tt := range tests {
+		if got := sshTargetFromURI(tt.uri); got != tt.want {
+			t.Errorf("sshTargetFromURI(%q) = %q, want %q", tt.uri, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+
+	"vfio_usb_passthrough/internals/i18n"
+	"vfio_usb_passthrough/internals/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AttachHubRequest names the hub whose downstream devices should be attached
+// Hub is a sysfs device name (e.g. "1-1") or its path under /sys/bus/usb/devices
+type AttachHubRequest struct {
+	Hub string `json:"hub" validate:"required"`
+}
+
+// AttachHubResult is the outcome of attaching every device behind a hub
+type AttachHubResult struct {
+	ApplyResult
+	Hub     string `json:"hub"`
+	Warning string `json:"warning"`
+}
+
+// AttachHub attaches all devices downstream of a USB hub to a VM
+// Devices are attached one by one by vendor:product ID (same path as bulk apply),
+// nested hubs are traversed but not attached themselves
+func AttachHub(c *fiber.Ctx) error {
+	host := hostFromCtx(c)
+
+	// Validate VM name (or resolve a VM UUID to its name)
+	vmName, err := resolveVMName(host, c.Params("vmName"))
+	if err != nil {
+		log.Printf("AttachHub: VM validation failed for '%s': %v", c.Params("vmName"), err)
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Localize(c, err),
+		})
+	}
+
+	var req AttachHubRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "invalid_request_body"),
+			"details": err.Error(),
+		})
+	}
+
+	if reqErr := validateRequest(c, &req); reqErr != nil {
+		return reqErr.send(c)
+	}
+
+	// Only plain sysfs names are accepted, which also rules out path traversal
+	hubName := filepath.Base(req.Hub)
+	if !usbDevicePattern.MatchString(hubName) && !usbRootHubPattern.MatchString(hubName) {
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Msg(c, "invalid_hub"),
+		})
+	}
+
+	hubDir := filepath.Join(usbSysfsPath, hubName)
+	if _, err := os.Stat(hubDir); err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"error": i18n.Msg(c, "hub_not_found", hubName),
+		})
+	}
+
+	hub := readUSBTopologyNode(hubDir, hubName, 0, 0)
+	if !hub.Hub {
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Msg(c, "not_a_hub", hubName),
+		})
+	}
+
+	devices := collectHubDevices(hub)
+	if len(devices) == 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Msg(c, "hub_no_devices", hubName),
+		})
+	}
+	if len(devices) > maxBulkDevices {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": i18n.Msg(c, "too_many_devices", maxBulkDevices),
+		})
+	}
+
+	log.Printf("AttachHub: VM=%s, Hub=%s, %d downstream device(s)", vmName, hubName, len(devices))
+	result, err := applyDesiredState(c.UserContext(), host, vmName, devices, false, c.IP())
+	if errors.As(err, new(*DeviceLimitError)) {
+		return sendDeviceCommandError(c, "attach_failed", vmName, err)
+	}
+	if err != nil {
+		log.Printf("Error getting attached devices for %s: %v", vmName, err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "get_attached_devices_failed", vmName),
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(AttachHubResult{
+		ApplyResult: result,
+		Hub:         hubName,
+		Warning:     i18n.Msg(c, "hub_passthrough_warning"),
+	})
+}
+
+// collectHubDevices returns the non-hub devices below a hub, depth first
+func collectHubDevices(node USBTopologyNode) []AttachedDeviceResponse {
+	var devices []AttachedDeviceResponse
+	for _, child := range node.Children {
+		if child.Hub {
+			devices = append(devices, collectHubDevices(child)...)
+			continue
+		}
+		if !utils.IsValidHexID(child.VendorID) || !utils.IsValidHexID(child.ProductID) {
+			continue
+		}
+		devices = append(devices, AttachedDeviceResponse{VendorID: child.VendorID, ProductID: child.ProductID})
+	}
+	return devices
+}
@@ -6,6 +6,5 @@ import (
 
 // GetIndex handles the main page request
 func GetIndex(c *fiber.Ctx) error {
-
-	return c.Render("index", fiber.Map{})
+	return Render(c, "index", nil)
 }
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// InflightOperation is a virsh device command that has not returned yet
+type InflightOperation struct {
+	ID        uint64    `json:"id"`
+	Command   string    `json:"command"`
+	Host      string    `json:"host"`
+	VM        string    `json:"vm"`
+	VendorID  string    `json:"vendorId"`
+	ProductID string    `json:"productId"`
+	TempFile  string    `json:"tempFile"`
+	StartedAt time.Time `json:"startedAt"`
+	ElapsedMs int64     `json:"elapsedMs"`
+}
+
+// inflightOps holds the running virsh device commands, keyed by operation ID
+var inflightOps = struct {
+	sync.Mutex
+	nextID uint64
+	ops    map[uint64]InflightOperation
+}{ops: make(map[uint64]InflightOperation)}
+
+// startInflight registers a running virsh device command and returns the function removing it once it returns
+func startInflight(host Host, command, vmName, vendorID, productID, tempFile string) (done func()) {
+	inflightOps.Lock()
+	defer inflightOps.Unlock()
+
+	inflightOps.nextID++
+	id := inflightOps.nextID
+	inflightOps.ops[id] = InflightOperation{
+		ID:        id,
+		Command:   command,
+		Host:      host.Name,
+		VM:        vmName,
+		VendorID:  vendorID,
+		ProductID: productID,
+		TempFile:  tempFile,
+		StartedAt: time.Now(),
+	}
+	return func() {
+		inflightOps.Lock()
+		defer inflightOps.Unlock()
+		delete(inflightOps.ops, id)
+	}
+}
+
+// inflightOperations returns the running virsh device commands, oldest first
+func inflightOperations() []InflightOperation {
+	inflightOps.Lock()
+	defer inflightOps.Unlock()
+
+	now := time.Now()
+	ops := make([]InflightOperation, 0, len(inflightOps.ops))
+	for _, op := range inflightOps.ops {
+		op.ElapsedMs = now.Sub(op.StartedAt).Milliseconds()
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].ID < ops[j].ID
+	})
+	return ops
+}
+
+// GetInflightOperations returns the virsh attach/detach commands currently running, with how long they have run
+// A command stuck in libvirt shows up here until deviceCommandTimeout kills it
+func GetInflightOperations(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"operations": inflightOperations(),
+		"timeoutMs":  deviceCommandTimeout.Milliseconds(),
+	})
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import "testing"
+
+func TestInflightOperations(t *testing.T) {
+	host := Host{Name: "local", Local: true}
+	doneAttach := startInflight(host, "attach-device", "win10", "046d", "c077", "/tmp/vfio-usb-1.xml")
+	doneDetach := startInflight(host, "detach-device", "ubuntu", "0781", "5567", "/tmp/vfio-usb-2.xml")
+
+	ops := inflightOperations()
+	if len(ops) != 2 || ops[0].VM != "win10" || ops[1].VM != "ubuntu" {
+		t.Fatalf("inflightOperations() = %+v, want the attach then the detach", ops)
+	}
+	if ops[0].Command != "attach-device" || ops[0].Host != "local" || ops[0].TempFile != "/tmp/vfio-usb-1.xml" || ops[0].ElapsedMs < 0 {
+		t.Errorf("inflightOperations()[0] = %+v, want the win10 attach", ops[0])
+	}
+
+	doneAttach()
+	if ops := inflightOperations(); len(ops) != 1 || ops[0].VM != "ubuntu" {
+		t.Errorf("after the attach returned inflightOperations() = %+v, want the detach only", ops)
+	}
+	doneDetach()
+	if ops := inflightOperations(); len(ops) != 0 {
+		t.Errorf("after both returned inflightOperations() = %+v, want none", ops)
+	}
+}
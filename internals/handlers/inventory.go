@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"vfio_usb_passthrough/internals/db"
+	"vfio_usb_passthrough/internals/i18n"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// VMInventory represents a running VM and its attached devices
+type VMInventory struct {
+	Name            string                   `json:"name"`
+	AttachedDevices []AttachedDeviceResponse `json:"attachedDevices"`
+}
+
+// InventoryResponse is a snapshot of running VMs, USB devices, favorites and attachments
+type InventoryResponse struct {
+	VMs       []VMInventory            `json:"vms"`
+	Devices   []USBDeviceResponse      `json:"devices"`
+	Favorites []FavoriteDeviceResponse `json:"favorites"`
+	Warnings  []string                 `json:"warnings"`
+}
+
+// GetInventory returns a single document describing the whole passthrough state
+// Sources that fail are reported in warnings instead of failing the request, except the USB devices
+// when filtering by ?class=
+// Attached devices get the class of the host device with the same IDs; ?class= (e.g. storage, hid, 08)
+// keeps only the host and attached devices of that class
+func GetInventory(c *fiber.Ctx) error {
+	host := hostFromCtx(c)
+	class, reqErr := usbClassQuery(c)
+	if reqErr != nil {
+		return reqErr.send(c)
+	}
+
+	var usbDevices []USBDeviceResponse
+	var favorites []db.FavoriteDevice
+	var vmNames []string
+	var usbErr, favoritesErr, vmsErr error
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		usbDevices, usbErr = hostUSBDevices(host)
+	}()
+	go func() {
+		defer wg.Done()
+		favorites, favoritesErr = db.GetAllFavorites()
+	}()
+	go func() {
+		defer wg.Done()
+		vmNames, vmsErr = getRunningVMNames(host)
+	}()
+	wg.Wait()
+
+	// Without the host's devices nothing can be matched to ?class=, so the filter would wrongly return nothing
+	if usbErr != nil && class != "" {
+		log.Printf("Inventory: Failed to list USB devices to filter by class: %v", usbErr)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "list_usb_devices_failed"),
+			"details": usbErr.Error(),
+		})
+	}
+
+	response := InventoryResponse{
+		VMs:       make([]VMInventory, len(vmNames)),
+		Devices:   []USBDeviceResponse{},
+		Favorites: toFavoritesResponse(favorites),
+		Warnings:  []string{},
+	}
+
+	if usbErr != nil {
+		log.Printf("Inventory: Warning - failed to list USB devices: %v", usbErr)
+		response.Warnings = append(response.Warnings, fmt.Sprintf("%s: %v", i18n.Msg(c, "list_usb_devices_failed"), usbErr))
+	}
+	if favoritesErr != nil {
+		log.Printf("Inventory: Warning - failed to get favorites: %v", favoritesErr)
+		response.Warnings = append(response.Warnings, fmt.Sprintf("%s: %v", i18n.Msg(c, "get_favorites_failed"), favoritesErr))
+	}
+	if vmsErr != nil {
+		log.Printf("Inventory: Warning - failed to list running VMs: %v", vmsErr)
+		response.Warnings = append(response.Warnings, fmt.Sprintf("%s: %v", i18n.Msg(c, "list_vms_failed"), vmsErr))
+	}
+
+	// Fetch attachments per VM with bounded concurrency, keeping VM order
+	attachedErrs := make([]error, len(vmNames))
+	sem := make(chan struct{}, maxConcurrentVMQueries)
+	for i, vmName := range vmNames {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			attached, err := getAttachedDevicesList(c.UserContext(), host, vmName)
+			if attached == nil {
+				attached = []AttachedDeviceResponse{}
+			}
+			classifyAttachedDevices(attached, usbDevices)
+			response.VMs[i] = VMInventory{Name: vmName, AttachedDevices: filterAttachedByClass(attached, class)}
+			attachedErrs[i] = err
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range attachedErrs {
+		if err != nil {
+			log.Printf("Inventory: Warning - failed to get attached devices for %s: %v", vmNames[i], err)
+			response.Warnings = append(response.Warnings, fmt.Sprintf("%s: %v", i18n.Msg(c, "get_attached_devices_failed", vmNames[i]), err))
+		}
+	}
+
+	for _, device := range usbDevices {
+		if class == "" || device.DeviceClass == class {
+			response.Devices = append(response.Devices, device)
+		}
+	}
+
+	return c.JSON(response)
+}
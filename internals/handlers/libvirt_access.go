@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"log"
+	"os/user"
+	"runtime"
+	"strings"
+
+	"vfio_usb_passthrough/internals/i18n"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// libvirtPermissionErrors are the virsh messages of a user that may not use the libvirt socket
+var libvirtPermissionErrors = []string{"permission denied", "authentication unavailable", "authentication failed", "access denied", "polkit"}
+
+// LibvirtAccess is the outcome of checking that virsh can talk to libvirt
+// PermissionDenied is set when the error looks like missing access rights (e.g. the service user
+// is not in the libvirt group), which otherwise only shows up as an empty VM list
+type LibvirtAccess struct {
+	OK               bool   `json:"ok"`
+	URI              string `json:"uri"`
+	Error            string `json:"error,omitempty"`
+	PermissionDenied bool   `json:"permissionDenied"`
+	Hint             string `json:"hint,omitempty"`
+}
+
+// checkLibvirtAccess runs `virsh version` against a connection and classifies its failure
+func checkLibvirtAccess(host Host) LibvirtAccess {
+	access := LibvirtAccess{URI: host.URI}
+	err := pingLibvirt(host)
+	if err == nil {
+		access.OK = true
+		return access
+	}
+
+	access.Error = err.Error()
+	message := strings.ToLower(access.Error)
+	for _, pattern := range libvirtPermissionErrors {
+		if strings.Contains(message, pattern) {
+			access.PermissionDenied = true
+			break
+		}
+	}
+	return access
+}
+
+// serviceUser returns the name of the user the server runs as, or "" if it cannot be determined
+func serviceUser() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return ""
+}
+
+// CheckLibvirtAccess checks at startup that virsh can reach libvirt on the default connection,
+// logging the error (and a hint about the libvirt group for permission errors) if it cannot
+func CheckLibvirtAccess() {
+	access := checkLibvirtAccess(defaultHost())
+	switch {
+	case access.OK:
+		log.Printf("libvirt access OK (%s)", access.URI)
+	case access.PermissionDenied:
+		log.Printf("Warning: No permission to use libvirt (%s) as user %q: %s. Add the user to the libvirt group "+
+			"(usermod -aG libvirt %s) and restart the service, or VM lists will be empty", access.URI, serviceUser(), access.Error, serviceUser())
+	default:
+		log.Printf("Warning: Cannot reach libvirt (%s): %s", access.URI, access.Error)
+	}
+}
+
+// GetSystemInfo describes the running server: version, platform, user and whether libvirt is usable
+// libvirt access is checked on each call, so a fixed group membership shows up here once the service restarts
+func GetSystemInfo(c *fiber.Ctx) error {
+	username := serviceUser()
+	access := checkLibvirtAccess(hostFromCtx(c))
+	if access.PermissionDenied {
+		access.Hint = i18n.Msg(c, "libvirt_permission_hint", username)
+	}
+
+	return c.JSON(fiber.Map{
+		"version":   appVersion,
+		"revision":  appRevision,
+		"goVersion": runtime.Version(),
+		"platform":  runtime.GOOS + "/" + runtime.GOARCH,
+		"user":      username,
+		"libvirt":   access,
+	})
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"testing"
+
+	"vfio_usb_passthrough/internals/utils"
+)
+
+func TestCheckLibvirtAccess(t *testing.T) {
+	host := Host{Name: "local", URI: "qemu:///system", Local: true}
+
+	t.Setenv(utils.VirshBinEnv, fakeCommand(t, "echo 'Compiled against library: libvirt 9.0.0'\n"))
+	if access := checkLibvirtAccess(host); !access.OK || access.PermissionDenied || access.Error != "" {
+		t.Fatalf("checkLibvirtAccess() = %+v, want OK", access)
+	}
+
+	t.Setenv(utils.VirshBinEnv, fakeCommand(t, `echo "error: failed to connect to the hypervisor
+error: Failed to connect socket to '/var/run/libvirt/libvirt-sock': Permission denied" >&2
+exit 1
+`))
+	access := checkLibvirtAccess(host)
+	if access.OK || !access.PermissionDenied || access.Error == "" {
+		t.Fatalf("checkLibvirtAccess() = %+v, want a permission error", access)
+	}
+
+	t.Setenv(utils.VirshBinEnv, fakeCommand(t, `echo "error: Failed to connect socket to '/var/run/libvirt/libvirt-sock': No such file or directory" >&2
+exit 1
+`))
+	access = checkLibvirtAccess(host)
+	if access.OK || access.PermissionDenied {
+		t.Fatalf("checkLibvirtAccess() = %+v, want a non-permission error", access)
+	}
+}
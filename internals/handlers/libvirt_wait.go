@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// WaitForLibvirtEnv makes startup wait up to this long for libvirt to answer (e.g. "60s", or minutes);
+// unset or 0 does not wait
+const WaitForLibvirtEnv = "WAIT_FOR_LIBVIRT"
+
+// WaitForLibvirtOnTimeoutEnv is what to do when libvirt is still down after the wait:
+// "continue" (default) starts degraded, "fail" exits
+const WaitForLibvirtOnTimeoutEnv = "WAIT_FOR_LIBVIRT_ON_TIMEOUT"
+
+// Polling of libvirt while waiting for it (variables so tests can poll faster)
+var (
+	libvirtPollInterval = 2 * time.Second
+	libvirtPollTimeout  = 5 * time.Second
+)
+
+// WaitForLibvirtFailOnTimeout reads WAIT_FOR_LIBVIRT_ON_TIMEOUT and reports whether a timeout should stop startup
+func WaitForLibvirtFailOnTimeout() (bool, error) {
+	switch value := strings.ToLower(strings.TrimSpace(os.Getenv(WaitForLibvirtOnTimeoutEnv))); value {
+	case "", "continue":
+		return false, nil
+	case "fail":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid %s: %q must be continue or fail", WaitForLibvirtOnTimeoutEnv, value)
+	}
+}
+
+// WaitForLibvirt polls `virsh version` on the default connection until it succeeds or timeout elapses
+// It is meant to run before anything at startup relies on libvirt (network auto-detection, the first requests)
+func WaitForLibvirt(timeout time.Duration) error {
+	host := defaultHost()
+	deadline := time.Now().Add(timeout)
+	log.Printf("Waiting up to %s for libvirt (%s)", timeout, host.URI)
+
+	for attempt := 1; ; attempt++ {
+		err := pingLibvirt(host)
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("libvirt is ready after %d attempts", attempt)
+			}
+			return nil
+		}
+		if time.Now().Add(libvirtPollInterval).After(deadline) {
+			return fmt.Errorf("libvirt (%s) not ready after %s: %w", host.URI, timeout, err)
+		}
+		log.Printf("libvirt is not ready yet (attempt %d): %v", attempt, err)
+		time.Sleep(libvirtPollInterval)
+	}
+}
+
+// pingLibvirt runs `virsh version` against a connection, which fails while libvirtd is down
+func pingLibvirt(host Host) error {
+	ctx, cancel := context.WithTimeout(context.Background(), libvirtPollTimeout)
+	defer cancel()
+
+	output, err := virshCommand(ctx, host, "version").CombinedOutput()
+	if err != nil {
+		return errors.New(failureMessage(string(output), err))
+	}
+	return nil
+}
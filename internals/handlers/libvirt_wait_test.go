@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"vfio_usb_passthrough/internals/utils"
+)
+
+func TestWaitForLibvirt(t *testing.T) {
+	oldInterval := libvirtPollInterval
+	libvirtPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { libvirtPollInterval = oldInterval })
+
+	// virsh fails until its third call, like libvirtd finishing its startup
+	count := filepath.Join(t.TempDir(), "count")
+	t.Setenv(utils.VirshBinEnv, fakeCommand(t, `n=$(cat `+count+` 2>/dev/null || echo 0)
+n=$((n + 1))
+echo $n > `+count+`
+if [ $n -lt 3 ]; then
+  echo "error: Failed to connect socket to '/var/run/libvirt/libvirt-sock': No such file or directory" >&2
+  exit 1
+fi
+echo "Compiled against library: libvirt 9.0.0"
+`))
+	if err := WaitForLibvirt(5 * time.Second); err != nil {
+		t.Fatalf("WaitForLibvirt() = %v, want libvirt ready", err)
+	}
+	if calls, _ := os.ReadFile(count); strings.TrimSpace(string(calls)) != "3" {
+		t.Errorf("virsh called %s times, want 3", calls)
+	}
+
+	t.Setenv(utils.VirshBinEnv, fakeCommand(t, "echo 'error: failed to connect to the hypervisor' >&2\nexit 1\n"))
+	start := time.Now()
+	err := WaitForLibvirt(100 * time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "failed to connect to the hypervisor") {
+		t.Fatalf("WaitForLibvirt() = %v, want the virsh error after the timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("WaitForLibvirt() took %s with a 100ms timeout", elapsed)
+	}
+}
+
+func TestWaitForLibvirtFailOnTimeout(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    bool
+		wantErr bool
+	}{
+		{"", false, false},
+		{"continue", false, false},
+		{" FAIL ", true, false},
+		{"exit", false, true},
+	}
+	for _, tt := range tests {
+		t.Setenv(WaitForLibvirtOnTimeoutEnv, tt.value)
+		got, err := WaitForLibvirtFailOnTimeout()
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("WaitForLibvirtFailOnTimeout() with %q = %v, %v; want %v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"vfio_usb_passthrough/internals/db"
+	"vfio_usb_passthrough/internals/middleware"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// metricsCacheTTL is how long a collected inventory is reused between scrapes
+const metricsCacheTTL = 15 * time.Second
+
+// maxConcurrentVMQueries bounds how many virsh dumpxml calls run at once
+const maxConcurrentVMQueries = 4
+
+var (
+	// metricsCollectTimeout bounds an inventory collection; lsusb and virsh are killed when it expires
+	metricsCollectTimeout = 10 * time.Second
+	// metricsScrapeTimeout is how long a scrape waits for the inventory before answering without it
+	metricsScrapeTimeout = 5 * time.Second
+)
+
+// inventoryCounts holds the gauges exposed on /metrics
+type inventoryCounts struct {
+	USBDevices      int
+	RunningVMs      int
+	Favorites       int
+	AttachedDevices int
+	Errors          int
+	CollectedAt     time.Time
+}
+
+var (
+	// metricsMu guards the cache; collection runs without it, and concurrent scrapes share one run
+	metricsMu         sync.Mutex
+	metricsCached     *inventoryCounts
+	metricsCollecting chan struct{}
+
+	metricsCacheHits   atomic.Uint64
+	metricsCacheMisses atomic.Uint64
+
+	// appRevision is the VCS commit reported by vfio_build_info
+	appRevision = "unknown"
+)
+
+// SetRevision sets the VCS commit reported by vfio_build_info
+func SetRevision(revision string) {
+	if revision != "" {
+		appRevision = revision
+	}
+}
+
+// getInventoryCounts returns the cached counts, collecting them again once the cache expires
+// It returns ctx's error if the collection does not finish in time; the collection then completes
+// in the background and fills the cache for later scrapes
+func getInventoryCounts(ctx context.Context) (*inventoryCounts, error) {
+	metricsMu.Lock()
+	if cached := metricsCached; cached != nil && time.Since(cached.CollectedAt) < metricsCacheTTL {
+		metricsMu.Unlock()
+		metricsCacheHits.Add(1)
+		return cached, nil
+	}
+	if metricsCollecting == nil {
+		metricsCacheMisses.Add(1)
+		metricsCollecting = make(chan struct{})
+		go func() {
+			collectCtx, cancel := context.WithTimeout(context.Background(), metricsCollectTimeout)
+			defer cancel()
+			counts := collectInventoryCounts(collectCtx)
+
+			metricsMu.Lock()
+			defer metricsMu.Unlock()
+			metricsCached = counts
+			close(metricsCollecting)
+			metricsCollecting = nil
+		}()
+	}
+	collecting := metricsCollecting
+	metricsMu.Unlock()
+
+	select {
+	case <-collecting:
+		metricsMu.Lock()
+		defer metricsMu.Unlock()
+		return metricsCached, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// collectInventoryCounts queries lsusb, virsh and the database for the current counts
+func collectInventoryCounts(ctx context.Context) *inventoryCounts {
+	host := defaultHost()
+	counts := &inventoryCounts{CollectedAt: time.Now()}
+
+	devices, err := getUSBDevicesListContext(ctx)
+	if err != nil {
+		log.Printf("Metrics: Warning - failed to list USB devices: %v", err)
+		counts.Errors++
+	}
+	counts.USBDevices = len(devices)
+
+	favorites, err := db.GetAllFavorites()
+	if err != nil {
+		log.Printf("Metrics: Warning - failed to get favorites: %v", err)
+		counts.Errors++
+	}
+	counts.Favorites = len(favorites)
+
+	vms, err := getRunningVMNamesContext(ctx, host)
+	if err != nil {
+		log.Printf("Metrics: Warning - failed to list running VMs: %v", err)
+		counts.Errors++
+	}
+	counts.RunningVMs = len(vms)
+
+	// Count attachments across VMs with bounded concurrency
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sem := make(chan struct{}, maxConcurrentVMQueries)
+	for _, vmName := range vms {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			attached, err := getAttachedDevicesList(ctx, host, vmName)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Printf("Metrics: Warning - failed to get attached devices for %s: %v", vmName, err)
+				counts.Errors++
+				return
+			}
+			counts.AttachedDevices += len(attached)
+		}()
+	}
+	wg.Wait()
+
+	return counts
+}
+
+// writeMetric writes a single metric in the Prometheus text exposition format
+func writeMetric(b *strings.Builder, name, metricType, help string, value interface{}) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", name, metricType)
+	fmt.Fprintf(b, "%s %v\n", name, value)
+}
+
+// writeBuildInfoMetrics writes vfio_up and vfio_build_info, which do not depend on the inventory
+func writeBuildInfoMetrics(b *strings.Builder) {
+	writeMetric(b, "vfio_up", "gauge", "Whether the service is up.", 1)
+
+	const name = "vfio_build_info"
+	fmt.Fprintf(b, "# HELP %s %s\n", name, "Build information, always 1.")
+	fmt.Fprintf(b, "# TYPE %s gauge\n", name)
+	fmt.Fprintf(b, "%s{version=%q,commit=%q} 1\n", name, appVersion, appRevision)
+}
+
+// GetMetrics exposes inventory gauges in the Prometheus text format
+// vfio_up and vfio_build_info come first; the (slower) inventory gauges are left out when the inventory
+// cannot be collected within metricsScrapeTimeout, e.g. because virsh hangs
+func GetMetrics(c *fiber.Ctx) error {
+	var b strings.Builder
+	writeBuildInfoMetrics(&b)
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), metricsScrapeTimeout)
+	defer cancel()
+	if counts, err := getInventoryCounts(ctx); err != nil {
+		log.Printf("Metrics: Warning - inventory not collected in time, serving metrics without it: %v", err)
+	} else {
+		writeInventoryMetrics(&b, counts)
+	}
+	writeBlockedRequestsMetric(&b)
+
+	c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+	return c.SendString(b.String())
+}
+
+// writeInventoryMetrics writes the inventory gauges and the cache counters
+func writeInventoryMetrics(b *strings.Builder, counts *inventoryCounts) {
+	writeMetric(b, "vfio_usb_devices", "gauge", "Number of USB devices on the host.", counts.USBDevices)
+	writeMetric(b, "vfio_running_vms", "gauge", "Number of running VMs.", counts.RunningVMs)
+	writeMetric(b, "vfio_favorites", "gauge", "Number of favorite devices.", counts.Favorites)
+	writeMetric(b, "vfio_attached_devices", "gauge", "Number of USB devices attached across running VMs.", counts.AttachedDevices)
+	writeMetric(b, "vfio_inventory_collect_errors", "gauge", "Number of sources that failed during the last inventory collection.", counts.Errors)
+	writeMetric(b, "vfio_inventory_collected_timestamp_seconds", "gauge", "Unix time of the last inventory collection.", counts.CollectedAt.Unix())
+	writeMetric(b, "vfio_inventory_cache_hits_total", "counter", "Scrapes served from the inventory cache.", metricsCacheHits.Load())
+	writeMetric(b, "vfio_inventory_cache_misses_total", "counter", "Scrapes that triggered a new inventory collection.", metricsCacheMisses.Load())
+}
+
+// writeBlockedRequestsMetric writes the IP filter block counter, labeled by target and method
+func writeBlockedRequestsMetric(b *strings.Builder) {
+	const name = "vfio_ip_filter_blocked_requests_total"
+	fmt.Fprintf(b, "# HELP %s %s\n", name, "Requests blocked by the IP filter, by target (api, static, page) and method.")
+	fmt.Fprintf(b, "# TYPE %s counter\n", name)
+
+	counts := middleware.BlockedRequestCounts()
+	keys := make([]middleware.BlockedRequestKey, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Target != keys[j].Target {
+			return keys[i].Target < keys[j].Target
+		}
+		return keys[i].Method < keys[j].Method
+	})
+	for _, key := range keys {
+		fmt.Fprintf(b, "%s{target=%q,method=%q} %d\n", name, key.Target, key.Method, counts[key])
+	}
+}
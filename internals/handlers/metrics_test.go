@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"vfio_usb_passthrough/internals/db"
+	"vfio_usb_passthrough/internals/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestWriteBuildInfoMetrics(t *testing.T) {
+	oldVersion, oldRevision := appVersion, appRevision
+	t.Cleanup(func() { appVersion, appRevision = oldVersion, oldRevision })
+
+	SetVersion("1.4.0")
+	SetRevision("")
+	var b strings.Builder
+	writeBuildInfoMetrics(&b)
+	for _, line := range []string{"vfio_up 1\n", `vfio_build_info{version="1.4.0",commit="unknown"} 1` + "\n"} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("metrics = %q, want line %q", b.String(), line)
+		}
+	}
+
+	SetRevision("6ad9730")
+	b.Reset()
+	writeBuildInfoMetrics(&b)
+	if want := `vfio_build_info{version="1.4.0",commit="6ad9730"} 1`; !strings.Contains(b.String(), want) {
+		t.Errorf("metrics = %q, want line %q", b.String(), want)
+	}
+}
+
+// resetMetricsCache empties the inventory cache and waits for a collection left running by the test
+func resetMetricsCache(t *testing.T) {
+	t.Helper()
+	metricsMu.Lock()
+	metricsCached = nil
+	metricsMu.Unlock()
+	t.Cleanup(func() {
+		metricsMu.Lock()
+		collecting := metricsCollecting
+		metricsMu.Unlock()
+		if collecting != nil {
+			<-collecting
+		}
+		metricsCached = nil
+	})
+}
+
+func scrapeMetrics(t *testing.T) string {
+	t.Helper()
+	app := fiber.New()
+	app.Get("/metrics", GetMetrics)
+	resp, err := app.Test(httptest.NewRequest("GET", "/metrics", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestGetMetricsInventory(t *testing.T) {
+	setupTestDB(t)
+	resetMetricsCache(t)
+	if err := db.AddFavorite("046d", "c077", "Mouse", ""); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(utils.LsusbBinEnv, fakeCommand(t, `echo "Bus 001 Device 004: ID 046d:c077 Logitech, Inc. Mouse"
+echo "Bus 001 Device 005: ID 0781:5567 SanDisk Corp. Cruzer Blade"
+`))
+	t.Setenv(utils.VirshBinEnv, fakeCommand(t, fakeVirshScript(usbHostdevXML("046d", "c077"))))
+
+	hits, misses := metricsCacheHits.Load(), metricsCacheMisses.Load()
+	metrics := scrapeMetrics(t)
+	for _, line := range []string{
+		"vfio_up 1\n",
+		"vfio_usb_devices 2\n",
+		"vfio_running_vms 1\n",
+		"vfio_favorites 1\n",
+		"vfio_attached_devices 1\n",
+		"vfio_inventory_collect_errors 0\n",
+	} {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("metrics = %q, want line %q", metrics, line)
+		}
+	}
+
+	// The next scrape is served from the cache
+	scrapeMetrics(t)
+	if metricsCacheHits.Load() != hits+1 || metricsCacheMisses.Load() != misses+1 {
+		t.Errorf("cache hits/misses = +%d/+%d, want +1/+1", metricsCacheHits.Load()-hits, metricsCacheMisses.Load()-misses)
+	}
+}
+
+func TestGetMetricsHungVirsh(t *testing.T) {
+	setupTestDB(t)
+	resetMetricsCache(t)
+	oldCollect, oldScrape := metricsCollectTimeout, metricsScrapeTimeout
+	t.Cleanup(func() { metricsCollectTimeout, metricsScrapeTimeout = oldCollect, oldScrape })
+	metricsCollectTimeout, metricsScrapeTimeout = 500*time.Millisecond, 100*time.Millisecond
+	t.Setenv(utils.LsusbBinEnv, fakeCommand(t, "exit 0\n"))
+	t.Setenv(utils.VirshBinEnv, fakeCommand(t, "exec sleep 30\n"))
+
+	start := time.Now()
+	metrics := scrapeMetrics(t)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("scrape took %s with a hung virsh", elapsed)
+	}
+	if !strings.Contains(metrics, "vfio_up 1\n") || !strings.Contains(metrics, "vfio_build_info{") {
+		t.Errorf("metrics = %q, want vfio_up and vfio_build_info", metrics)
+	}
+	if strings.Contains(metrics, "vfio_running_vms") {
+		t.Errorf("metrics = %q, want no inventory gauges before the collection completes", metrics)
+	}
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"vfio_usb_passthrough/internals/utils"
+)
+
+// Where the kernel exposes block devices and mounted filesystems (overridable in tests)
+var (
+	blockSysfsPath = "/sys/class/block"
+	procMountsPath = "/proc/mounts"
+)
+
+// errCheckMounts is returned when a USB storage device is found but the mounted filesystems cannot be read
+var errCheckMounts = errors.New("cannot read the mounted filesystems")
+
+// DeviceMountedError is returned when attaching a USB storage device with filesystems mounted on the host
+// Taking it away from the host would be like unplugging it, losing unwritten data
+type DeviceMountedError struct {
+	VendorID  string
+	ProductID string
+	// Mounts are the mounted filesystems, as "/dev/sdb1 on /media/usb"
+	Mounts []string
+}
+
+func (e *DeviceMountedError) Error() string {
+	return fmt.Sprintf("device %s:%s has filesystems mounted on the host: %s",
+		e.VendorID, e.ProductID, strings.Join(e.Mounts, ", "))
+}
+
+// checkDeviceNotMounted returns a DeviceMountedError when a local USB device with the given IDs
+// (only the one at address when it is set) backs a mounted filesystem
+// Mass-storage devices are recognized by the block devices below them in sysfs; filesystems on a
+// device-mapper or md device stacked on a partition count too. Devices of other hosts cannot be checked
+// When sysfs cannot be read the device cannot be told apart from a non-storage one and a warning is logged;
+// a storage device whose mounts cannot be read is refused with errCheckMounts
+func checkDeviceNotMounted(host Host, vendorID, productID string, address *utils.USBAddressXML) error {
+	if !host.Local {
+		return nil
+	}
+
+	entries, err := os.ReadDir(usbSysfsPath)
+	if err != nil {
+		log.Printf("Warning: Cannot check whether %s:%s has filesystems mounted on the host, attaching without the check: %v", vendorID, productID, err)
+		return nil
+	}
+	var deviceDirs []string
+	for _, entry := range entries {
+		if !usbDevicePattern.MatchString(entry.Name()) {
+			continue
+		}
+		dir := filepath.Join(usbSysfsPath, entry.Name())
+		if readSysfsAttr(dir, "idVendor") != vendorID || readSysfsAttr(dir, "idProduct") != productID {
+			continue
+		}
+		if address != nil && !sysfsDeviceAt(dir, address) {
+			continue
+		}
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			deviceDirs = append(deviceDirs, resolved)
+		}
+	}
+	if len(deviceDirs) == 0 {
+		return nil
+	}
+
+	blockDevices, err := usbBlockDevices(deviceDirs)
+	if err != nil {
+		log.Printf("Warning: Cannot check whether %s:%s has filesystems mounted on the host, attaching without the check: %v", vendorID, productID, err)
+		return nil
+	}
+	if len(blockDevices) == 0 {
+		return nil
+	}
+	mounts, err := mountsOf(blockDevices)
+	if err != nil {
+		return fmt.Errorf("device %s:%s: %w: %w", vendorID, productID, errCheckMounts, err)
+	}
+	if len(mounts) == 0 {
+		return nil
+	}
+	return &DeviceMountedError{VendorID: vendorID, ProductID: productID, Mounts: mounts}
+}
+
+// sysfsDeviceAt reports whether a sysfs USB device is at the given bus and device number
+func sysfsDeviceAt(dir string, address *utils.USBAddressXML) bool {
+	bus, err := strconv.Atoi(readSysfsAttr(dir, "busnum"))
+	if err != nil {
+		return false
+	}
+	devnum, err := strconv.Atoi(readSysfsAttr(dir, "devnum"))
+	return err == nil && bus == address.Bus && devnum == address.Device
+}
+
+// usbBlockDevices returns the names of the disks and partitions below resolved sysfs USB device
+// directories, with the devices stacked on them (e.g. "sdb", "sdb1", "dm-0")
+func usbBlockDevices(deviceDirs []string) (map[string]bool, error) {
+	names := make(map[string]bool)
+	entries, err := os.ReadDir(blockSysfsPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		resolved, err := filepath.EvalSymlinks(filepath.Join(blockSysfsPath, entry.Name()))
+		if err != nil {
+			continue
+		}
+		for _, deviceDir := range deviceDirs {
+			if strings.HasPrefix(resolved, deviceDir+string(filepath.Separator)) {
+				addBlockHolders(entry.Name(), names)
+				break
+			}
+		}
+	}
+	return names, nil
+}
+
+// addBlockHolders adds a block device and, recursively, the devices holding it (LUKS, LVM, RAID)
+func addBlockHolders(name string, names map[string]bool) {
+	if names[name] {
+		return
+	}
+	names[name] = true
+	holders, err := os.ReadDir(filepath.Join(blockSysfsPath, name, "holders"))
+	if err != nil {
+		return
+	}
+	for _, holder := range holders {
+		addBlockHolders(holder.Name(), names)
+	}
+}
+
+// mountEscapes decodes the octal escapes of /proc/mounts fields
+var mountEscapes = strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+
+// mountsOf returns the mounted filesystems of block devices, as "source on mountpoint"
+func mountsOf(blockDevices map[string]bool) ([]string, error) {
+	file, err := os.Open(procMountsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var mounts []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.HasPrefix(fields[0], "/dev/") {
+			continue
+		}
+		source := mountEscapes.Replace(fields[0])
+		// Sources like /dev/mapper/name or /dev/disk/by-uuid/... are links to the device node
+		node := source
+		if resolved, err := filepath.EvalSymlinks(source); err == nil {
+			node = resolved
+		}
+		if blockDevices[filepath.Base(node)] {
+			mounts = append(mounts, source+" on "+mountEscapes.Replace(fields[1]))
+		}
+	}
+	return mounts, scanner.Err()
+}
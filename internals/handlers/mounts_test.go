@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"vfio_usb_passthrough/internals/utils"
+)
+
+// writeFile writes a file, creating its directory
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// symlink links name to target, creating the directory of name
+func symlink(t *testing.T, target, name string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeStorageSysfs lays out a USB stick 0781:5567 at 1-2 with partition sdb1 held by dm-0,
+// and a mouse 046d:c077 at 1-3, and points the sysfs and mounts paths at it
+func fakeStorageSysfs(t *testing.T, mounts string) {
+	root := t.TempDir()
+	devices := filepath.Join(root, "devices", "usb1")
+
+	stick := filepath.Join(devices, "1-2")
+	writeFile(t, filepath.Join(stick, "idVendor"), "0781\n")
+	writeFile(t, filepath.Join(stick, "idProduct"), "5567\n")
+	writeFile(t, filepath.Join(stick, "busnum"), "1\n")
+	writeFile(t, filepath.Join(stick, "devnum"), "4\n")
+	disk := filepath.Join(stick, "1-2:1.0", "host6", "target6:0:0", "6:0:0:0", "block", "sdb")
+	writeFile(t, filepath.Join(disk, "sdb1", "holders", "dm-0"), "")
+	writeFile(t, filepath.Join(root, "devices", "virtual", "block", "dm-0", "dev"), "253:0\n")
+
+	mouse := filepath.Join(devices, "1-3")
+	writeFile(t, filepath.Join(mouse, "idVendor"), "046d\n")
+	writeFile(t, filepath.Join(mouse, "idProduct"), "c077\n")
+
+	usbDir := filepath.Join(root, "bus", "usb", "devices")
+	symlink(t, stick, filepath.Join(usbDir, "1-2"))
+	symlink(t, mouse, filepath.Join(usbDir, "1-3"))
+
+	blockDir := filepath.Join(root, "class", "block")
+	symlink(t, disk, filepath.Join(blockDir, "sdb"))
+	symlink(t, filepath.Join(disk, "sdb1"), filepath.Join(blockDir, "sdb1"))
+	symlink(t, filepath.Join(root, "devices", "virtual", "block", "dm-0"), filepath.Join(blockDir, "dm-0"))
+
+	mountsPath := filepath.Join(root, "mounts")
+	writeFile(t, mountsPath, mounts)
+
+	oldUSB, oldBlock, oldMounts := usbSysfsPath, blockSysfsPath, procMountsPath
+	usbSysfsPath, blockSysfsPath, procMountsPath = usbDir, blockDir, mountsPath
+	t.Cleanup(func() {
+		usbSysfsPath, blockSysfsPath, procMountsPath = oldUSB, oldBlock, oldMounts
+	})
+}
+
+func TestCheckDeviceNotMounted(t *testing.T) {
+	fakeStorageSysfs(t, "/dev/sda2 / ext4 rw 0 0\n"+
+		"/dev/sdb1 /media/my\\040stick vfat rw 0 0\n"+
+		"/dev/dm-0 /mnt/secret ext4 rw 0 0\n"+
+		"tmpfs /tmp tmpfs rw 0 0\n")
+	local := Host{Name: "local", Local: true}
+
+	err := checkDeviceNotMounted(local, "0781", "5567", nil)
+	var mountedErr *DeviceMountedError
+	if !errors.As(err, &mountedErr) {
+		t.Fatalf("checkDeviceNotMounted() = %v, want a DeviceMountedError", err)
+	}
+	want := []string{"/dev/sdb1 on /media/my stick", "/dev/dm-0 on /mnt/secret"}
+	if !reflect.DeepEqual(mountedErr.Mounts, want) {
+		t.Errorf("mounts = %q, want %q", mountedErr.Mounts, want)
+	}
+
+	// Another device, another instance of the same device and a remote host are not blocked
+	if err := checkDeviceNotMounted(local, "046d", "c077", nil); err != nil {
+		t.Errorf("mouse: checkDeviceNotMounted() = %v, want nil", err)
+	}
+	if err := checkDeviceNotMounted(local, "0781", "5567", &utils.USBAddressXML{Bus: 1, Device: 9}); err != nil {
+		t.Errorf("other address: checkDeviceNotMounted() = %v, want nil", err)
+	}
+	if err := checkDeviceNotMounted(Host{Name: "remote"}, "0781", "5567", nil); err != nil {
+		t.Errorf("remote host: checkDeviceNotMounted() = %v, want nil", err)
+	}
+}
+
+func TestCheckDeviceNotMountedUnmounted(t *testing.T) {
+	fakeStorageSysfs(t, "/dev/sda2 / ext4 rw 0 0\n")
+	if err := checkDeviceNotMounted(Host{Name: "local", Local: true}, "0781", "5567", nil); err != nil {
+		t.Errorf("checkDeviceNotMounted() = %v, want nil", err)
+	}
+}
+
+func TestCheckDeviceNotMountedUnreadable(t *testing.T) {
+	fakeStorageSysfs(t, "/dev/sdb1 /media/stick vfat rw 0 0\n")
+	local := Host{Name: "local", Local: true}
+
+	// A storage device whose mounts cannot be read is refused
+	procMountsPath = filepath.Join(t.TempDir(), "missing")
+	if err := checkDeviceNotMounted(local, "0781", "5567", nil); !errors.Is(err, errCheckMounts) {
+		t.Errorf("unreadable mounts: checkDeviceNotMounted() = %v, want errCheckMounts", err)
+	}
+	// The mounts of other devices are never read
+	if err := checkDeviceNotMounted(local, "046d", "c077", nil); err != nil {
+		t.Errorf("mouse: checkDeviceNotMounted() = %v, want nil", err)
+	}
+}
@@ -0,0 +1,9 @@
+package handlers
+
+import "github.com/gofiber/fiber/v2"
+
+// Ping answers {"pong": true} without touching libvirt or the database, so the UI can tell
+// whether the backend is reachable (it is not rate limited)
+func Ping(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"pong": true})
+}
@@ -0,0 +1,20 @@
+package handlers
+
+import "time"
+
+// DevicesPollIntervalEnv sets how often clients are told to poll /api/devices-state (e.g. "5s"; default 5s)
+const DevicesPollIntervalEnv = "DEVICES_POLL_INTERVAL"
+
+// defaultDevicesPollInterval is the polling interval suggested when DEVICES_POLL_INTERVAL is unset
+const defaultDevicesPollInterval = 5 * time.Second
+
+// devicesPollInterval is the interval returned as pollIntervalMs in the devices state
+var devicesPollInterval = defaultDevicesPollInterval
+
+// SetDevicesPollInterval sets the polling interval suggested to clients (0 for the default)
+func SetDevicesPollInterval(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultDevicesPollInterval
+	}
+	devicesPollInterval = interval
+}
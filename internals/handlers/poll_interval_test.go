@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetDevicesPollInterval(t *testing.T) {
+	t.Cleanup(func() { devicesPollInterval = defaultDevicesPollInterval })
+
+	SetDevicesPollInterval(2 * time.Second)
+	if devicesPollInterval != 2*time.Second {
+		t.Fatalf("devicesPollInterval = %s, want 2s", devicesPollInterval)
+	}
+
+	SetDevicesPollInterval(0)
+	if devicesPollInterval != defaultDevicesPollInterval {
+		t.Fatalf("devicesPollInterval = %s, want the default %s", devicesPollInterval, defaultDevicesPollInterval)
+	}
+}
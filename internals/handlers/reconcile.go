@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"vfio_usb_passthrough/internals/db"
+	"vfio_usb_passthrough/internals/i18n"
+	"vfio_usb_passthrough/internals/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ReconcileIntervalEnv enables the background reconciler of declared device sets (disabled when unset)
+const ReconcileIntervalEnv = "RECONCILE_INTERVAL"
+
+// maxReconcileBackoffShift caps how many intervals a failing VM is skipped (1 << 5 = 32)
+const maxReconcileBackoffShift = 5
+
+// reconcilerEnabled is set once the background reconciler is started
+var reconcilerEnabled bool
+
+// desiredStateVMName returns the VM name of a desired-state route; the VM does not need to be running
+// A VM UUID is resolved to its name, other names must match vmNamePattern
+func desiredStateVMName(c *fiber.Ctx) (string, error) {
+	param := c.Params("vmName")
+	if vmUUIDPattern.MatchString(param) {
+		return resolveVMParam(hostFromCtx(c), param)
+	}
+	if !isValidVMNameFormat(param) {
+		return "", ErrVMNameInvalidFormat
+	}
+	return param, nil
+}
+
+// GetDesiredState returns the declared device set of a VM
+func GetDesiredState(c *fiber.Ctx) error {
+	vmName, err := desiredStateVMName(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Localize(c, err),
+		})
+	}
+
+	devices, err := db.GetDesiredDevices(hostKey(hostFromCtx(c)), vmName)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "get_desired_state_failed"),
+			"details": err.Error(),
+		})
+	}
+	if devices == nil {
+		devices = []db.DesiredDevice{}
+	}
+
+	return c.JSON(fiber.Map{
+		"vmName":  vmName,
+		"devices": devices,
+	})
+}
+
+// SetDesiredState stores the declared device set of a VM for the background reconciler
+// The VM does not need to be running; the body has the same format as the apply endpoint
+func SetDesiredState(c *fiber.Ctx) error {
+	vmName, err := desiredStateVMName(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Localize(c, err),
+		})
+	}
+
+	desired, reqErr := readDesiredDevices(c)
+	if reqErr != nil {
+		return reqErr.send(c)
+	}
+
+	devices := make([]db.DesiredDevice, 0, len(desired))
+	for _, device := range desired {
+		devices = append(devices, db.DesiredDevice{VendorID: device.VendorID, ProductID: device.ProductID})
+	}
+
+	if err := db.SetDesiredDevices(hostKey(hostFromCtx(c)), vmName, devices); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "set_desired_state_failed"),
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"vmName":  vmName,
+		"devices": devices,
+	})
+}
+
+// ClearDesiredState removes the declared device set of a VM
+func ClearDesiredState(c *fiber.Ctx) error {
+	vmName, err := desiredStateVMName(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Localize(c, err),
+		})
+	}
+
+	if err := db.ClearDesiredDevices(hostKey(hostFromCtx(c)), vmName); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "set_desired_state_failed"),
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+	})
+}
+
+// AutoTarget is a VM whose declared device set includes a device
+type AutoTarget struct {
+	VMName  string `json:"vmName"`
+	Running bool   `json:"running"`
+}
+
+// GetAutoTarget returns the VM the reconciler attaches a device to: the first running VM (in virsh
+// order, as the reconciler goes) declaring it, else the first declaring VM by name, or null if none does
+// Every declaring VM is listed in candidates; reconcilerEnabled tells whether the reconciler runs at all
+func GetAutoTarget(c *fiber.Ctx) error {
+	vendorID := normalizeDeviceID(c.Params("vendorId"))
+	productID := normalizeDeviceID(c.Params("productId"))
+	if !utils.IsValidHexID(vendorID) || !utils.IsValidHexID(productID) {
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Msg(c, "invalid_device_id"),
+		})
+	}
+
+	host := hostFromCtx(c)
+	desiredByVM, err := db.GetAllDesiredDevices(hostKey(host))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "get_desired_state_failed"),
+			"details": err.Error(),
+		})
+	}
+
+	runningVMs, err := getRunningVMNames(host)
+	if err != nil {
+		log.Printf("GetAutoTarget: Failed to list running VMs: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "list_vms_failed"),
+			"details": err.Error(),
+		})
+	}
+
+	target, candidates := autoTarget(desiredByVM, runningVMs, vendorID, productID)
+	return c.JSON(fiber.Map{
+		"vendorId":          vendorID,
+		"productId":         productID,
+		"target":            target,
+		"candidates":        candidates,
+		"reconcilerEnabled": reconcilerEnabled,
+	})
+}
+
+// autoTarget returns the VM a device is reconciled to (nil if no VM declares it) and every VM declaring it, by name
+func autoTarget(desiredByVM map[string][]db.DesiredDevice, runningVMs []string, vendorID, productID string) (*AutoTarget, []AutoTarget) {
+	running := make(map[string]bool, len(runningVMs))
+	for _, vmName := range runningVMs {
+		running[vmName] = true
+	}
+
+	declares := func(vmName string) bool {
+		for _, device := range desiredByVM[vmName] {
+			if normalizeDeviceID(device.VendorID) == vendorID && normalizeDeviceID(device.ProductID) == productID {
+				return true
+			}
+		}
+		return false
+	}
+
+	candidates := []AutoTarget{}
+	for vmName := range desiredByVM {
+		if declares(vmName) {
+			candidates = append(candidates, AutoTarget{VMName: vmName, Running: running[vmName]})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].VMName < candidates[j].VMName })
+
+	for _, vmName := range runningVMs {
+		if declares(vmName) {
+			return &AutoTarget{VMName: vmName, Running: true}, candidates
+		}
+	}
+	if len(candidates) > 0 {
+		return &candidates[0], candidates
+	}
+	return nil, candidates
+}
+
+// reconcileBackoff tracks consecutive failures of a VM to avoid thrashing
+type reconcileBackoff struct {
+	failures int
+	skip     int
+}
+
+// StartReconciler re-attaches declared devices that dropped off running VMs of every libvirt connection
+// every interval. Devices are only attached, never detached. VMs that keep failing are retried with
+// exponential backoff (in intervals). Does nothing if interval is 0.
+func StartReconciler(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	log.Printf("Reconciling declared devices every %s", interval)
+	reconcilerEnabled = true
+	go func() {
+		backoff := make(map[trackedVM]*reconcileBackoff)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			reconcileAll(backoff)
+		}
+	}()
+}
+
+// reconcileAll runs one reconciliation pass over the libvirt connections
+func reconcileAll(backoff map[trackedVM]*reconcileBackoff) {
+	for _, host := range hosts {
+		reconcileHost(host, backoff)
+	}
+}
+
+// reconcileHost runs one reconciliation pass over the running VMs of a host that have a declared device set
+func reconcileHost(host Host, backoff map[trackedVM]*reconcileBackoff) {
+	desiredByVM, err := db.GetAllDesiredDevices(hostKey(host))
+	if err != nil {
+		log.Printf("Reconcile: Warning - failed to get desired devices of %s: %v", host.Name, err)
+		return
+	}
+	if len(desiredByVM) == 0 {
+		return
+	}
+
+	runningVMs, err := getRunningVMNames(host)
+	if err != nil {
+		log.Printf("Reconcile: Warning - failed to list running VMs of %s: %v", host.Name, err)
+		return
+	}
+
+	for _, vmName := range runningVMs {
+		devices, ok := desiredByVM[vmName]
+		if !ok {
+			continue
+		}
+
+		key := trackedVM{Host: host, VMName: vmName}
+		state := backoff[key]
+		if state == nil {
+			state = &reconcileBackoff{}
+			backoff[key] = state
+		}
+		if state.skip > 0 {
+			state.skip--
+			continue
+		}
+
+		desired := make([]AttachedDeviceResponse, 0, len(devices))
+		for _, device := range devices {
+			desired = append(desired, AttachedDeviceResponse{VendorID: device.VendorID, ProductID: device.ProductID})
+		}
+
+		result, err := applyDesiredState(context.Background(), host, vmName, desired, false, "reconciler")
+		if err == nil && result.Success {
+			state.failures = 0
+			continue
+		}
+
+		state.failures++
+		state.skip = 1 << min(state.failures-1, maxReconcileBackoffShift)
+		if err != nil {
+			log.Printf("Reconcile: Warning - failed to reconcile %s on %s: %v", vmName, host.Name, err)
+		}
+		log.Printf("Reconcile: %s on %s failed %d time(s) in a row, skipping %d interval(s)", vmName, host.Name, state.failures, state.skip)
+	}
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"vfio_usb_passthrough/internals/db"
+	"vfio_usb_passthrough/internals/utils"
+)
+
+func TestAutoTarget(t *testing.T) {
+	mouse := db.DesiredDevice{VendorID: "046d", ProductID: "c077"}
+	desired := map[string][]db.DesiredDevice{
+		"work":   {mouse},
+		"gaming": {mouse, {VendorID: "1234", ProductID: "5678"}},
+		"media":  {{VendorID: "1234", ProductID: "5678"}},
+	}
+
+	// The first running VM in virsh order wins, as in the reconciler
+	target, candidates := autoTarget(desired, []string{"media", "work", "gaming"}, "046d", "c077")
+	if want := (&AutoTarget{VMName: "work", Running: true}); !reflect.DeepEqual(target, want) {
+		t.Errorf("target = %+v, want %+v", target, want)
+	}
+	wantCandidates := []AutoTarget{{VMName: "gaming", Running: true}, {VMName: "work", Running: true}}
+	if !reflect.DeepEqual(candidates, wantCandidates) {
+		t.Errorf("candidates = %+v, want %+v", candidates, wantCandidates)
+	}
+
+	// Without a running VM, the first declaring VM by name is reported as not running
+	target, _ = autoTarget(desired, []string{"media"}, "046d", "c077")
+	if want := (&AutoTarget{VMName: "gaming"}); !reflect.DeepEqual(target, want) {
+		t.Errorf("target = %+v, want %+v", target, want)
+	}
+
+	target, candidates = autoTarget(desired, []string{"media"}, "dead", "beef")
+	if target != nil || len(candidates) != 0 {
+		t.Errorf("undeclared device: target = %+v, candidates = %+v, want none", target, candidates)
+	}
+}
+
+// fakeReconcileVirsh returns a virsh script for hosts running win10 without devices; attach-device
+// appends the connection URI to calls and fails while the file fail exists
+func fakeReconcileVirsh(calls, fail string) string {
+	return `case "$1" in
+list) echo win10; exit 0 ;;
+dumpxml) echo "<domain><name>win10</name><devices></devices></domain>"; exit 0 ;;
+attach-device)
+  echo "$LIBVIRT_DEFAULT_URI" >> ` + calls + `
+  [ -e ` + fail + ` ] && { echo "error: internal error: unable to attach" >&2; exit 1; }
+  exit 0 ;;
+esac
+exit 0
+`
+}
+
+// countLines returns the number of lines of a file, 0 if it does not exist
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(data), "\n")
+}
+
+func TestReconcileBackoff(t *testing.T) {
+	setupTestDB(t)
+	dir := t.TempDir()
+	calls, fail := filepath.Join(dir, "calls"), filepath.Join(dir, "fail")
+	writeFile(t, fail, "")
+	t.Setenv(utils.VirshBinEnv, fakeCommand(t, fakeReconcileVirsh(calls, fail)))
+	if err := db.SetDesiredDevices("", "win10", []db.DesiredDevice{{VendorID: "046d", ProductID: "c077"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failing VM is retried after 1, 2, then 4 skipped passes
+	backoff := make(map[trackedVM]*reconcileBackoff)
+	var attempted []int
+	for pass := 1; pass <= 11; pass++ {
+		before := countLines(t, calls)
+		reconcileAll(backoff)
+		if countLines(t, calls) > before {
+			attempted = append(attempted, pass)
+		}
+	}
+	if want := []int{1, 3, 6, 11}; !reflect.DeepEqual(attempted, want) {
+		t.Errorf("attempts in passes %v, want %v", attempted, want)
+	}
+
+	// A success resets the backoff: after the pending skips the VM is tried on every pass again
+	if err := os.Remove(fail); err != nil {
+		t.Fatal(err)
+	}
+	state := backoff[trackedVM{Host: defaultHost(), VMName: "win10"}]
+	for state.skip > 0 {
+		reconcileAll(backoff)
+	}
+	reconcileAll(backoff)
+	if state.failures != 0 || state.skip != 0 {
+		t.Errorf("after a success backoff = %+v, want none", state)
+	}
+}
+
+func TestReconcileAllHosts(t *testing.T) {
+	setupTestDB(t)
+	oldHosts := hosts
+	t.Cleanup(func() { hosts = oldHosts })
+	hosts = []Host{{Name: "local", URI: "qemu:///system", Local: true}, {Name: "lab", URI: "qemu+ssh://lab/system"}}
+
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	t.Setenv(utils.VirshBinEnv, fakeCommand(t, fakeReconcileVirsh(calls, filepath.Join(dir, "fail"))))
+	if err := db.SetDesiredDevices("lab", "win10", []db.DesiredDevice{{VendorID: "046d", ProductID: "c077"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only lab declares devices, so only its win10 gets an attach
+	reconcileAll(make(map[trackedVM]*reconcileBackoff))
+	data, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "qemu+ssh://lab/system" {
+		t.Errorf("attaches on %q, want one on qemu+ssh://lab/system", got)
+	}
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SSH round-trips are slow, so remote device lists are cached; a stale list is served if SSH fails
+const (
+	remoteUSBCacheTTL = 60 * time.Second
+	remoteUSBTimeout  = 15 * time.Second
+)
+
+// remoteUSBSysfsScript prints "busnum devnum speed version bMaxPower bDeviceClass bInterfaceClass serial"
+// for each USB device ("-" if unreadable); the interface class is that of the first interface
+// and the serial comes last as it may contain spaces
+const remoteUSBSysfsScript = `for d in /sys/bus/usb/devices/*; do [ -r "$d/devnum" ] || continue; ` +
+	`line=""; for a in busnum devnum speed version bMaxPower bDeviceClass; do v=$(cat "$d/$a" 2>/dev/null | tr -d ' '); line="$line ${v:--}"; done; ` +
+	`v=$(cat "$d"/"${d##*/}":*/bInterfaceClass 2>/dev/null | head -n 1); s=$(cat "$d/serial" 2>/dev/null); echo $line ${v:--} ${s:--}; done`
+
+// remoteUSBEntry is a cached remote device list
+type remoteUSBEntry struct {
+	devices   []USBDeviceResponse
+	fetchedAt time.Time
+}
+
+// remoteUSBCache holds the last device list read from each remote host
+var remoteUSBCache = struct {
+	sync.Mutex
+	entries map[string]remoteUSBEntry
+}{entries: make(map[string]remoteUSBEntry)}
+
+// getRemoteUSBDevices returns the cached device list of a host, refreshing it over SSH when older than maxAge
+// (a zero maxAge always refreshes it)
+// On SSH failure the previous list is returned along with the error (zero fetchedAt if there is none)
+func getRemoteUSBDevices(host Host, maxAge time.Duration) ([]USBDeviceResponse, time.Time, error) {
+	remoteUSBCache.Lock()
+	cached, ok := remoteUSBCache.entries[host.Name]
+	remoteUSBCache.Unlock()
+	if ok && time.Since(cached.fetchedAt) < maxAge {
+		return cached.devices, cached.fetchedAt, nil
+	}
+
+	devices, err := fetchRemoteUSBDevices(host)
+	if err != nil {
+		return cached.devices, cached.fetchedAt, err
+	}
+
+	entry := remoteUSBEntry{devices: devices, fetchedAt: time.Now()}
+	remoteUSBCache.Lock()
+	remoteUSBCache.entries[host.Name] = entry
+	remoteUSBCache.Unlock()
+	return entry.devices, entry.fetchedAt, nil
+}
+
+// fetchRemoteUSBDevices runs lsusb and reads sysfs attributes on a host over SSH
+func fetchRemoteUSBDevices(host Host) ([]USBDeviceResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteUSBTimeout)
+	defer cancel()
+
+	runner := runnerFor(host)
+	// Like locally, a non-zero lsusb exit is tolerated if devices were listed
+	output, lsusbErr := runner.Output(ctx, "lsusb")
+	if len(output) == 0 && lsusbErr != nil {
+		return nil, lsusbErr
+	}
+
+	// Attributes are best-effort, as they are for local devices
+	sysfsInfo := make(map[string]usbSysfsInfo)
+	if sysfsOutput, err := runner.Output(ctx, "sh", "-c", remoteUSBSysfsScript); err == nil {
+		sysfsInfo = parseRemoteUSBSysfsInfo(string(sysfsOutput))
+	} else {
+		log.Printf("Warning: Failed to read USB sysfs attributes on %s: %v", host.Name, err)
+	}
+
+	devices := parseLSUSB(string(output), sysfsInfo)
+	if err := toleratePartialOutput("lsusb on "+host.Name, len(devices), lsusbErr); err != nil {
+		return nil, err
+	}
+	return usbFilter.apply(devices), nil
+}
+
+// parseRemoteUSBSysfsInfo parses the output of remoteUSBSysfsScript, keyed by bus:devnum
+func parseRemoteUSBSysfsInfo(output string) map[string]usbSysfsInfo {
+	info := make(map[string]usbSysfsInfo)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 8 {
+			continue
+		}
+		bus, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		devnum, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		for i := range fields {
+			if fields[i] == "-" {
+				fields[i] = ""
+			}
+		}
+		info[usbBusDevKey(bus, devnum)] = usbSysfsInfo{
+			Speed:       fields[2],
+			USBVersion:  fields[3],
+			MaxPower:    fields[4],
+			DeviceClass: usbClassLabel(fields[5], fields[6]),
+			Serial:      strings.Join(fields[7:], " "),
+		}
+	}
+	return info
+}
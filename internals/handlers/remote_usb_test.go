@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestParseRemoteUSBSysfsInfo(t *testing.T) {
+	output := "1 1 480 2.00 0mA 09 09 0000:00:14.0\n" +
+		"1 4 12 2.00 100mA 00 03 -\n" +
+		"2 3 5000 3.20 896mA 00 08 AA0123 4567\n" +
+		"3 2 - - - - - -\n" +
+		"x 2 12 2.00 100mA 00 03 -\n" +
+		"1 5 12 2.00\n" +
+		"\n"
+
+	want := map[string]usbSysfsInfo{
+		"1:1": {Speed: "480", USBVersion: "2.00", MaxPower: "0mA", DeviceClass: usbClassLabel("09", ""), Serial: "0000:00:14.0"},
+		"1:4": {Speed: "12", USBVersion: "2.00", MaxPower: "100mA", DeviceClass: usbClassLabel("00", "03")},
+		"2:3": {Speed: "5000", USBVersion: "3.20", MaxPower: "896mA", DeviceClass: usbClassLabel("00", "08"), Serial: "AA0123 4567"},
+		"3:2": {},
+	}
+	if got := parseRemoteUSBSysfsInfo(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseRemoteUSBSysfsInfo() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDevicesStateListsHostDevices(t *testing.T) {
+	setupTestDB(t)
+	lab := Host{Name: "lab", URI: "qemu+ssh://root@lab/system", SSH: "root@lab"}
+	remote := []USBDeviceResponse{{VendorID: "0781", ProductID: "5583", Description: "SanDisk Corp. Ultra Fit"}}
+
+	remoteUSBCache.Lock()
+	remoteUSBCache.entries[lab.Name] = remoteUSBEntry{devices: remote, fetchedAt: time.Now()}
+	remoteUSBCache.Unlock()
+	t.Cleanup(func() {
+		remoteUSBCache.Lock()
+		delete(remoteUSBCache.entries, lab.Name)
+		remoteUSBCache.Unlock()
+	})
+
+	state, err := Devices.DevicesState(context.Background(), lab, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(state.Devices, remote) {
+		t.Errorf("DevicesState() devices = %+v, want those of lab %+v", state.Devices, remote)
+	}
+}
+
+func TestRefreshUSBDevices(t *testing.T) {
+	lab := Host{Name: "lab", URI: "qemu+ssh://root@lab/system", SSH: "root@lab"}
+	t.Cleanup(func() {
+		remoteUSBCache.Lock()
+		delete(remoteUSBCache.entries, lab.Name)
+		remoteUSBCache.Unlock()
+	})
+
+	// lab lists a stick plugged in after its devices were cached
+	remoteUSBCache.Lock()
+	remoteUSBCache.entries[lab.Name] = remoteUSBEntry{
+		devices:   []USBDeviceResponse{{VendorID: "046d", ProductID: "c077", Description: "Logitech, Inc. Mouse"}},
+		fetchedAt: time.Now(),
+	}
+	remoteUSBCache.Unlock()
+	bin := t.TempDir()
+	writeFile(t, filepath.Join(bin, "ssh"), `#!/bin/sh
+case "$*" in
+*lsusb*)
+  echo "Bus 001 Device 004: ID 046d:c077 Logitech, Inc. Mouse"
+  echo "Bus 001 Device 005: ID 0781:5583 SanDisk Corp. Ultra Fit" ;;
+esac
+`)
+	if err := os.Chmod(filepath.Join(bin, "ssh"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("host", lab)
+		return c.Next()
+	})
+	app.Get("/usb-devices", ListUSBDevices)
+	app.Post("/usb-devices/refresh", RefreshUSBDevices)
+
+	listedProducts := func(method, path string) []string {
+		t.Helper()
+		resp, err := app.Test(httptest.NewRequest(method, path, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Fatalf("%s %s status = %d, want 200", method, path, resp.StatusCode)
+		}
+		var body struct {
+			Devices []USBDeviceResponse `json:"devices"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		var products []string
+		for _, device := range body.Devices {
+			products = append(products, device.ProductID)
+		}
+		return products
+	}
+
+	if got := listedProducts("GET", "/usb-devices"); !reflect.DeepEqual(got, []string{"c077"}) {
+		t.Errorf("cached devices = %v, want [c077]", got)
+	}
+	if got := listedProducts("POST", "/usb-devices/refresh"); !reflect.DeepEqual(got, []string{"c077", "5583"}) {
+		t.Errorf("refreshed devices = %v, want [c077 5583]", got)
+	}
+	// The refreshed list replaces the cached one
+	if got := listedProducts("GET", "/usb-devices"); !reflect.DeepEqual(got, []string{"c077", "5583"}) {
+		t.Errorf("devices after refresh = %v, want [c077 5583]", got)
+	}
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"vfio_usb_passthrough/internals/i18n"
+	"vfio_usb_passthrough/internals/middleware"
+	"vfio_usb_passthrough/internals/mqtt"
+	"vfio_usb_passthrough/internals/webhook"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Page layouts (under views/layouts)
+const (
+	// DefaultLayout wraps pages with the shared head, headers and footers
+	DefaultLayout = "layouts/base"
+	// NoLayout renders a page on its own, e.g. for fragments
+	NoLayout = "layouts/no_layout"
+)
+
+// Locals keys used when rendering pages
+const (
+	// pageDataKey holds the common page data stored by InjectPageData
+	pageDataKey = "pageData"
+	// csrfTokenKey is where a CSRF middleware stores the request token
+	csrfTokenKey = "csrf"
+)
+
+// appVersion is the version shown in pages
+var appVersion = "dev"
+
+// SetVersion sets the version shown in pages
+func SetVersion(version string) {
+	appVersion = version
+}
+
+// InjectPageData stores the data common to every page in Locals for Render to merge
+// Use it on page routes so the layout always has what it needs
+func InjectPageData(c *fiber.Ctx) error {
+	c.Locals(pageDataKey, pageData(c))
+	return c.Next()
+}
+
+// Render renders a page with the common page data (from InjectPageData, computed if missing)
+// Page data takes precedence over common data; the layout defaults to DefaultLayout, or to NoLayout
+// for partial requests (see isPartialRequest)
+func Render(c *fiber.Ctx, page string, data fiber.Map, layout ...string) error {
+	common, ok := c.Locals(pageDataKey).(fiber.Map)
+	if !ok {
+		common = pageData(c)
+	}
+
+	bind := make(fiber.Map, len(common)+len(data))
+	for key, value := range common {
+		bind[key] = value
+	}
+	for key, value := range data {
+		bind[key] = value
+	}
+
+	// The same URL answers full pages and fragments, caches must tell them apart
+	c.Vary("HX-Request")
+	if len(layout) == 0 && isPartialRequest(c) {
+		layout = []string{NoLayout}
+	}
+	return c.Render(page, bind, layout...)
+}
+
+// isPartialRequest reports whether a request swaps a fragment into the current page (HTMX sets HX-Request)
+// Boosted links and forms replace the whole page, so they keep the layout
+func isPartialRequest(c *fiber.Ctx) bool {
+	return c.Get("HX-Request") == "true" && c.Get("HX-Boosted") != "true"
+}
+
+// pageData returns the data every page template can rely on:
+// Theme, Version, Lang, CSRFToken, AuthEnabled and Features (optional features that are on)
+func pageData(c *fiber.Ctx) fiber.Map {
+	theme := c.Cookies("theme")
+	if theme != "dark" {
+		theme = "light"
+	}
+	csrfToken, _ := c.Locals(csrfTokenKey).(string)
+
+	return fiber.Map{
+		"Theme":       theme,
+		"Version":     appVersion,
+		"Lang":        i18n.Lang(c),
+		"CSRFToken":   csrfToken,
+		"AuthEnabled": middleware.BasicAuthEnabled(),
+		"Features":    featureFlags(),
+	}
+}
+
+// featureFlags reports which optional features are enabled by the runtime configuration
+func featureFlags() fiber.Map {
+	remoteUSB := false
+	for _, host := range hosts {
+		if host.SSH != "" {
+			remoteUSB = true
+		}
+	}
+
+	return fiber.Map{
+		"multiHost": len(hosts) > 1,
+		"remoteUSB": remoteUSB,
+		"mqtt":      mqtt.Enabled(),
+		"webhooks":  webhook.Enabled(),
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/template/html/v2"
+)
+
+// renderPage serves the "row" template through Render with the given request headers
+func renderPage(t *testing.T, headers map[string]string) (string, *http.Response) {
+	t.Helper()
+	views := fstest.MapFS{
+		"layouts/base.html":      {Data: []byte("<html>{{embed}}</html>")},
+		"layouts/no_layout.html": {Data: []byte("{{embed}}")},
+		"row.html":               {Data: []byte("<tr>{{.Name}}</tr>")},
+	}
+	app := fiber.New(fiber.Config{
+		Views:       html.NewFileSystem(http.FS(views), ".html"),
+		ViewsLayout: DefaultLayout,
+	})
+	app.Get("/row", func(c *fiber.Ctx) error {
+		return Render(c, "row", fiber.Map{"Name": "mouse"})
+	})
+
+	req := httptest.NewRequest("GET", "/row", nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body), resp
+}
+
+func TestRenderPartialRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{"full page", nil, "<html><tr>mouse</tr></html>"},
+		{"htmx swap", map[string]string{"HX-Request": "true"}, "<tr>mouse</tr>"},
+		{"boosted link", map[string]string{"HX-Request": "true", "HX-Boosted": "true"}, "<html><tr>mouse</tr></html>"},
+	}
+	for _, tt := range tests {
+		body, resp := renderPage(t, tt.headers)
+		if body != tt.want {
+			t.Errorf("%s: body = %q, want %q", tt.name, body, tt.want)
+		}
+		if vary := resp.Header.Get(fiber.HeaderVary); vary != "HX-Request" {
+			t.Errorf("%s: Vary = %q, want HX-Request", tt.name, vary)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+
+	"vfio_usb_passthrough/internals/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// CommandRunner runs a command on the machine whose USB devices are enumerated
+type CommandRunner interface {
+	Output(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// localRunner runs commands directly on this machine
+type localRunner struct{}
+
+// Output runs the command locally and returns its standard output
+func (localRunner) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	ctx, span := tracing.StartExec(ctx, name, args)
+	output, err := exec.CommandContext(ctx, name, args...).Output()
+	tracing.EndExec(span, err)
+	return output, err
+}
+
+// sshConnectTimeout bounds establishing an SSH connection to a remote host
+const sshConnectTimeout = 5 * time.Second
+
+// sshRunner runs commands on a remote host over SSH (key-based, non-interactive)
+type sshRunner struct {
+	target string // [user@]host
+	port   string
+}
+
+// Output runs the command on the remote host and returns its standard output
+// Arguments are quoted since the remote shell parses the command line
+func (r sshRunner) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	sshArgs := []string{
+		"-o", "BatchMode=yes",
+		"-o", fmt.Sprintf("ConnectTimeout=%d", int(sshConnectTimeout.Seconds())),
+	}
+	if r.port != "" {
+		sshArgs = append(sshArgs, "-p", r.port)
+	}
+
+	quoted := []string{shellQuote(name)}
+	for _, arg := range args {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	sshArgs = append(sshArgs, r.target, "--", strings.Join(quoted, " "))
+
+	ctx, span := tracing.StartExec(ctx, name, args, attribute.String("ssh.target", r.target))
+	output, err := exec.CommandContext(ctx, "ssh", sshArgs...).Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		err = fmt.Errorf("ssh %s: %w: %s", r.target, err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	tracing.EndExec(span, err)
+	return output, err
+}
+
+// toleratePartialOutput drops the error of a command that exited non-zero but still printed parsed
+// usable entries (e.g. lsusb warning about a device it cannot open), logging it instead
+// Errors of commands that did not run, or printed nothing usable, are returned
+func toleratePartialOutput(name string, parsed int, err error) error {
+	var exitErr *exec.ExitError
+	if err == nil || parsed == 0 || !errors.As(err, &exitErr) {
+		return err
+	}
+	log.Printf("Warning: %s exited with an error but listed %d entries, using them: %v", name, parsed, err)
+	return nil
+}
+
+// shellQuote quotes a string for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// runnerFor returns the runner that enumerates USB devices for a libvirt connection
+func runnerFor(host Host) CommandRunner {
+	if host.SSH == "" {
+		return localRunner{}
+	}
+	target, port := host.SSH, ""
+	if i := strings.LastIndex(target, ":"); i > strings.LastIndex(target, "]") {
+		target, port = target[:i], target[i+1:]
+	}
+	return sshRunner{target: target, port: port}
+}
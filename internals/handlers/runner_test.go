@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"vfio_usb_passthrough/internals/utils"
+)
+
+// fakeCommand writes an executable shell script standing in for a command and returns its path
+func fakeCommand(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "fake")
+	writeFile(t, path, "#!/bin/sh\n"+script)
+	if err := os.Chmod(path, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGetUSBDevicesListPartialOutput(t *testing.T) {
+	oldSysfs := usbSysfsPath
+	usbSysfsPath = t.TempDir()
+	t.Cleanup(func() { usbSysfsPath = oldSysfs })
+
+	t.Setenv(utils.LsusbBinEnv, fakeCommand(t, `echo "Bus 001 Device 004: ID 046d:c077 Logitech, Inc. Mouse"
+echo "Bus 001 Device 005: ID 0781:5567 SanDisk Corp. Cruzer Blade"
+echo "lsusb: cannot open /dev/bus/usb/001/006: Permission denied" >&2
+exit 1
+`))
+	devices, err := getUSBDevicesList()
+	if err != nil {
+		t.Fatalf("getUSBDevicesList() = %v, want the listed devices", err)
+	}
+	if len(devices) != 2 || devices[0].ProductID != "c077" || devices[1].ProductID != "5567" {
+		t.Errorf("devices = %+v, want the mouse and the stick", devices)
+	}
+
+	// Nothing usable is still an error
+	t.Setenv(utils.LsusbBinEnv, fakeCommand(t, "echo 'lsusb: failed' >&2\nexit 1\n"))
+	if _, err := getUSBDevicesList(); err == nil {
+		t.Error("getUSBDevicesList() = nil error, want an error without devices")
+	}
+}
+
+func TestGetRunningVMNamesPartialOutput(t *testing.T) {
+	t.Setenv(utils.VirshBinEnv, fakeCommand(t, "printf 'win10\\n\\nubuntu\\n'\necho 'error: failed to get domain' >&2\nexit 1\n"))
+	vms, err := getRunningVMNames(Host{Name: "local", Local: true})
+	if err != nil {
+		t.Fatalf("getRunningVMNames() = %v, want the listed VMs", err)
+	}
+	if want := []string{"win10", "ubuntu"}; !reflect.DeepEqual(vms, want) {
+		t.Errorf("vms = %q, want %q", vms, want)
+	}
+
+	t.Setenv(utils.VirshBinEnv, fakeCommand(t, "echo 'error: failed to connect' >&2\nexit 1\n"))
+	if _, err := getRunningVMNames(Host{Name: "local", Local: true}); err == nil {
+		t.Error("getRunningVMNames() = nil error, want an error without VMs")
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"lsusb", "'lsusb'"},
+		{"", "''"},
+		{"a b", "'a b'"},
+		{"it's", `'it'\''s'`},
+		{"$(reboot); `id`", "'$(reboot); `id`'"},
+	}
+	for _, tt := range tests {
+		if got := shellQuote(tt.in); got != tt.want {
+			t.Errorf("shellQuote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+
+		// The shell reads the quoted string back unchanged
+		output, err := exec.Command("sh", "-c", "printf %s "+shellQuote(tt.in)).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(output) != tt.in {
+			t.Errorf("sh read shellQuote(%q) as %q", tt.in, output)
+		}
+	}
+}
+
+func TestRunnerFor(t *testing.T) {
+	tests := []struct {
+		ssh  string
+		want CommandRunner
+	}{
+		{"", localRunner{}},
+		{"lab", sshRunner{target: "lab"}},
+		{"root@lab:2222", sshRunner{target: "root@lab", port: "2222"}},
+		{"[2001:db8::1]", sshRunner{target: "[2001:db8::1]"}},
+		{"root@[2001:db8::1]:22", sshRunner{target: "root@[2001:db8::1]", port: "22"}},
+	}
+	for _, tt := range tests {
+		if got := runnerFor(Host{Name: "lab", SSH: tt.ssh}); got != tt.want {
+			t.Errorf("runnerFor(SSH %q) = %#v, want %#v", tt.ssh, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"vfio_usb_passthrough/internals/i18n"
+	"vfio_usb_passthrough/internals/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// LogDeviceSerialsEnv enables logging device serial numbers in full (they are masked by default)
+const LogDeviceSerialsEnv = "LOG_DEVICE_SERIALS"
+
+// Errors returned when resolving a device serial number to its host address
+var (
+	ErrSerialNotFound  = errors.New("no connected device with this serial number")
+	ErrSerialAmbiguous = errors.New("several connected devices share this serial number")
+	ErrSerialLocalOnly = errors.New("serial numbers can only be resolved on the local host")
+)
+
+// resolveUSBSerial returns the host address of the connected device with the given IDs and serial number
+func resolveUSBSerial(host Host, vendorID, productID, serial string) (*utils.USBAddressXML, error) {
+	if !host.Local {
+		return nil, ErrSerialLocalOnly
+	}
+
+	entries, err := os.ReadDir(usbSysfsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var address *utils.USBAddressXML
+	for _, entry := range entries {
+		if !usbDevicePattern.MatchString(entry.Name()) {
+			continue
+		}
+		dir := filepath.Join(usbSysfsPath, entry.Name())
+		if readSysfsAttr(dir, "idVendor") != vendorID || readSysfsAttr(dir, "idProduct") != productID ||
+			readSysfsAttr(dir, "serial") != serial {
+			continue
+		}
+		bus, err := strconv.Atoi(readSysfsAttr(dir, "busnum"))
+		if err != nil {
+			continue
+		}
+		devnum, err := strconv.Atoi(readSysfsAttr(dir, "devnum"))
+		if err != nil {
+			continue
+		}
+		if address != nil {
+			return nil, ErrSerialAmbiguous
+		}
+		address = &utils.USBAddressXML{Bus: bus, Device: devnum}
+	}
+
+	if address == nil {
+		return nil, ErrSerialNotFound
+	}
+	return address, nil
+}
+
+// redactSerial masks a serial number for logging, keeping its last 4 characters,
+// unless LOG_DEVICE_SERIALS is true
+func redactSerial(serial string) string {
+	if serial == "" || os.Getenv(LogDeviceSerialsEnv) == "true" {
+		return serial
+	}
+	if len(serial) <= 4 {
+		return strings.Repeat("*", len(serial))
+	}
+	return "****" + serial[len(serial)-4:]
+}
+
+// requestDeviceAddress returns the host address selected by an attach/detach request:
+// its explicit address, the address of the device with its serial number, or nil
+func requestDeviceAddress(host Host, req AttachDetachRequest, vendorID, productID string) (*utils.USBAddressXML, error) {
+	if req.Address != nil {
+		return req.Address, nil
+	}
+	return resolveRequestSerial(host, vendorID, productID, req.Serial)
+}
+
+// resolveRequestSerial returns the host address of the device with the given serial number,
+// or nil when the serial number is empty
+func resolveRequestSerial(host Host, vendorID, productID, serial string) (*utils.USBAddressXML, error) {
+	serial = strings.TrimSpace(serial)
+	if serial == "" {
+		return nil, nil
+	}
+	address, err := resolveUSBSerial(host, vendorID, productID, serial)
+	if err != nil {
+		log.Printf("Could not resolve serial %s of %s:%s: %v", redactSerial(serial), vendorID, productID, err)
+		return nil, err
+	}
+	log.Printf("Resolved serial %s of %s:%s to bus %d device %d", redactSerial(serial), vendorID, productID, address.Bus, address.Device)
+	return address, nil
+}
+
+// sendSerialError writes the error response for a serial number that could not be resolved
+func sendSerialError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, ErrSerialNotFound):
+		return c.Status(404).JSON(fiber.Map{
+			"error": i18n.Msg(c, "serial_not_found"),
+		})
+	case errors.Is(err, ErrSerialAmbiguous):
+		return c.Status(409).JSON(fiber.Map{
+			"error": i18n.Msg(c, "serial_ambiguous"),
+		})
+	case errors.Is(err, ErrSerialLocalOnly):
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Msg(c, "serial_local_only"),
+		})
+	default:
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "resolve_serial_failed"),
+			"details": err.Error(),
+		})
+	}
+}
@@ -0,0 +1,403 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"vfio_usb_passthrough/internals/db"
+	"vfio_usb_passthrough/internals/utils"
+)
+
+// ErrInvalidDeviceRequest is returned when an attach/detach request does not pass validation
+var ErrInvalidDeviceRequest = errors.New("invalid device request")
+
+// DeviceService runs the device operations shared by the REST API and the gRPC server
+// VM names must already be resolved with ResolveVM; errors are returned unlocalized and
+// each transport maps them to its own status codes
+type DeviceService struct{}
+
+// Devices is the service used by the REST handlers and the gRPC server
+var Devices DeviceService
+
+// DeviceCommandError is a virsh attach-device/detach-device that failed
+// Rollback is set when an attach timed out and a detach was attempted to undo it
+type DeviceCommandError struct {
+	Output   string
+	Err      error
+	Rollback *RollbackResult
+}
+
+func (e *DeviceCommandError) Error() string {
+	return failureMessage(e.Output, e.Err)
+}
+
+func (e *DeviceCommandError) Unwrap() error {
+	return e.Err
+}
+
+// AttachOptions are the flags of an attach
+type AttachOptions struct {
+	// Idempotent reports a device that is already attached as success
+	Idempotent bool
+	// Verify re-reads the live XML to check that libvirt kept the device
+	Verify bool
+	// Force attaches a storage device even if its filesystems are mounted on the host
+	Force bool
+	// Client is recorded in the audit log and with the attachment
+	Client string
+	// vmLocked is set by callers already holding the VM's attach lock (handoff)
+	vmLocked bool
+}
+
+// AttachResult is the outcome of a successful attach
+type AttachResult struct {
+	VendorID        string
+	ProductID       string
+	AlreadyAttached bool
+	// Verified is only set when verification was requested and the live XML could be read
+	Verified *bool
+}
+
+// DetachOptions are the flags of a detach
+type DetachOptions struct {
+	// Idempotent reports a device that is not attached as success
+	Idempotent bool
+	// All detaches every attached instance of the device
+	All bool
+	// Client is recorded in the audit log
+	Client string
+}
+
+// DetachResult is the outcome of a successful detach
+type DetachResult struct {
+	VendorID        string
+	ProductID       string
+	AlreadyDetached bool
+	// Detached is the number of instances detached
+	Detached int
+}
+
+// DetachAllError is returned when some instances of a device could not be detached
+type DetachAllError struct {
+	Instances int
+	Detached  int
+	Failures  []string
+}
+
+func (e *DetachAllError) Error() string {
+	return fmt.Sprintf("failed to detach %d of %d instance(s)", len(e.Failures), e.Instances)
+}
+
+// LookupHost returns the libvirt connection with the given name (the default one when empty)
+func (DeviceService) LookupHost(name string) (Host, error) {
+	host, ok := findHost(name)
+	if !ok {
+		return Host{}, ErrUnknownHost
+	}
+	return host, nil
+}
+
+// ResolveVM returns the name of the running VM designated by a name or UUID
+func (DeviceService) ResolveVM(host Host, param string) (string, error) {
+	return resolveVMName(host, param)
+}
+
+// ListVMs returns the running VMs of a host
+func (DeviceService) ListVMs(host Host) ([]VMResponse, error) {
+	vmNames, err := getRunningVMNames(host)
+	if err != nil {
+		return nil, err
+	}
+	return vmResponses(host, vmNames), nil
+}
+
+// vmResponses returns the API form of running VMs, with their UUIDs
+func vmResponses(host Host, vmNames []string) []VMResponse {
+	uuids := getVMUUIDs(host, vmNames)
+	vms := make([]VMResponse, 0, len(vmNames))
+	for _, vmName := range vmNames {
+		vms = append(vms, VMResponse{Name: vmName, UUID: uuids[vmName], Ref: vmRef(vmName, uuids[vmName])})
+	}
+	return vms
+}
+
+// USBDeviceList is the USB devices of a host
+type USBDeviceList struct {
+	Devices []USBDeviceResponse
+	// LocalOnly is set when the devices are those of this machine rather than of the host
+	LocalOnly bool
+	// StaleSince is when the cached list served after an SSH failure was fetched (zero otherwise)
+	StaleSince time.Time
+}
+
+// ListUSBDevices returns the USB devices of a host, enumerated over SSH when configured
+// (the devices of a remote host are cached for a minute)
+func (DeviceService) ListUSBDevices(host Host) (USBDeviceList, error) {
+	return listUSBDevices(host, remoteUSBCacheTTL)
+}
+
+// RefreshUSBDevices returns the USB devices of a host like ListUSBDevices, bypassing the cache
+// of a remote host, e.g. right after a device was plugged in
+func (DeviceService) RefreshUSBDevices(host Host) (USBDeviceList, error) {
+	return listUSBDevices(host, 0)
+}
+
+// listUSBDevices returns the USB devices of a host, reusing a remote list fetched less than maxAge ago
+func listUSBDevices(host Host, maxAge time.Duration) (USBDeviceList, error) {
+	if host.SSH != "" {
+		devices, fetchedAt, err := getRemoteUSBDevices(host, maxAge)
+		if err != nil && fetchedAt.IsZero() {
+			return USBDeviceList{}, err
+		}
+		list := USBDeviceList{Devices: applyDeviceNames(devices)}
+		if err != nil {
+			log.Printf("Warning: Serving USB devices of %s fetched at %s: %v", host.Name, fetchedAt.Format(time.RFC3339), err)
+			list.StaleSince = fetchedAt
+		}
+		return list, nil
+	}
+
+	devices, err := getUSBDevicesList()
+	if err != nil {
+		return USBDeviceList{}, err
+	}
+	return USBDeviceList{Devices: devices, LocalOnly: !host.Local}, nil
+}
+
+// hostUSBDevices returns the USB devices of a host like ListUSBDevices, for views that only need the devices
+func hostUSBDevices(host Host) ([]USBDeviceResponse, error) {
+	list, err := listUSBDevices(host, remoteUSBCacheTTL)
+	return list.Devices, err
+}
+
+// DevicesState returns the USB devices, the devices attached to a VM (if vmName is not empty) and the favorites
+// Attached devices and favorites that cannot be read are returned empty
+func (DeviceService) DevicesState(ctx context.Context, host Host, vmName string) (DevicesStateResponse, error) {
+	// Run independent operations in parallel using goroutines
+	var usbDevices []USBDeviceResponse
+	var attachedDevices []AttachedDeviceResponse
+	var favorites []db.FavoriteDevice
+
+	var wg sync.WaitGroup
+	var usbErr, attachedErr, favoritesErr error
+
+	// Get USB devices
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		usbDevices, usbErr = hostUSBDevices(host)
+	}()
+
+	// Get attached devices if VM is selected
+	if vmName != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attachedDevices, attachedErr = getAttachedDevicesList(ctx, host, vmName)
+		}()
+	}
+
+	// Get favorites
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		favorites, favoritesErr = db.GetAllFavorites()
+	}()
+
+	// Wait for all goroutines to complete
+	wg.Wait()
+
+	if usbErr != nil {
+		return DevicesStateResponse{}, usbErr
+	}
+
+	if attachedErr != nil {
+		// Log error but continue - attached devices might not be available
+		log.Printf("Warning: Failed to get attached devices for %s: %v", vmName, attachedErr)
+		attachedDevices = []AttachedDeviceResponse{}
+	}
+
+	if favoritesErr != nil {
+		log.Printf("Warning: Failed to get favorites: %v", favoritesErr)
+		favorites = []db.FavoriteDevice{}
+	}
+
+	// Ensure we return empty arrays instead of null
+	if usbDevices == nil {
+		usbDevices = []USBDeviceResponse{}
+	}
+	if attachedDevices == nil {
+		attachedDevices = []AttachedDeviceResponse{}
+	}
+
+	return DevicesStateResponse{
+		Devices:         usbDevices,
+		AttachedDevices: attachedDevices,
+		Favorites:       toFavoritesResponse(favorites),
+	}, nil
+}
+
+// prepareDeviceRequest resolves the device string or alias of a request, validates it and returns its normalized IDs
+func prepareDeviceRequest(req *AttachDetachRequest) (vendorID, productID string, err error) {
+	if err := applyDeviceString(req); err != nil {
+		return "", "", fmt.Errorf("%w: %w", ErrInvalidDeviceRequest, err)
+	}
+	if err := applyDeviceAlias(req); err != nil {
+		return "", "", err
+	}
+	if err := validate.Struct(req); err != nil {
+		return "", "", fmt.Errorf("%w: %w", ErrInvalidDeviceRequest, err)
+	}
+	// Normalize vendor and product IDs to ensure consistent format (lowercase, no 0x prefix)
+	return normalizeDeviceID(req.VendorID), normalizeDeviceID(req.ProductID), nil
+}
+
+// Attach attaches a USB device to a running VM
+func (DeviceService) Attach(ctx context.Context, host Host, vmName string, req AttachDetachRequest, opts AttachOptions) (AttachResult, error) {
+	vendorID, productID, err := prepareDeviceRequest(&req)
+	if err != nil {
+		return AttachResult{}, err
+	}
+	result := AttachResult{VendorID: vendorID, ProductID: productID}
+
+	log.Printf("AttachDevice: VM=%s, VendorID=%s, ProductID=%s, Serial=%s (normalized from %s:%s)",
+		vmName, vendorID, productID, redactSerial(req.Serial), req.VendorID, req.ProductID)
+
+	// An address or serial number pins the attach to one physical device
+	address, err := requestDeviceAddress(host, req, vendorID, productID)
+	if err != nil {
+		return AttachResult{}, err
+	}
+
+	// In idempotent mode attaching a device that is already attached is a success
+	// If the attachments cannot be read, fall through to virsh and rely on its error
+	if opts.Idempotent {
+		if attached, err := isDeviceAttached(ctx, host, vmName, vendorID, productID, address); err == nil && attached {
+			log.Printf("AttachDevice: Device %s:%s is already attached to %s, nothing to do", vendorID, productID, vmName)
+			result.AlreadyAttached = true
+			return result, nil
+		}
+	}
+
+	// The VM must have room left under MAX_DEVICES_PER_VM, until the device is attached
+	if !opts.vmLocked {
+		unlock := lockVMAttach(host, vmName)
+		defer unlock()
+	}
+	if err := checkAttachLimit(ctx, host, vmName); err != nil {
+		log.Printf("AttachDevice: Refusing to attach %s:%s to %s: %v", vendorID, productID, vmName, err)
+		return AttachResult{}, err
+	}
+
+	// A storage device with mounted filesystems would be pulled from under the host
+	if !opts.Force {
+		if err := checkDeviceNotMounted(host, vendorID, productID, address); err != nil {
+			log.Printf("AttachDevice: Refusing to attach %s:%s to %s: %v", vendorID, productID, vmName, err)
+			return AttachResult{}, err
+		}
+	}
+
+	// Execute virsh attach-device (rolled back with a detach if it times out)
+	output, rollback, err := attachDevice(ctx, host, vmName, vendorID, productID, &utils.USBHostdevOptions{
+		Address: address,
+		Alias:   userAlias(req.Alias),
+	})
+	if errors.Is(err, errGenerateXML) || errors.Is(err, errCreateTempXML) {
+		return AttachResult{}, err
+	}
+	if err != nil && opts.Idempotent && isDeviceExistsError(output) {
+		// Attached concurrently between the check and virsh
+		log.Printf("AttachDevice: Device %s:%s was already attached to %s", vendorID, productID, vmName)
+		result.AlreadyAttached = true
+		return result, nil
+	}
+	if rollback != nil {
+		notifyDeviceEvent(host, "attach", vmName, vendorID, productID, opts.Client, err.Error())
+		return AttachResult{}, &DeviceCommandError{Output: output, Err: err, Rollback: rollback}
+	}
+	if err != nil {
+		log.Printf("Error attaching device to %s: %v, output: %s", vmName, err, output)
+		notifyDeviceEvent(host, "attach", vmName, vendorID, productID, opts.Client, failureMessage(output, err))
+		return AttachResult{}, &DeviceCommandError{Output: output, Err: err}
+	}
+
+	trackAttach(host, vmName, vendorID, productID, opts.Client)
+	notifyDeviceEvent(host, "attach", vmName, vendorID, productID, opts.Client, "")
+	publishVMAttachments(host, vmName)
+
+	// Check that libvirt actually kept the device in the live XML
+	if opts.Verify {
+		attached, err := isDeviceAttached(ctx, host, vmName, vendorID, productID, address)
+		if err != nil {
+			log.Printf("AttachDevice: Could not verify %s:%s on %s: %v", vendorID, productID, vmName, err)
+		} else {
+			if !attached {
+				log.Printf("AttachDevice: Device %s:%s reported attached but missing from %s XML", vendorID, productID, vmName)
+			}
+			result.Verified = &attached
+		}
+	}
+
+	return result, nil
+}
+
+// Detach detaches a USB device from a running VM
+func (DeviceService) Detach(ctx context.Context, host Host, vmName string, req AttachDetachRequest, opts DetachOptions) (DetachResult, error) {
+	vendorID, productID, err := prepareDeviceRequest(&req)
+	if err != nil {
+		return DetachResult{}, err
+	}
+	result := DetachResult{VendorID: vendorID, ProductID: productID}
+
+	log.Printf("DetachDevice: VM=%s, VendorID=%s, ProductID=%s, Serial=%s (normalized from %s:%s)",
+		vmName, vendorID, productID, redactSerial(req.Serial), req.VendorID, req.ProductID)
+
+	if opts.All {
+		result.Detached, err = detachAllInstances(ctx, host, vmName, vendorID, productID, opts.Client)
+		return result, err
+	}
+
+	// An address or serial number pins the detach to one physical device
+	address, err := requestDeviceAddress(host, req, vendorID, productID)
+	if err != nil {
+		return DetachResult{}, err
+	}
+
+	// In idempotent mode detaching a device that is not attached is a success
+	// If the attachments cannot be read, fall through to virsh and rely on its error
+	if opts.Idempotent {
+		if attached, err := isDeviceAttached(ctx, host, vmName, vendorID, productID, address); err == nil && !attached {
+			log.Printf("DetachDevice: Device %s:%s is not attached to %s, nothing to do", vendorID, productID, vmName)
+			result.AlreadyDetached = true
+			return result, nil
+		}
+	}
+
+	// Execute virsh detach-device
+	output, err := runDeviceCommand(ctx, host, "detach-device", vmName, vendorID, productID, &utils.USBHostdevOptions{Address: address})
+	if errors.Is(err, errGenerateXML) || errors.Is(err, errCreateTempXML) {
+		return DetachResult{}, err
+	}
+	if err != nil && opts.Idempotent && isDeviceNotFoundError(output) {
+		// Detached concurrently between the check and virsh
+		log.Printf("DetachDevice: Device %s:%s was already detached from %s", vendorID, productID, vmName)
+		result.AlreadyDetached = true
+		return result, nil
+	}
+	if err != nil {
+		log.Printf("Error detaching device from %s: %v, output: %s", vmName, err, output)
+		notifyDeviceEvent(host, "detach", vmName, vendorID, productID, opts.Client, failureMessage(output, err))
+		return DetachResult{}, &DeviceCommandError{Output: output, Err: err}
+	}
+
+	trackDetach(host, vmName, vendorID, productID)
+	notifyDeviceEvent(host, "detach", vmName, vendorID, productID, opts.Client, "")
+	publishVMAttachments(host, vmName)
+
+	result.Detached = 1
+	return result, nil
+}
@@ -1,23 +1,81 @@
 package handlers
 
 import (
+	"fmt"
+	"os"
+	"strings"
+
 	"github.com/gofiber/fiber/v2"
 )
 
+// ThemeCookieSameSiteEnv sets the SameSite attribute of the theme cookie: Lax (default), Strict or None
+const ThemeCookieSameSiteEnv = "THEME_COOKIE_SAMESITE"
+
+// ThemeCookieSecureEnv sets the Secure attribute of the theme cookie: auto (default, only over TLS), true or false
+const ThemeCookieSecureEnv = "THEME_COOKIE_SECURE"
+
+// themeCookie holds the theme cookie attributes; the cookie is never HttpOnly since the UI reads it
+var themeCookie = struct {
+	sameSite string
+	secure   string
+}{sameSite: fiber.CookieSameSiteLaxMode, secure: "auto"}
+
+// ConfigureThemeCookie reads the theme cookie attributes from THEME_COOKIE_SAMESITE and THEME_COOKIE_SECURE
+func ConfigureThemeCookie() error {
+	sameSite := fiber.CookieSameSiteLaxMode
+	if value := strings.TrimSpace(os.Getenv(ThemeCookieSameSiteEnv)); value != "" {
+		switch strings.ToLower(value) {
+		case "lax":
+			sameSite = fiber.CookieSameSiteLaxMode
+		case "strict":
+			sameSite = fiber.CookieSameSiteStrictMode
+		case "none":
+			sameSite = fiber.CookieSameSiteNoneMode
+		default:
+			return fmt.Errorf("invalid %s: %q must be Lax, Strict or None", ThemeCookieSameSiteEnv, value)
+		}
+	}
+
+	secure := "auto"
+	if value := strings.TrimSpace(os.Getenv(ThemeCookieSecureEnv)); value != "" {
+		secure = strings.ToLower(value)
+		if secure != "auto" && secure != "true" && secure != "false" {
+			return fmt.Errorf("invalid %s: %q must be auto, true or false", ThemeCookieSecureEnv, value)
+		}
+	}
+	// Browsers drop SameSite=None cookies that are not Secure
+	if sameSite == fiber.CookieSameSiteNoneMode && secure == "false" {
+		return fmt.Errorf("invalid %s: SameSite=None requires a Secure cookie", ThemeCookieSecureEnv)
+	}
+
+	themeCookie.sameSite = sameSite
+	themeCookie.secure = secure
+	return nil
+}
+
+// themeCookieSecure reports whether the theme cookie of a request is Secure
+// In auto mode it is Secure over TLS, including behind a proxy setting X-Forwarded-Proto
+func themeCookieSecure(c *fiber.Ctx) bool {
+	switch themeCookie.secure {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return c.Secure() || themeCookie.sameSite == fiber.CookieSameSiteNoneMode
+}
+
 func ToggleTheme(c *fiber.Ctx) error {
-	cookie := c.Cookies("theme")
-	if cookie == "light" || cookie == "" {
-		c.Cookie(&fiber.Cookie{
-			Name:  "theme",
-			Value: "dark",
-			Path:  "/",
-		})
-	} else {
-		c.Cookie(&fiber.Cookie{
-			Name:  "theme",
-			Value: "light",
-			Path:  "/",
-		})
+	theme := "dark"
+	if cookie := c.Cookies("theme"); cookie != "light" && cookie != "" {
+		theme = "light"
 	}
+	c.Cookie(&fiber.Cookie{
+		Name:     "theme",
+		Value:    theme,
+		Path:     "/",
+		Secure:   themeCookieSecure(c),
+		SameSite: themeCookie.sameSite,
+	})
 	return c.SendStatus(fiber.StatusOK)
 }
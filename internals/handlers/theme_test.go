@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// toggleThemeCookie calls ToggleTheme and returns the Set-Cookie header
+func toggleThemeCookie(t *testing.T, headers map[string]string) string {
+	t.Helper()
+	app := fiber.New()
+	app.Post("/theme", ToggleTheme)
+
+	req := httptest.NewRequest("POST", "/theme", nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Header.Get(fiber.HeaderSetCookie)
+}
+
+func TestToggleThemeCookieAttributes(t *testing.T) {
+	// Runs after t.Setenv restored the environment
+	t.Cleanup(func() { ConfigureThemeCookie() })
+	if err := ConfigureThemeCookie(); err != nil {
+		t.Fatal(err)
+	}
+
+	cookie := toggleThemeCookie(t, map[string]string{"Cookie": "theme=light"})
+	if !strings.Contains(cookie, "theme=dark") || !strings.Contains(cookie, "SameSite=Lax") || strings.Contains(cookie, "secure") || strings.Contains(cookie, "HttpOnly") {
+		t.Errorf("over HTTP Set-Cookie = %q, want a dark, SameSite=Lax cookie that is neither Secure nor HttpOnly", cookie)
+	}
+	cookie = toggleThemeCookie(t, map[string]string{"Cookie": "theme=dark", "X-Forwarded-Proto": "https"})
+	if !strings.Contains(cookie, "theme=light") || !strings.Contains(cookie, "secure") {
+		t.Errorf("over HTTPS Set-Cookie = %q, want a light, Secure cookie", cookie)
+	}
+
+	t.Setenv(ThemeCookieSameSiteEnv, "strict")
+	t.Setenv(ThemeCookieSecureEnv, "true")
+	if err := ConfigureThemeCookie(); err != nil {
+		t.Fatal(err)
+	}
+	if cookie = toggleThemeCookie(t, nil); !strings.Contains(cookie, "SameSite=Strict") || !strings.Contains(cookie, "secure") {
+		t.Errorf("Set-Cookie = %q, want a SameSite=Strict, Secure cookie", cookie)
+	}
+
+	for _, env := range [][2]string{{"sometimes", "auto"}, {"lax", "yes"}, {"none", "false"}} {
+		t.Setenv(ThemeCookieSameSiteEnv, env[0])
+		t.Setenv(ThemeCookieSecureEnv, env[1])
+		if err := ConfigureThemeCookie(); err == nil {
+			t.Errorf("ConfigureThemeCookie() with SameSite=%s, Secure=%s = nil error, want an error", env[0], env[1])
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Phases of an attach/detach reported with ?timing=true
+const (
+	phaseValidate      = "validate"
+	phaseGenerateXML   = "generateXml"
+	phaseWriteTempFile = "writeTempFile"
+	phaseVirsh         = "virsh"
+	phaseTotal         = "total"
+)
+
+// deviceTiming accumulates the time spent in each phase of an attach/detach request
+// A nil *deviceTiming records nothing, so the device helpers can record unconditionally
+type deviceTiming struct {
+	start  time.Time
+	phases map[string]time.Duration
+}
+
+type deviceTimingKey struct{}
+
+// withDeviceTiming returns a context recording the phases of the device commands run with it
+func withDeviceTiming(ctx context.Context) (context.Context, *deviceTiming) {
+	timing := &deviceTiming{start: time.Now(), phases: make(map[string]time.Duration)}
+	return context.WithValue(ctx, deviceTimingKey{}, timing), timing
+}
+
+// deviceTimingFrom returns the timing recorded for a context, or nil if it is not timed
+func deviceTimingFrom(ctx context.Context) *deviceTiming {
+	timing, _ := ctx.Value(deviceTimingKey{}).(*deviceTiming)
+	return timing
+}
+
+// since adds the time elapsed since start to a phase (phases run several times add up,
+// e.g. the detaches of ?all=true or the rollback of a timed out attach)
+func (t *deviceTiming) since(phase string, start time.Time) {
+	if t == nil {
+		return
+	}
+	t.phases[phase] += time.Since(start)
+}
+
+// sinceStart adds the time elapsed since the request started to a phase
+func (t *deviceTiming) sinceStart(phase string) {
+	if t == nil {
+		return
+	}
+	t.since(phase, t.start)
+}
+
+// milliseconds returns the recorded phases and the total time so far in milliseconds, rounded to microseconds
+func (t *deviceTiming) milliseconds() map[string]float64 {
+	ms := make(map[string]float64, len(t.phases)+1)
+	for phase, duration := range t.phases {
+		ms[phase] = toMilliseconds(duration)
+	}
+	ms[phaseTotal] = toMilliseconds(time.Since(t.start))
+	return ms
+}
+
+// toMilliseconds converts a duration to milliseconds with microsecond precision
+func toMilliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// requestTiming returns the context of an attach/detach request, timed when ?timing=true
+// (timing is nil otherwise)
+func requestTiming(c *fiber.Ctx) (context.Context, *deviceTiming) {
+	if !c.QueryBool("timing") {
+		return c.UserContext(), nil
+	}
+	return withDeviceTiming(c.UserContext())
+}
+
+// withTiming adds the recorded phases to a response when the request is timed
+func withTiming(response fiber.Map, timing *deviceTiming) fiber.Map {
+	if timing != nil {
+		response["timing"] = timing.milliseconds()
+	}
+	return response
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDeviceTiming(t *testing.T) {
+	// An untimed request records nothing and does not panic
+	var untimed *deviceTiming
+	untimed.since(phaseVirsh, time.Now())
+	untimed.sinceStart(phaseValidate)
+	if timing := deviceTimingFrom(context.Background()); timing != nil {
+		t.Errorf("deviceTimingFrom() = %v, want nil", timing)
+	}
+
+	ctx, timing := withDeviceTiming(context.Background())
+	if deviceTimingFrom(ctx) != timing {
+		t.Fatal("deviceTimingFrom() does not return the timing of the context")
+	}
+	timing.since(phaseVirsh, time.Now().Add(-2*time.Millisecond))
+	timing.since(phaseVirsh, time.Now().Add(-3*time.Millisecond))
+
+	ms := timing.milliseconds()
+	if ms[phaseVirsh] < 5 {
+		t.Errorf("virsh = %vms, want the sum of both calls (at least 5ms)", ms[phaseVirsh])
+	}
+	if ms[phaseTotal] <= 0 {
+		t.Errorf("total = %vms, want it to be set", ms[phaseTotal])
+	}
+	if _, ok := ms[phaseGenerateXML]; ok {
+		t.Error("a phase that did not run is reported")
+	}
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"vfio_usb_passthrough/internals/i18n"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// usbSysfsPath is where the kernel exposes USB devices (overridable in tests)
+var usbSysfsPath = "/sys/bus/usb/devices"
+
+// usbHubClass is the bDeviceClass of USB hubs
+const usbHubClass = "09"
+
+var (
+	// usbRootHubPattern matches root hub entries (usb1, usb2, ...)
+	usbRootHubPattern = regexp.MustCompile(`^usb(\d+)$`)
+	// usbDevicePattern matches device entries (1-1, 1-1.2, ...); interfaces (1-1:1.0) are skipped
+	usbDevicePattern = regexp.MustCompile(`^(\d+)-[\d.]+$`)
+)
+
+// USBTopologyNode is a USB device in the hub tree
+// Port is the port on the parent hub (0 for root hubs)
+type USBTopologyNode struct {
+	Name        string            `json:"name"`
+	Bus         int               `json:"bus"`
+	Port        int               `json:"port"`
+	VendorID    string            `json:"vendorId"`
+	ProductID   string            `json:"productId"`
+	Description string            `json:"description"`
+	Speed       string            `json:"speed,omitempty"`
+	USBVersion  string            `json:"usbVersion,omitempty"`
+	MaxPower    string            `json:"maxPower,omitempty"`
+	DeviceClass string            `json:"deviceClass,omitempty"`
+	Hub         bool              `json:"hub"`
+	Children    []USBTopologyNode `json:"children,omitempty"`
+}
+
+// GetUSBTopology returns USB devices grouped by the hub they are plugged into
+// Falls back to the flat device list when sysfs is unavailable, and for hosts enumerated over SSH
+// (only this machine's sysfs is read)
+func GetUSBTopology(c *fiber.Ctx) error {
+	host := hostFromCtx(c)
+	if host.SSH == "" {
+		topology, err := getUSBTopology()
+		if err == nil {
+			return c.JSON(fiber.Map{
+				"flat":     false,
+				"topology": topology,
+			})
+		}
+		log.Printf("USB topology unavailable, falling back to flat list: %v", err)
+	}
+
+	devices, err := hostUSBDevices(host)
+	if err != nil {
+		log.Printf("Error listing USB devices: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "list_usb_devices_failed"),
+			"details": err.Error(),
+		})
+	}
+	if devices == nil {
+		devices = []USBDeviceResponse{}
+	}
+
+	return c.JSON(fiber.Map{
+		"flat":    true,
+		"devices": devices,
+	})
+}
+
+// getUSBTopology builds one tree per root hub from sysfs
+func getUSBTopology() ([]USBTopologyNode, error) {
+	entries, err := os.ReadDir(usbSysfsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", usbSysfsPath, err)
+	}
+
+	var roots []USBTopologyNode
+	for _, entry := range entries {
+		matches := usbRootHubPattern.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			continue
+		}
+		bus, _ := strconv.Atoi(matches[1])
+		roots = append(roots, readUSBTopologyNode(filepath.Join(usbSysfsPath, entry.Name()), entry.Name(), bus, 0))
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no USB root hubs found in %s", usbSysfsPath)
+	}
+
+	sort.Slice(roots, func(i, j int) bool { return roots[i].Bus < roots[j].Bus })
+	return roots, nil
+}
+
+// readUSBTopologyNode reads a device and, recursively, the devices on its ports
+// Child devices are subdirectories of their hub in sysfs (usb1/1-1/1-1.2)
+func readUSBTopologyNode(dir, name string, bus, port int) USBTopologyNode {
+	node := USBTopologyNode{
+		Name:       name,
+		Bus:        bus,
+		Port:       port,
+		VendorID:   readSysfsAttr(dir, "idVendor"),
+		ProductID:  readSysfsAttr(dir, "idProduct"),
+		Speed:      readSysfsAttr(dir, "speed"),
+		USBVersion: readSysfsAttr(dir, "version"),
+		MaxPower:   readSysfsAttr(dir, "bMaxPower"),
+		Hub:        readSysfsAttr(dir, "bDeviceClass") == usbHubClass,
+	}
+	node.DeviceClass = readUSBDeviceClass(dir)
+
+	manufacturer := readSysfsAttr(dir, "manufacturer")
+	product := readSysfsAttr(dir, "product")
+	node.Description = strings.TrimSpace(manufacturer + " " + product)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return node
+	}
+	for _, entry := range entries {
+		if !usbDevicePattern.MatchString(entry.Name()) {
+			continue
+		}
+		// The port number is the last component of the name (1-1.4 is port 4 of hub 1-1)
+		childPort, err := strconv.Atoi(entry.Name()[strings.LastIndexAny(entry.Name(), "-.")+1:])
+		if err != nil {
+			continue
+		}
+		node.Children = append(node.Children, readUSBTopologyNode(filepath.Join(dir, entry.Name()), entry.Name(), bus, childPort))
+	}
+
+	sort.Slice(node.Children, func(i, j int) bool { return node.Children[i].Port < node.Children[j].Port })
+	return node
+}
+
+// readSysfsAttr returns a trimmed sysfs attribute, or "" if it cannot be read
+func readSysfsAttr(dir, attr string) string {
+	data, err := os.ReadFile(filepath.Join(dir, attr))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// usbSysfsInfo holds the sysfs attributes lsusb does not report cheaply
+type usbSysfsInfo struct {
+	Speed       string
+	USBVersion  string
+	MaxPower    string
+	DeviceClass string
+	Serial      string
+}
+
+// usbBusDevKey identifies a device by bus and device number, as printed by lsusb
+func usbBusDevKey(bus, devnum int) string {
+	return fmt.Sprintf("%d:%d", bus, devnum)
+}
+
+// getUSBSysfsInfo reads speed, USB version, max power, class and serial number of every device, keyed by bus:devnum
+// Returns an empty map when sysfs is unavailable
+func getUSBSysfsInfo() map[string]usbSysfsInfo {
+	info := make(map[string]usbSysfsInfo)
+	entries, err := os.ReadDir(usbSysfsPath)
+	if err != nil {
+		return info
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !usbRootHubPattern.MatchString(name) && !usbDevicePattern.MatchString(name) {
+			continue
+		}
+		dir := filepath.Join(usbSysfsPath, name)
+		bus, err := strconv.Atoi(readSysfsAttr(dir, "busnum"))
+		if err != nil {
+			continue
+		}
+		devnum, err := strconv.Atoi(readSysfsAttr(dir, "devnum"))
+		if err != nil {
+			continue
+		}
+		info[usbBusDevKey(bus, devnum)] = usbSysfsInfo{
+			Speed:       readSysfsAttr(dir, "speed"),
+			USBVersion:  readSysfsAttr(dir, "version"),
+			MaxPower:    readSysfsAttr(dir, "bMaxPower"),
+			DeviceClass: readUSBDeviceClass(dir),
+			Serial:      readSysfsAttr(dir, "serial"),
+		}
+	}
+	return info
+}
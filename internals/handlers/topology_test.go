@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// describeTopology renders a hub tree compactly: name:port, * for hubs, children in brackets
+func describeTopology(nodes []USBTopologyNode) string {
+	var parts []string
+	for _, node := range nodes {
+		part := fmt.Sprintf("%s:%d", node.Name, node.Port)
+		if node.Hub {
+			part += "*"
+		}
+		if len(node.Children) > 0 {
+			part += "[" + describeTopology(node.Children) + "]"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestGetUSBTopology(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string // sysfs attribute files, relative to the devices directory
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "no root hub",
+			files:   map[string]string{"1-1/idVendor": "046d"},
+			wantErr: true,
+		},
+		{
+			name: "root hubs sorted by bus",
+			files: map[string]string{
+				"usb2/bDeviceClass": "09",
+				"usb1/bDeviceClass": "09",
+			},
+			want: "usb1:0* usb2:0*",
+		},
+		{
+			name: "nested hub with ports sorted numerically",
+			files: map[string]string{
+				"usb1/bDeviceClass":                "09",
+				"usb1/1-10/idVendor":               "046d",
+				"usb1/1-2/bDeviceClass":            "09",
+				"usb1/1-2/1-2.4/idVendor":          "1050",
+				"usb1/1-2/1-2.1/idVendor":          "0781",
+				"usb1/1-2/1-2:1.0/bInterfaceClass": "09",
+			},
+			want: "usb1:0*[1-2:2*[1-2.1:1 1-2.4:4] 1-10:10]",
+		},
+		{
+			name: "interfaces and unrelated entries skipped",
+			files: map[string]string{
+				"usb3/bDeviceClass":          "09",
+				"usb3/3-1/idVendor":          "046d",
+				"usb3/3-1/3-1:1.0/bNumEPs":   "1",
+				"usb3/3-0:1.0/bNumEPs":       "1",
+				"usb3/power/control":         "auto",
+				"usbmon/ignored":             "1",
+				"usb3/3-1/3-1:1.1/bNumEPs":   "1",
+				"usb3/3-1/power/autosuspend": "2",
+			},
+			want: "usb3:0*[3-1:1]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldSysfs := usbSysfsPath
+			usbSysfsPath = t.TempDir()
+			t.Cleanup(func() { usbSysfsPath = oldSysfs })
+			for path, content := range tt.files {
+				writeFile(t, filepath.Join(usbSysfsPath, path), content)
+			}
+
+			topology, err := getUSBTopology()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("getUSBTopology() = %s, want an error", describeTopology(topology))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := describeTopology(topology); got != tt.want {
+				t.Errorf("getUSBTopology() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetUSBTopologyMissingSysfs(t *testing.T) {
+	oldSysfs := usbSysfsPath
+	usbSysfsPath = filepath.Join(t.TempDir(), "missing")
+	t.Cleanup(func() { usbSysfsPath = oldSysfs })
+
+	if _, err := getUSBTopology(); err == nil {
+		t.Error("getUSBTopology() without sysfs should fail")
+	}
+}
+
+func TestReadUSBTopologyNode(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "1-2")
+	for attr, value := range map[string]string{
+		"idVendor":     "046d",
+		"idProduct":    "c077\n",
+		"manufacturer": "Logitech",
+		"product":      "USB Optical Mouse",
+		"speed":        "1.5",
+		"version":      " 2.00",
+		"bMaxPower":    "100mA",
+		"bDeviceClass": "00",
+	} {
+		writeFile(t, filepath.Join(dir, attr), value)
+	}
+
+	node := readUSBTopologyNode(dir, "1-2", 1, 2)
+	want := USBTopologyNode{
+		Name: "1-2", Bus: 1, Port: 2, VendorID: "046d", ProductID: "c077",
+		Description: "Logitech USB Optical Mouse", Speed: "1.5", USBVersion: "2.00", MaxPower: "100mA",
+		DeviceClass: node.DeviceClass,
+	}
+	if fmt.Sprintf("%+v", node) != fmt.Sprintf("%+v", want) {
+		t.Errorf("readUSBTopologyNode() = %+v, want %+v", node, want)
+	}
+}
@@ -2,26 +2,31 @@ package handlers
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"regexp"
+	"strconv"
 	"strings"
-	"sync"
+	"time"
 
 	"vfio_usb_passthrough/internals/db"
+	"vfio_usb_passthrough/internals/i18n"
+	"vfio_usb_passthrough/internals/mqtt"
 	"vfio_usb_passthrough/internals/utils"
+	"vfio_usb_passthrough/internals/webhook"
 
 	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // VM name validation errors
 var (
-	ErrVMNameEmpty         = errors.New("VM name is required")
-	ErrVMNameInvalidFormat = errors.New("VM name contains invalid characters (only alphanumeric, dash, underscore allowed, max 64 chars)")
-	ErrVMNotRunning        = errors.New("VM is not running or does not exist")
+	ErrVMNameEmpty         = i18n.NewError("vm_name_required")
+	ErrVMNameInvalidFormat = i18n.NewError("vm_name_invalid_format")
+	ErrVMNotRunning        = i18n.NewError("vm_not_running")
 )
 
 // vmNamePattern validates VM names: alphanumeric, dash, underscore only, max 64 chars
@@ -35,31 +40,41 @@ func isValidVMNameFormat(vmName string) bool {
 	return vmNamePattern.MatchString(vmName)
 }
 
-// getRunningVMNames returns a list of currently running VM names
-func getRunningVMNames() ([]string, error) {
-	cmd := exec.Command("virsh", "list", "--name", "--state-running")
-	cmd.Env = append(os.Environ(), "LIBVIRT_DEFAULT_URI=qemu:///system")
+// getRunningVMNames returns a list of currently running VM names on a libvirt connection
+func getRunningVMNames(host Host) ([]string, error) {
+	return getRunningVMNamesContext(context.Background(), host)
+}
+
+// getRunningVMNamesContext is getRunningVMNames with virsh killed when ctx is done
+func getRunningVMNamesContext(ctx context.Context, host Host) ([]string, error) {
+	cmd := virshCommand(ctx, host, "list", "--name", "--state-running")
 
+	// The names printed before a failure are used (stdout is kept on a non-zero exit)
 	output, err := cmd.Output()
-	if err != nil {
+	vms := parseVMNames(string(output))
+	if err := toleratePartialOutput("virsh list", len(vms), err); err != nil {
 		return nil, fmt.Errorf("failed to list running VMs: %w", err)
 	}
 
+	return vms, nil
+}
+
+// parseVMNames parses the output of virsh list --name, one VM per line
+func parseVMNames(output string) []string {
 	var vms []string
-	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	scanner := bufio.NewScanner(strings.NewReader(output))
 	for scanner.Scan() {
 		vmName := strings.TrimSpace(scanner.Text())
 		if vmName != "" {
 			vms = append(vms, vmName)
 		}
 	}
-
-	return vms, nil
+	return vms
 }
 
 // isVMRunning checks if a VM is currently running
-func isVMRunning(vmName string) bool {
-	runningVMs, err := getRunningVMNames()
+func isVMRunning(host Host, vmName string) bool {
+	runningVMs, err := getRunningVMNames(host)
 	if err != nil {
 		log.Printf("Error checking running VMs: %v", err)
 		return false
@@ -74,7 +89,7 @@ func isVMRunning(vmName string) bool {
 }
 
 // validateVMName performs full validation of a VM name
-func validateVMName(vmName string) error {
+func validateVMName(host Host, vmName string) error {
 	if vmName == "" {
 		return ErrVMNameEmpty
 	}
@@ -83,7 +98,7 @@ func validateVMName(vmName string) error {
 		return ErrVMNameInvalidFormat
 	}
 
-	if !isVMRunning(vmName) {
+	if !isVMRunning(host, vmName) {
 		return ErrVMNotRunning
 	}
 
@@ -91,21 +106,41 @@ func validateVMName(vmName string) error {
 }
 
 // VMResponse represents a VM in the API response
+// Ref identifies the VM in API paths: its name, or its UUID when the name has characters
+// outside vmNamePattern (e.g. spaces)
 type VMResponse struct {
 	Name string `json:"name"`
+	UUID string `json:"uuid,omitempty"`
+	Ref  string `json:"ref"`
 }
 
 // USBDeviceResponse represents a USB device in the API response
+// Speed (Mbps), USBVersion, MaxPower, DeviceClass (e.g. "HID", "Audio") and Serial come from sysfs
+// and are omitted when unavailable
 type USBDeviceResponse struct {
 	VendorID    string `json:"vendorId"`
 	ProductID   string `json:"productId"`
 	Description string `json:"description"`
+	Speed       string `json:"speed,omitempty"`
+	USBVersion  string `json:"usbVersion,omitempty"`
+	MaxPower    string `json:"maxPower,omitempty"`
+	DeviceClass string `json:"deviceClass,omitempty"`
+	Serial      string `json:"serial,omitempty"`
+	// IconHint is the kind of device for its icon: keyboard, mouse, storage, audio, webcam, dongle or unknown
+	IconHint string `json:"iconHint"`
 }
 
 // AttachedDeviceResponse represents an attached device for a VM
+// Managed is true if the device was attached through this tool; Address is the host
+// bus and device number and Alias the device alias when libvirt reports them
+// DeviceClass is only set by views that match attached devices with the host's (inventory, VM groups)
 type AttachedDeviceResponse struct {
-	VendorID  string `json:"vendorId"`
-	ProductID string `json:"productId"`
+	VendorID    string               `json:"vendorId"`
+	ProductID   string               `json:"productId"`
+	Managed     bool                 `json:"managed"`
+	Address     *utils.USBAddressXML `json:"address,omitempty"`
+	Alias       string               `json:"alias,omitempty"`
+	DeviceClass string               `json:"deviceClass,omitempty"`
 }
 
 // FavoriteDeviceResponse represents a favorite device in the API response
@@ -113,12 +148,24 @@ type FavoriteDeviceResponse struct {
 	VendorID    string `json:"vendorId"`
 	ProductID   string `json:"productId"`
 	Description string `json:"description"`
+	Notes       string `json:"notes"`
 }
 
 // AttachDetachRequest represents a request to attach/detach a device
+// Serial or Address (host bus and device number) optionally select one device among several
+// with the same IDs. Alias labels an attached device in the VM XML ("ua-" is prepended if missing).
+// DeviceAlias names a device alias to use instead of VendorID and ProductID
 type AttachDetachRequest struct {
-	VendorID  string `json:"vendorId"`
-	ProductID string `json:"productId"`
+	VendorID    string               `json:"vendorId" yaml:"vendorId" validate:"required,usbid"`
+	ProductID   string               `json:"productId" yaml:"productId" validate:"required,usbid"`
+	Serial      string               `json:"serial,omitempty" yaml:"serial,omitempty" validate:"max=255"`
+	Address     *utils.USBAddressXML `json:"address,omitempty" yaml:"address,omitempty"`
+	Alias       string               `json:"alias,omitempty" yaml:"alias,omitempty" validate:"omitempty,devicealias"`
+	DeviceAlias string               `json:"deviceAlias,omitempty" yaml:"deviceAlias,omitempty"`
+	// Device gives both IDs in lsusb form ("046d:c52b") instead of vendorId and productId
+	Device string `json:"device,omitempty" yaml:"device,omitempty"`
+	// Force attaches a storage device whose filesystems are mounted on the host (ignored on detach)
+	Force bool `json:"force,omitempty" yaml:"force,omitempty"`
 }
 
 // DevicesStateResponse represents the combined state of all devices
@@ -126,327 +173,754 @@ type DevicesStateResponse struct {
 	Devices         []USBDeviceResponse      `json:"devices"`
 	AttachedDevices []AttachedDeviceResponse `json:"attachedDevices"`
 	Favorites       []FavoriteDeviceResponse `json:"favorites"`
+	// PollIntervalMs is how often clients should poll the devices state (DEVICES_POLL_INTERVAL)
+	PollIntervalMs int64 `json:"pollIntervalMs"`
 }
 
-// ListRunningVMs returns a list of running VMs
+// ListRunningVMs returns running VMs, optionally filtered by name (?q=) and paginated (?limit=, ?offset=),
+// with the number of matching VMs (total) and the offset of the next page (next)
 func ListRunningVMs(c *fiber.Ctx) error {
-	cmd := exec.Command("virsh", "list", "--name", "--state-running")
-	cmd.Env = append(os.Environ(), "LIBVIRT_DEFAULT_URI=qemu:///system")
+	host := hostFromCtx(c)
+	// Optional name filter (?q=) and pagination (?limit=&offset=)
+	query := strings.TrimSpace(c.Query("q"))
+	if query != "" && !isValidVMNameFormat(query) {
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Msg(c, "invalid_vm_filter"),
+		})
+	}
 
-	output, err := cmd.Output()
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Msg(c, "invalid_pagination"),
+		})
+	}
+
+	vmNames, err := getRunningVMNames(host)
 	if err != nil {
 		log.Printf("Error listing VMs: %v", err)
 		return c.Status(500).JSON(fiber.Map{
-			"error":   "Failed to list running VMs",
+			"error":   i18n.Msg(c, "list_vms_failed"),
 			"details": err.Error(),
 		})
 	}
 
-	var vms []VMResponse
-	scanner := bufio.NewScanner(strings.NewReader(string(output)))
-	for scanner.Scan() {
-		vmName := strings.TrimSpace(scanner.Text())
-		if vmName != "" {
-			vms = append(vms, VMResponse{Name: vmName})
+	// Case-insensitive substring match; the filter never reaches virsh
+	var matched []string
+	for _, vmName := range vmNames {
+		if query == "" || strings.Contains(strings.ToLower(vmName), strings.ToLower(query)) {
+			matched = append(matched, vmName)
 		}
 	}
 
+	total := len(matched)
+	matched = paginate(matched, limit, offset)
+
+	// next is the offset of the following page, or null on the last one
+	var next *int
+	if limit > 0 && offset+limit < total {
+		next = new(int)
+		*next = offset + limit
+	}
+
 	return c.JSON(fiber.Map{
-		"vms": vms,
+		"vms":   vmResponses(host, matched),
+		"total": total,
+		"next":  next,
 	})
 }
 
+// parsePagination reads ?limit= and ?offset= (limit 0 or absent means no limit)
+func parsePagination(c *fiber.Ctx) (limit, offset int, err error) {
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			return 0, 0, fmt.Errorf("invalid limit %q", v)
+		}
+	}
+	if v := c.Query("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid offset %q", v)
+		}
+	}
+	return limit, offset, nil
+}
+
+// paginate returns the window of items selected by limit and offset
+func paginate[T any](items []T, limit, offset int) []T {
+	if offset >= len(items) {
+		return nil
+	}
+	items = items[offset:]
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
+
 // ListUSBDevices returns a list of available USB devices
+// Devices are those of this machine, or of the selected host when it is enumerated over SSH
 func ListUSBDevices(c *fiber.Ctx) error {
-	devices, err := getUSBDevicesList()
+	host := hostFromCtx(c)
+	list, err := Devices.ListUSBDevices(host)
+	return usbDevicesResponse(c, host, list, err)
+}
+
+// RefreshUSBDevices returns the USB devices like ListUSBDevices after dropping the cached list
+// of a remote host, so a device that was just plugged in shows up
+func RefreshUSBDevices(c *fiber.Ctx) error {
+	host := hostFromCtx(c)
+	list, err := Devices.RefreshUSBDevices(host)
+	return usbDevicesResponse(c, host, list, err)
+}
+
+// usbDevicesResponse writes a USB device list, or the error listing it
+func usbDevicesResponse(c *fiber.Ctx, host Host, list USBDeviceList, err error) error {
+	if err != nil && host.SSH != "" {
+		log.Printf("Error listing USB devices on %s over SSH: %v", host.Name, err)
+		return c.Status(502).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "list_remote_usb_devices_failed", host.Name),
+			"details": err.Error(),
+		})
+	}
 	if err != nil {
 		log.Printf("Error listing USB devices: %v", err)
 		return c.Status(500).JSON(fiber.Map{
-			"error":   "Failed to list USB devices",
+			"error":   i18n.Msg(c, "list_usb_devices_failed"),
 			"details": err.Error(),
 		})
 	}
 
-	return c.JSON(fiber.Map{
-		"devices": devices,
-	})
+	// Ensure we return an empty array instead of null
+	if list.Devices == nil {
+		list.Devices = []USBDeviceResponse{}
+	}
+
+	response := fiber.Map{
+		"devices": list.Devices,
+	}
+	if list.LocalOnly {
+		response["warning"] = i18n.Msg(c, "usb_devices_local_only", host.Name)
+	}
+	if !list.StaleSince.IsZero() {
+		response["warning"] = i18n.Msg(c, "remote_usb_devices_stale", host.Name, list.StaleSince.Format(time.RFC3339))
+	}
+	return c.JSON(response)
 }
 
-// GetAttachedDevices returns a list of USB devices attached to a VM
+// GetAttachedDevices returns the devices attached to a VM; ?type=pci or ?type=all also lists PCI hostdevs
 func GetAttachedDevices(c *fiber.Ctx) error {
-	vmName := c.Params("vmName")
+	host := hostFromCtx(c)
 
-	// Validate VM name
-	if err := validateVMName(vmName); err != nil {
-		log.Printf("GetAttachedDevices: VM validation failed for '%s': %v", vmName, err)
+	// Validate VM name (or resolve a VM UUID to its name)
+	vmName, err := resolveVMName(host, c.Params("vmName"))
+	if err != nil {
+		log.Printf("GetAttachedDevices: VM validation failed for '%s': %v", c.Params("vmName"), err)
 		return c.Status(400).JSON(fiber.Map{
-			"error": err.Error(),
+			"error": i18n.Localize(c, err),
 		})
 	}
 
-	devices, err := getAttachedDevicesList(vmName)
-	if err != nil {
-		log.Printf("Error getting attached devices for %s: %v", vmName, err)
-		return c.Status(500).JSON(fiber.Map{
-			"error":   fmt.Sprintf("Failed to get attached devices for %s", vmName),
-			"details": err.Error(),
+	// Hostdev types to return: usb (default), pci, or all
+	hostdevType := strings.ToLower(c.Query("type", "usb"))
+	if hostdevType != "usb" && hostdevType != "pci" && hostdevType != "all" {
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Msg(c, "invalid_hostdev_type", hostdevType),
 		})
 	}
 
-	return c.JSON(fiber.Map{
-		"devices": devices,
-	})
+	response := fiber.Map{}
+	if hostdevType == "usb" || hostdevType == "all" {
+		devices, err := getAttachedDevicesList(c.UserContext(), host, vmName)
+		if err != nil {
+			log.Printf("Error getting attached devices for %s: %v", vmName, err)
+			return c.Status(500).JSON(fiber.Map{
+				"error":   i18n.Msg(c, "get_attached_devices_failed", vmName),
+				"details": err.Error(),
+			})
+		}
+		if devices == nil {
+			devices = []AttachedDeviceResponse{}
+		}
+		response["devices"] = devices
+	}
+	if hostdevType == "pci" || hostdevType == "all" {
+		pciDevices, err := getAttachedPCIDevicesList(c.UserContext(), host, vmName)
+		if err != nil {
+			log.Printf("Error getting attached PCI devices for %s: %v", vmName, err)
+			return c.Status(500).JSON(fiber.Map{
+				"error":   i18n.Msg(c, "get_attached_devices_failed", vmName),
+				"details": err.Error(),
+			})
+		}
+		if pciDevices == nil {
+			pciDevices = []utils.PCIDevice{}
+		}
+		response["pciDevices"] = pciDevices
+	}
+
+	return c.JSON(response)
+}
+
+// getAttachedPCIDevicesList returns the PCI devices passed through to a VM
+func getAttachedPCIDevicesList(ctx context.Context, host Host, vmName string) ([]utils.PCIDevice, error) {
+	vmXML, err := getVMXML(ctx, host, vmName)
+	if err != nil {
+		return nil, err
+	}
+	return utils.ParseVMPCIXML(vmXML)
 }
 
 // GetDevicesState returns a combined state of all USB devices, attached devices, and favorites
 // This endpoint eliminates multiple round-trips and race conditions
 func GetDevicesState(c *fiber.Ctx) error {
+	host := hostFromCtx(c)
 	vmName := c.Query("vmName", "")
 
-	// Validate VM name if provided
+	// Validate VM name (or resolve a VM UUID to its name) if provided
 	if vmName != "" {
-		if err := validateVMName(vmName); err != nil {
-			log.Printf("GetDevicesState: VM validation failed for '%s': %v", vmName, err)
+		param := vmName
+		var err error
+		if vmName, err = resolveVMName(host, param); err != nil {
+			log.Printf("GetDevicesState: VM validation failed for '%s': %v", param, err)
 			return c.Status(400).JSON(fiber.Map{
-				"error": err.Error(),
+				"error": i18n.Localize(c, err),
 			})
 		}
 	}
 
-	// Run independent operations in parallel using goroutines
-	var usbDevices []USBDeviceResponse
-	var attachedDevices []AttachedDeviceResponse
-	var favorites []db.FavoriteDevice
-
-	var wg sync.WaitGroup
-	var usbErr, attachedErr, favoritesErr error
-
-	// Get USB devices
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		usbDevices, usbErr = getUSBDevicesList()
-	}()
-
-	// Get attached devices if VM is selected
-	if vmName != "" {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			attachedDevices, attachedErr = getAttachedDevicesList(vmName)
-		}()
+	state, err := Devices.DevicesState(c.UserContext(), host, vmName)
+	if err != nil {
+		log.Printf("Error getting USB devices: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "list_usb_devices_failed"),
+			"details": err.Error(),
+		})
 	}
+	state.PollIntervalMs = devicesPollInterval.Milliseconds()
+	return c.JSON(state)
+}
 
-	// Get favorites
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		favorites, favoritesErr = db.GetAllFavorites()
-	}()
+// AttachDevice attaches a USB device to a VM
+// With ?verify=true the live XML is re-read and a warning is returned if the device is missing
+// With ?idempotent=true a device that is already attached is reported as success
+// With ?timing=true the time spent in each phase is returned in milliseconds
+func AttachDevice(c *fiber.Ctx) error {
+	ctx, timing := requestTiming(c)
+	host := hostFromCtx(c)
 
-	// Wait for all goroutines to complete
-	wg.Wait()
+	// Validate VM name (or resolve a VM UUID to its name)
+	vmName, err := resolveVMName(host, c.Params("vmName"))
+	if err != nil {
+		log.Printf("AttachDevice: VM validation failed for '%s': %v", c.Params("vmName"), err)
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Localize(c, err),
+		})
+	}
 
-	// Handle errors
-	if usbErr != nil {
-		log.Printf("Error getting USB devices: %v", usbErr)
-		return c.Status(500).JSON(fiber.Map{
-			"error":   "Failed to list USB devices",
-			"details": usbErr.Error(),
+	var req AttachDetachRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "invalid_request_body"),
+			"details": err.Error(),
 		})
 	}
 
-	if attachedErr != nil {
-		// Log error but continue - attached devices might not be available
-		log.Printf("Warning: Failed to get attached devices for %s: %v", vmName, attachedErr)
-		attachedDevices = []AttachedDeviceResponse{}
+	if reqErr := resolveRequestDevice(c, &req); reqErr != nil {
+		return reqErr.send(c)
 	}
 
-	if favoritesErr != nil {
-		log.Printf("Warning: Failed to get favorites: %v", favoritesErr)
-		favorites = []db.FavoriteDevice{}
+	if reqErr := resolveRequestDeviceAlias(c, &req); reqErr != nil {
+		return reqErr.send(c)
 	}
 
-	// Convert favorites to response format
-	var favoritesResponse []FavoriteDeviceResponse
-	for _, fav := range favorites {
-		favoritesResponse = append(favoritesResponse, FavoriteDeviceResponse{
-			VendorID:    fav.VendorID,
-			ProductID:   fav.ProductID,
-			Description: fav.Description,
-		})
+	if reqErr := validateRequest(c, &req); reqErr != nil {
+		return reqErr.send(c)
 	}
 
-	// Ensure we return empty arrays instead of null
-	if usbDevices == nil {
-		usbDevices = []USBDeviceResponse{}
+	timing.sinceStart(phaseValidate)
+	result, err := Devices.Attach(ctx, host, vmName, req, AttachOptions{
+		Idempotent: c.QueryBool("idempotent"),
+		Verify:     c.QueryBool("verify"),
+		Force:      req.Force,
+		Client:     c.IP(),
+	})
+	if err != nil {
+		return sendDeviceCommandError(c, "attach_failed", vmName, err)
 	}
-	if attachedDevices == nil {
-		attachedDevices = []AttachedDeviceResponse{}
+
+	if result.AlreadyAttached {
+		return c.JSON(withTiming(fiber.Map{
+			"success":         true,
+			"alreadyAttached": true,
+			"message":         i18n.Msg(c, "device_already_attached", vmName),
+		}, timing))
 	}
-	if favoritesResponse == nil {
-		favoritesResponse = []FavoriteDeviceResponse{}
+
+	response := fiber.Map{
+		"success": true,
+		"message": i18n.Msg(c, "device_attached", result.VendorID, result.ProductID, vmName),
 	}
 
-	return c.JSON(DevicesStateResponse{
-		Devices:         usbDevices,
-		AttachedDevices: attachedDevices,
-		Favorites:       favoritesResponse,
-	})
+	// With ?verify=true, report whether libvirt actually kept the device in the live XML
+	if c.QueryBool("verify") {
+		response["verified"] = result.Verified != nil && *result.Verified
+		if result.Verified == nil {
+			response["warning"] = i18n.Msg(c, "attach_verify_failed", vmName)
+		} else if !*result.Verified {
+			response["warning"] = i18n.Msg(c, "attach_not_verified", result.VendorID, result.ProductID, vmName)
+		}
+	}
+
+	return c.JSON(withTiming(response, timing))
 }
 
-// AttachDevice attaches a USB device to a VM
-func AttachDevice(c *fiber.Ctx) error {
-	vmName := c.Params("vmName")
+// DetachDevice detaches a USB device from a VM
+// With ?idempotent=true (always for DELETE) a device that is not attached is reported as success
+// With ?all=true every attached instance of the device is detached
+// With ?timing=true the time spent in each phase is returned in milliseconds
+func DetachDevice(c *fiber.Ctx) error {
+	ctx, timing := requestTiming(c)
+	host := hostFromCtx(c)
 
-	// Validate VM name
-	if err := validateVMName(vmName); err != nil {
-		log.Printf("AttachDevice: VM validation failed for '%s': %v", vmName, err)
+	// Validate VM name (or resolve a VM UUID to its name)
+	vmName, err := resolveVMName(host, c.Params("vmName"))
+	if err != nil {
+		log.Printf("DetachDevice: VM validation failed for '%s': %v", c.Params("vmName"), err)
 		return c.Status(400).JSON(fiber.Map{
-			"error": err.Error(),
+			"error": i18n.Localize(c, err),
 		})
 	}
 
 	var req AttachDetachRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(400).JSON(fiber.Map{
-			"error":   "Invalid request body",
+			"error":   i18n.Msg(c, "invalid_request_body"),
 			"details": err.Error(),
 		})
 	}
 
-	if req.VendorID == "" || req.ProductID == "" {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "vendorId and productId are required",
-		})
+	if reqErr := resolveRequestDevice(c, &req); reqErr != nil {
+		return reqErr.send(c)
 	}
 
-	// Normalize vendor and product IDs to ensure consistent format (lowercase, no 0x prefix)
-	vendorID := strings.ToLower(strings.TrimSpace(req.VendorID))
-	productID := strings.ToLower(strings.TrimSpace(req.ProductID))
-	vendorID = strings.TrimPrefix(vendorID, "0x")
-	productID = strings.TrimPrefix(productID, "0x")
+	if reqErr := resolveRequestDeviceAlias(c, &req); reqErr != nil {
+		return reqErr.send(c)
+	}
 
-	log.Printf("AttachDevice: VM=%s, VendorID=%s, ProductID=%s (normalized from %s:%s)",
-		vmName, vendorID, productID, req.VendorID, req.ProductID)
+	if reqErr := validateRequest(c, &req); reqErr != nil {
+		return reqErr.send(c)
+	}
 
-	// Generate XML
-	xml, err := utils.GenerateUSBXML(vendorID, productID)
-	if err != nil {
-		log.Printf("Error generating XML for device %s:%s: %v", vendorID, productID, err)
+	timing.sinceStart(phaseValidate)
+	result, err := Devices.Detach(ctx, host, vmName, req, DetachOptions{
+		Idempotent: c.QueryBool("idempotent") || c.Method() == fiber.MethodDelete,
+		All:        c.QueryBool("all"),
+		Client:     c.IP(),
+	})
+	var detachAllErr *DetachAllError
+	if errors.As(err, &detachAllErr) {
+		return c.Status(500).JSON(fiber.Map{
+			"error":    i18n.Msg(c, "detach_all_failed", len(detachAllErr.Failures), detachAllErr.Instances, result.VendorID, result.ProductID, vmName),
+			"detached": detachAllErr.Detached,
+			"details":  detachAllErr.Failures,
+		})
+	}
+	if errors.Is(err, errReadAttachedDevices) {
 		return c.Status(500).JSON(fiber.Map{
-			"error":   "Failed to generate device XML",
+			"error":   i18n.Msg(c, "get_attached_devices_failed", vmName),
 			"details": err.Error(),
 		})
 	}
+	if err != nil {
+		return sendDeviceCommandError(c, "detach_failed", vmName, err)
+	}
 
-	log.Printf("Generated XML for attach: %s", xml)
+	if c.QueryBool("all") {
+		return c.JSON(withTiming(fiber.Map{
+			"success":  true,
+			"detached": result.Detached,
+			"message":  i18n.Msg(c, "device_instances_detached", result.Detached, result.VendorID, result.ProductID, vmName),
+		}, timing))
+	}
+	if result.AlreadyDetached {
+		return c.JSON(withTiming(fiber.Map{
+			"success":         true,
+			"alreadyDetached": true,
+			"message":         i18n.Msg(c, "device_already_detached", result.VendorID, result.ProductID, vmName),
+		}, timing))
+	}
+	return c.JSON(withTiming(fiber.Map{
+		"success": true,
+		"message": i18n.Msg(c, "device_detached", result.VendorID, result.ProductID, vmName),
+	}, timing))
+}
 
-	// Create a temporary file for the XML
-	tmpFile, err := createTempXMLFile(xml)
+// detachAllInstances detaches every hostdev of a VM with the given IDs and returns how many were detached
+// Instances are detached by the host address libvirt reports for them, so identical devices are
+// told apart; an instance without an address is detached by IDs (libvirt picks one)
+func detachAllInstances(ctx context.Context, host Host, vmName, vendorID, productID, client string) (int, error) {
+	attached, err := getAttachedDevicesList(ctx, host, vmName)
 	if err != nil {
-		log.Printf("Error creating temp XML file: %v", err)
+		log.Printf("Error getting attached devices for %s: %v", vmName, err)
+		return 0, fmt.Errorf("%w: %w", errReadAttachedDevices, err)
+	}
+
+	var instances []AttachedDeviceResponse
+	for _, device := range attached {
+		if device.VendorID == vendorID && device.ProductID == productID {
+			instances = append(instances, device)
+		}
+	}
+
+	detached := 0
+	var failures []string
+	for _, instance := range instances {
+		output, err := runDeviceCommand(ctx, host, "detach-device", vmName, vendorID, productID, &utils.USBHostdevOptions{Address: instance.Address})
+		// An instance detached concurrently counts as detached
+		if err != nil && !isDeviceNotFoundError(output) {
+			message := failureMessage(output, err)
+			log.Printf("Error detaching an instance of %s:%s from %s: %v, output: %s", vendorID, productID, vmName, err, output)
+			notifyDeviceEvent(host, "detach", vmName, vendorID, productID, client, message)
+			failures = append(failures, message)
+			continue
+		}
+		detached++
+		notifyDeviceEvent(host, "detach", vmName, vendorID, productID, client, "")
+	}
+
+	log.Printf("DetachDevice: Detached %d of %d instance(s) of %s:%s from %s", detached, len(instances), vendorID, productID, vmName)
+	if detached > 0 {
+		if len(failures) == 0 {
+			trackDetach(host, vmName, vendorID, productID)
+		}
+		publishVMAttachments(host, vmName)
+	}
+
+	if len(failures) > 0 {
+		return detached, &DetachAllError{Instances: len(instances), Detached: detached, Failures: failures}
+	}
+	return detached, nil
+}
+
+// sendDeviceCommandError writes the error response of a failed attach or detach
+// failedKey is the message of a virsh failure ("attach_failed" or "detach_failed")
+func sendDeviceCommandError(c *fiber.Ctx, failedKey, vmName string, err error) error {
+	var cmdErr *DeviceCommandError
+	var mountedErr *DeviceMountedError
+	var limitErr *DeviceLimitError
+	switch {
+	case errors.As(err, &mountedErr):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":  i18n.Msg(c, "device_mounted", mountedErr.VendorID, mountedErr.ProductID),
+			"mounts": mountedErr.Mounts,
+		})
+	case errors.As(err, &limitErr):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "device_limit_exceeded", vmName, limitErr.Current, limitErr.Limit),
+			"limit":   limitErr.Limit,
+			"current": limitErr.Current,
+			"adding":  limitErr.Adding,
+		})
+	case errors.Is(err, errCheckMounts):
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "check_mounts_failed"),
+			"details": err.Error(),
+		})
+	case errors.Is(err, errReadAttachedDevices):
 		return c.Status(500).JSON(fiber.Map{
-			"error":   "Failed to create temporary XML file",
+			"error":   i18n.Msg(c, "get_attached_devices_failed", vmName),
 			"details": err.Error(),
 		})
+	case errors.Is(err, errGenerateXML):
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "generate_xml_failed"),
+			"details": err.Error(),
+		})
+	case errors.Is(err, errCreateTempXML):
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "create_temp_xml_failed"),
+			"details": err.Error(),
+		})
+	case errors.As(err, &cmdErr) && cmdErr.Rollback != nil:
+		return c.Status(fiber.StatusGatewayTimeout).JSON(fiber.Map{
+			"error":    i18n.Msg(c, "attach_timed_out", vmName),
+			"details":  cmdErr.Err.Error(),
+			"rollback": cmdErr.Rollback,
+		})
+	case errors.As(err, &cmdErr):
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, failedKey, vmName),
+			"details": cmdErr.Output,
+		})
+	default:
+		return sendSerialError(c, err)
 	}
-	defer removeTempFile(tmpFile)
+}
 
-	// Execute virsh attach-device
-	cmd := exec.Command("virsh", "attach-device", vmName, tmpFile, "--live")
-	cmd.Env = append(os.Environ(), "LIBVIRT_DEFAULT_URI=qemu:///system")
+// failureMessage returns the virsh output of a failed command, or the error if there was none
+func failureMessage(output string, err error) string {
+	if msg := strings.TrimSpace(output); msg != "" {
+		return msg
+	}
+	return err.Error()
+}
 
-	output, err := cmd.CombinedOutput()
+// notifyDeviceEvent records an attach/detach attempt in the event and audit logs and sends a webhook event;
+// an empty errMsg means success
+// The device description is looked up among the host's devices in the background so the response is not
+// delayed; it is left out for remote hosts whose devices are not enumerated over SSH
+func notifyDeviceEvent(host Host, action, vmName, vendorID, productID, client, errMsg string) {
+	// The in-memory log is kept even when the audit log cannot be written
+	recordEvent(action, vmName, vendorID, productID, client, errMsg)
+
+	err := db.RecordAudit(db.AuditEntry{
+		Action:    action,
+		VMName:    vmName,
+		VendorID:  vendorID,
+		ProductID: productID,
+		Client:    client,
+		Success:   errMsg == "",
+		Error:     strings.TrimSpace(errMsg),
+	})
 	if err != nil {
-		log.Printf("Error attaching device to %s: %v, output: %s", vmName, err, string(output))
-		return c.Status(500).JSON(fiber.Map{
-			"error":   fmt.Sprintf("Failed to attach device to %s", vmName),
-			"details": string(output),
-		})
+		log.Printf("Warning: Failed to record %s of %s:%s on %s in audit log: %v", action, vendorID, productID, vmName, err)
 	}
 
-	return c.JSON(fiber.Map{
-		"success": true,
-		"message": fmt.Sprintf("Device %s:%s attached to %s", vendorID, productID, vmName),
-	})
+	if !webhook.Enabled() {
+		return
+	}
+
+	event := webhook.Event{
+		Action:    action,
+		VM:        vmName,
+		Device:    webhook.Device{VendorID: vendorID, ProductID: productID},
+		Timestamp: time.Now().UTC(),
+		Success:   errMsg == "",
+		Error:     strings.TrimSpace(errMsg),
+	}
+
+	go func() {
+		if list, err := listUSBDevices(host, remoteUSBCacheTTL); err == nil && !list.LocalOnly {
+			for _, device := range list.Devices {
+				if device.VendorID == vendorID && device.ProductID == productID {
+					event.Device.Description = device.Description
+					break
+				}
+			}
+		}
+		webhook.Send(event)
+	}()
 }
 
-// DetachDevice detaches a USB device from a VM
-func DetachDevice(c *fiber.Ctx) error {
-	vmName := c.Params("vmName")
+// publishVMAttachments publishes the current attachments of a VM over MQTT in the background
+func publishVMAttachments(host Host, vmName string) {
+	go func() {
+		attached, err := getAttachedDevicesList(context.Background(), host, vmName)
+		if err != nil {
+			log.Printf("Warning: Failed to get attached devices for %s, not publishing to MQTT: %v", vmName, err)
+			return
+		}
 
-	// Validate VM name
-	if err := validateVMName(vmName); err != nil {
-		log.Printf("DetachDevice: VM validation failed for '%s': %v", vmName, err)
-		return c.Status(400).JSON(fiber.Map{
-			"error": err.Error(),
-		})
+		var devices []mqtt.Device
+		for _, device := range attached {
+			devices = append(devices, mqtt.Device{VendorID: device.VendorID, ProductID: device.ProductID})
+		}
+		mqtt.PublishVMAttachments(vmName, devices)
+	}()
+}
+
+// isDeviceAttached checks if a device is currently attached to a VM
+// A non-nil address (given or resolved from a serial number) must match the host address of the attached
+// device, so another device with the same IDs does not count; a device without an address in the XML never matches it
+func isDeviceAttached(ctx context.Context, host Host, vmName, vendorID, productID string, address *utils.USBAddressXML) (bool, error) {
+	attached, err := getAttachedDevicesList(ctx, host, vmName)
+	if err != nil {
+		return false, err
 	}
 
-	var req AttachDetachRequest
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(400).JSON(fiber.Map{
-			"error":   "Invalid request body",
-			"details": err.Error(),
-		})
+	for _, device := range attached {
+		if device.VendorID != vendorID || device.ProductID != productID {
+			continue
+		}
+		if address == nil || (device.Address != nil && *device.Address == *address) {
+			return true, nil
+		}
 	}
+	return false, nil
+}
 
-	if req.VendorID == "" || req.ProductID == "" {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "vendorId and productId are required",
-		})
+// isDeviceNotFoundError checks if virsh output reports that the device to detach is not attached
+func isDeviceNotFoundError(output string) bool {
+	output = strings.ToLower(output)
+	return strings.Contains(output, "device not found") || strings.Contains(output, "not attached")
+}
+
+// isDeviceExistsError checks if virsh output reports that the device to attach is already attached
+func isDeviceExistsError(output string) bool {
+	output = strings.ToLower(output)
+	return strings.Contains(output, "already exists") || strings.Contains(output, "already attached")
+}
+
+// normalizeDeviceID converts a vendor or product ID to lowercase without 0x prefix
+func normalizeDeviceID(id string) string {
+	return strings.TrimPrefix(strings.ToLower(strings.TrimSpace(id)), "0x")
+}
+
+// Errors returned when the device of an attach/detach request is given as a "vendor:product" string
+var (
+	ErrDeviceWithIDs       = errors.New("a device string cannot be combined with vendor and product IDs or a device alias")
+	ErrInvalidDeviceString = errors.New(`device must be "vendor:product" with 4-digit hex IDs`)
+)
+
+// applyDeviceString fills in the IDs of an attach/detach request giving its device as "vendor:product"
+// The string is cleared once split, so a request can be applied more than once
+func applyDeviceString(req *AttachDetachRequest) error {
+	if strings.TrimSpace(req.Device) == "" {
+		return nil
+	}
+	if req.VendorID != "" || req.ProductID != "" || strings.TrimSpace(req.DeviceAlias) != "" {
+		return ErrDeviceWithIDs
+	}
+
+	vendorID, productID, found := strings.Cut(strings.TrimSpace(req.Device), ":")
+	if !found || !utils.IsValidHexID(vendorID) || !utils.IsValidHexID(productID) {
+		return ErrInvalidDeviceString
+	}
+
+	req.VendorID = vendorID
+	req.ProductID = productID
+	req.Device = ""
+	return nil
+}
+
+// resolveRequestDevice fills in the IDs of an attach/detach request giving its device as "vendor:product"
+func resolveRequestDevice(c *fiber.Ctx, req *AttachDetachRequest) *requestError {
+	device := req.Device
+	err := applyDeviceString(req)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrDeviceWithIDs):
+		return &requestError{400, fiber.Map{
+			"error": i18n.Msg(c, "device_with_ids"),
+		}}
+	default:
+		return &requestError{400, fiber.Map{
+			"error": i18n.Msg(c, "invalid_device_string", device),
+		}}
 	}
+}
+
+// Errors returned by runDeviceCommand when virsh could not be run or did not finish
+var (
+	errGenerateXML          = errors.New("failed to generate device XML")
+	errCreateTempXML        = errors.New("failed to create temporary XML file")
+	errDeviceCommandTimeout = errors.New("virsh device command timed out")
+	errReadAttachedDevices  = errors.New("failed to read attached devices")
+)
 
-	// Normalize vendor and product IDs to ensure consistent format (lowercase, no 0x prefix)
-	vendorID := strings.ToLower(strings.TrimSpace(req.VendorID))
-	productID := strings.ToLower(strings.TrimSpace(req.ProductID))
-	vendorID = strings.TrimPrefix(vendorID, "0x")
-	productID = strings.TrimPrefix(productID, "0x")
+// deviceCommandTimeout bounds a virsh attach-device/detach-device call
+// (it can hang when the guest does not respond to the USB controller)
+var deviceCommandTimeout = 30 * time.Second
 
-	log.Printf("DetachDevice: VM=%s, VendorID=%s, ProductID=%s (normalized from %s:%s)",
-		vmName, vendorID, productID, req.VendorID, req.ProductID)
+// runDeviceCommand generates the hostdev XML for a device and runs a virsh device command
+// (attach-device or detach-device) against the live VM, returning the virsh output
+// A non-nil address selects one device among several with the same IDs
+func runDeviceCommand(ctx context.Context, host Host, command, vmName, vendorID, productID string, opts *utils.USBHostdevOptions) (string, error) {
+	xml, err := generateHostdevXML(ctx, vendorID, productID, opts)
+	if err != nil {
+		return "", err
+	}
+	return runHostdevCommand(ctx, host, command, vmName, vendorID, productID, xml)
+}
 
-	// Generate XML
-	xml, err := utils.GenerateUSBXML(vendorID, productID)
+// generateHostdevXML generates the hostdev XML of a device, recording the time spent for ?timing=true
+func generateHostdevXML(ctx context.Context, vendorID, productID string, opts *utils.USBHostdevOptions) (string, error) {
+	start := time.Now()
+	xml, err := utils.GenerateUSBXMLWithOptions(vendorID, productID, opts)
+	deviceTimingFrom(ctx).since(phaseGenerateXML, start)
 	if err != nil {
 		log.Printf("Error generating XML for device %s:%s: %v", vendorID, productID, err)
-		return c.Status(500).JSON(fiber.Map{
-			"error":   "Failed to generate device XML",
-			"details": err.Error(),
-		})
+		return "", fmt.Errorf("%w: %w", errGenerateXML, err)
 	}
+	return xml, nil
+}
+
+// runHostdevCommand runs a virsh device command with a hostdev XML against the live VM,
+// returning the virsh output (the IDs only label the trace span and the log)
+// The XML itself is not logged: it may be written by the user (attach-raw)
+func runHostdevCommand(ctx context.Context, host Host, command, vmName, vendorID, productID, xml string) (string, error) {
+	timing := deviceTimingFrom(ctx)
 
-	log.Printf("Generated XML for detach: %s", xml)
+	log.Printf("Running %s of %s:%s on %s (%d bytes of hostdev XML)", command, vendorID, productID, vmName, len(xml))
 
 	// Create a temporary file for the XML
+	start := time.Now()
 	tmpFile, err := createTempXMLFile(xml)
+	timing.since(phaseWriteTempFile, start)
 	if err != nil {
 		log.Printf("Error creating temp XML file: %v", err)
-		return c.Status(500).JSON(fiber.Map{
-			"error":   "Failed to create temporary XML file",
-			"details": err.Error(),
-		})
+		return "", fmt.Errorf("%w: %w", errCreateTempXML, err)
 	}
 	defer removeTempFile(tmpFile)
 
-	// Execute virsh detach-device
-	cmd := exec.Command("virsh", "detach-device", vmName, tmpFile, "--live")
-	cmd.Env = append(os.Environ(), "LIBVIRT_DEFAULT_URI=qemu:///system")
+	// The command is not cancelled with the request: an interrupted attach could leave the device half-attached
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), deviceCommandTimeout)
+	defer cancel()
+
+	// Listed in /api/admin/inflight until virsh returns
+	done := startInflight(host, command, vmName, vendorID, productID, tmpFile)
+	defer done()
 
+	cmd := virshCommand(ctx, host, command, vmName, tmpFile, "--live").withAttributes(
+		attribute.String("vm.name", vmName),
+		attribute.String("usb.vendor_id", vendorID),
+		attribute.String("usb.product_id", productID),
+	)
+
+	start = time.Now()
 	output, err := cmd.CombinedOutput()
+	timing.since(phaseVirsh, start)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return string(output), fmt.Errorf("%w after %s", errDeviceCommandTimeout, deviceCommandTimeout)
+	}
+	return string(output), err
+}
+
+// RollbackResult reports the best-effort detach made after an attach timed out
+type RollbackResult struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// attachDevice runs virsh attach-device; if it times out the device may be half-attached,
+// so a detach is attempted to roll back and its outcome is returned (nil if no rollback was needed)
+func attachDevice(ctx context.Context, host Host, vmName, vendorID, productID string, opts *utils.USBHostdevOptions) (string, *RollbackResult, error) {
+	xml, err := generateHostdevXML(ctx, vendorID, productID, opts)
 	if err != nil {
-		log.Printf("Error detaching device from %s: %v, output: %s", vmName, err, string(output))
-		return c.Status(500).JSON(fiber.Map{
-			"error":   fmt.Sprintf("Failed to detach device from %s", vmName),
-			"details": string(output),
-		})
+		return "", nil, err
 	}
+	return attachHostdev(ctx, host, vmName, vendorID, productID, xml)
+}
 
-	return c.JSON(fiber.Map{
-		"success": true,
-		"message": fmt.Sprintf("Device %s:%s detached from %s", vendorID, productID, vmName),
-	})
+// attachHostdev attaches a hostdev XML like attachDevice, detaching the same XML to roll back a timeout
+func attachHostdev(ctx context.Context, host Host, vmName, vendorID, productID, xml string) (string, *RollbackResult, error) {
+	output, err := runHostdevCommand(ctx, host, "attach-device", vmName, vendorID, productID, xml)
+	if !errors.Is(err, errDeviceCommandTimeout) {
+		return output, nil, err
+	}
+
+	log.Printf("ROLLBACK: Attach of %s:%s to %s timed out, detaching to undo a partial attach", vendorID, productID, vmName)
+	rollback := &RollbackResult{}
+	detachOutput, detachErr := runHostdevCommand(ctx, host, "detach-device", vmName, vendorID, productID, xml)
+	if detachErr == nil || isDeviceNotFoundError(detachOutput) {
+		rollback.Success = true
+		log.Printf("ROLLBACK: Device %s:%s is detached from %s", vendorID, productID, vmName)
+	} else {
+		rollback.Error = failureMessage(detachOutput, detachErr)
+		log.Printf("ROLLBACK FAILED: Device %s:%s may be left half-attached to %s: %s", vendorID, productID, vmName, rollback.Error)
+	}
+	return output, rollback, err
 }
 
 // Helper functions for temporary file management
@@ -477,50 +951,111 @@ func removeTempFile(filePath string) {
 	os.Remove(filePath)
 }
 
+// toFavoritesResponse converts database favorites to the API response format (never nil)
+func toFavoritesResponse(favorites []db.FavoriteDevice) []FavoriteDeviceResponse {
+	favoritesResponse := []FavoriteDeviceResponse{}
+	for _, fav := range favorites {
+		favoritesResponse = append(favoritesResponse, FavoriteDeviceResponse{
+			VendorID:    fav.VendorID,
+			ProductID:   fav.ProductID,
+			Description: fav.Description,
+			Notes:       fav.Notes,
+		})
+	}
+	return favoritesResponse
+}
+
 // Helper functions to get data
 func getUSBDevicesList() ([]USBDeviceResponse, error) {
-	cmd := exec.Command("lsusb")
-	output, err := cmd.Output()
-	if err != nil {
+	return getUSBDevicesListContext(context.Background())
+}
+
+// getUSBDevicesListContext is getUSBDevicesList with lsusb killed when ctx is done
+func getUSBDevicesListContext(ctx context.Context) ([]USBDeviceResponse, error) {
+	// lsusb may exit non-zero while listing the devices it could read
+	output, err := localRunner{}.Output(ctx, utils.LsusbBin())
+	devices := parseLSUSB(string(output), getUSBSysfsInfo())
+	if err := toleratePartialOutput("lsusb", len(devices), err); err != nil {
 		return nil, err
 	}
 
+	// Speed, power, class and serial are only exposed by sysfs (lsusb -v is too slow)
+	// Devices hidden by USB_HIDE_IDS and USB_HIDE_CLASSES are left out, DEVICE_NAMES_FILE names the others
+	return applyDeviceNames(usbFilter.apply(devices)), nil
+}
+
+// parseLSUSB parses lsusb output, completing devices with their sysfs attributes (keyed by bus:devnum)
+func parseLSUSB(output string, sysfsInfo map[string]usbSysfsInfo) []USBDeviceResponse {
 	var devices []USBDeviceResponse
-	linePattern := regexp.MustCompile(`ID\s+([0-9a-fA-F]{4}):([0-9a-fA-F]{4})\s+(.+)`)
-	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	linePattern := regexp.MustCompile(`Bus\s+(\d+)\s+Device\s+(\d+):\s+ID\s+([0-9a-fA-F]{4}):([0-9a-fA-F]{4})\s+(.+)`)
+	scanner := bufio.NewScanner(strings.NewReader(output))
 	for scanner.Scan() {
 		line := scanner.Text()
 		matches := linePattern.FindStringSubmatch(line)
-		if len(matches) >= 4 {
-			devices = append(devices, USBDeviceResponse{
-				VendorID:    strings.ToLower(matches[1]),
-				ProductID:   strings.ToLower(matches[2]),
-				Description: strings.TrimSpace(matches[3]),
-			})
+		if len(matches) >= 6 {
+			device := USBDeviceResponse{
+				VendorID:    strings.ToLower(matches[3]),
+				ProductID:   strings.ToLower(matches[4]),
+				Description: strings.TrimSpace(matches[5]),
+			}
+			bus, _ := strconv.Atoi(matches[1])
+			devnum, _ := strconv.Atoi(matches[2])
+			if info, ok := sysfsInfo[usbBusDevKey(bus, devnum)]; ok {
+				device.Speed = info.Speed
+				device.USBVersion = info.USBVersion
+				device.MaxPower = info.MaxPower
+				device.DeviceClass = info.DeviceClass
+				device.Serial = info.Serial
+			}
+			device.IconHint = usbIconHint(device.DeviceClass, device.Description)
+			devices = append(devices, device)
 		}
 	}
-	return devices, nil
+	return devices
 }
 
-func getAttachedDevicesList(vmName string) ([]AttachedDeviceResponse, error) {
-	cmd := exec.Command("virsh", "dumpxml", vmName)
-	cmd.Env = append(os.Environ(), "LIBVIRT_DEFAULT_URI=qemu:///system")
+// getVMXML returns the live XML dump of a VM
+func getVMXML(ctx context.Context, host Host, vmName string) (string, error) {
+	cmd := virshCommand(ctx, host, "dumpxml", vmName)
 	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return string(output), nil
+}
+
+func getAttachedDevicesList(ctx context.Context, host Host, vmName string) ([]AttachedDeviceResponse, error) {
+	vmXML, err := getVMXML(ctx, host, vmName)
 	if err != nil {
 		return nil, err
 	}
 
-	attachedDevices, err := utils.ParseVMXML(string(output))
+	attachedDevices, err := utils.ParseVMXML(vmXML)
 	if err != nil {
 		return nil, err
 	}
 
+	// Mark devices attached through this tool (best-effort)
+	managed := make(map[string]bool)
+	attachments, err := db.GetAttachments(hostKey(host), vmName)
+	if err != nil {
+		log.Printf("Warning: Failed to get recorded attachments for %s: %v", vmName, err)
+	}
+	for _, attachment := range attachments {
+		managed[attachment.VendorID+":"+attachment.ProductID] = true
+	}
+
 	var devices []AttachedDeviceResponse
 	for _, device := range attachedDevices {
+		key := device.VendorID + ":" + device.ProductID
 		devices = append(devices, AttachedDeviceResponse{
 			VendorID:  device.VendorID,
 			ProductID: device.ProductID,
+			Managed:   managed[key],
+			Address:   device.Address,
+			Alias:     device.Alias,
 		})
 	}
+
 	return devices, nil
 }
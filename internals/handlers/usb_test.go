@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"vfio_usb_passthrough/internals/utils"
+	"vfio_usb_passthrough/internals/webhook"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestParseLSUSB(t *testing.T) {
+	sysfsInfo := map[string]usbSysfsInfo{
+		"3:7": {Speed: "480", USBVersion: "2.00", MaxPower: "100mA", DeviceClass: "08", Serial: "AA0123"},
+	}
+
+	tests := []struct {
+		name   string
+		output string
+		want   []USBDeviceResponse
+	}{
+		{
+			name:   "standard line",
+			output: "Bus 001 Device 002: ID 046d:c077 Logitech, Inc. M105 Optical Mouse",
+			want:   []USBDeviceResponse{{VendorID: "046d", ProductID: "c077", Description: "Logitech, Inc. M105 Optical Mouse"}},
+		},
+		{
+			name:   "uppercase hex and trailing spaces",
+			output: "Bus 002 Device 003: ID 0A5C:21E8 Broadcom Corp.   ",
+			want:   []USBDeviceResponse{{VendorID: "0a5c", ProductID: "21e8", Description: "Broadcom Corp."}},
+		},
+		{
+			name:   "sysfs attributes merged by bus and device number",
+			output: "Bus 003 Device 007: ID 0781:5583 SanDisk Corp. Ultra Fit",
+			want: []USBDeviceResponse{{
+				VendorID: "0781", ProductID: "5583", Description: "SanDisk Corp. Ultra Fit",
+				Speed: "480", USBVersion: "2.00", MaxPower: "100mA", DeviceClass: "08", Serial: "AA0123",
+			}},
+		},
+		{
+			name: "malformed lines skipped",
+			output: "\n" +
+				"garbage\n" +
+				"Bus 001 Device 002 ID 046d:c077 missing colon\n" +
+				"Bus 001 Device 002: ID 046g:c077 not hex\n" +
+				"Bus 001 Device 002: ID 046d:c07 short product\n" +
+				"Bus 001 Device 002: ID 046d:c0777 long product\n" +
+				"Bus 001 Device 002: ID 046d:c077\n" +
+				"Bus xx Device 002: ID 046d:c077 bad bus\n" +
+				"Bus 001 Device 004: ID 1d6b:0002 Linux Foundation 2.0 root hub\n",
+			want: []USBDeviceResponse{{VendorID: "1d6b", ProductID: "0002", Description: "Linux Foundation 2.0 root hub"}},
+		},
+		{
+			name:   "empty output",
+			output: "",
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := range tt.want {
+				tt.want[i].IconHint = usbIconHint(tt.want[i].DeviceClass, tt.want[i].Description)
+			}
+			if got := parseLSUSB(tt.output, sysfsInfo); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLSUSB() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAttachHostdevTimeoutRollsBack(t *testing.T) {
+	oldTimeout := deviceCommandTimeout
+	t.Cleanup(func() { deviceCommandTimeout = oldTimeout })
+	deviceCommandTimeout = 200 * time.Millisecond
+
+	tests := []struct {
+		name   string
+		detach string
+		want   RollbackResult
+	}{
+		{"detached", "echo 'Device detached successfully'", RollbackResult{Success: true}},
+		{"never attached", "echo 'error: device not found' >&2; exit 1", RollbackResult{Success: true}},
+		{"detach fails", "echo 'error: internal error: guest unresponsive' >&2; exit 1", RollbackResult{Error: "error: internal error: guest unresponsive"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// attach-device hangs until killed by the timeout
+			calls := filepath.Join(t.TempDir(), "calls")
+			t.Setenv(utils.VirshBinEnv, fakeCommand(t, `echo "$1 $2" >> `+calls+`
+case "$1" in
+attach-device) exec sleep 30 ;;
+detach-device) `+tt.detach+` ;;
+esac
+`))
+
+			xml := "<hostdev mode='subsystem' type='usb'/>"
+			_, rollback, err := attachHostdev(context.Background(), defaultHost(), "win10", "046d", "c077", xml)
+			if !errors.Is(err, errDeviceCommandTimeout) {
+				t.Fatalf("attachHostdev() error = %v, want errDeviceCommandTimeout", err)
+			}
+			if rollback == nil || *rollback != tt.want {
+				t.Errorf("rollback = %+v, want %+v", rollback, tt.want)
+			}
+
+			data, err := os.ReadFile(calls)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSpace(string(data)); got != "attach-device win10\ndetach-device win10" {
+				t.Errorf("virsh calls = %q, want the attach then a detach from win10", got)
+			}
+		})
+	}
+}
+
+func TestAttachHostdevNoRollback(t *testing.T) {
+	// Failures other than a timeout leave nothing to roll back
+	t.Setenv(utils.VirshBinEnv, fakeCommand(t, "echo 'error: Requested operation is not valid' >&2\nexit 1\n"))
+	_, rollback, err := attachHostdev(context.Background(), defaultHost(), "win10", "046d", "c077", "<hostdev/>")
+	if err == nil || errors.Is(err, errDeviceCommandTimeout) || rollback != nil {
+		t.Errorf("attachHostdev() = %+v, %v, want a plain error without rollback", rollback, err)
+	}
+}
+
+func TestIdempotentAttachDetachByAddress(t *testing.T) {
+	setupTestDB(t)
+	// win10 has one of two identical keyboards attached, the one at bus 1 device 4
+	hostdev := "<hostdev mode='subsystem' type='usb'><source><vendor id='0x046d'/><product id='0xc31c'/><address bus='1' device='4'/></source></hostdev>"
+	calls := filepath.Join(t.TempDir(), "calls")
+	t.Setenv(utils.VirshBinEnv, fakeCommand(t, `case "$1" in
+list) echo win10; exit 0 ;;
+dumpxml) echo "<domain><name>win10</name><devices>`+hostdev+`</devices></domain>"; exit 0 ;;
+attach-device|detach-device) echo "$1" >> `+calls+`; exit 0 ;;
+esac
+exit 0
+`))
+
+	tests := []struct {
+		name    string
+		detach  bool
+		address utils.USBAddressXML
+		want    string
+	}{
+		{"attach the attached keyboard", false, utils.USBAddressXML{Bus: 1, Device: 4}, ""},
+		{"attach the other keyboard", false, utils.USBAddressXML{Bus: 1, Device: 5}, "attach-device"},
+		{"detach the attached keyboard", true, utils.USBAddressXML{Bus: 1, Device: 4}, "detach-device"},
+		{"detach the other keyboard", true, utils.USBAddressXML{Bus: 1, Device: 5}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(calls)
+			req := AttachDetachRequest{VendorID: "046d", ProductID: "c31c", Address: &tt.address}
+			var err error
+			if tt.detach {
+				_, err = Devices.Detach(context.Background(), defaultHost(), "win10", req, DetachOptions{Idempotent: true})
+			} else {
+				_, err = Devices.Attach(context.Background(), defaultHost(), "win10", req, AttachOptions{Idempotent: true, Force: true})
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := os.ReadFile(calls)
+			if got := strings.TrimSpace(string(data)); got != tt.want {
+				t.Errorf("virsh device commands = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListRunningVMsPagination(t *testing.T) {
+	t.Setenv(utils.VirshBinEnv, fakeCommand(t, `[ "$1" = list ] && printf 'win10\nubuntu\nWin11\ndebian\nwinserver\n'
+exit 0
+`))
+	app := fiber.New()
+	app.Get("/api/vms", ListRunningVMs)
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantVMs    []string
+		wantTotal  int
+		wantNext   any
+	}{
+		{"everything", "", 200, []string{"win10", "ubuntu", "Win11", "debian", "winserver"}, 5, nil},
+		{"filter is case-insensitive", "?q=WIN", 200, []string{"win10", "Win11", "winserver"}, 3, nil},
+		{"first page", "?limit=2", 200, []string{"win10", "ubuntu"}, 5, float64(2)},
+		{"middle page", "?limit=2&offset=2", 200, []string{"Win11", "debian"}, 5, float64(4)},
+		{"last page", "?limit=2&offset=4", 200, []string{"winserver"}, 5, nil},
+		{"exact last page", "?limit=5", 200, []string{"win10", "ubuntu", "Win11", "debian", "winserver"}, 5, nil},
+		{"filtered page", "?q=win&limit=2", 200, []string{"win10", "Win11"}, 3, float64(2)},
+		{"offset past the end", "?offset=10", 200, []string{}, 5, nil},
+		{"limit 0 means no limit", "?limit=0&offset=3", 200, []string{"debian", "winserver"}, 5, nil},
+		{"negative limit", "?limit=-1", 400, nil, 0, nil},
+		{"non-numeric offset", "?offset=abc", 400, nil, 0, nil},
+		{"negative offset", "?offset=-2", 400, nil, 0, nil},
+		{"invalid filter", "?q=a%3Bb", 400, nil, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest("GET", "/api/vms"+tt.query, nil), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus != 200 {
+				return
+			}
+
+			var body struct {
+				VMs   []VMResponse `json:"vms"`
+				Total int          `json:"total"`
+				Next  any          `json:"next"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			names := []string{}
+			for _, vm := range body.VMs {
+				names = append(names, vm.Name)
+			}
+			if !reflect.DeepEqual(names, tt.wantVMs) || body.Total != tt.wantTotal || body.Next != tt.wantNext {
+				t.Errorf("vms = %q, total = %d, next = %v, want %q, %d, %v", names, body.Total, body.Next, tt.wantVMs, tt.wantTotal, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestNotifyDeviceEventDescription(t *testing.T) {
+	setupTestDB(t)
+	events := make(chan webhook.Event, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event webhook.Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Error(err)
+		}
+		events <- event
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("WEBHOOK_URL", server.URL)
+
+	t.Setenv(utils.LsusbBinEnv, fakeCommand(t, `echo "Bus 001 Device 004: ID 046d:c077 Logitech, Inc. Mouse"`))
+
+	tests := []struct {
+		name string
+		host Host
+		want string
+	}{
+		{"local host", Host{Name: "local", Local: true}, "Logitech, Inc. Mouse"},
+		// Without SSH the devices of a remote host are unknown: this machine's mouse is not its device
+		{"remote host", Host{Name: "lab", URI: "qemu+ssh://lab/system"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notifyDeviceEvent(tt.host, "attach", "win10", "046d", "c077", "127.0.0.1", "")
+			select {
+			case event := <-events:
+				if event.Device.Description != tt.want {
+					t.Errorf("description = %q, want %q", event.Device.Description, tt.want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("no webhook event received")
+			}
+		})
+	}
+}
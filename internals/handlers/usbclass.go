@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"vfio_usb_passthrough/internals/i18n"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// usbClassLabels maps USB class codes (as printed by sysfs) to human-readable labels
+var usbClassLabels = map[string]string{
+	"01": "Audio",
+	"02": "Communications",
+	"03": "HID",
+	"05": "Physical",
+	"06": "Image",
+	"07": "Printer",
+	"08": "Mass Storage",
+	"09": "Hub",
+	"0a": "CDC Data",
+	"0b": "Smart Card",
+	"0d": "Content Security",
+	"0e": "Video",
+	"0f": "Personal Healthcare",
+	"10": "Audio/Video",
+	"11": "Billboard",
+	"12": "USB Type-C Bridge",
+	"dc": "Diagnostic",
+	"e0": "Wireless Controller",
+	"fe": "Application Specific",
+	"ff": "Vendor Specific",
+}
+
+// usbClassLabel returns the label of a device from its device class and the class of its first interface
+// Class 00 (defined per interface) and ef (miscellaneous, composite devices) defer to the interface class.
+// Returns "" for unknown classes
+func usbClassLabel(deviceClass, interfaceClass string) string {
+	class := strings.ToLower(deviceClass)
+	if class == "" || class == "00" || class == "ef" {
+		class = strings.ToLower(interfaceClass)
+	}
+	return usbClassLabels[class]
+}
+
+// usbClassAliases are short names accepted for class labels in ?class= filters
+var usbClassAliases = map[string]string{
+	"storage":   "Mass Storage",
+	"smartcard": "Smart Card",
+	"wireless":  "Wireless Controller",
+	"vendor":    "Vendor Specific",
+}
+
+// resolveUSBClass returns the class label designated by a class code (08 or 0x08), a label
+// (case-insensitive, e.g. "mass storage") or an alias ("storage"), or "" if there is none
+func resolveUSBClass(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if label, ok := usbClassLabels[strings.TrimPrefix(value, "0x")]; ok {
+		return label
+	}
+	if label, ok := usbClassAliases[value]; ok {
+		return label
+	}
+	for _, label := range usbClassLabels {
+		if strings.ToLower(label) == value {
+			return label
+		}
+	}
+	return ""
+}
+
+// usbClassQuery returns the class label of a ?class= filter, "" if there is none
+func usbClassQuery(c *fiber.Ctx) (string, *requestError) {
+	value := strings.TrimSpace(c.Query("class"))
+	if value == "" {
+		return "", nil
+	}
+	label := resolveUSBClass(value)
+	if label == "" {
+		return "", &requestError{400, fiber.Map{
+			"error": i18n.Msg(c, "invalid_usb_class", value),
+		}}
+	}
+	return label, nil
+}
+
+// classifyAttachedDevices sets the class of attached devices from the host's devices with the same IDs
+// Devices that are not plugged into the host (or whose class is unknown) are left without a class
+func classifyAttachedDevices(attached []AttachedDeviceResponse, devices []USBDeviceResponse) {
+	classes := make(map[string]string, len(devices))
+	for _, device := range devices {
+		if device.DeviceClass != "" {
+			classes[device.VendorID+":"+device.ProductID] = device.DeviceClass
+		}
+	}
+	for i := range attached {
+		attached[i].DeviceClass = classes[attached[i].VendorID+":"+attached[i].ProductID]
+	}
+}
+
+// filterAttachedByClass returns the attached devices of a class label (all of them if label is "")
+func filterAttachedByClass(attached []AttachedDeviceResponse, label string) []AttachedDeviceResponse {
+	if label == "" {
+		return attached
+	}
+	filtered := []AttachedDeviceResponse{}
+	for _, device := range attached {
+		if device.DeviceClass == label {
+			filtered = append(filtered, device)
+		}
+	}
+	return filtered
+}
+
+// readUSBDeviceClass returns the class label of the device in a sysfs directory
+func readUSBDeviceClass(dir string) string {
+	var interfaceClass string
+	// Interfaces are subdirectories named <device>:<config>.<interface> (1-1:1.0)
+	interfaces, _ := filepath.Glob(filepath.Join(dir, filepath.Base(dir)+":*"))
+	sort.Strings(interfaces)
+	if len(interfaces) > 0 {
+		interfaceClass = readSysfsAttr(interfaces[0], "bInterfaceClass")
+	}
+	return usbClassLabel(readSysfsAttr(dir, "bDeviceClass"), interfaceClass)
+}
+
+// Icon hints of USBDeviceResponse, shared by all clients
+const (
+	iconKeyboard = "keyboard"
+	iconMouse    = "mouse"
+	iconStorage  = "storage"
+	iconAudio    = "audio"
+	iconWebcam   = "webcam"
+	iconDongle   = "dongle"
+	iconUnknown  = "unknown"
+)
+
+// iconKeywords are the description words of each icon, checked in order: a webcam has a microphone
+// and a receiver serves a keyboard, so the more specific kinds come first
+var iconKeywords = []struct {
+	icon  string
+	words []string
+}{
+	{iconWebcam, []string{"webcam", "camera", "cam"}},
+	{iconDongle, []string{"receiver", "dongle", "bluetooth", "wlan", "wi-fi", "wifi", "802.11", "unifying", "lightspeed"}},
+	{iconKeyboard, []string{"keyboard", "keypad"}},
+	{iconMouse, []string{"mouse", "trackball", "touchpad", "trackpad"}},
+	{iconStorage, []string{"storage", "disk", "drive", "ssd", "flash", "card reader", "cruzer", "thumb"}},
+	{iconAudio, []string{"audio", "sound", "headset", "headphones", "microphone", "mic", "speaker", "speakers", "dac"}},
+}
+
+// iconClasses are the icons of the class labels that identify a kind of device on their own
+// (HID covers keyboards, mice and more, so it is left to the description)
+var iconClasses = map[string]string{
+	"Mass Storage":        iconStorage,
+	"Audio":               iconAudio,
+	"Video":               iconWebcam,
+	"Wireless Controller": iconDongle,
+}
+
+// usbIconHint classifies a device for its icon from its description words, then its class label
+// Words are matched whole ("mic" does not match "Microsoft"); unrecognized devices are iconUnknown
+func usbIconHint(deviceClass, description string) string {
+	text := " " + strings.Join(strings.FieldsFunc(strings.ToLower(description), func(r rune) bool {
+		return !('a' <= r && r <= 'z' || '0' <= r && r <= '9' || r == '.' || r == '-')
+	}), " ") + " "
+	for _, kind := range iconKeywords {
+		for _, word := range kind.words {
+			if strings.Contains(text, " "+word+" ") {
+				return kind.icon
+			}
+		}
+	}
+	if icon, ok := iconClasses[deviceClass]; ok {
+		return icon
+	}
+	return iconUnknown
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"vfio_usb_passthrough/internals/db"
+	"vfio_usb_passthrough/internals/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestUSBIconHint(t *testing.T) {
+	tests := []struct {
+		class       string
+		description string
+		want        string
+	}{
+		{"HID", "Logitech, Inc. Unifying Receiver", iconDongle},
+		{"HID", "Logitech, Inc. K120 Keyboard", iconKeyboard},
+		{"HID", "Logitech, Inc. M105 Optical Mouse", iconMouse},
+		{"HID", "Microsoft Corp. Nano Transceiver", iconUnknown},
+		{"Mass Storage", "SanDisk Corp. Cruzer Blade", iconStorage},
+		{"", "Generic USB2.0 Card Reader", iconStorage},
+		{"Video", "Logitech, Inc. HD Pro Webcam C920", iconWebcam},
+		{"Audio", "Blue Microphones Yeti Stereo Microphone", iconAudio},
+		{"Audio", "C-Media Electronics, Inc. Audio Adapter", iconAudio},
+		{"Wireless Controller", "Intel Corp. AX200 Bluetooth", iconDongle},
+		{"Wireless Controller", "Cambridge Silicon Radio, Ltd", iconDongle},
+		{"Hub", "Genesys Logic, Inc. Hub", iconUnknown},
+		{"", "", iconUnknown},
+	}
+	for _, tt := range tests {
+		if got := usbIconHint(tt.class, tt.description); got != tt.want {
+			t.Errorf("usbIconHint(%q, %q) = %q, want %q", tt.class, tt.description, got, tt.want)
+		}
+	}
+}
+
+func TestResolveUSBClass(t *testing.T) {
+	tests := map[string]string{
+		"storage":      "Mass Storage",
+		"Mass Storage": "Mass Storage",
+		"0x08":         "Mass Storage",
+		"hid":          "HID",
+		"03":           "HID",
+		" Audio ":      "Audio",
+		"printers":     "",
+		"":             "",
+	}
+	for value, want := range tests {
+		if got := resolveUSBClass(value); got != want {
+			t.Errorf("resolveUSBClass(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestClassifyAttachedDevices(t *testing.T) {
+	attached := []AttachedDeviceResponse{
+		{VendorID: "046d", ProductID: "c077"},
+		{VendorID: "0781", ProductID: "5567"},
+		{VendorID: "dead", ProductID: "beef"},
+	}
+	classifyAttachedDevices(attached, []USBDeviceResponse{
+		{VendorID: "0781", ProductID: "5567", DeviceClass: "Mass Storage"},
+		{VendorID: "046d", ProductID: "c077", DeviceClass: "HID"},
+	})
+	if attached[0].DeviceClass != "HID" || attached[1].DeviceClass != "Mass Storage" || attached[2].DeviceClass != "" {
+		t.Errorf("classifyAttachedDevices() = %+v, want HID, Mass Storage and unknown", attached)
+	}
+
+	storage := filterAttachedByClass(attached, "Mass Storage")
+	if len(storage) != 1 || storage[0].ProductID != "5567" {
+		t.Errorf("filterAttachedByClass(Mass Storage) = %+v, want the stick", storage)
+	}
+	if video := filterAttachedByClass(attached, "Video"); video == nil || len(video) != 0 {
+		t.Errorf("filterAttachedByClass(Video) = %#v, want an empty list", video)
+	}
+	if all := filterAttachedByClass(attached, ""); len(all) != 3 {
+		t.Errorf("filterAttachedByClass(\"\") = %+v, want all devices", all)
+	}
+}
+
+func TestClassFilterNeedsUSBDevices(t *testing.T) {
+	setupTestDB(t)
+	t.Setenv(utils.VirshBinEnv, fakeCommand(t, fakeVirshScript(usbHostdevXML("0781", "5567"))))
+	t.Setenv(utils.LsusbBinEnv, fakeCommand(t, "echo 'lsusb: failed' >&2\nexit 1\n"))
+	if err := db.SetVMGroup("gaming", []string{"win10"}); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Get("/api/inventory", GetInventory)
+	app.Get("/api/vm-groups/:group/attachments", GetVMGroupAttachments)
+
+	// Without the host's devices the attached stick cannot be classified: filtering must fail
+	// rather than return nothing, while unfiltered requests only warn
+	tests := []struct {
+		path       string
+		wantStatus int
+	}{
+		{"/api/inventory?class=storage", fiber.StatusInternalServerError},
+		{"/api/inventory", fiber.StatusOK},
+		{"/api/vm-groups/gaming/attachments?class=storage", fiber.StatusInternalServerError},
+		{"/api/vm-groups/gaming/attachments", fiber.StatusOK},
+	}
+	for _, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest("GET", tt.path, nil), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.wantStatus {
+			t.Errorf("GET %s status = %d, want %d", tt.path, resp.StatusCode, tt.wantStatus)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"vfio_usb_passthrough/internals/utils"
+)
+
+// Environment variables configuring the USB devices hidden from device lists
+// USB_HIDE_IDS is a comma-separated list of vendor:product or vendor:* patterns (default: root hubs);
+// set it to an empty value to hide nothing. USB_HIDE_CLASSES is a comma-separated list of class
+// codes (09) or labels (Hub)
+const (
+	USBHideIDsEnv     = "USB_HIDE_IDS"
+	USBHideClassesEnv = "USB_HIDE_CLASSES"
+)
+
+// defaultUSBHideIDs hides the Linux Foundation root hubs, which cannot be passed through
+const defaultUSBHideIDs = "1d6b:*"
+
+// usbIDPattern matches a vendor and a product ID, or any product of the vendor if product is "*"
+type usbIDPattern struct {
+	vendorID  string
+	productID string
+}
+
+// usbHideFilter is the set of devices hidden from device lists
+type usbHideFilter struct {
+	ids     []usbIDPattern
+	classes map[string]bool // lowercase class labels
+}
+
+// usbFilter is the filter applied by getUSBDevicesList, set by LoadUSBHideFilter
+var usbFilter = usbHideFilter{}
+
+// LoadUSBHideFilter reads the hidden devices from USB_HIDE_IDS and USB_HIDE_CLASSES
+func LoadUSBHideFilter() error {
+	ids, ok := os.LookupEnv(USBHideIDsEnv)
+	if !ok {
+		ids = defaultUSBHideIDs
+	}
+
+	filter, err := parseUSBHideFilter(ids, os.Getenv(USBHideClassesEnv))
+	if err != nil {
+		return err
+	}
+	usbFilter = filter
+	if len(filter.ids) > 0 || len(filter.classes) > 0 {
+		log.Printf("Hiding USB devices matching %d ID pattern(s) and %d class(es)", len(filter.ids), len(filter.classes))
+	}
+	return nil
+}
+
+// parseUSBHideFilter parses the comma-separated ID patterns and classes of a filter
+func parseUSBHideFilter(ids, classes string) (usbHideFilter, error) {
+	filter := usbHideFilter{classes: make(map[string]bool)}
+
+	for _, entry := range strings.Split(ids, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		pattern, err := parseUSBIDPattern(entry)
+		if err != nil {
+			return usbHideFilter{}, fmt.Errorf("invalid %s: %w", USBHideIDsEnv, err)
+		}
+		filter.ids = append(filter.ids, pattern)
+	}
+
+	for _, entry := range strings.Split(classes, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		// Class codes are accepted with or without 0x prefix
+		if label, ok := usbClassLabels[strings.TrimPrefix(entry, "0x")]; ok {
+			entry = strings.ToLower(label)
+		} else if !isUSBClassLabel(entry) {
+			return usbHideFilter{}, fmt.Errorf("invalid %s: unknown USB class %q", USBHideClassesEnv, entry)
+		}
+		filter.classes[entry] = true
+	}
+
+	return filter, nil
+}
+
+// parseUSBIDPattern parses a vendor:product or vendor:* pattern
+func parseUSBIDPattern(entry string) (usbIDPattern, error) {
+	vendorID, productID, found := strings.Cut(entry, ":")
+	if !found {
+		return usbIDPattern{}, fmt.Errorf("%q is not a vendor:product pattern", entry)
+	}
+	vendorID = normalizeDeviceID(vendorID)
+	productID = strings.TrimSpace(productID)
+	if !utils.IsValidHexID(vendorID) {
+		return usbIDPattern{}, fmt.Errorf("%q has an invalid vendor ID", entry)
+	}
+	if productID != "*" {
+		productID = normalizeDeviceID(productID)
+		if !utils.IsValidHexID(productID) {
+			return usbIDPattern{}, fmt.Errorf("%q has an invalid product ID", entry)
+		}
+	}
+	return usbIDPattern{vendorID: vendorID, productID: productID}, nil
+}
+
+// isUSBClassLabel reports whether a lowercase string is the label of a known USB class
+func isUSBClassLabel(label string) bool {
+	for _, known := range usbClassLabels {
+		if strings.ToLower(known) == label {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the pattern matches normalized vendor and product IDs
+func (p usbIDPattern) matches(vendorID, productID string) bool {
+	return p.vendorID == vendorID && (p.productID == "*" || p.productID == productID)
+}
+
+// hides reports whether a device is hidden by the filter
+func (f usbHideFilter) hides(device USBDeviceResponse) bool {
+	for _, pattern := range f.ids {
+		if pattern.matches(device.VendorID, device.ProductID) {
+			return true
+		}
+	}
+	return device.DeviceClass != "" && f.classes[strings.ToLower(device.DeviceClass)]
+}
+
+// apply returns the devices not hidden by the filter
+func (f usbHideFilter) apply(devices []USBDeviceResponse) []USBDeviceResponse {
+	if len(f.ids) == 0 && len(f.classes) == 0 {
+		return devices
+	}
+
+	var visible []USBDeviceResponse
+	for _, device := range devices {
+		if !f.hides(device) {
+			visible = append(visible, device)
+		}
+	}
+	return visible
+}
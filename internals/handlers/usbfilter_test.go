@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUSBIDPatternMatches(t *testing.T) {
+	tests := []struct {
+		pattern   string
+		vendorID  string
+		productID string
+		want      bool
+	}{
+		{"046d:c52b", "046d", "c52b", true},
+		{"046d:c52b", "046d", "c52c", false},
+		{"046d:*", "046d", "c52b", true},
+		{"046d:*", "046e", "c52b", false},
+		{"0x046D:0xC52B", "046d", "c52b", true},
+		{" 1d6b : * ", "1d6b", "0003", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			pattern, err := parseUSBIDPattern(tt.pattern)
+			if err != nil {
+				t.Fatalf("parseUSBIDPattern(%q): %v", tt.pattern, err)
+			}
+			if got := pattern.matches(tt.vendorID, tt.productID); got != tt.want {
+				t.Errorf("%q matches %s:%s = %v, want %v", tt.pattern, tt.vendorID, tt.productID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseUSBIDPatternInvalid(t *testing.T) {
+	for _, entry := range []string{"046d", "046d:", ":c52b", "*:c52b", "46d:c52b", "046d:c5*", "zzzz:c52b"} {
+		if _, err := parseUSBIDPattern(entry); err == nil {
+			t.Errorf("parseUSBIDPattern(%q) expected an error", entry)
+		}
+	}
+}
+
+func TestParseUSBHideFilterClasses(t *testing.T) {
+	filter, err := parseUSBHideFilter("", "09, video,0x0E")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]bool{"hub": true, "video": true}; !reflect.DeepEqual(filter.classes, want) {
+		t.Errorf("classes = %v, want %v", filter.classes, want)
+	}
+
+	if _, err := parseUSBHideFilter("", "Webcam"); err == nil {
+		t.Error("expected an error for an unknown class")
+	}
+	if _, err := parseUSBHideFilter("046d", ""); err == nil {
+		t.Error("expected an error for an invalid ID pattern")
+	}
+}
+
+func TestUSBHideFilterApply(t *testing.T) {
+	filter, err := parseUSBHideFilter("1d6b:*,04f2:b6dd", "Hub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	devices := []USBDeviceResponse{
+		{VendorID: "1d6b", ProductID: "0002", Description: "root hub"},
+		{VendorID: "04f2", ProductID: "b6dd", Description: "internal webcam"},
+		{VendorID: "04f2", ProductID: "b6de", Description: "other webcam"},
+		{VendorID: "05e3", ProductID: "0610", Description: "hub", DeviceClass: "Hub"},
+		{VendorID: "046d", ProductID: "c52b", Description: "receiver", DeviceClass: "HID"},
+	}
+
+	var got []string
+	for _, device := range filter.apply(devices) {
+		got = append(got, device.Description)
+	}
+	if want := []string{"other webcam", "receiver"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("apply() = %v, want %v", got, want)
+	}
+
+	if visible := (usbHideFilter{}).apply(devices); len(visible) != len(devices) {
+		t.Errorf("empty filter hid %d devices", len(devices)-len(visible))
+	}
+}
+
+func TestLoadUSBHideFilterDefault(t *testing.T) {
+	original := usbFilter
+	t.Cleanup(func() { usbFilter = original })
+
+	// Root hubs are hidden unless USB_HIDE_IDS is set, even to an empty value
+	if err := LoadUSBHideFilter(); err != nil {
+		t.Fatal(err)
+	}
+	if !usbFilter.hides(USBDeviceResponse{VendorID: "1d6b", ProductID: "0003"}) {
+		t.Error("expected root hubs to be hidden by default")
+	}
+
+	t.Setenv(USBHideIDsEnv, "")
+	if err := LoadUSBHideFilter(); err != nil {
+		t.Fatal(err)
+	}
+	if usbFilter.hides(USBDeviceResponse{VendorID: "1d6b", ProductID: "0003"}) {
+		t.Error("expected nothing hidden with an empty USB_HIDE_IDS")
+	}
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+
+	"vfio_usb_passthrough/internals/i18n"
+	"vfio_usb_passthrough/internals/utils"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+)
+
+// FieldError is one invalid field of a request body
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validate checks request structs against their `validate` tags
+// Fields are reported by their JSON name; "usbid" accepts a 4-digit hex ID with optional 0x prefix
+// "devicealias" a libvirt user alias, with or without its "ua-" prefix, "aliasname" a device alias name
+// and "vmname" a VM name
+var validate = newValidator()
+
+// newValidator returns the request validator with the custom rules registered
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	v.RegisterValidation("usbid", func(fl validator.FieldLevel) bool {
+		return utils.IsValidHexID(fl.Field().String())
+	})
+	v.RegisterValidation("devicealias", func(fl validator.FieldLevel) bool {
+		return utils.IsValidUserAlias(userAlias(fl.Field().String()))
+	})
+	v.RegisterValidation("aliasname", func(fl validator.FieldLevel) bool {
+		return deviceAliasPattern.MatchString(fl.Field().String())
+	})
+	v.RegisterValidation("vmname", func(fl validator.FieldLevel) bool {
+		return isValidVMNameFormat(fl.Field().String())
+	})
+	return v
+}
+
+// validateRequest validates a parsed request body and returns a response listing every invalid field
+// The status is 422 if only hex IDs are malformed, 400 otherwise
+func validateRequest(c *fiber.Ctx, req any) *requestError {
+	err := validate.Struct(req)
+	if err == nil {
+		return nil
+	}
+
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return &requestError{400, fiber.Map{
+			"error":   i18n.Msg(c, "invalid_request_body"),
+			"details": err.Error(),
+		}}
+	}
+
+	// Malformed hex IDs are well-formed requests with unprocessable values: 422 when they are the only problem
+	status := fiber.StatusUnprocessableEntity
+	fields := make([]FieldError, 0, len(validationErrors))
+	for _, fieldErr := range validationErrors {
+		if fieldErr.Tag() != "usbid" {
+			status = 400
+		}
+		fields = append(fields, FieldError{
+			Field:   fieldPath(fieldErr),
+			Message: fieldErrorMessage(c, fieldErr),
+		})
+	}
+	return &requestError{status, fiber.Map{
+		"error":  i18n.Msg(c, "validation_failed"),
+		"errors": fields,
+	}}
+}
+
+// fieldPath returns the JSON path of an invalid field without the struct name (e.g. "devices[0].vendorId")
+func fieldPath(fieldErr validator.FieldError) string {
+	_, path, found := strings.Cut(fieldErr.Namespace(), ".")
+	if !found {
+		return fieldErr.Field()
+	}
+	return path
+}
+
+// fieldErrorMessage returns the localized message of a failed validation rule
+func fieldErrorMessage(c *fiber.Ctx, fieldErr validator.FieldError) string {
+	switch fieldErr.Tag() {
+	case "required":
+		return i18n.Msg(c, "validation_required")
+	case "usbid":
+		return i18n.Msg(c, "validation_usbid")
+	case "devicealias":
+		return i18n.Msg(c, "validation_devicealias")
+	case "aliasname":
+		return i18n.Msg(c, "validation_aliasname")
+	case "vmname":
+		return i18n.Msg(c, "validation_vmname")
+	case "min":
+		return i18n.Msg(c, "validation_min", fieldErr.Param())
+	case "max":
+		return i18n.Msg(c, "validation_max", fieldErr.Param())
+	default:
+		return i18n.Msg(c, "validation_invalid")
+	}
+}
+
+// userAlias returns a device alias with the "ua-" prefix libvirt requires for user aliases ("" stays "")
+func userAlias(alias string) string {
+	alias = strings.TrimSpace(alias)
+	if alias == "" || strings.HasPrefix(alias, utils.UserAliasPrefix) {
+		return alias
+	}
+	return utils.UserAliasPrefix + alias
+}
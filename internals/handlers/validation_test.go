@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// newValidationTestApp returns an app validating attach/detach request bodies
+func newValidationTestApp() *fiber.App {
+	app := fiber.New()
+	app.Post("/", func(c *fiber.Ctx) error {
+		var req AttachDetachRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		if reqErr := validateRequest(c, &req); reqErr != nil {
+			return reqErr.send(c)
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+	return app
+}
+
+// postValidation posts a request body and returns the status and reported field errors
+func postValidation(t *testing.T, app *fiber.App, body string) (int, []FieldError) {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed struct {
+		Errors []FieldError `json:"errors"`
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &parsed); err != nil {
+			t.Fatalf("invalid response body %q: %v", data, err)
+		}
+	}
+	return resp.StatusCode, parsed.Errors
+}
+
+func TestValidateRequestMalformedHexIDs(t *testing.T) {
+	app := newValidationTestApp()
+
+	tests := []struct {
+		name     string
+		vendorID string
+	}{
+		{"too short", "46d"},
+		{"too long", "046dd"},
+		{"not hex", "zz6d"},
+		{"prefix only", "0x"},
+		{"prefixed too short", "0x46d"},
+		{"double prefix", "0x0x046d"},
+		{"vendor:product", "046d:c52b"},
+		{"negative", "-46d"},
+		{"inner space", "04 6d"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(fiber.Map{"vendorId": tt.vendorID, "productId": "c52b"})
+			status, fields := postValidation(t, app, string(body))
+			if status != fiber.StatusUnprocessableEntity {
+				t.Fatalf("expected 422, got %d", status)
+			}
+			if len(fields) != 1 || fields[0].Field != "vendorId" {
+				t.Fatalf("expected a vendorId field error, got %+v", fields)
+			}
+			if !strings.Contains(fields[0].Message, "4 hexadecimal digits") {
+				t.Errorf("unexpected message %q", fields[0].Message)
+			}
+		})
+	}
+}
+
+func TestValidateRequestValidHexIDs(t *testing.T) {
+	app := newValidationTestApp()
+
+	for _, id := range []string{"046d", "046D", "0x046d", "0X046D", " 046d "} {
+		body, _ := json.Marshal(fiber.Map{"vendorId": id, "productId": "c52b"})
+		if status, fields := postValidation(t, app, string(body)); status != fiber.StatusNoContent {
+			t.Errorf("%q: expected 204, got %d (%+v)", id, status, fields)
+		}
+	}
+}
+
+func TestValidateRequestMissingIDIsBadRequest(t *testing.T) {
+	app := newValidationTestApp()
+
+	// A missing field is a malformed request, even alongside a malformed ID
+	for _, body := range []string{`{"productId":"c52b"}`, `{"vendorId":"zzzz"}`} {
+		if status, _ := postValidation(t, app, body); status != 400 {
+			t.Errorf("%s: expected 400, got %d", body, status)
+		}
+	}
+}
+
+func TestApplyDeviceString(t *testing.T) {
+	req := AttachDetachRequest{Device: " 046D:0xC52B "}
+	if err := applyDeviceString(&req); err != nil {
+		t.Fatal(err)
+	}
+	if req.VendorID != "046D" || req.ProductID != "0xC52B" || req.Device != "" {
+		t.Errorf("request = %+v, want the IDs split out of the device string", req)
+	}
+
+	tests := []struct {
+		name string
+		req  AttachDetachRequest
+		want error
+	}{
+		{"no separator", AttachDetachRequest{Device: "046dc52b"}, ErrInvalidDeviceString},
+		{"short ID", AttachDetachRequest{Device: "46d:c52b"}, ErrInvalidDeviceString},
+		{"not hex", AttachDetachRequest{Device: "046d:zzzz"}, ErrInvalidDeviceString},
+		{"extra part", AttachDetachRequest{Device: "046d:c52b:0001"}, ErrInvalidDeviceString},
+		{"with IDs", AttachDetachRequest{Device: "046d:c52b", VendorID: "046d"}, ErrDeviceWithIDs},
+		{"with alias", AttachDetachRequest{Device: "046d:c52b", DeviceAlias: "mouse"}, ErrDeviceWithIDs},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := applyDeviceString(&tt.req); err != tt.want {
+				t.Errorf("applyDeviceString() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"vfio_usb_passthrough/internals/db"
+	"vfio_usb_passthrough/internals/i18n"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// VMGroupRequest is the member list of a VM group (e.g. "gaming": win10, steamos), at most 100 VMs
+type VMGroupRequest struct {
+	VMs []string `json:"vms" validate:"required,min=1,max=100,dive,vmname"`
+}
+
+// VMGroupAttachments are the devices attached to the running members of a VM group
+// Members that are not running are listed in NotRunning; members whose devices cannot be read
+// are reported in Warnings and left out of Attachments
+type VMGroupAttachments struct {
+	Group       string                              `json:"group"`
+	Attachments map[string][]AttachedDeviceResponse `json:"attachments"`
+	NotRunning  []string                            `json:"notRunning"`
+	Warnings    []string                            `json:"warnings"`
+}
+
+// vmGroupName returns the group name of a request path, normalized like device alias names,
+// or "" if it is invalid
+func vmGroupName(c *fiber.Ctx) string {
+	name := strings.ToLower(strings.TrimSpace(c.Params("group")))
+	if !deviceAliasPattern.MatchString(name) {
+		return ""
+	}
+	return name
+}
+
+// GetVMGroups returns the members of all VM groups, keyed by group name
+func GetVMGroups(c *fiber.Ctx) error {
+	groups, err := db.GetVMGroups()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "get_vm_groups_failed"),
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"groups": groups,
+	})
+}
+
+// GetVMGroup returns the members of a VM group
+func GetVMGroup(c *fiber.Ctx) error {
+	name := vmGroupName(c)
+	if name == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Msg(c, "invalid_vm_group"),
+		})
+	}
+
+	members, err := db.GetVMGroup(name)
+	if errors.Is(err, db.ErrVMGroupNotFound) {
+		return c.Status(404).JSON(fiber.Map{
+			"error": i18n.Msg(c, "vm_group_not_found", name),
+		})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "get_vm_groups_failed"),
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"group": name,
+		"vms":   members,
+	})
+}
+
+// SetVMGroup creates a VM group or replaces its members
+// Members are VM names; they need not be running, or even defined yet
+func SetVMGroup(c *fiber.Ctx) error {
+	name := vmGroupName(c)
+	if name == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Msg(c, "invalid_vm_group"),
+		})
+	}
+
+	var req VMGroupRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "invalid_request_body"),
+			"details": err.Error(),
+		})
+	}
+
+	if reqErr := validateRequest(c, &req); reqErr != nil {
+		return reqErr.send(c)
+	}
+
+	if err := db.SetVMGroup(name, req.VMs); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "set_vm_group_failed"),
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": i18n.Msg(c, "vm_group_saved", name),
+	})
+}
+
+// RemoveVMGroup removes a VM group (its VMs are left alone)
+func RemoveVMGroup(c *fiber.Ctx) error {
+	name := vmGroupName(c)
+	if name == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Msg(c, "invalid_vm_group"),
+		})
+	}
+
+	err := db.RemoveVMGroup(name)
+	if errors.Is(err, db.ErrVMGroupNotFound) {
+		return c.Status(404).JSON(fiber.Map{
+			"error": i18n.Msg(c, "vm_group_not_found", name),
+		})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "remove_vm_group_failed"),
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": i18n.Msg(c, "vm_group_removed", name),
+	})
+}
+
+// GetVMGroupAttachments returns the devices attached to each running member of a VM group, keyed by VM
+// Devices get the class of the host device with the same IDs; ?class= (e.g. storage) keeps only that class
+func GetVMGroupAttachments(c *fiber.Ctx) error {
+	host := hostFromCtx(c)
+	name := vmGroupName(c)
+	if name == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"error": i18n.Msg(c, "invalid_vm_group"),
+		})
+	}
+	class, reqErr := usbClassQuery(c)
+	if reqErr != nil {
+		return reqErr.send(c)
+	}
+
+	members, err := db.GetVMGroup(name)
+	if errors.Is(err, db.ErrVMGroupNotFound) {
+		return c.Status(404).JSON(fiber.Map{
+			"error": i18n.Msg(c, "vm_group_not_found", name),
+		})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "get_vm_groups_failed"),
+			"details": err.Error(),
+		})
+	}
+
+	runningNames, err := getRunningVMNames(host)
+	if err != nil {
+		log.Printf("Error listing VMs: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "list_vms_failed"),
+			"details": err.Error(),
+		})
+	}
+	isRunning := make(map[string]bool, len(runningNames))
+	for _, vmName := range runningNames {
+		isRunning[vmName] = true
+	}
+
+	response := VMGroupAttachments{
+		Group:       name,
+		Attachments: make(map[string][]AttachedDeviceResponse),
+		NotRunning:  []string{},
+		Warnings:    []string{},
+	}
+	var running []string
+	for _, vmName := range members {
+		if isRunning[vmName] {
+			running = append(running, vmName)
+		} else {
+			response.NotRunning = append(response.NotRunning, vmName)
+		}
+	}
+
+	// Fetch attachments per running member with bounded concurrency
+	attached := make([][]AttachedDeviceResponse, len(running))
+	attachedErrs := make([]error, len(running))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentVMQueries)
+	for i, vmName := range running {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			attached[i], attachedErrs[i] = getAttachedDevicesList(c.UserContext(), host, vmName)
+		}()
+	}
+	wg.Wait()
+
+	// Classes come from the host's devices; without them attached devices are left unclassified,
+	// which would make a ?class= filter wrongly return nothing
+	usbDevices, err := hostUSBDevices(host)
+	if err != nil && class != "" {
+		log.Printf("VM group %s: Failed to list USB devices to filter by class: %v", name, err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   i18n.Msg(c, "list_usb_devices_failed"),
+			"details": err.Error(),
+		})
+	}
+	if err != nil {
+		log.Printf("VM group %s: Warning - failed to list USB devices: %v", name, err)
+		response.Warnings = append(response.Warnings, fmt.Sprintf("%s: %v", i18n.Msg(c, "list_usb_devices_failed"), err))
+	}
+
+	for i, vmName := range running {
+		if err := attachedErrs[i]; err != nil {
+			log.Printf("VM group %s: Warning - failed to get attached devices for %s: %v", name, vmName, err)
+			response.Warnings = append(response.Warnings, fmt.Sprintf("%s: %v", i18n.Msg(c, "get_attached_devices_failed", vmName), err))
+			continue
+		}
+		if attached[i] == nil {
+			attached[i] = []AttachedDeviceResponse{}
+		}
+		classifyAttachedDevices(attached[i], usbDevices)
+		response.Attachments[vmName] = filterAttachedByClass(attached[i], class)
+	}
+
+	return c.JSON(response)
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+
+	"vfio_usb_passthrough/internals/i18n"
+)
+
+// ErrVMUUIDUnknown is returned when no VM has the UUID given in place of a VM name
+var ErrVMUUIDUnknown = i18n.NewError("vm_uuid_unknown")
+
+// vmUUIDPattern matches a libvirt domain UUID
+var vmUUIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// vmUUIDCache remembers the UUID of running VMs by host and name (a running VM cannot be redefined)
+var vmUUIDCache = struct {
+	sync.Mutex
+	uuids map[trackedVM]string
+}{uuids: make(map[trackedVM]string)}
+
+// resolveVMParam returns the VM name designated by a route parameter, which is either a VM name
+// or a libvirt UUID; UUIDs let VMs whose names do not match vmNamePattern (e.g. with spaces) be managed.
+// Names are returned unchanged and are not validated
+func resolveVMParam(host Host, param string) (string, error) {
+	if !vmUUIDPattern.MatchString(param) {
+		return param, nil
+	}
+
+	cmd := virshCommand(context.Background(), host, "domname", param)
+	output, err := cmd.Output()
+	vmName := strings.TrimSpace(string(output))
+	if err != nil || vmName == "" {
+		log.Printf("No VM with UUID %s on %s: %v", param, host.Name, err)
+		return "", ErrVMUUIDUnknown
+	}
+	return vmName, nil
+}
+
+// resolveVMName returns the name of the running VM designated by a route parameter (name or UUID)
+// Names resolved from a UUID come from libvirt and are only ever passed to virsh as exec arguments,
+// so they are not held to vmNamePattern
+func resolveVMName(host Host, param string) (string, error) {
+	if !vmUUIDPattern.MatchString(param) {
+		return param, validateVMName(host, param)
+	}
+
+	vmName, err := resolveVMParam(host, param)
+	if err != nil {
+		return "", err
+	}
+	if !isVMRunning(host, vmName) {
+		return "", ErrVMNotRunning
+	}
+	return vmName, nil
+}
+
+// getVMUUIDs returns the UUIDs of running VMs by name; VMs whose UUID cannot be read are left out
+// Missing UUIDs are read with the cache unlocked, so a slow virsh does not block other requests
+func getVMUUIDs(host Host, vmNames []string) map[string]string {
+	uuids := make(map[string]string, len(vmNames))
+	var missing []string
+	vmUUIDCache.Lock()
+	for _, vmName := range vmNames {
+		if uuid, ok := vmUUIDCache.uuids[trackedVM{Host: host, VMName: vmName}]; ok {
+			uuids[vmName] = uuid
+		} else {
+			missing = append(missing, vmName)
+		}
+	}
+	vmUUIDCache.Unlock()
+
+	fetched := make(map[string]string, len(missing))
+	for _, vmName := range missing {
+		cmd := virshCommand(context.Background(), host, "domuuid", vmName)
+		output, err := cmd.Output()
+		if err != nil {
+			log.Printf("Warning: Failed to read UUID of VM %s: %v", vmName, err)
+			continue
+		}
+		fetched[vmName] = strings.TrimSpace(string(output))
+		uuids[vmName] = fetched[vmName]
+	}
+
+	vmUUIDCache.Lock()
+	defer vmUUIDCache.Unlock()
+
+	for vmName, uuid := range fetched {
+		vmUUIDCache.uuids[trackedVM{Host: host, VMName: vmName}] = uuid
+	}
+
+	// Forget stopped VMs so a redefined VM gets its new UUID
+	running := make(map[string]bool, len(vmNames))
+	for _, vmName := range vmNames {
+		running[vmName] = true
+	}
+	for key := range vmUUIDCache.uuids {
+		if key.Host == host && !running[key.VMName] {
+			delete(vmUUIDCache.uuids, key)
+		}
+	}
+	return uuids
+}
+
+// vmRef returns the identifier to use for a VM in API paths: its name, or its UUID when
+// the name does not match vmNamePattern
+func vmRef(vmName, uuid string) string {
+	if isValidVMNameFormat(vmName) || uuid == "" {
+		return vmName
+	}
+	return uuid
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"vfio_usb_passthrough/internals/utils"
+)
+
+func TestGetVMUUIDs(t *testing.T) {
+	host := Host{Name: "uuid-test", URI: "qemu:///system", Local: true}
+	t.Cleanup(func() {
+		vmUUIDCache.Lock()
+		for key := range vmUUIDCache.uuids {
+			if key.Host == host {
+				delete(vmUUIDCache.uuids, key)
+			}
+		}
+		vmUUIDCache.Unlock()
+	})
+
+	// virsh signals it started and blocks until release exists, so the test can check the cache is usable meanwhile
+	dir := t.TempDir()
+	started, release := filepath.Join(dir, "started"), filepath.Join(dir, "release")
+	t.Setenv(utils.VirshBinEnv, fakeCommand(t, `touch `+started+`
+while [ ! -e `+release+` ]; do sleep 0.01; done
+for arg in "$@"; do
+  case "$arg" in
+  win10) echo 6f1c3e4a-0b2d-4c5e-8f70-1a2b3c4d5e6f; exit 0 ;;
+  esac
+done
+echo "error: failed to get domain" >&2
+exit 1
+`))
+
+	done := make(chan map[string]string)
+	go func() { done <- getVMUUIDs(host, []string{"win10", "broken"}) }()
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(started); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("virsh was not run")
+		}
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		vmUUIDCache.Lock()
+		vmUUIDCache.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		writeFile(t, release, "")
+		t.Fatal("vmUUIDCache stayed locked while virsh was running")
+	}
+	writeFile(t, release, "")
+
+	want := map[string]string{"win10": "6f1c3e4a-0b2d-4c5e-8f70-1a2b3c4d5e6f"}
+	if got := <-done; !reflect.DeepEqual(got, want) {
+		t.Errorf("getVMUUIDs() = %v, want %v", got, want)
+	}
+
+	// Cached UUIDs are served without virsh; stopped VMs are forgotten
+	t.Setenv(utils.VirshBinEnv, fakeCommand(t, "exit 1\n"))
+	if got := getVMUUIDs(host, []string{"win10"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("cached getVMUUIDs() = %v, want %v", got, want)
+	}
+	getVMUUIDs(host, nil)
+	vmUUIDCache.Lock()
+	_, cached := vmUUIDCache.uuids[trackedVM{Host: host, VMName: "win10"}]
+	vmUUIDCache.Unlock()
+	if cached {
+		t.Error("UUID of a stopped VM is still cached")
+	}
+}
@@ -0,0 +1,120 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DefaultLang is the language used when no supported language is requested
+const DefaultLang = "en"
+
+//go:embed locales/*.json
+var localesFS embed.FS
+
+// catalogs maps a language code to its message catalog (key -> format string)
+var catalogs = map[string]map[string]string{}
+
+// supportedLangs lists the loaded languages, DefaultLang first
+var supportedLangs []string
+
+func init() {
+	entries, err := localesFS.ReadDir("locales")
+	if err != nil {
+		log.Fatalf("i18n: failed to read embedded locales: %v", err)
+	}
+
+	supportedLangs = append(supportedLangs, DefaultLang)
+	for _, entry := range entries {
+		lang := strings.TrimSuffix(entry.Name(), ".json")
+
+		data, err := localesFS.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			log.Fatalf("i18n: failed to read locale %s: %v", lang, err)
+		}
+
+		var catalog map[string]string
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			log.Fatalf("i18n: failed to parse locale %s: %v", lang, err)
+		}
+
+		catalogs[lang] = catalog
+		if lang != DefaultLang {
+			supportedLangs = append(supportedLangs, lang)
+		}
+	}
+
+	if _, ok := catalogs[DefaultLang]; !ok {
+		log.Fatalf("i18n: default locale %s is missing", DefaultLang)
+	}
+}
+
+// T returns the message for key in the given language, formatted with args.
+// Falls back to DefaultLang, then to the key itself if no translation exists.
+func T(lang, key string, args ...interface{}) string {
+	format, ok := catalogs[lang][key]
+	if !ok {
+		format, ok = catalogs[DefaultLang][key]
+	}
+	if !ok {
+		format = key
+	}
+
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// Lang determines the language for a request.
+// The ?lang= query parameter takes precedence over the Accept-Language header.
+func Lang(c *fiber.Ctx) string {
+	if lang := strings.ToLower(c.Query("lang")); lang != "" {
+		if _, ok := catalogs[lang]; ok {
+			return lang
+		}
+	}
+
+	if lang := c.AcceptsLanguages(supportedLangs...); lang != "" {
+		return lang
+	}
+
+	return DefaultLang
+}
+
+// Msg returns the message for key in the language of the request
+func Msg(c *fiber.Ctx, key string, args ...interface{}) string {
+	return T(Lang(c), key, args...)
+}
+
+// Error is an error whose message is looked up in the catalog by key
+type Error struct {
+	Key  string
+	Args []interface{}
+}
+
+// NewError creates a new keyed error
+func NewError(key string, args ...interface{}) *Error {
+	return &Error{Key: key, Args: args}
+}
+
+// Error returns the message in DefaultLang so keyed errors read normally in logs
+func (e *Error) Error() string {
+	return T(DefaultLang, e.Key, e.Args...)
+}
+
+// Localize returns the message of err in the language of the request.
+// Errors that are not keyed are returned as-is.
+func Localize(c *fiber.Ctx, err error) string {
+	var keyed *Error
+	if errors.As(err, &keyed) {
+		return Msg(c, keyed.Key, keyed.Args...)
+	}
+	return err.Error()
+}
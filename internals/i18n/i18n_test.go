@@ -0,0 +1,116 @@
+package i18n
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// requestLang runs Lang and Msg for a request with the given query string and Accept-Language header
+func requestLang(t *testing.T, query, acceptLanguage string) (lang, msg string) {
+	t.Helper()
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString(Lang(c) + "|" + Msg(c, "unauthorized"))
+	})
+
+	req := httptest.NewRequest("GET", "/"+query, nil)
+	if acceptLanguage != "" {
+		req.Header.Set(fiber.HeaderAcceptLanguage, acceptLanguage)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lang, msg, _ = strings.Cut(string(body), "|")
+	return lang, msg
+}
+
+func TestLang(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		acceptLanguage string
+		want           string
+	}{
+		{"no preference", "", "", "en"},
+		{"supported language", "", "fr", "fr"},
+		{"region falls back to the language", "", "fr-CA", "fr"},
+		{"unsupported language", "", "de-DE", "en"},
+		{"highest quality first", "", "en;q=0.5, fr;q=0.9", "fr"},
+		{"first supported by quality", "", "de;q=1.0, fr-CH;q=0.8, en;q=0.5", "fr"},
+		{"wildcard", "", "*", "en"},
+		{"query overrides the header", "?lang=fr", "en", "fr"},
+		{"query is case-insensitive", "?lang=FR", "", "fr"},
+		{"unsupported query falls back to the header", "?lang=xx", "fr", "fr"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if lang, _ := requestLang(t, tt.query, tt.acceptLanguage); lang != tt.want {
+				t.Errorf("Lang() = %q, want %q", lang, tt.want)
+			}
+		})
+	}
+}
+
+func TestMsg(t *testing.T) {
+	if _, msg := requestLang(t, "", "fr-FR"); msg != catalogs["fr"]["unauthorized"] {
+		t.Errorf("Msg() = %q, want the French message", msg)
+	}
+	if _, msg := requestLang(t, "?lang=en", "fr"); msg != "Authentication required" {
+		t.Errorf("Msg() = %q, want the English message", msg)
+	}
+}
+
+func TestT(t *testing.T) {
+	// A key missing from a language falls back to English, then to the key itself
+	catalogs["en"]["test_only_en"] = "Only in %s"
+	t.Cleanup(func() { delete(catalogs["en"], "test_only_en") })
+
+	tests := []struct {
+		lang string
+		key  string
+		args []interface{}
+		want string
+	}{
+		{"fr", "test_only_en", []interface{}{"English"}, "Only in English"},
+		{"xx", "unauthorized", nil, "Authentication required"},
+		{"fr", "no_such_key", nil, "no_such_key"},
+	}
+	for _, tt := range tests {
+		if got := T(tt.lang, tt.key, tt.args...); got != tt.want {
+			t.Errorf("T(%q, %q) = %q, want %q", tt.lang, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		keyed := fmt.Errorf("wrapped: %w", NewError("unauthorized"))
+		return c.SendString(Localize(c, keyed) + "|" + Localize(c, errors.New("plain")))
+	})
+
+	req := httptest.NewRequest("GET", "/?lang=fr", nil)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if want := catalogs["fr"]["unauthorized"] + "|plain"; string(body) != want {
+		t.Errorf("Localize() = %q, want %q", body, want)
+	}
+	if got := NewError("unauthorized").Error(); got != "Authentication required" {
+		t.Errorf("Error() = %q, want the English message", got)
+	}
+}
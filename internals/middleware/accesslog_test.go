@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/logger"
+)
+
+// accessLogLine serves one request through the configured access logger and returns the logged line
+func accessLogLine(t *testing.T, path string, handler fiber.Handler) string {
+	t.Helper()
+	cfg, err := AccessLogConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	cfg.Output = &out
+
+	app := fiber.New()
+	app.Use(logger.New(cfg))
+	app.Get("/*", handler)
+	if _, err := app.Test(httptest.NewRequest("GET", path, nil)); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestAccessLogConfigInvalid(t *testing.T) {
+	t.Setenv(LogFormatEnv, "xml")
+	if _, err := AccessLogConfig(); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestAccessLogText(t *testing.T) {
+	t.Setenv(LogFormatEnv, "")
+	line := accessLogLine(t, "/api/vms", func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+	if !strings.Contains(line, "| GET | /api/vms | - |") {
+		t.Errorf("line = %q, want the method, path and an anonymous user", line)
+	}
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	t.Setenv(LogFormatEnv, "json")
+	line := accessLogLine(t, `/api/vms/a"b`, func(c *fiber.Ctx) error {
+		c.Locals("username", "admin")
+		return fiber.NewError(fiber.StatusNotFound, "not found")
+	})
+
+	var entry struct {
+		Time      string   `json:"time"`
+		IP        string   `json:"ip"`
+		Method    string   `json:"method"`
+		Path      string   `json:"path"`
+		Status    int      `json:"status"`
+		LatencyMS *float64 `json:"latency_ms"`
+		User      *string  `json:"user"`
+		Error     *string  `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		t.Fatalf("line %q is not JSON: %v", line, err)
+	}
+	if entry.Method != "GET" || entry.Path != `/api/vms/a"b` || entry.Status != fiber.StatusNotFound {
+		t.Errorf("entry = %+v, want GET /api/vms/a\"b with status 404", entry)
+	}
+	if entry.Time == "" || entry.IP == "" || entry.LatencyMS == nil {
+		t.Errorf("entry = %+v, want a time, IP and latency", entry)
+	}
+	if entry.User == nil || *entry.User != "admin" {
+		t.Errorf("user = %v, want admin", entry.User)
+	}
+	if entry.Error == nil || *entry.Error != "not found" {
+		t.Errorf("error = %v, want not found", entry.Error)
+	}
+}
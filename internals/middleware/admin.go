@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"log"
+
+	"vfio_usb_passthrough/internals/i18n"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// NewAdminGuard returns a middleware for the admin routes, which reveal and change the server configuration
+// When authenticated is false (no HTTP Basic auth in front of the API) only loopback clients are let through;
+// otherwise the basic auth middleware registered before it has already checked the credentials
+func NewAdminGuard(authenticated bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if authenticated {
+			return c.Next()
+		}
+
+		ip := extractIP(c.IP())
+		if ip == nil || !ip.IsLoopback() {
+			log.Printf("Security: Rejected admin request from %s (%s %s): admin routes require basic auth or a loopback client", c.IP(), c.Method(), c.Path())
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": i18n.Msg(c, "admin_forbidden"),
+			})
+		}
+
+		return c.Next()
+	}
+}
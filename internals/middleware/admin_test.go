@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestAdminGuard(t *testing.T) {
+	tests := []struct {
+		name          string
+		authenticated bool
+		clientIP      string
+		wantStatus    int
+	}{
+		{"loopback IPv4", false, "127.0.0.1", fiber.StatusOK},
+		{"loopback IPv6", false, "::1", fiber.StatusOK},
+		{"LAN client", false, "192.168.1.20", fiber.StatusForbidden},
+		{"invalid address", false, "not-an-ip", fiber.StatusForbidden},
+		{"LAN client with basic auth", true, "192.168.1.20", fiber.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New(fiber.Config{ProxyHeader: "X-Real-IP"})
+			app.Get("/api/admin/config", NewAdminGuard(tt.authenticated), func(c *fiber.Ctx) error {
+				return c.SendString("ok")
+			})
+
+			req := httptest.NewRequest("GET", "/api/admin/config", nil)
+			req.Header.Set("X-Real-IP", tt.clientIP)
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
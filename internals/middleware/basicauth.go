@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"os"
+
+	"vfio_usb_passthrough/internals/i18n"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/basicauth"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// BasicAuthUserEnv is the username required by HTTP Basic auth
+	BasicAuthUserEnv = "BASIC_AUTH_USER"
+	// BasicAuthPassEnv is the bcrypt hash of the HTTP Basic auth password
+	// (e.g. generated with `htpasswd -nbB user password`)
+	BasicAuthPassEnv = "BASIC_AUTH_PASS"
+)
+
+// BasicAuthEnabled reports whether HTTP Basic auth is configured for the API
+func BasicAuthEnabled() bool {
+	return os.Getenv(BasicAuthUserEnv) != "" && os.Getenv(BasicAuthPassEnv) != ""
+}
+
+// BasicAuthAuthorizer returns the credential check of the configured HTTP Basic auth, or nil when
+// BASIC_AUTH_USER and BASIC_AUTH_PASS are not set; it is shared by the API and the gRPC server
+func BasicAuthAuthorizer() (func(username, password string) bool, error) {
+	user := os.Getenv(BasicAuthUserEnv)
+	passHash := os.Getenv(BasicAuthPassEnv)
+	if user == "" && passHash == "" {
+		return nil, nil
+	}
+	if user == "" || passHash == "" {
+		return nil, fmt.Errorf("%s and %s must be set together", BasicAuthUserEnv, BasicAuthPassEnv)
+	}
+	if _, err := bcrypt.Cost([]byte(passHash)); err != nil {
+		return nil, fmt.Errorf("%s must be a bcrypt hash: %w", BasicAuthPassEnv, err)
+	}
+
+	return func(username, password string) bool {
+		// Always check the password so timing does not reveal whether the username matched
+		userOK := subtle.ConstantTimeCompare([]byte(username), []byte(user)) == 1
+		passOK := bcrypt.CompareHashAndPassword([]byte(passHash), []byte(password)) == nil
+		return userOK && passOK
+	}, nil
+}
+
+// NewBasicAuth returns an HTTP Basic auth middleware for the API, or nil when
+// BASIC_AUTH_USER and BASIC_AUTH_PASS are not set
+func NewBasicAuth() (fiber.Handler, error) {
+	authorizer, err := BasicAuthAuthorizer()
+	if authorizer == nil || err != nil {
+		return nil, err
+	}
+
+	log.Printf("Security: HTTP Basic auth enabled for user %q", os.Getenv(BasicAuthUserEnv))
+	return basicauth.New(basicauth.Config{
+		Realm:      "vfio_usb_passthrough",
+		Authorizer: authorizer,
+		Unauthorized: func(c *fiber.Ctx) error {
+			log.Printf("Security: Rejected API request from %s: invalid or missing basic auth credentials", c.IP())
+			c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="vfio_usb_passthrough", charset="UTF-8"`)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": i18n.Msg(c, "unauthorized"),
+			})
+		},
+	}), nil
+}
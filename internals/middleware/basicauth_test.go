@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestNewBasicAuthDisabled(t *testing.T) {
+	t.Setenv(BasicAuthUserEnv, "")
+	t.Setenv(BasicAuthPassEnv, "")
+
+	handler, err := NewBasicAuth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handler != nil {
+		t.Error("expected no middleware when basic auth is not configured")
+	}
+}
+
+func TestNewBasicAuthInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		user string
+		pass string
+	}{
+		{"user only", "admin", ""},
+		{"password only", "", "$2a$10$abcdefghijklmnopqrstuu"},
+		{"plain text password", "admin", "hunter2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(BasicAuthUserEnv, tt.user)
+			t.Setenv(BasicAuthPassEnv, tt.pass)
+			if _, err := NewBasicAuth(); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestNewBasicAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(BasicAuthUserEnv, "admin")
+	t.Setenv(BasicAuthPassEnv, string(hash))
+
+	handler, err := NewBasicAuth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	app.Get("/", handler, func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	tests := []struct {
+		name       string
+		user       string
+		pass       string
+		setAuth    bool
+		wantStatus int
+	}{
+		{"valid credentials", "admin", "hunter2", true, fiber.StatusOK},
+		{"wrong password", "admin", "hunter3", true, fiber.StatusUnauthorized},
+		{"wrong user", "root", "hunter2", true, fiber.StatusUnauthorized},
+		{"no credentials", "", "", false, fiber.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.setAuth {
+				req.SetBasicAuth(tt.user, tt.pass)
+			}
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus == fiber.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") == "" {
+				t.Error("missing WWW-Authenticate header")
+			}
+		})
+	}
+}
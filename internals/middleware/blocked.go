@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// BlockedRequestKey labels requests blocked by the IP filter
+// Target is "api", "static" (assets and other files) or "page"; Method is the HTTP method
+// (or "OTHER" for non-standard ones) so the label set stays bounded
+type BlockedRequestKey struct {
+	Target string
+	Method string
+}
+
+// blockedRequests counts blocked requests per target and method
+var blockedRequests = struct {
+	sync.Mutex
+	counts map[BlockedRequestKey]uint64
+}{counts: make(map[BlockedRequestKey]uint64)}
+
+// knownMethods are the HTTP methods kept as-is in blocked request labels
+var knownMethods = []string{
+	fiber.MethodGet, fiber.MethodHead, fiber.MethodPost, fiber.MethodPut,
+	fiber.MethodPatch, fiber.MethodDelete, fiber.MethodOptions,
+}
+
+// requestTarget classifies a request path for blocked request logs and metrics
+func requestTarget(requestPath string) string {
+	switch {
+	case requestPath == "/api" || strings.HasPrefix(requestPath, "/api/"):
+		return "api"
+	case strings.HasPrefix(requestPath, "/assets/") || path.Ext(requestPath) != "":
+		return "static"
+	default:
+		return "page"
+	}
+}
+
+// recordBlockedRequest counts a blocked request and returns its labels
+func recordBlockedRequest(c *fiber.Ctx) BlockedRequestKey {
+	// c.Method() is only valid during the request, so known methods are stored as constants
+	key := BlockedRequestKey{Target: requestTarget(c.Path()), Method: "OTHER"}
+	for _, method := range knownMethods {
+		if c.Method() == method {
+			key.Method = method
+		}
+	}
+
+	blockedRequests.Lock()
+	defer blockedRequests.Unlock()
+	blockedRequests.counts[key]++
+	return key
+}
+
+// BlockedRequestCounts returns a snapshot of the blocked request counters
+func BlockedRequestCounts() map[BlockedRequestKey]uint64 {
+	blockedRequests.Lock()
+	defer blockedRequests.Unlock()
+
+	counts := make(map[BlockedRequestKey]uint64, len(blockedRequests.counts))
+	for key, count := range blockedRequests.counts {
+		counts[key] = count
+	}
+	return counts
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRequestTarget(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api", "api"},
+		{"/api/vms/win11/attach", "api"},
+		{"/apis", "page"},
+		{"/assets/bundle.js", "static"},
+		{"/wp-login.php", "static"},
+		{"/", "page"},
+		{"/admin", "page"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := requestTarget(tt.path); got != tt.want {
+				t.Errorf("requestTarget(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlockedRequestCounts(t *testing.T) {
+	allowed := mustParseNetworks(t, "127.0.0.0/8")
+
+	app := fiber.New(fiber.Config{ProxyHeader: "X-Real-IP"})
+	app.Use(IPFilterMiddleware(allowed, nil))
+	app.Use(func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	before := BlockedRequestCounts()
+	requests := []struct {
+		method, path string
+	}{
+		{"POST", "/api/vms/win11/attach"},
+		{"POST", "/api/vms/win11/attach"},
+		{"GET", "/"},
+		{"DELETE", "/"},
+	}
+	for _, r := range requests {
+		req := httptest.NewRequest(r.method, r.path, nil)
+		req.Header.Set("X-Real-IP", "10.0.0.1")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	after := BlockedRequestCounts()
+	want := map[BlockedRequestKey]uint64{
+		{Target: "api", Method: "POST"}:    2,
+		{Target: "page", Method: "GET"}:    1,
+		{Target: "page", Method: "DELETE"}: 1,
+	}
+	for key, count := range want {
+		if got := after[key] - before[key]; got != count {
+			t.Errorf("blocked %v = %d, want %d", key, got, count)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"strings"
+
+	"vfio_usb_passthrough/internals/i18n"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireJSON rejects requests with a body that is not application/json
+// Requests with an empty body are let through so handlers can report missing fields
+func RequireJSON(c *fiber.Ctx) error {
+	if len(c.Body()) == 0 {
+		return c.Next()
+	}
+
+	mediaType, _, _ := strings.Cut(c.Get(fiber.HeaderContentType), ";")
+	if !strings.EqualFold(strings.TrimSpace(mediaType), fiber.MIMEApplicationJSON) {
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{
+			"error": i18n.Msg(c, "unsupported_media_type"),
+		})
+	}
+
+	return c.Next()
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRequireJSON(t *testing.T) {
+	app := fiber.New()
+	app.Post("/", RequireJSON, func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+	}{
+		{"json", "application/json", `{}`, fiber.StatusOK},
+		{"json with charset", "application/json; charset=utf-8", `{}`, fiber.StatusOK},
+		{"uppercase", "Application/JSON", `{}`, fiber.StatusOK},
+		{"form", "application/x-www-form-urlencoded", "vendorId=046d", fiber.StatusUnsupportedMediaType},
+		{"missing content type", "", `{}`, fiber.StatusUnsupportedMediaType},
+		{"empty body", "text/plain", "", fiber.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
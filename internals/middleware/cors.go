@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+)
+
+// GetCORSOrigins returns the origins allowed to call the API cross-origin
+// Read from CORS_ORIGINS (comma-separated, e.g. "https://ui.example.com,http://localhost:5173")
+// Wildcards are rejected because credentials are allowed
+func GetCORSOrigins() []string {
+	var origins []string
+	for _, origin := range strings.Split(os.Getenv("CORS_ORIGINS"), ",") {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if origin == "" {
+			continue
+		}
+		if strings.Contains(origin, "*") {
+			log.Printf("Security: Ignoring wildcard CORS origin %q", origin)
+			continue
+		}
+		origins = append(origins, origin)
+	}
+	return origins
+}
+
+// NewCORS returns the CORS middleware for the API, or nil when no origins are configured
+// (same-origin only: browsers block cross-origin calls without CORS headers)
+func NewCORS() fiber.Handler {
+	origins := GetCORSOrigins()
+	if len(origins) == 0 {
+		return nil
+	}
+
+	log.Printf("Security: CORS enabled for origins: %s", strings.Join(origins, ", "))
+	return cors.New(cors.Config{
+		AllowOrigins:     strings.Join(origins, ","),
+		AllowMethods:     "GET,POST,PUT,PATCH,DELETE,OPTIONS",
+		AllowHeaders:     "Content-Type,Accept,Accept-Language,Authorization",
+		ExposeHeaders:    "X-RateLimit-Limit,X-RateLimit-Remaining,X-RateLimit-Reset,Retry-After",
+		AllowCredentials: true,
+		MaxAge:           600,
+	})
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestGetCORSOrigins(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string
+		want []string
+	}{
+		{"unset", "", nil},
+		{"single", "https://ui.example.com", []string{"https://ui.example.com"}},
+		{"list with spaces and trailing slash", " https://a.example.com/ , http://localhost:5173", []string{"https://a.example.com", "http://localhost:5173"}},
+		{"wildcard ignored", "*,https://a.example.com", []string{"https://a.example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CORS_ORIGINS", tt.env)
+			if got := GetCORSOrigins(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetCORSOrigins() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewCORS(t *testing.T) {
+	t.Setenv("CORS_ORIGINS", "")
+	if NewCORS() != nil {
+		t.Fatal("NewCORS() should be nil without CORS_ORIGINS")
+	}
+
+	t.Setenv("CORS_ORIGINS", "https://ui.example.com")
+	app := fiber.New()
+	app.Use(NewCORS())
+	app.Delete("/api/favorites", func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	tests := []struct {
+		name       string
+		origin     string
+		wantOrigin string
+	}{
+		{"allowed origin", "https://ui.example.com", "https://ui.example.com"},
+		{"other origin", "https://evil.example.com", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("OPTIONS", "/api/favorites", nil)
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("Access-Control-Request-Method", "DELETE")
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != fiber.StatusNoContent {
+				t.Errorf("status = %d, want %d", resp.StatusCode, fiber.StatusNoContent)
+			}
+			if got := resp.Header.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if tt.wantOrigin != "" && resp.Header.Get("Access-Control-Allow-Credentials") != "true" {
+				t.Error("Access-Control-Allow-Credentials should be true")
+			}
+		})
+	}
+}
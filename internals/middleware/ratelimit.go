@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"vfio_usb_passthrough/internals/i18n"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+	"github.com/gofiber/storage/redis/v3"
+)
+
+// NewRateLimitStorage returns the storage shared by rate limiters
+// When REDIS_URL is set (e.g. redis://localhost:6379/0) limits are shared through Redis
+// across instances; otherwise nil is returned and the limiter keeps counts in memory
+func NewRateLimitStorage() (storage fiber.Storage, err error) {
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		return nil, nil
+	}
+
+	// The redis storage panics on invalid URLs and unreachable servers
+	defer func() {
+		if r := recover(); r != nil {
+			storage, err = nil, fmt.Errorf("failed to connect to Redis: %v", r)
+		}
+	}()
+
+	storage = redis.New(redis.Config{URL: redisURL})
+	log.Printf("Security: Rate limits are stored in Redis")
+	return storage, nil
+}
+
+// NewRateLimiter returns a middleware allowing max requests per window and client IP (storage may be nil)
+// Allowed responses carry X-RateLimit-Limit/Remaining/Reset (set by the limiter); rejected ones get a
+// 429 with the same headers and Retry-After
+func NewRateLimiter(max int, window time.Duration, storage fiber.Storage) fiber.Handler {
+	return limiter.New(limiter.Config{
+		Max:        max,
+		Expiration: window,
+		Storage:    storage,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			return "ratelimit:api:" + c.IP()
+		},
+		LimitReached: func(c *fiber.Ctx) error {
+			log.Printf("Rate limit exceeded for IP: %s", c.IP())
+			// The limiter only sets Retry-After on rejection, add the rest so clients can back off
+			c.Set("X-RateLimit-Limit", strconv.Itoa(max))
+			c.Set("X-RateLimit-Remaining", "0")
+			c.Set("X-RateLimit-Reset", c.GetRespHeader(fiber.HeaderRetryAfter))
+
+			// Seconds until the window resets, for client countdowns
+			retryAfter, _ := strconv.Atoi(c.GetRespHeader(fiber.HeaderRetryAfter))
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error":      i18n.Msg(c, "rate_limit_exceeded"),
+				"retryAfter": retryAfter,
+			})
+		},
+	})
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestNewRateLimiter(t *testing.T) {
+	app := fiber.New()
+	app.Use(NewRateLimiter(2, time.Minute, nil))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	for i := 0; i < 2; i++ {
+		resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200", i+1, resp.StatusCode)
+		}
+		if got := resp.Header.Get("X-RateLimit-Limit"); got != "2" {
+			t.Errorf("request %d: X-RateLimit-Limit = %q, want 2", i+1, got)
+		}
+		if got := resp.Header.Get("X-RateLimit-Remaining"); got != strconv.Itoa(1-i) {
+			t.Errorf("request %d: X-RateLimit-Remaining = %q, want %d", i+1, got, 1-i)
+		}
+	}
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", resp.StatusCode)
+	}
+	if got := resp.Header.Get("X-RateLimit-Limit"); got != "2" {
+		t.Errorf("X-RateLimit-Limit = %q, want 2", got)
+	}
+	if got := resp.Header.Get("X-RateLimit-Remaining"); got != "0" {
+		t.Errorf("X-RateLimit-Remaining = %q, want 0", got)
+	}
+	retryAfter, err := strconv.Atoi(resp.Header.Get(fiber.HeaderRetryAfter))
+	if err != nil || retryAfter <= 0 || retryAfter > 60 {
+		t.Errorf("Retry-After = %q, want 1 to 60 seconds", resp.Header.Get(fiber.HeaderRetryAfter))
+	}
+	if got := resp.Header.Get("X-RateLimit-Reset"); got != strconv.Itoa(retryAfter) {
+		t.Errorf("X-RateLimit-Reset = %q, want the Retry-After value %d", got, retryAfter)
+	}
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"runtime/debug"
+
+	"vfio_usb_passthrough/internals/i18n"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+)
+
+// requestIDKey is the Locals key holding the request ID
+const requestIDKey = "requestid"
+
+// RequestID returns a middleware giving each request an ID, returned in the X-Request-ID header
+// A client may pass its own ID in that header to correlate its logs with ours
+func RequestID() fiber.Handler {
+	return requestid.New(requestid.Config{ContextKey: requestIDKey})
+}
+
+// requestIDFrom returns the ID of a request, or "" if the request ID middleware did not run
+func requestIDFrom(c *fiber.Ctx) string {
+	id, _ := c.Locals(requestIDKey).(string)
+	return id
+}
+
+// Recover returns a middleware that turns a panic in a handler into an error (answered by ErrorHandler)
+// instead of crashing the server, logging the panic with its stack trace
+func Recover() fiber.Handler {
+	return recover.New(recover.Config{
+		EnableStackTrace:  true,
+		StackTraceHandler: logPanic,
+	})
+}
+
+// logPanic logs a recovered panic with the request it happened in and the stack trace
+func logPanic(c *fiber.Ctx, e interface{}) {
+	log.Printf("PANIC: %s %s (request %s): %v\n%s", c.Method(), c.Path(), requestIDFrom(c), e, debug.Stack())
+}
+
+// ErrorHandler answers the errors returned by handlers and middleware
+// A *fiber.Error (e.g. 404 for an unknown route) is answered like Fiber does by default; any other error,
+// including a recovered panic, becomes a JSON 500 with the request ID to look it up in the logs
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return fiber.DefaultErrorHandler(c, err)
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error":     i18n.Msg(c, "internal_error"),
+		"requestId": requestIDFrom(c),
+	})
+}
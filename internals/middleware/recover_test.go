@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/logger"
+)
+
+func TestRecoverJSON(t *testing.T) {
+	t.Setenv(LogFormatEnv, "json")
+	cfg, err := AccessLogConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	cfg.Output = &out
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	app.Use(RequestID())
+	app.Use(logger.New(cfg))
+	app.Use(Recover())
+	app.Get("/panic", func(c *fiber.Ctx) error {
+		var devices map[string]int
+		devices["046d:c077"]++
+		return nil
+	})
+
+	req := httptest.NewRequest("GET", "/panic", nil)
+	req.Header.Set(fiber.HeaderXRequestID, "req-42")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", resp.StatusCode)
+	}
+	var body struct {
+		Error     string `json:"error"`
+		RequestID string `json:"requestId"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("body is not JSON: %v", err)
+	}
+	if body.Error == "" || body.RequestID != "req-42" {
+		t.Errorf("body = %+v, want an error and request req-42", body)
+	}
+
+	var entry struct {
+		Status    int    `json:"status"`
+		RequestID string `json:"request_id"`
+		Error     string `json:"error"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("log line %q is not JSON: %v", out.String(), err)
+	}
+	if entry.Status != fiber.StatusInternalServerError || entry.RequestID != "req-42" || !strings.Contains(entry.Error, "nil map") {
+		t.Errorf("log entry = %+v, want the panic logged as a 500 of request req-42", entry)
+	}
+
+	// The server is still up, and Fiber errors keep their status
+	resp, err = app.Test(httptest.NewRequest("GET", "/missing", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("unknown route status = %d, want 404", resp.StatusCode)
+	}
+}
@@ -2,14 +2,27 @@ package middleware
 
 import (
 	"bufio"
+	"context"
 	"encoding/hex"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"os"
 	"os/exec"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"vfio_usb_passthrough/internals/i18n"
+	"vfio_usb_passthrough/internals/utils"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -17,18 +30,46 @@ import (
 // DefaultBindPort is the default port to bind to
 const DefaultBindPort = "9876"
 
+// NetworkRefreshIntervalEnv configures periodic re-detection of allowed networks (disabled when unset)
+const NetworkRefreshIntervalEnv = "NETWORK_REFRESH_INTERVAL"
+
+// networkDetectionTimeout bounds how long subnet auto-detection may take
+const networkDetectionTimeout = 10 * time.Second
+
+// ErrNetworkDetection is returned when subnet auto-detection timed out or a virsh call failed,
+// along with the subnets that could be detected
+var ErrNetworkDetection = errors.New("network detection incomplete")
+
+// DefaultExemptPaths are the path prefixes that bypass the IP filter by default: none, since /metrics
+// reveals the inventory and runs virsh; a monitoring system on another subnet needs IP_FILTER_EXEMPT_PATHS=/metrics
+const DefaultExemptPaths = ""
+
+// routeTablePath is the kernel IPv4 routing table (overridable for tests)
+var routeTablePath = "/proc/net/route"
+
+// interfaceAddrs returns the addresses of a network interface (overridable for tests)
+var interfaceAddrs = func(ifaceName string) ([]net.Addr, error) {
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return nil, err
+	}
+	return iface.Addrs()
+}
+
 // getDefaultRouteInterfaces reads /proc/net/route to find interfaces with default routes (0.0.0.0)
-// Returns a list of interface names that have a default route
+// Returns a list of interface names that have a default route, ordered by their lowest
+// default-route metric (preferred route first), then by name
+// If the routing table cannot be read, falls back to getFallbackRouteInterfaces
 func getDefaultRouteInterfaces() []string {
-	file, err := os.Open("/proc/net/route")
+	file, err := os.Open(routeTablePath)
 	if err != nil {
 		log.Printf("Security: Warning - could not read routing table: %v", err)
-		return nil
+		return getFallbackRouteInterfaces()
 	}
 	defer file.Close()
 
 	var interfaces []string
-	seen := make(map[string]bool)
+	metrics := make(map[string]int)
 	scanner := bufio.NewScanner(file)
 
 	// Skip header line
@@ -44,30 +85,93 @@ func getDefaultRouteInterfaces() []string {
 		destination := fields[1]
 
 		// Check if this is a default route (destination = 00000000)
-		if destination == "00000000" {
-			if !seen[ifaceName] {
-				seen[ifaceName] = true
-				interfaces = append(interfaces, ifaceName)
-				log.Printf("Security: Found default route on interface %s", ifaceName)
-			}
+		if destination != "00000000" {
+			continue
+		}
+
+		metric := 0
+		if len(fields) > 6 {
+			metric, _ = strconv.Atoi(fields[6])
+		}
+
+		if current, seen := metrics[ifaceName]; !seen {
+			metrics[ifaceName] = metric
+			interfaces = append(interfaces, ifaceName)
+			log.Printf("Security: Found default route on interface %s (metric %d)", ifaceName, metric)
+		} else if metric < current {
+			metrics[ifaceName] = metric
 		}
 	}
 
+	sort.Slice(interfaces, func(i, j int) bool {
+		if metrics[interfaces[i]] != metrics[interfaces[j]] {
+			return metrics[interfaces[i]] < metrics[interfaces[j]]
+		}
+		return interfaces[i] < interfaces[j]
+	})
+
 	return interfaces
 }
 
-// getInterfaceSubnet returns the CIDR subnet for a network interface
-func getInterfaceSubnet(ifaceName string) (string, error) {
-	iface, err := net.InterfaceByName(ifaceName)
+// upInterfaces returns the names of the non-loopback network interfaces that are up,
+// in kernel order (overridable for tests)
+var upInterfaces = func() ([]string, error) {
+	ifaces, err := net.Interfaces()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	addrs, err := iface.Addrs()
+	var names []string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagLoopback == 0 {
+			names = append(names, iface.Name)
+		}
+	}
+	return names, nil
+}
+
+// getFallbackRouteInterfaces guesses the default-route interface when the routing table cannot be read
+// (e.g. in some containers): the first interface that is up with a non-loopback, non-link-local IPv4 address
+func getFallbackRouteInterfaces() []string {
+	names, err := upInterfaces()
 	if err != nil {
-		return "", err
+		log.Printf("Security: Warning - could not list network interfaces: %v", err)
+		return nil
 	}
 
+	for _, ifaceName := range names {
+		addrs, err := interfaceAddrs(ifaceName)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip := ipNet.IP.To4()
+			if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+				continue
+			}
+			log.Printf("Security: Falling back to interface %s (%s) as the default-route interface", ifaceName, ip)
+			return []string{ifaceName}
+		}
+	}
+
+	log.Printf("Security: Warning - no interface with a usable IPv4 address found for the fallback")
+	return nil
+}
+
+// getInterfaceSubnets returns all IPv4 CIDR subnets of a network interface
+// Subnets are returned in the order the kernel reports the addresses, without duplicates
+func getInterfaceSubnets(ifaceName string) ([]string, error) {
+	addrs, err := interfaceAddrs(ifaceName)
+	if err != nil {
+		return nil, err
+	}
+
+	var subnets []string
+	seen := make(map[string]bool)
 	for _, addr := range addrs {
 		ipNet, ok := addr.(*net.IPNet)
 		if !ok {
@@ -85,10 +189,41 @@ func getInterfaceSubnet(ifaceName string) (string, error) {
 			IP:   ip.Mask(ipNet.Mask),
 			Mask: ipNet.Mask,
 		}
-		return network.String(), nil
+		if !seen[network.String()] {
+			seen[network.String()] = true
+			subnets = append(subnets, network.String())
+		}
 	}
 
-	return "", fmt.Errorf("no IPv4 address found for interface %s", ifaceName)
+	if len(subnets) == 0 {
+		return nil, fmt.Errorf("no IPv4 address found for interface %s", ifaceName)
+	}
+	return subnets, nil
+}
+
+// getDefaultRouteSubnets returns the IPv4 subnets of all default-route interfaces,
+// following the interface order of getDefaultRouteInterfaces
+func getDefaultRouteSubnets() []string {
+	var subnets []string
+	seen := make(map[string]bool)
+
+	for _, ifaceName := range getDefaultRouteInterfaces() {
+		ifaceSubnets, err := getInterfaceSubnets(ifaceName)
+		if err != nil {
+			log.Printf("Security: Warning - could not get subnets for interface %s: %v", ifaceName, err)
+			continue
+		}
+
+		for _, subnet := range ifaceSubnets {
+			if !seen[subnet] {
+				seen[subnet] = true
+				subnets = append(subnets, subnet)
+				log.Printf("Security: Auto-allowing subnet %s from default-route interface %s", subnet, ifaceName)
+			}
+		}
+	}
+
+	return subnets
 }
 
 // virshNetworkIP represents the IP configuration in a virsh network XML
@@ -118,88 +253,144 @@ func netmaskToCIDR(netmask string) (int, error) {
 	}
 
 	mask := net.IPMask(ip4)
-	ones, _ := mask.Size()
+	ones, bits := mask.Size()
+	if bits == 0 {
+		// Size returns 0, 0 for non-contiguous masks, which would otherwise read as /0
+		return 0, fmt.Errorf("non-contiguous netmask: %s", netmask)
+	}
 	return ones, nil
 }
 
 // getVirshNetworkSubnets queries libvirt for active networks and returns their subnets
-func getVirshNetworkSubnets() []string {
+// Network XML dumps are fetched in parallel; all virsh calls are bound to ctx
+func getVirshNetworkSubnets(ctx context.Context) ([]string, error) {
 	var subnets []string
 
 	// Get list of active networks
-	cmd := exec.Command("virsh", "net-list", "--name")
+	cmd := exec.CommandContext(ctx, utils.VirshBin(), "net-list", "--name")
 	cmd.Env = append(os.Environ(), "LIBVIRT_DEFAULT_URI=qemu:///system")
 	output, err := cmd.Output()
 	if err != nil {
 		log.Printf("Security: Warning - could not list virsh networks: %v", err)
-		return subnets
+		return subnets, fmt.Errorf("%w: could not list virsh networks: %v", ErrNetworkDetection, err)
 	}
 
+	var netNames []string
 	scanner := bufio.NewScanner(strings.NewReader(string(output)))
 	for scanner.Scan() {
 		netName := strings.TrimSpace(scanner.Text())
-		if netName == "" {
-			continue
+		if netName != "" {
+			netNames = append(netNames, netName)
 		}
+	}
 
-		// Get network XML
-		xmlCmd := exec.Command("virsh", "net-dumpxml", netName)
-		xmlCmd.Env = append(os.Environ(), "LIBVIRT_DEFAULT_URI=qemu:///system")
-		xmlOutput, err := xmlCmd.Output()
-		if err != nil {
-			log.Printf("Security: Warning - could not get XML for virsh network %s: %v", netName, err)
+	// Fetch network XML in parallel, keeping results in net-list order
+	results := make([][]string, len(netNames))
+	errs := make([]error, len(netNames))
+	var wg sync.WaitGroup
+	for i, netName := range netNames {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = getVirshNetworkSubnet(ctx, netName)
+		}()
+	}
+	wg.Wait()
+
+	for _, result := range results {
+		subnets = append(subnets, result...)
+	}
+	return subnets, errors.Join(errs...)
+}
+
+// getVirshNetworkSubnet returns the IPv4 subnets of a single virsh network
+func getVirshNetworkSubnet(ctx context.Context, netName string) ([]string, error) {
+	var subnets []string
+
+	// Get network XML
+	xmlCmd := exec.CommandContext(ctx, utils.VirshBin(), "net-dumpxml", netName)
+	xmlCmd.Env = append(os.Environ(), "LIBVIRT_DEFAULT_URI=qemu:///system")
+	xmlOutput, err := xmlCmd.Output()
+	if err != nil {
+		log.Printf("Security: Warning - could not get XML for virsh network %s: %v", netName, err)
+		return subnets, fmt.Errorf("%w: could not get XML for virsh network %s: %v", ErrNetworkDetection, netName, err)
+	}
+
+	// Parse the XML
+	var network virshNetwork
+	if err := xml.Unmarshal(xmlOutput, &network); err != nil {
+		log.Printf("Security: Warning - could not parse XML for virsh network %s: %v", netName, err)
+		return subnets, fmt.Errorf("%w: could not parse XML for virsh network %s: %v", ErrNetworkDetection, netName, err)
+	}
+
+	// Extract subnets from IP configurations
+	for _, ipConfig := range network.IPs {
+		if ipConfig.Address == "" {
 			continue
 		}
 
-		// Parse the XML
-		var network virshNetwork
-		if err := xml.Unmarshal(xmlOutput, &network); err != nil {
-			log.Printf("Security: Warning - could not parse XML for virsh network %s: %v", netName, err)
+		ip := net.ParseIP(ipConfig.Address)
+		if ip == nil || ip.To4() == nil {
 			continue
 		}
 
-		// Extract subnets from IP configurations
-		for _, ipConfig := range network.IPs {
-			if ipConfig.Address == "" {
+		var cidrPrefix int
+		if ipConfig.Prefix != "" {
+			fmt.Sscanf(ipConfig.Prefix, "%d", &cidrPrefix)
+		} else if ipConfig.Netmask != "" {
+			cidrPrefix, err = netmaskToCIDR(ipConfig.Netmask)
+			if err != nil {
+				log.Printf("Security: Warning - invalid netmask for virsh network %s: %v", netName, err)
 				continue
 			}
-
-			ip := net.ParseIP(ipConfig.Address)
-			if ip == nil || ip.To4() == nil {
-				continue
-			}
-
-			var cidrPrefix int
-			if ipConfig.Prefix != "" {
-				fmt.Sscanf(ipConfig.Prefix, "%d", &cidrPrefix)
-			} else if ipConfig.Netmask != "" {
-				cidrPrefix, err = netmaskToCIDR(ipConfig.Netmask)
-				if err != nil {
-					log.Printf("Security: Warning - invalid netmask for virsh network %s: %v", netName, err)
-					continue
-				}
-			} else {
-				// Default to /24 if no mask specified
-				cidrPrefix = 24
-			}
-
-			// Calculate network address
-			mask := net.CIDRMask(cidrPrefix, 32)
-			networkIP := ip.To4().Mask(mask)
-			subnet := fmt.Sprintf("%s/%d", networkIP.String(), cidrPrefix)
-			subnets = append(subnets, subnet)
-			log.Printf("Security: Auto-allowing subnet %s from virsh network %s", subnet, netName)
+		} else {
+			// Default to /24 if no mask specified
+			cidrPrefix = 24
 		}
+
+		// Calculate network address
+		mask := net.CIDRMask(cidrPrefix, 32)
+		networkIP := ip.To4().Mask(mask)
+		subnet := fmt.Sprintf("%s/%d", networkIP.String(), cidrPrefix)
+		subnets = append(subnets, subnet)
+		log.Printf("Security: Auto-allowing subnet %s from virsh network %s", subnet, netName)
 	}
 
-	return subnets
+	return subnets, nil
 }
 
 // getAutoDetectedSubnets finds all subnets that should be allowed by default:
 // 1. Localhost (127.0.0.0/8)
 // 2. Subnets from interfaces with default routes (local network)
 // 3. Subnets from libvirt/virsh networks (VM networks)
-func getAutoDetectedSubnets() []string {
+// Detection is bounded by networkDetectionTimeout so a hanging libvirt cannot block startup.
+// On timeout, or if a virsh call fails, an ErrNetworkDetection is returned with the subnets found
+// (only localhost on timeout)
+func getAutoDetectedSubnets() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), networkDetectionTimeout)
+	defer cancel()
+
+	type detection struct {
+		subnets []string
+		err     error
+	}
+	done := make(chan detection, 1)
+	go func() {
+		subnets, err := detectSubnets(ctx)
+		done <- detection{subnets, err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.subnets, result.err
+	case <-ctx.Done():
+		log.Printf("Security: Warning - network detection timed out after %s", networkDetectionTimeout)
+		return []string{"127.0.0.0/8"}, fmt.Errorf("%w: timed out after %s", ErrNetworkDetection, networkDetectionTimeout)
+	}
+}
+
+// detectSubnets performs the actual subnet auto-detection for getAutoDetectedSubnets
+func detectSubnets(ctx context.Context) ([]string, error) {
 	var subnets []string
 	seen := make(map[string]bool)
 
@@ -207,24 +398,16 @@ func getAutoDetectedSubnets() []string {
 	subnets = append(subnets, "127.0.0.0/8")
 	seen["127.0.0.0/8"] = true
 
-	// Get interfaces with default routes
-	defaultRouteIfaces := getDefaultRouteInterfaces()
-	for _, ifaceName := range defaultRouteIfaces {
-		subnet, err := getInterfaceSubnet(ifaceName)
-		if err != nil {
-			log.Printf("Security: Warning - could not get subnet for interface %s: %v", ifaceName, err)
-			continue
-		}
-
+	// Get subnets of interfaces with default routes
+	for _, subnet := range getDefaultRouteSubnets() {
 		if !seen[subnet] {
 			seen[subnet] = true
 			subnets = append(subnets, subnet)
-			log.Printf("Security: Auto-allowing subnet %s from default-route interface %s", subnet, ifaceName)
 		}
 	}
 
 	// Get virsh network subnets
-	virshSubnets := getVirshNetworkSubnets()
+	virshSubnets, err := getVirshNetworkSubnets(ctx)
 	for _, subnet := range virshSubnets {
 		if !seen[subnet] {
 			seen[subnet] = true
@@ -236,7 +419,7 @@ func getAutoDetectedSubnets() []string {
 		log.Printf("Security: No default route interfaces or virsh networks found, only localhost will be allowed")
 	}
 
-	return subnets
+	return subnets, err
 }
 
 // parseHexIP converts a hex-encoded IP from /proc/net/route to net.IP
@@ -324,52 +507,169 @@ func ListAvailableInterfaces() []string {
 	return names
 }
 
+// AllowedNetworksFileEnv points to a file of allowed networks, one CIDR per line ('#' starts a comment)
+const AllowedNetworksFileEnv = "ALLOWED_NETWORKS_FILE"
+
 // GetAllowedNetworks returns the allowed networks
-// If ALLOWED_NETWORKS env var is set, use that
+// If ALLOWED_NETWORKS and/or ALLOWED_NETWORKS_FILE are set, their networks are combined
 // Otherwise, auto-detect subnets from:
 // - Interfaces with default routes (local network)
 // - Libvirt/virsh networks (VM networks)
 // This ensures only local and VM network traffic is allowed, blocking internet-originated requests
-func GetAllowedNetworks() string {
+// If auto-detection is incomplete, the subnets found are returned with an ErrNetworkDetection
+func GetAllowedNetworks() (string, error) {
 	allowedNetworks := os.Getenv("ALLOWED_NETWORKS")
-	if allowedNetworks != "" {
-		return allowedNetworks
+	networksFile := os.Getenv(AllowedNetworksFileEnv)
+	if allowedNetworks == "" && networksFile == "" {
+		// Auto-detect subnets
+		subnets, err := getAutoDetectedSubnets()
+		return strings.Join(subnets, ","), err
+	}
+
+	if networksFile != "" {
+		fromFile, err := readNetworksFile(networksFile)
+		if err != nil {
+			return "", err
+		}
+		if allowedNetworks != "" && fromFile != "" {
+			allowedNetworks += ","
+		}
+		allowedNetworks += fromFile
 	}
+	return allowedNetworks, nil
+}
 
-	// Auto-detect subnets
-	subnets := getAutoDetectedSubnets()
-	return strings.Join(subnets, ",")
+// readNetworksFile reads a file of networks (one CIDR per line, blank lines and '#' comments ignored)
+// and returns them comma-separated
+func readNetworksFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", AllowedNetworksFileEnv, err)
+	}
+
+	var networks []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		if line = strings.TrimSpace(line); line != "" {
+			networks = append(networks, line)
+		}
+	}
+	return strings.Join(networks, ","), nil
 }
 
-// ParseCIDRs parses a comma-separated list of CIDR strings into net.IPNet slices
-func ParseCIDRs(cidrList string) ([]*net.IPNet, error) {
-	var networks []*net.IPNet
-	cidrs := strings.Split(cidrList, ",")
+// hostnamePattern matches DNS hostnames (labels of letters, digits and inner hyphens)
+var hostnamePattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$`)
+
+// numericEntryPattern matches entries made only of digits and dots, which are mistyped IPv4
+// addresses (e.g. 192.168.1.300 or 10.0.0) rather than hostnames, since no top-level domain is numeric
+var numericEntryPattern = regexp.MustCompile(`^[0-9.]+$`)
+
+// hostnameResolveTimeout bounds resolving one allowlist hostname
+const hostnameResolveTimeout = 5 * time.Second
+
+// hostnameRefreshInterval is how often hostnames are re-resolved when no refresh interval is set
+const hostnameRefreshInterval = 5 * time.Minute
 
-	for _, cidr := range cidrs {
-		cidr = strings.TrimSpace(cidr)
-		if cidr == "" {
+// lookupIP resolves a hostname (overridable in tests)
+var lookupIP = func(ctx context.Context, host string) ([]net.IP, error) {
+	return net.DefaultResolver.LookupIP(ctx, "ip", host)
+}
+
+// ParseNetworks parses a comma-separated allowlist of CIDRs, bare IPs and hostnames
+// Bare IPs become /32 (IPv4) or /128 (IPv6) networks, and hostnames are resolved to one such
+// network per address. Trusting a hostname means trusting DNS: whoever controls the name's
+// records (or can spoof DNS responses) controls access. Unresolvable hostnames are skipped with
+// a warning so a DNS outage does not fail the whole list. hasHostnames reports whether any
+// entry was a hostname, so callers know the list needs periodic re-resolution
+func ParseNetworks(list string) (networks []*net.IPNet, hasHostnames bool, err error) {
+	for i, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
 			continue
+		case strings.Contains(entry, "/"):
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid network %q at position %d (expected CIDR like 192.168.1.0/24): %w", entry, i+1, err)
+			}
+			networks = append(networks, network)
+		case net.ParseIP(entry) != nil:
+			networks = append(networks, singleIPNet(net.ParseIP(entry)))
+		case numericEntryPattern.MatchString(entry):
+			return nil, false, fmt.Errorf("invalid IP address %q at position %d", entry, i+1)
+		case hostnamePattern.MatchString(entry):
+			hasHostnames = true
+			networks = append(networks, resolveHostNetworks(entry)...)
+		default:
+			return nil, false, fmt.Errorf("invalid network %q at position %d (expected CIDR, IP address or hostname)", entry, i+1)
 		}
+	}
+	return networks, hasHostnames, nil
+}
 
-		_, network, err := net.ParseCIDR(cidr)
-		if err != nil {
-			return nil, err
-		}
-		networks = append(networks, network)
+// singleIPNet returns the network containing only ip
+func singleIPNet(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
 	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// resolveHostNetworks resolves an allowlist hostname to single-address networks
+func resolveHostNetworks(host string) []*net.IPNet {
+	ctx, cancel := context.WithTimeout(context.Background(), hostnameResolveTimeout)
+	defer cancel()
+
+	ips, err := lookupIP(ctx, host)
+	if err != nil {
+		log.Printf("Security: Warning - could not resolve allowlist host %s, skipping it: %v", host, err)
+		return nil
+	}
+
+	networks := make([]*net.IPNet, 0, len(ips))
+	for _, ip := range ips {
+		networks = append(networks, singleIPNet(ip))
+	}
+	return networks
+}
 
-	return networks, nil
+// formatNetworks returns networks as a comma-separated list of normalized CIDRs
+func formatNetworks(networks []*net.IPNet) string {
+	if len(networks) == 0 {
+		return "(none)"
+	}
+	cidrs := make([]string, len(networks))
+	for i, network := range networks {
+		cidrs[i] = network.String()
+	}
+	return strings.Join(cidrs, ",")
 }
 
 // isIPAllowed checks if an IP address is within the allowed networks
 func isIPAllowed(ip net.IP, allowedNetworks []*net.IPNet) bool {
+	return matchingNetwork(ip, allowedNetworks) != nil
+}
+
+// matchingNetwork returns the first allowed network containing ip, or nil if none does
+func matchingNetwork(ip net.IP, allowedNetworks []*net.IPNet) *net.IPNet {
 	for _, network := range allowedNetworks {
 		if network.Contains(ip) {
-			return true
+			return network
 		}
 	}
-	return false
+	return nil
+}
+
+// coveringNetwork returns the first allowed network containing every address of network, or nil if none does
+func coveringNetwork(network *net.IPNet, allowedNetworks []*net.IPNet) *net.IPNet {
+	ones, bits := network.Mask.Size()
+	for _, allowed := range allowedNetworks {
+		allowedOnes, allowedBits := allowed.Mask.Size()
+		if allowedBits == bits && allowedOnes <= ones && allowed.Contains(network.IP) {
+			return allowed
+		}
+	}
+	return nil
 }
 
 // extractIP extracts the IP address from a remote address string (ip:port or just ip)
@@ -381,9 +681,189 @@ func extractIP(remoteAddr string) net.IP {
 	return net.ParseIP(host)
 }
 
-// IPFilterMiddleware returns a Fiber middleware that filters requests by client IP
-func IPFilterMiddleware(allowedNetworks []*net.IPNet) fiber.Handler {
+// GetExemptPaths returns the path prefixes exempt from the IP filter
+// If IP_FILTER_EXEMPT_PATHS env var is set, use that (empty value disables exemptions)
+// Otherwise, use DefaultExemptPaths
+func GetExemptPaths() []string {
+	exemptPaths, ok := os.LookupEnv("IP_FILTER_EXEMPT_PATHS")
+	if !ok {
+		exemptPaths = DefaultExemptPaths
+	}
+
+	var paths []string
+	for _, path := range strings.Split(exemptPaths, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		paths = append(paths, "/"+strings.Trim(path, "/"))
+	}
+	return paths
+}
+
+// isPathExempt checks if a request path matches one of the exempt path prefixes
+// A prefix matches the path itself or anything below it (e.g. /metrics and /metrics/foo, not /metricsfoo)
+func isPathExempt(path string, exemptPaths []string) bool {
+	for _, prefix := range exemptPaths {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// IPFilter holds the allowed networks behind an atomically swappable pointer
+// so they can be reloaded at runtime without restarting the server
+type IPFilter struct {
+	networks    atomic.Pointer[[]*net.IPNet]
+	exemptPaths []string
+
+	// hasHostnames is set when the allowlist names hosts that must be re-resolved
+	hasHostnames atomic.Bool
+
+	// reloadMu serializes reloads so change logging compares against the right list
+	reloadMu sync.Mutex
+}
+
+// Networks returns the currently allowed networks
+func (f *IPFilter) Networks() []*net.IPNet {
+	return *f.networks.Load()
+}
+
+// Reload recomputes the allowed networks from the environment (or auto-detection)
+// On error the previous allowlist is kept, including when auto-detection timed out or a virsh call
+// failed: a slow libvirt must not lock LAN clients out. Without a previous allowlist (at startup),
+// the subnets that could be detected are used.
+func (f *IPFilter) Reload() error {
+	f.reloadMu.Lock()
+	defer f.reloadMu.Unlock()
+
+	allowedNetworksStr, err := GetAllowedNetworks()
+	if errors.Is(err, ErrNetworkDetection) && f.networks.Load() == nil {
+		log.Printf("Security: Warning - %v, allowing the networks detected so far", err)
+	} else if err != nil {
+		return err
+	}
+	allowedNetworks, hasHostnames, err := ParseNetworks(allowedNetworksStr)
+	if err != nil {
+		return err
+	}
+	f.hasHostnames.Store(hasHostnames)
+
+	previous := f.networks.Swap(&allowedNetworks)
+	if previous == nil {
+		log.Printf("Security: IP filter effective allowlist (%d network(s)): %s", len(allowedNetworks), formatNetworks(allowedNetworks))
+		return nil
+	}
+
+	added, removed := diffNetworks(*previous, allowedNetworks)
+	if len(added) > 0 {
+		log.Printf("Security: Allowed networks added: %s", strings.Join(added, ","))
+	}
+	if len(removed) > 0 {
+		log.Printf("Security: Allowed networks removed: %s", strings.Join(removed, ","))
+	}
+	return nil
+}
+
+// diffNetworks returns the networks present only in next (added) and only in previous (removed)
+func diffNetworks(previous, next []*net.IPNet) (added, removed []string) {
+	previousSet := make(map[string]bool)
+	for _, network := range previous {
+		previousSet[network.String()] = true
+	}
+
+	nextSet := make(map[string]bool)
+	for _, network := range next {
+		nextSet[network.String()] = true
+		if !previousSet[network.String()] {
+			added = append(added, network.String())
+		}
+	}
+
+	for _, network := range previous {
+		if !nextSet[network.String()] {
+			removed = append(removed, network.String())
+		}
+	}
+	return added, removed
+}
+
+// GetNetworkRefreshInterval returns the interval between automatic reloads of the allowed networks
+// NETWORK_REFRESH_INTERVAL accepts a duration (e.g. "5m") or a plain number of minutes
+// Returns 0 when unset, meaning periodic refresh is disabled
+func GetNetworkRefreshInterval() (time.Duration, error) {
+	return utils.GetIntervalEnv(NetworkRefreshIntervalEnv)
+}
+
+// StartAutoRefresh reloads the allowed networks every interval in the background
+// Does nothing if interval is 0, unless the allowlist contains hostnames (re-resolved every 5 minutes)
+func (f *IPFilter) StartAutoRefresh(interval time.Duration) {
+	// Hostnames in the allowlist are re-resolved even without a configured interval (DHCP addresses change)
+	if interval <= 0 && f.hasHostnames.Load() {
+		interval = hostnameRefreshInterval
+	}
+	if interval <= 0 {
+		return
+	}
+
+	log.Printf("Security: Refreshing allowed networks every %s", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := f.Reload(); err != nil {
+				log.Printf("Security: Warning - failed to refresh allowed networks, keeping previous list: %v", err)
+			}
+		}
+	}()
+}
+
+// ReloadOnSIGHUP reloads the allowed networks whenever the process receives SIGHUP
+func (f *IPFilter) ReloadOnSIGHUP() {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	go func() {
+		for range sighup {
+			log.Printf("Security: Received SIGHUP, reloading allowed networks")
+			if err := f.Reload(); err != nil {
+				log.Printf("Security: Warning - failed to reload allowed networks, keeping previous list: %v", err)
+			}
+		}
+	}()
+}
+
+// ExemptPaths returns the paths that are not filtered
+func (f *IPFilter) ExemptPaths() []string {
+	return f.exemptPaths
+}
+
+// Allows reports whether a client IP is in the allowed networks (for listeners other than the API)
+func (f *IPFilter) Allows(ip net.IP) bool {
+	return isIPAllowed(ip, f.Networks())
+}
+
+// MatchIP returns the allowed network that lets a client IP through, or nil if it would be blocked
+func (f *IPFilter) MatchIP(ip net.IP) *net.IPNet {
+	return matchingNetwork(ip, f.Networks())
+}
+
+// MatchNetwork returns the allowed network that lets every address of a network through,
+// or nil if some of them would be blocked
+func (f *IPFilter) MatchNetwork(network *net.IPNet) *net.IPNet {
+	return coveringNetwork(network, f.Networks())
+}
+
+// Handler returns a Fiber middleware that filters requests by client IP
+// Requests to exempt paths (e.g. health checks, metrics) are not filtered
+func (f *IPFilter) Handler() fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		if isPathExempt(c.Path(), f.exemptPaths) {
+			return c.Next()
+		}
+
 		clientIP := c.IP()
 
 		ip := net.ParseIP(clientIP)
@@ -392,16 +872,18 @@ func IPFilterMiddleware(allowedNetworks []*net.IPNet) fiber.Handler {
 		}
 
 		if ip == nil {
-			log.Printf("Security: Could not parse client IP: %s", clientIP)
+			key := recordBlockedRequest(c)
+			log.Printf("Security: Could not parse client IP: %s (%s %s, %s)", clientIP, c.Method(), c.Path(), key.Target)
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-				"error": "Access denied: invalid client address",
+				"error": i18n.Msg(c, "access_denied_invalid_address"),
 			})
 		}
 
-		if !isIPAllowed(ip, allowedNetworks) {
-			log.Printf("Security: Blocked request from unauthorized IP: %s", ip.String())
+		if !isIPAllowed(ip, f.Networks()) {
+			key := recordBlockedRequest(c)
+			log.Printf("Security: Blocked request from unauthorized IP: %s (%s %s, %s)", ip.String(), c.Method(), c.Path(), key.Target)
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-				"error": "Access denied: your IP is not in the allowed networks",
+				"error": i18n.Msg(c, "access_denied_not_allowed"),
 			})
 		}
 
@@ -409,14 +891,23 @@ func IPFilterMiddleware(allowedNetworks []*net.IPNet) fiber.Handler {
 	}
 }
 
-// NewIPFilterMiddleware creates a new IP filter middleware using environment configuration
-func NewIPFilterMiddleware() (fiber.Handler, error) {
-	allowedNetworksStr := GetAllowedNetworks()
-	allowedNetworks, err := ParseCIDRs(allowedNetworksStr)
-	if err != nil {
+// IPFilterMiddleware returns a Fiber middleware that filters requests by client IP
+// against a fixed list of allowed networks
+func IPFilterMiddleware(allowedNetworks []*net.IPNet, exemptPaths []string) fiber.Handler {
+	f := &IPFilter{exemptPaths: exemptPaths}
+	f.networks.Store(&allowedNetworks)
+	return f.Handler()
+}
+
+// NewIPFilter creates a new reloadable IP filter using environment configuration
+func NewIPFilter() (*IPFilter, error) {
+	f := &IPFilter{exemptPaths: GetExemptPaths()}
+	if err := f.Reload(); err != nil {
 		return nil, err
 	}
 
-	log.Printf("Security: IP filter initialized with allowed networks: %s", allowedNetworksStr)
-	return IPFilterMiddleware(allowedNetworks), nil
+	if len(f.exemptPaths) > 0 {
+		log.Printf("Security: IP filter exempt paths: %s", strings.Join(f.exemptPaths, ","))
+	}
+	return f, nil
 }
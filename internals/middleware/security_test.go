@@ -0,0 +1,552 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"vfio_usb_passthrough/internals/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// fakeRouteTable has two default routes (a physical NIC and a VPN with a lower metric),
+// a duplicate default route on eth0 and a non-default route
+const fakeRouteTable = `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	00000000	0101A8C0	0003	0	0	100	00000000	0	0	0
+eth0	0001A8C0	00000000	0001	0	0	100	00FFFFFF	0	0	0
+tun0	00000000	0100080A	0003	0	0	50	00000000	0	0	0
+eth0	00000000	0101A8C0	0003	0	0	600	00000000	0	0	0
+`
+
+func mustIPNet(t *testing.T, cidr string) *net.IPNet {
+	t.Helper()
+	ip, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("ParseCIDR(%q): %v", cidr, err)
+	}
+	network.IP = ip
+	return network
+}
+
+func TestGetDefaultRouteSubnetsMultipleInterfaces(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "route")
+	if err := os.WriteFile(path, []byte(fakeRouteTable), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	originalPath, originalAddrs := routeTablePath, interfaceAddrs
+	t.Cleanup(func() {
+		routeTablePath, interfaceAddrs = originalPath, originalAddrs
+	})
+
+	routeTablePath = path
+	interfaceAddrs = func(ifaceName string) ([]net.Addr, error) {
+		switch ifaceName {
+		case "eth0":
+			return []net.Addr{
+				mustIPNet(t, "192.168.1.10/24"),
+				mustIPNet(t, "fe80::1/64"),
+				mustIPNet(t, "192.168.1.11/24"),
+				mustIPNet(t, "172.16.5.1/16"),
+			}, nil
+		case "tun0":
+			return []net.Addr{mustIPNet(t, "10.8.0.2/24")}, nil
+		}
+		return nil, nil
+	}
+
+	if got, want := getDefaultRouteInterfaces(), []string{"tun0", "eth0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("getDefaultRouteInterfaces() = %v, want %v", got, want)
+	}
+
+	got := getDefaultRouteSubnets()
+	want := []string{"10.8.0.0/24", "192.168.1.0/24", "172.16.0.0/16"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getDefaultRouteSubnets() = %v, want %v", got, want)
+	}
+}
+
+func TestGetInterfaceSubnetsNoIPv4(t *testing.T) {
+	originalAddrs := interfaceAddrs
+	t.Cleanup(func() { interfaceAddrs = originalAddrs })
+
+	interfaceAddrs = func(string) ([]net.Addr, error) {
+		return []net.Addr{mustIPNet(t, "fe80::1/64")}, nil
+	}
+
+	if _, err := getInterfaceSubnets("eth0"); err == nil {
+		t.Error("getInterfaceSubnets() expected error for interface without IPv4 address")
+	}
+}
+
+func TestGetDefaultRouteInterfacesFallback(t *testing.T) {
+	originalPath, originalAddrs, originalUp := routeTablePath, interfaceAddrs, upInterfaces
+	t.Cleanup(func() {
+		routeTablePath, interfaceAddrs, upInterfaces = originalPath, originalAddrs, originalUp
+	})
+
+	routeTablePath = filepath.Join(t.TempDir(), "missing")
+	upInterfaces = func() ([]string, error) {
+		return []string{"ib0", "eth0", "eth1"}, nil
+	}
+	interfaceAddrs = func(ifaceName string) ([]net.Addr, error) {
+		switch ifaceName {
+		case "ib0":
+			return []net.Addr{mustIPNet(t, "169.254.3.4/16"), mustIPNet(t, "fe80::1/64")}, nil
+		case "eth0":
+			return []net.Addr{mustIPNet(t, "192.168.1.10/24")}, nil
+		case "eth1":
+			return []net.Addr{mustIPNet(t, "10.0.0.5/8")}, nil
+		}
+		return nil, nil
+	}
+
+	if got, want := getDefaultRouteInterfaces(), []string{"eth0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("getDefaultRouteInterfaces() = %v, want %v", got, want)
+	}
+
+	upInterfaces = func() ([]string, error) {
+		return []string{"ib0"}, nil
+	}
+	if got := getDefaultRouteInterfaces(); got != nil {
+		t.Errorf("getDefaultRouteInterfaces() = %v, want nil without a usable interface", got)
+	}
+}
+
+func TestParseHexIP(t *testing.T) {
+	tests := []struct {
+		name  string
+		hexIP string
+		want  net.IP
+	}{
+		{"loopback", "0100007F", net.IPv4(127, 0, 0, 1)},
+		{"gateway", "0101A8C0", net.IPv4(192, 168, 1, 1)},
+		{"zero", "00000000", net.IPv4(0, 0, 0, 0)},
+		{"lowercase", "0a00a8c0", net.IPv4(192, 168, 0, 10)},
+		{"too short", "0100007", nil},
+		{"too long", "0100007F00", nil},
+		{"empty", "", nil},
+		{"bad hex", "0100007G", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseHexIP(tt.hexIP)
+			if !got.Equal(tt.want) {
+				t.Errorf("parseHexIP(%q) = %v, want %v", tt.hexIP, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNetmaskToCIDR(t *testing.T) {
+	tests := []struct {
+		netmask string
+		want    int
+		wantErr bool
+	}{
+		{"255.255.255.0", 24, false},
+		{"255.255.255.255", 32, false},
+		{"255.255.252.0", 22, false},
+		{"0.0.0.0", 0, false},
+		{"255.0.255.0", 0, true},
+		{"255.255.255.1", 0, true},
+		{"ffff:ffff:ffff:ffff::", 0, true},
+		{"not-a-mask", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.netmask, func(t *testing.T) {
+			got, err := netmaskToCIDR(tt.netmask)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("netmaskToCIDR(%q) error = %v, wantErr %v", tt.netmask, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("netmaskToCIDR(%q) = %d, want %d", tt.netmask, got, tt.want)
+			}
+		})
+	}
+}
+
+// mustParseNetworks parses an allowlist of CIDRs and IPs for a test
+func mustParseNetworks(t *testing.T, list string) []*net.IPNet {
+	t.Helper()
+	networks, _, err := ParseNetworks(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return networks
+}
+
+func TestParseNetworksErrorNamesEntry(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"prefix too large", "10.0.0.0/8,192.168.1.0/33", `"192.168.1.0/33" at position 2`},
+		{"out of range ipv4", " 192.168.1.300 ", `"192.168.1.300" at position 1`},
+		{"position counts empty entries", "10.0.0.0/8,,not a host", `"not a host" at position 3`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := ParseNetworks(tt.input)
+			if err == nil {
+				t.Fatalf("ParseNetworks(%q) should fail", tt.input)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseNetworks(%q) error = %q, want it to contain %q", tt.input, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatNetworks(t *testing.T) {
+	networks := mustParseNetworks(t, "192.168.1.42/24, 10.0.0.0/8")
+	if got, want := formatNetworks(networks), "192.168.1.0/24,10.0.0.0/8"; got != want {
+		t.Errorf("formatNetworks() = %q, want %q", got, want)
+	}
+	if got := formatNetworks(nil); got != "(none)" {
+		t.Errorf("formatNetworks(nil) = %q, want %q", got, "(none)")
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	origLookupIP := lookupIP
+	t.Cleanup(func() { lookupIP = origLookupIP })
+	lookupIP = func(ctx context.Context, host string) ([]net.IP, error) {
+		switch host {
+		case "desktop.lan":
+			return []net.IP{net.ParseIP("192.168.1.50"), net.ParseIP("fd00::50")}, nil
+		default:
+			return nil, errors.New("no such host")
+		}
+	}
+
+	tests := []struct {
+		name          string
+		input         string
+		want          []string
+		wantHostnames bool
+		wantErr       bool
+	}{
+		{"cidrs", "10.0.0.0/8,192.168.1.0/24", []string{"10.0.0.0/8", "192.168.1.0/24"}, false, false},
+		{"spaces and empty entries", " 10.0.0.0/8 ,, ,192.168.1.0/24,", []string{"10.0.0.0/8", "192.168.1.0/24"}, false, false},
+		{"host bits are masked", "192.168.1.42/24", []string{"192.168.1.0/24"}, false, false},
+		{"empty", "", nil, false, false},
+		{"bare ipv4", "192.168.1.42", []string{"192.168.1.42/32"}, false, false},
+		{"bare ipv6", "fd00::1", []string{"fd00::1/128"}, false, false},
+		{"hostname", "10.0.0.0/8, desktop.lan", []string{"10.0.0.0/8", "192.168.1.50/32", "fd00::50/128"}, true, false},
+		{"unresolvable hostname skipped", "gone.lan,10.0.0.0/8", []string{"10.0.0.0/8"}, true, false},
+		{"invalid cidr", "192.168.1.0/33", nil, false, true},
+		{"invalid entry", "not a host", nil, false, true},
+		{"out of range ipv4", "192.168.1.300", nil, false, true},
+		{"truncated ipv4", "10.0.0.0/8,10.0.0", nil, false, true},
+		{"trailing dot ipv4", "192.168.1.1.", nil, false, true},
+		{"digits only", "1234", nil, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			networks, hasHostnames, err := ParseNetworks(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseNetworks(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if hasHostnames != tt.wantHostnames {
+				t.Errorf("ParseNetworks(%q) hasHostnames = %v, want %v", tt.input, hasHostnames, tt.wantHostnames)
+			}
+
+			var got []string
+			for _, network := range networks {
+				got = append(got, network.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseNetworks(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadNetworksFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allowed_networks")
+	content := `# Office
+10.0.0.0/8
+
+  192.168.1.0/24   # lab
+#172.16.0.0/12
+	fd00::/8
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readNetworksFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "10.0.0.0/8,192.168.1.0/24,fd00::/8"; got != want {
+		t.Errorf("readNetworksFile() = %q, want %q", got, want)
+	}
+
+	if _, err := readNetworksFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("readNetworksFile() on a missing file should fail")
+	}
+}
+
+func TestGetAllowedNetworksFromEnvAndFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allowed_networks")
+	if err := os.WriteFile(path, []byte("# comment only\n192.168.1.0/24\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		env  string
+		file string
+		want string
+	}{
+		{"env only", "10.0.0.0/8", "", "10.0.0.0/8"},
+		{"file only", "", path, "192.168.1.0/24"},
+		{"combined", "10.0.0.0/8", path, "10.0.0.0/8,192.168.1.0/24"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ALLOWED_NETWORKS", tt.env)
+			t.Setenv(AllowedNetworksFileEnv, tt.file)
+
+			got, err := GetAllowedNetworks()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("GetAllowedNetworks() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsIPAllowed(t *testing.T) {
+	allowed := mustParseNetworks(t, "127.0.0.0/8,192.168.1.0/24,10.8.0.0/16")
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"127.0.0.1", true},
+		{"192.168.1.1", true},
+		{"192.168.1.255", true},
+		{"10.8.200.3", true},
+		{"192.168.2.1", false},
+		{"8.8.8.8", false},
+		{"::1", false},
+		{"::ffff:192.168.1.20", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := isIPAllowed(net.ParseIP(tt.ip), allowed); got != tt.want {
+				t.Errorf("isIPAllowed(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+
+	if isIPAllowed(nil, allowed) {
+		t.Error("isIPAllowed(nil) = true, want false")
+	}
+	if isIPAllowed(net.ParseIP("127.0.0.1"), nil) {
+		t.Error("isIPAllowed with no allowed networks = true, want false")
+	}
+}
+
+func TestIPFilterMatch(t *testing.T) {
+	allowed := mustParseNetworks(t, "127.0.0.0/8,192.168.1.0/24,192.168.0.0/16")
+	f := &IPFilter{}
+	f.networks.Store(&allowed)
+
+	ipTests := []struct {
+		ip   string
+		want string
+	}{
+		{"192.168.1.50", "192.168.1.0/24"},
+		{"192.168.7.1", "192.168.0.0/16"},
+		{"10.0.0.1", ""},
+	}
+	for _, tt := range ipTests {
+		if got := f.MatchIP(net.ParseIP(tt.ip)); (got == nil && tt.want != "") || (got != nil && got.String() != tt.want) {
+			t.Errorf("MatchIP(%s) = %v, want %q", tt.ip, got, tt.want)
+		}
+	}
+
+	networkTests := []struct {
+		cidr string
+		want string
+	}{
+		{"192.168.1.128/25", "192.168.1.0/24"},
+		{"192.168.1.0/24", "192.168.1.0/24"},
+		{"192.168.0.0/15", ""},
+		{"10.0.0.0/8", ""},
+	}
+	for _, tt := range networkTests {
+		_, network, err := net.ParseCIDR(tt.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.MatchNetwork(network); (got == nil && tt.want != "") || (got != nil && got.String() != tt.want) {
+			t.Errorf("MatchNetwork(%s) = %v, want %q", tt.cidr, got, tt.want)
+		}
+	}
+}
+
+func TestExtractIP(t *testing.T) {
+	tests := []struct {
+		remoteAddr string
+		want       net.IP
+	}{
+		{"192.168.1.5:1234", net.ParseIP("192.168.1.5")},
+		{"192.168.1.5", net.ParseIP("192.168.1.5")},
+		{"[::1]:8080", net.ParseIP("::1")},
+		{"garbage", nil},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.remoteAddr, func(t *testing.T) {
+			if got := extractIP(tt.remoteAddr); !got.Equal(tt.want) {
+				t.Errorf("extractIP(%q) = %v, want %v", tt.remoteAddr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIPFilterMiddleware(t *testing.T) {
+	allowed := mustParseNetworks(t, "127.0.0.0/8,192.168.1.0/24")
+
+	// Take the client IP from a header so tests can choose it
+	app := fiber.New(fiber.Config{ProxyHeader: "X-Real-IP"})
+	app.Use(IPFilterMiddleware(allowed, []string{"/api/health"}))
+
+	nextCalled := false
+	app.Use(func(c *fiber.Ctx) error {
+		nextCalled = true
+		return c.SendString("ok")
+	})
+
+	tests := []struct {
+		name       string
+		path       string
+		clientIP   string
+		wantStatus int
+	}{
+		{"loopback allowed", "/", "127.0.0.1", fiber.StatusOK},
+		{"in range allowed", "/api/vms", "192.168.1.42", fiber.StatusOK},
+		{"ip with port allowed", "/api/vms", "192.168.1.42:51234", fiber.StatusOK},
+		{"out of range blocked", "/api/vms", "10.0.0.1", fiber.StatusForbidden},
+		{"ip with port blocked", "/api/vms", "10.0.0.1:51234", fiber.StatusForbidden},
+		{"unparseable blocked", "/api/vms", "not-an-ip", fiber.StatusForbidden},
+		{"exempt path", "/api/health", "10.0.0.1", fiber.StatusOK},
+		{"exempt subpath", "/api/health/live", "10.0.0.1", fiber.StatusOK},
+		{"exempt prefix only on boundary", "/api/healthz", "10.0.0.1", fiber.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nextCalled = false
+
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req.Header.Set("X-Real-IP", tt.clientIP)
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if wantNext := tt.wantStatus == fiber.StatusOK; nextCalled != wantNext {
+				t.Errorf("next handler called = %v, want %v", nextCalled, wantNext)
+			}
+		})
+	}
+}
+
+func TestReloadKeepsNetworksWhenDetectionFails(t *testing.T) {
+	t.Setenv("ALLOWED_NETWORKS", "")
+	t.Setenv(AllowedNetworksFileEnv, "")
+
+	virsh := filepath.Join(t.TempDir(), "virsh")
+	writeVirsh := func(script string) {
+		if err := os.WriteFile(virsh, []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv(utils.VirshBinEnv, virsh)
+
+	writeVirsh(`case "$1" in
+net-list) echo default ;;
+net-dumpxml) echo "<network><ip address='192.168.122.1' prefix='24'/></network>" ;;
+esac
+`)
+	f, err := NewIPFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.MatchIP(net.ParseIP("192.168.122.50")) == nil {
+		t.Fatalf("networks = %s, want the virsh network 192.168.122.0/24", formatNetworks(f.Networks()))
+	}
+	before := formatNetworks(f.Networks())
+
+	// libvirt failing during a reload must not shrink the allowlist
+	writeVirsh("echo 'error: failed to connect to the hypervisor' >&2\nexit 1\n")
+	if err := f.Reload(); !errors.Is(err, ErrNetworkDetection) {
+		t.Fatalf("Reload() = %v, want ErrNetworkDetection", err)
+	}
+	if after := formatNetworks(f.Networks()); after != before {
+		t.Errorf("networks after a failed reload = %s, want %s", after, before)
+	}
+
+	// At startup there is nothing to keep, so the networks found are allowed
+	f, err = NewIPFilter()
+	if err != nil {
+		t.Fatalf("NewIPFilter() with failing virsh = %v, want the detected networks", err)
+	}
+	if f.MatchIP(net.ParseIP("127.0.0.1")) == nil {
+		t.Errorf("networks = %s, want localhost", formatNetworks(f.Networks()))
+	}
+}
+
+func TestGetExemptPaths(t *testing.T) {
+	tests := []struct {
+		name  string
+		set   bool
+		value string
+		want  []string
+	}{
+		{"unset: nothing is exempt", false, "", nil},
+		{"empty", true, "", nil},
+		{"normalized", true, " metrics/ ,, /api/ping", []string{"/metrics", "/api/ping"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("IP_FILTER_EXEMPT_PATHS", tt.value)
+			if !tt.set {
+				os.Unsetenv("IP_FILTER_EXEMPT_PATHS")
+			}
+			if got := GetExemptPaths(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetExemptPaths() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// DefaultTopicPrefix is the topic prefix used when MQTT_TOPIC_PREFIX is not set
+const DefaultTopicPrefix = "vfio"
+
+// publishTimeout bounds how long a publish waits for the broker
+const publishTimeout = 5 * time.Second
+
+// Device is a USB device as published in attachment messages
+type Device struct {
+	VendorID  string `json:"vendorId"`
+	ProductID string `json:"productId"`
+}
+
+var (
+	client      paho.Client
+	topicPrefix = DefaultTopicPrefix
+)
+
+// Connect connects to the MQTT broker configured by MQTT_BROKER (e.g. tcp://localhost:1883)
+// Does nothing if MQTT_BROKER is not set. The client reconnects on its own if the connection drops,
+// and keeps retrying in the background if the broker is not reachable at startup.
+func Connect() error {
+	broker := os.Getenv("MQTT_BROKER")
+	if broker == "" {
+		return nil
+	}
+
+	if prefix := strings.Trim(os.Getenv("MQTT_TOPIC_PREFIX"), "/"); prefix != "" {
+		topicPrefix = prefix
+	}
+
+	clientID := os.Getenv("MQTT_CLIENT_ID")
+	if clientID == "" {
+		clientID = "vfio-usb-passthrough"
+	}
+
+	opts := paho.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetUsername(os.Getenv("MQTT_USERNAME")).
+		SetPassword(os.Getenv("MQTT_PASSWORD")).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(func(paho.Client) {
+			log.Printf("MQTT: Connected to %s", broker)
+		}).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			log.Printf("MQTT: Connection lost: %v", err)
+		})
+
+	client = paho.NewClient(opts)
+	token := client.Connect()
+	if token.WaitTimeout(publishTimeout) && token.Error() != nil {
+		return fmt.Errorf("failed to connect to MQTT broker %s: %w", broker, token.Error())
+	}
+
+	log.Printf("MQTT: Enabled with broker %s and topic prefix %s", broker, topicPrefix)
+	return nil
+}
+
+// Enabled reports whether a broker is configured (the client may still be reconnecting)
+func Enabled() bool {
+	return client != nil
+}
+
+// Disconnect closes the broker connection, if any
+func Disconnect() {
+	if client != nil {
+		client.Disconnect(250)
+	}
+}
+
+// PublishVMAttachments publishes the devices attached to a VM as a retained message
+// on <prefix>/vm/<name>/attached
+func PublishVMAttachments(vmName string, devices []Device) {
+	if client == nil {
+		return
+	}
+	if devices == nil {
+		devices = []Device{}
+	}
+
+	payload, err := json.Marshal(devices)
+	if err != nil {
+		log.Printf("MQTT: Failed to marshal attachments for %s: %v", vmName, err)
+		return
+	}
+
+	publish(fmt.Sprintf("%s/vm/%s/attached", topicPrefix, vmName), payload)
+}
+
+// publish sends a retained message in the background, logging failures
+func publish(topic string, payload []byte) {
+	token := client.Publish(topic, 1, true, payload)
+	go func() {
+		if !token.WaitTimeout(publishTimeout) {
+			log.Printf("MQTT: Timed out publishing to %s", topic)
+			return
+		}
+		if err := token.Error(); err != nil {
+			log.Printf("MQTT: Failed to publish to %s: %v", topic, err)
+		}
+	}()
+}
@@ -0,0 +1,357 @@
+package rpc
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"vfio_usb_passthrough/internals/db"
+	"vfio_usb_passthrough/internals/handlers"
+	"vfio_usb_passthrough/internals/middleware"
+	vfiov1 "vfio_usb_passthrough/internals/rpc/vfio/v1"
+	"vfio_usb_passthrough/internals/tracing"
+	"vfio_usb_passthrough/internals/utils"
+
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCPortEnv enables the gRPC server on this port, on the same interface as the API (disabled when unset)
+const GRPCPortEnv = "GRPC_PORT"
+
+// Enabled reports whether GRPC_PORT is set, i.e. whether the server starts with the API
+func Enabled() bool {
+	return strings.TrimSpace(os.Getenv(GRPCPortEnv)) != ""
+}
+
+// Addr returns the listen address of the gRPC server: the host of the API bind address with
+// GRPC_PORT, or "" when GRPC_PORT is not set
+func Addr(bindAddr string) (string, error) {
+	port := strings.TrimSpace(os.Getenv(GRPCPortEnv))
+	if port == "" {
+		return "", nil
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid %s %q: must be a port number", GRPCPortEnv, port)
+	}
+	host, _, err := net.SplitHostPort(bindAddr)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// server implements vfiov1.DeviceServiceServer on top of the service used by the REST handlers
+type server struct {
+	vfiov1.UnimplementedDeviceServiceServer
+	devices handlers.DeviceService
+}
+
+// NewServer returns a gRPC server exposing DeviceService
+// Calls are subject to the API IP filter and, when configured, to the API basic auth
+// (sent as "authorization: Basic ..." metadata)
+func NewServer(ipFilter *middleware.IPFilter) (*grpc.Server, error) {
+	authorizer, err := middleware.BasicAuthAuthorizer()
+	if err != nil {
+		return nil, err
+	}
+
+	s := grpc.NewServer(grpc.UnaryInterceptor(accessInterceptor(ipFilter, authorizer)))
+	vfiov1.RegisterDeviceServiceServer(s, &server{devices: handlers.Devices})
+	return s, nil
+}
+
+// accessInterceptor rejects calls from outside the allowed networks or without valid credentials, and logs calls
+func accessInterceptor(ipFilter *middleware.IPFilter, authorizer func(username, password string) bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ip := peerIP(ctx)
+		if ip == nil || !ipFilter.Allows(ip) {
+			log.Printf("Security: Blocked gRPC call %s from unauthorized address %v", info.FullMethod, ip)
+			return nil, status.Error(codes.PermissionDenied, "access denied")
+		}
+		if authorizer != nil {
+			username, password, ok := basicAuthCredentials(ctx)
+			if !ok || !authorizer(username, password) {
+				log.Printf("Security: Rejected gRPC call %s from %s: invalid or missing basic auth credentials", info.FullMethod, ip)
+				return nil, status.Error(codes.Unauthenticated, "invalid or missing basic auth credentials")
+			}
+		}
+
+		ctx, span := tracing.Tracer().Start(ctx, info.FullMethod, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		if err != nil {
+			span.SetStatus(otelcodes.Error, status.Code(err).String())
+		}
+		log.Printf("gRPC %s from %s: %s (%s)", info.FullMethod, ip, status.Code(err), time.Since(start).Round(time.Millisecond))
+		return resp, err
+	}
+}
+
+// peerIP returns the IP address of the caller, or nil if unknown
+func peerIP(ctx context.Context) net.IP {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// basicAuthCredentials reads basic auth credentials from the "authorization" metadata of a call
+func basicAuthCredentials(ctx context.Context) (username, password string, ok bool) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		scheme, encoded, found := strings.Cut(value, " ")
+		if !found || !strings.EqualFold(scheme, "basic") {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			continue
+		}
+		return strings.Cut(string(decoded), ":")
+	}
+	return "", "", false
+}
+
+// client returns how a caller is recorded in the audit log
+func client(ctx context.Context) string {
+	if ip := peerIP(ctx); ip != nil {
+		return ip.String()
+	}
+	return "grpc"
+}
+
+// ListVMs returns the running VMs
+func (s *server) ListVMs(ctx context.Context, req *vfiov1.ListVMsRequest) (*vfiov1.ListVMsResponse, error) {
+	host, err := s.devices.LookupHost(req.GetHost())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	vms, err := s.devices.ListVMs(host)
+	if err != nil {
+		log.Printf("Error listing VMs: %v", err)
+		return nil, toStatus(err)
+	}
+
+	resp := &vfiov1.ListVMsResponse{}
+	for _, vm := range vms {
+		resp.Vms = append(resp.Vms, &vfiov1.VM{Name: vm.Name, Uuid: vm.UUID, Ref: vm.Ref})
+	}
+	return resp, nil
+}
+
+// ListUSBDevices returns the USB devices of the host
+func (s *server) ListUSBDevices(ctx context.Context, req *vfiov1.ListUSBDevicesRequest) (*vfiov1.ListUSBDevicesResponse, error) {
+	host, err := s.devices.LookupHost(req.GetHost())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	list, err := s.devices.ListUSBDevices(host)
+	if err != nil {
+		log.Printf("Error listing USB devices: %v", err)
+		return nil, toStatus(err)
+	}
+
+	resp := &vfiov1.ListUSBDevicesResponse{
+		Devices:   toUSBDevices(list.Devices),
+		LocalOnly: list.LocalOnly,
+	}
+	if !list.StaleSince.IsZero() {
+		resp.StaleSince = list.StaleSince.Format(time.RFC3339)
+	}
+	return resp, nil
+}
+
+// Attach attaches a USB device to a running VM
+func (s *server) Attach(ctx context.Context, req *vfiov1.AttachRequest) (*vfiov1.AttachResponse, error) {
+	host, vmName, err := s.resolveVM(req.GetHost(), req.GetVm())
+	if err != nil {
+		return nil, err
+	}
+
+	deviceReq := toDeviceRequest(req.GetDevice())
+	deviceReq.Alias = req.GetAlias()
+	result, err := s.devices.Attach(ctx, host, vmName, deviceReq, handlers.AttachOptions{
+		Idempotent: req.GetIdempotent(),
+		Verify:     req.GetVerify(),
+		Client:     client(ctx),
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &vfiov1.AttachResponse{
+		VendorId:        result.VendorID,
+		ProductId:       result.ProductID,
+		AlreadyAttached: result.AlreadyAttached,
+		Verified:        result.Verified,
+	}, nil
+}
+
+// Detach detaches a USB device from a running VM
+func (s *server) Detach(ctx context.Context, req *vfiov1.DetachRequest) (*vfiov1.DetachResponse, error) {
+	host, vmName, err := s.resolveVM(req.GetHost(), req.GetVm())
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.devices.Detach(ctx, host, vmName, toDeviceRequest(req.GetDevice()), handlers.DetachOptions{
+		Idempotent: req.GetIdempotent(),
+		All:        req.GetAll(),
+		Client:     client(ctx),
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &vfiov1.DetachResponse{
+		VendorId:        result.VendorID,
+		ProductId:       result.ProductID,
+		AlreadyDetached: result.AlreadyDetached,
+		Detached:        int32(result.Detached),
+	}, nil
+}
+
+// GetDevicesState returns the USB devices, the devices attached to a VM and the favorites
+func (s *server) GetDevicesState(ctx context.Context, req *vfiov1.GetDevicesStateRequest) (*vfiov1.GetDevicesStateResponse, error) {
+	host, err := s.devices.LookupHost(req.GetHost())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	vmName := req.GetVm()
+	if vmName != "" {
+		if vmName, err = s.devices.ResolveVM(host, vmName); err != nil {
+			return nil, toStatus(err)
+		}
+	}
+
+	state, err := s.devices.DevicesState(ctx, host, vmName)
+	if err != nil {
+		log.Printf("Error getting USB devices: %v", err)
+		return nil, toStatus(err)
+	}
+
+	resp := &vfiov1.GetDevicesStateResponse{
+		Devices: toUSBDevices(state.Devices),
+	}
+	for _, device := range state.AttachedDevices {
+		attached := &vfiov1.AttachedDevice{
+			VendorId:  device.VendorID,
+			ProductId: device.ProductID,
+			Managed:   device.Managed,
+			Alias:     device.Alias,
+		}
+		if device.Address != nil {
+			attached.Address = &vfiov1.USBAddress{Bus: uint32(device.Address.Bus), Device: uint32(device.Address.Device)}
+		}
+		resp.AttachedDevices = append(resp.AttachedDevices, attached)
+	}
+	for _, fav := range state.Favorites {
+		resp.Favorites = append(resp.Favorites, &vfiov1.FavoriteDevice{
+			VendorId:    fav.VendorID,
+			ProductId:   fav.ProductID,
+			Description: fav.Description,
+			Notes:       fav.Notes,
+		})
+	}
+	return resp, nil
+}
+
+// resolveVM returns the connection and the running VM designated by a request
+func (s *server) resolveVM(hostName, vm string) (handlers.Host, string, error) {
+	host, err := s.devices.LookupHost(hostName)
+	if err != nil {
+		return handlers.Host{}, "", toStatus(err)
+	}
+	vmName, err := s.devices.ResolveVM(host, vm)
+	if err != nil {
+		return handlers.Host{}, "", toStatus(err)
+	}
+	return host, vmName, nil
+}
+
+// toDeviceRequest converts a device selector to the request type of the REST API
+func toDeviceRequest(device *vfiov1.DeviceSelector) handlers.AttachDetachRequest {
+	req := handlers.AttachDetachRequest{
+		VendorID:    device.GetVendorId(),
+		ProductID:   device.GetProductId(),
+		DeviceAlias: device.GetDeviceAlias(),
+		Serial:      device.GetSerial(),
+	}
+	if address := device.GetAddress(); address != nil {
+		req.Address = &utils.USBAddressXML{Bus: int(address.GetBus()), Device: int(address.GetDevice())}
+	}
+	return req
+}
+
+// toUSBDevices converts USB devices to their gRPC form
+func toUSBDevices(devices []handlers.USBDeviceResponse) []*vfiov1.USBDevice {
+	converted := make([]*vfiov1.USBDevice, 0, len(devices))
+	for _, device := range devices {
+		converted = append(converted, &vfiov1.USBDevice{
+			VendorId:    device.VendorID,
+			ProductId:   device.ProductID,
+			Description: device.Description,
+			Speed:       device.Speed,
+			UsbVersion:  device.USBVersion,
+			MaxPower:    device.MaxPower,
+			DeviceClass: device.DeviceClass,
+			Serial:      device.Serial,
+		})
+	}
+	return converted
+}
+
+// toStatus maps a service error to a gRPC status
+func toStatus(err error) error {
+	var cmdErr *handlers.DeviceCommandError
+	var detachAllErr *handlers.DetachAllError
+	var mountedErr *handlers.DeviceMountedError
+	var limitErr *handlers.DeviceLimitError
+	switch {
+	case errors.Is(err, handlers.ErrUnknownHost),
+		errors.Is(err, handlers.ErrVMNameEmpty),
+		errors.Is(err, handlers.ErrVMNameInvalidFormat),
+		errors.Is(err, handlers.ErrInvalidDeviceRequest),
+		errors.Is(err, handlers.ErrDeviceAliasWithIDs),
+		errors.Is(err, handlers.ErrSerialLocalOnly):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, handlers.ErrVMNotRunning),
+		errors.Is(err, handlers.ErrVMUUIDUnknown),
+		errors.Is(err, db.ErrDeviceAliasNotFound),
+		errors.Is(err, handlers.ErrSerialNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, handlers.ErrSerialAmbiguous), errors.As(err, &mountedErr):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.As(err, &limitErr):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.As(err, &cmdErr) && cmdErr.Rollback != nil:
+		if cmdErr.Rollback.Success {
+			return status.Errorf(codes.DeadlineExceeded, "%v (rolled back)", cmdErr.Err)
+		}
+		return status.Errorf(codes.DeadlineExceeded, "%v (rollback failed: %s)", cmdErr.Err, cmdErr.Rollback.Error)
+	case errors.As(err, &detachAllErr):
+		return status.Errorf(codes.Internal, "%v: %s", err, strings.Join(detachAllErr.Failures, "; "))
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
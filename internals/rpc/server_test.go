@@ -0,0 +1,80 @@
+package rpc
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"vfio_usb_passthrough/internals/db"
+	"vfio_usb_passthrough/internals/handlers"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAddr(t *testing.T) {
+	tests := []struct {
+		port     string
+		bindAddr string
+		want     string
+		wantErr  bool
+	}{
+		{"", "0.0.0.0:3000", "", false},
+		{"50051", "0.0.0.0:3000", "0.0.0.0:50051", false},
+		{"50051", "192.168.1.10:3000", "192.168.1.10:50051", false},
+		{"grpc", "0.0.0.0:3000", "", true},
+		{"70000", "0.0.0.0:3000", "", true},
+	}
+	for _, tt := range tests {
+		t.Setenv(GRPCPortEnv, tt.port)
+		got, err := Addr(tt.bindAddr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Addr(%q) with %s=%q error = %v, wantErr %v", tt.bindAddr, GRPCPortEnv, tt.port, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Addr(%q) with %s=%q = %q, want %q", tt.bindAddr, GRPCPortEnv, tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestBasicAuthCredentials(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString([]byte("admin:s3cr:et"))
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Basic "+encoded))
+	username, password, ok := basicAuthCredentials(ctx)
+	if !ok || username != "admin" || password != "s3cr:et" {
+		t.Errorf("basicAuthCredentials() = %q, %q, %v, want admin, s3cr:et, true", username, password, ok)
+	}
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer token"))
+	if _, _, ok := basicAuthCredentials(ctx); ok {
+		t.Error("basicAuthCredentials() accepted a bearer token")
+	}
+	if _, _, ok := basicAuthCredentials(context.Background()); ok {
+		t.Error("basicAuthCredentials() accepted a call without metadata")
+	}
+}
+
+func TestToStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want codes.Code
+	}{
+		{handlers.ErrUnknownHost, codes.InvalidArgument},
+		{handlers.ErrVMNameInvalidFormat, codes.InvalidArgument},
+		{handlers.ErrInvalidDeviceRequest, codes.InvalidArgument},
+		{handlers.ErrVMNotRunning, codes.NotFound},
+		{db.ErrDeviceAliasNotFound, codes.NotFound},
+		{handlers.ErrSerialAmbiguous, codes.FailedPrecondition},
+		{&handlers.DeviceCommandError{Err: errors.New("timed out"), Rollback: &handlers.RollbackResult{Success: true}}, codes.DeadlineExceeded},
+		{&handlers.DeviceCommandError{Output: "error: device busy", Err: errors.New("exit status 1")}, codes.Internal},
+		{&handlers.DetachAllError{Instances: 2, Detached: 1, Failures: []string{"busy"}}, codes.Internal},
+	}
+	for _, tt := range tests {
+		if got := status.Code(toStatus(tt.err)); got != tt.want {
+			t.Errorf("toStatus(%v) code = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
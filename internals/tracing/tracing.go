@@ -0,0 +1,153 @@
+package tracing
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OTLPEndpointEnv enables exporting traces over OTLP/HTTP to this endpoint (e.g. http://collector:4318)
+// The other standard OTEL_EXPORTER_OTLP_* and OTEL_SERVICE_NAME variables are honoured
+const OTLPEndpointEnv = "OTEL_EXPORTER_OTLP_ENDPOINT"
+
+// serviceName names this service in traces unless OTEL_SERVICE_NAME is set
+const serviceName = "vfio_usb_passthrough"
+
+// Init installs the global tracer provider exporting to OTEL_EXPORTER_OTLP_ENDPOINT and returns
+// a function flushing pending spans on shutdown
+// Without an endpoint tracing stays a no-op: spans are created but never recorded
+func Init(version string) (func(context.Context) error, error) {
+	if strings.TrimSpace(os.Getenv(OTLPEndpointEnv)) == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// The exporter reads the endpoint, headers, timeout and TLS settings from the environment
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName(serviceName),
+		semconv.ServiceVersion(version),
+	))
+	if err != nil {
+		return nil, err
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence over the defaults above
+	if fromEnv, err := resource.New(context.Background(), resource.WithFromEnv()); err == nil {
+		if merged, err := resource.Merge(res, fromEnv); err == nil {
+			res = merged
+		}
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	log.Printf("Tracing: exporting spans to %s", os.Getenv(OTLPEndpointEnv))
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer of this service
+func Tracer() trace.Tracer {
+	return otel.Tracer(serviceName)
+}
+
+// StartExec starts a client span around a command run by this service, named after the command
+// and its first argument (e.g. "virsh attach-device")
+func StartExec(ctx context.Context, name string, args []string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	spanName := name
+	if len(args) > 0 {
+		spanName += " " + args[0]
+	}
+	attrs = append(attrs,
+		attribute.String("process.command", name),
+		attribute.StringSlice("process.command_args", args),
+	)
+	return Tracer().Start(ctx, spanName, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// EndExec records the outcome of a command span and ends it
+func EndExec(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// carrier adapts Fiber request headers to the propagation API
+type carrier struct {
+	c *fiber.Ctx
+}
+
+func (h carrier) Get(key string) string {
+	return h.c.Get(key)
+}
+
+func (h carrier) Set(key, value string) {
+	h.c.Request().Header.Set(key, value)
+}
+
+func (h carrier) Keys() []string {
+	var keys []string
+	h.c.Request().Header.VisitAll(func(key, _ []byte) {
+		keys = append(keys, string(key))
+	})
+	return keys
+}
+
+// Middleware wraps each request in a server span, continuing the trace of an incoming traceparent header
+// The request context (c.UserContext()) carries the span so the work done for the request nests under it
+func Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := otel.GetTextMapPropagator().Extract(c.UserContext(), carrier{c})
+		ctx, span := Tracer().Start(ctx, c.Method(), trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(c.Method()),
+			semconv.URLPath(c.Path()),
+			semconv.ClientAddress(c.IP()),
+		))
+		defer span.End()
+		c.SetUserContext(ctx)
+
+		err := c.Next()
+
+		// The route is only known once the router matched the request
+		route := c.Route().Path
+		span.SetName(c.Method() + " " + route)
+		span.SetAttributes(semconv.HTTPRoute(route))
+
+		status := c.Response().StatusCode()
+		if err != nil {
+			// The error handler has not written the response yet
+			if fiberErr, ok := err.(*fiber.Error); ok {
+				status = fiberErr.Code
+			} else {
+				status = fiber.StatusInternalServerError
+			}
+			span.RecordError(err)
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, strconv.Itoa(status))
+		}
+		return err
+	}
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GetIntervalEnv reads an interval from an environment variable
+// Accepts a duration (e.g. "5m") or a plain number of minutes
+// Returns 0 when the variable is unset or empty
+func GetIntervalEnv(name string) (time.Duration, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return 0, nil
+	}
+
+	if minutes, err := strconv.Atoi(value); err == nil {
+		if minutes < 0 {
+			return 0, fmt.Errorf("invalid %s: %q must not be negative", name, value)
+		}
+		return time.Duration(minutes) * time.Minute, nil
+	}
+
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	if interval < 0 {
+		return 0, fmt.Errorf("invalid %s: %q must not be negative", name, value)
+	}
+	return interval, nil
+}
+
+// GetNonNegativeIntEnv reads a non-negative integer from an environment variable
+// Returns 0 when the variable is unset or empty
+func GetNonNegativeIntEnv(name string) (int, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("invalid %s: %q must not be negative", name, value)
+	}
+	return n, nil
+}
+
+// Environment variables overriding the binaries run on the local host, for systems where they
+// are not on the service user's PATH (e.g. NixOS)
+const (
+	VirshBinEnv = "VIRSH_BIN"
+	LsusbBinEnv = "LSUSB_BIN"
+)
+
+// VirshBin returns the virsh binary to run: VIRSH_BIN (name or path), or "virsh" resolved via PATH
+func VirshBin() string {
+	return binFromEnv(VirshBinEnv, "virsh")
+}
+
+// LsusbBin returns the lsusb binary to run: LSUSB_BIN (name or path), or "lsusb" resolved via PATH
+func LsusbBin() string {
+	return binFromEnv(LsusbBinEnv, "lsusb")
+}
+
+// binFromEnv returns the binary named by an environment variable, or the default name when unset
+func binFromEnv(name, defaultBin string) string {
+	if bin := strings.TrimSpace(os.Getenv(name)); bin != "" {
+		return bin
+	}
+	return defaultBin
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// USBIDsPathEnv overrides the location of the usb.ids database
+const USBIDsPathEnv = "USB_IDS_PATH"
+
+// usbIDsPaths are the usual usb.ids locations (hwdata, usbutils)
+var usbIDsPaths = []string{
+	"/usr/share/hwdata/usb.ids",
+	"/usr/share/misc/usb.ids",
+	"/usr/share/usb.ids",
+	"/var/lib/usbutils/usb.ids",
+}
+
+// usbIDs holds the vendor and product names of usb.ids, loaded on first use
+var usbIDs struct {
+	once     sync.Once
+	vendors  map[string]string
+	products map[string]string // keyed by vendor:product
+}
+
+// LookupUSBName returns the "Vendor Product" name of a device from usb.ids, like lsusb prints it
+// Returns false if neither the vendor nor the product is known
+func LookupUSBName(vendorID, productID string) (string, bool) {
+	usbIDs.once.Do(loadUSBIDs)
+
+	vendorID, productID = strings.ToLower(vendorID), strings.ToLower(productID)
+	vendor, ok := usbIDs.vendors[vendorID]
+	if !ok {
+		return "", false
+	}
+	if product, ok := usbIDs.products[vendorID+":"+productID]; ok {
+		return vendor + " " + product, true
+	}
+	return vendor, true
+}
+
+// loadUSBIDs reads usb.ids from USB_IDS_PATH or the first usual location that exists
+func loadUSBIDs() {
+	usbIDs.vendors = make(map[string]string)
+	usbIDs.products = make(map[string]string)
+
+	paths := usbIDsPaths
+	if path := os.Getenv(USBIDsPathEnv); path != "" {
+		paths = []string{path}
+	}
+
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		defer file.Close()
+
+		parseUSBIDs(file, usbIDs.vendors, usbIDs.products)
+		log.Printf("Loaded %d USB vendors from %s", len(usbIDs.vendors), path)
+		return
+	}
+	log.Printf("Warning: usb.ids not found, device names are only taken from lsusb")
+}
+
+// parseUSBIDs reads the vendor/product section of usb.ids
+// Vendors are "vvvv  Name" lines and their products "\tpppp  Name" lines; other sections are skipped
+func parseUSBIDs(r io.Reader, vendors, products map[string]string) {
+	scanner := bufio.NewScanner(r)
+	vendor := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "\t\t") {
+			// Interfaces
+			continue
+		}
+		if strings.HasPrefix(line, "\t") {
+			id, name, ok := splitUSBIDsLine(line[1:])
+			if ok && vendor != "" {
+				products[vendor+":"+id] = name
+			}
+			continue
+		}
+
+		id, name, ok := splitUSBIDsLine(line)
+		if !ok {
+			// Device classes and other lists follow the vendors
+			vendor = ""
+			continue
+		}
+		vendor = id
+		vendors[id] = name
+	}
+}
+
+// splitUSBIDsLine splits an "xxxx  Name" line into a lowercase hex ID and a name
+func splitUSBIDsLine(line string) (id, name string, ok bool) {
+	id, name, ok = strings.Cut(line, "  ")
+	id = strings.ToLower(id)
+	if !ok || !IsValidHexID(id) {
+		return "", "", false
+	}
+	return id, strings.TrimSpace(name), true
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// usbIDsSample follows the layout of usb.ids: comments, vendors with products and interfaces,
+// then the device class list whose subclass lines look like products
+const usbIDsSample = `#
+#	List of USB ID's
+#
+# Version: 2025.01.01
+
+046d  Logitech, Inc.
+	c077  M105 Optical Mouse
+	C52B  Unifying Receiver
+		0001  Interface that is skipped
+0781  SanDisk Corp.
+	5583  Ultra Fit
+1D6B  Linux Foundation
+	0002  2.0 root hub
+zzzz  Not a vendor
+	0001  Product of no vendor
+
+C 03  Human Interface Device
+	01  Boot Interface Subclass
+	0002  Looks like a product
+`
+
+func TestParseUSBIDs(t *testing.T) {
+	vendors := make(map[string]string)
+	products := make(map[string]string)
+	parseUSBIDs(strings.NewReader(usbIDsSample), vendors, products)
+
+	wantVendors := map[string]string{
+		"046d": "Logitech, Inc.",
+		"0781": "SanDisk Corp.",
+		"1d6b": "Linux Foundation",
+	}
+	wantProducts := map[string]string{
+		"046d:c077": "M105 Optical Mouse",
+		"046d:c52b": "Unifying Receiver",
+		"0781:5583": "Ultra Fit",
+		"1d6b:0002": "2.0 root hub",
+	}
+	if !reflect.DeepEqual(vendors, wantVendors) {
+		t.Errorf("vendors = %v, want %v", vendors, wantVendors)
+	}
+	if !reflect.DeepEqual(products, wantProducts) {
+		t.Errorf("products = %v, want %v", products, wantProducts)
+	}
+}
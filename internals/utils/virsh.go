@@ -2,16 +2,24 @@ package utils
 
 import (
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
 // USBDevice represents a USB device with vendor and product IDs
+// Address is the host address and Alias the device alias from the VM XML, when libvirt reports them;
+// Managed is the hostdev managed attribute
 type USBDevice struct {
-	VendorID  string `json:"vendorId"`
-	ProductID string `json:"productId"`
-	Description string `json:"description,omitempty"`
+	VendorID    string         `json:"vendorId"`
+	ProductID   string         `json:"productId"`
+	Description string         `json:"description,omitempty"`
+	Address     *USBAddressXML `json:"address,omitempty"`
+	Alias       string         `json:"alias,omitempty"`
+	Managed     bool           `json:"managed,omitempty"`
 }
 
 // USBHostdevXML represents the libvirt USB hostdev XML structure
@@ -19,28 +27,124 @@ type USBHostdevXML struct {
 	XMLName xml.Name `xml:"hostdev"`
 	Mode    string   `xml:"mode,attr"`
 	Type    string   `xml:"type,attr"`
+	Managed string   `xml:"managed,attr,omitempty"`
 	Source  struct {
-		Vendor  struct {
+		Vendor struct {
 			ID string `xml:"id,attr"`
 		} `xml:"vendor"`
 		Product struct {
 			ID string `xml:"id,attr"`
 		} `xml:"product"`
+		Address *USBAddressXML `xml:"address,omitempty"`
 	} `xml:"source"`
+	Alias *AliasXML `xml:"alias,omitempty"`
+}
+
+// AliasXML is the alias of a device in a VM
+// Aliases set by users must start with UserAliasPrefix
+type AliasXML struct {
+	Name string `xml:"name,attr"`
+}
+
+// UserAliasPrefix is the prefix libvirt requires for device aliases chosen by users
+const UserAliasPrefix = "ua-"
+
+// userAliasPattern matches the aliases libvirt accepts from users
+var userAliasPattern = regexp.MustCompile(`^ua-[A-Za-z0-9_-]+$`)
+
+// IsValidUserAlias checks that an alias follows libvirt's rules for user aliases
+func IsValidUserAlias(alias string) bool {
+	return len(alias) <= 64 && userAliasPattern.MatchString(alias)
+}
+
+// USBHostdevOptions are optional settings of a generated hostdev
+// Address pins the host bus and device number, to select one device among several with the same IDs;
+// Alias labels the device in the VM XML and must be a valid user alias; Managed sets the managed
+// attribute, left out when nil (libvirt does not detach USB devices from host drivers by default)
+type USBHostdevOptions struct {
+	Address *USBAddressXML
+	Alias   string
+	Managed *bool
+}
+
+// managedAttr returns the value of a hostdev managed attribute
+func managedAttr(managed bool) string {
+	if managed {
+		return "yes"
+	}
+	return "no"
+}
+
+// USBAddressXML is the host bus and device number of a USB device
+type USBAddressXML struct {
+	Bus    int `xml:"bus,attr" json:"bus" yaml:"bus" validate:"min=1"`
+	Device int `xml:"device,attr" json:"device" yaml:"device" validate:"min=1"`
+}
+
+// HostdevXML represents a hostdev element of any type in a VM XML dump
+// Address attributes are kept as strings as PCI addresses are hexadecimal (e.g. bus="0x01")
+type HostdevXML struct {
+	Mode    string `xml:"mode,attr"`
+	Type    string `xml:"type,attr"`
+	Managed string `xml:"managed,attr"`
+	Source  struct {
+		Vendor struct {
+			ID string `xml:"id,attr"`
+		} `xml:"vendor"`
+		Product struct {
+			ID string `xml:"id,attr"`
+		} `xml:"product"`
+		Address *struct {
+			Domain   string `xml:"domain,attr"`
+			Bus      string `xml:"bus,attr"`
+			Slot     string `xml:"slot,attr"`
+			Function string `xml:"function,attr"`
+			Device   string `xml:"device,attr"`
+		} `xml:"address"`
+	} `xml:"source"`
+	Alias *AliasXML `xml:"alias"`
+}
+
+// PCIDevice represents a PCI device passed through to a VM
+// Address is the host address in domain:bus:slot.function form (e.g. 0000:01:00.0)
+type PCIDevice struct {
+	Address string `json:"address"`
+	Managed bool   `json:"managed"`
+	Alias   string `json:"alias,omitempty"`
+}
+
+// ControllerXML represents a controller element in a VM XML dump
+type ControllerXML struct {
+	Type  string `xml:"type,attr"`
+	Model string `xml:"model,attr"`
+	Ports string `xml:"ports,attr"`
+}
+
+// BusDeviceXML represents a device element that only matters for the bus it sits on (input, redirdev)
+type BusDeviceXML struct {
+	Bus string `xml:"bus,attr"`
 }
 
 // VMXML represents the structure of a VM XML dump from libvirt
 type VMXML struct {
 	XMLName xml.Name `xml:"domain"`
 	Devices struct {
-		Hostdevs []USBHostdevXML `xml:"hostdev"`
+		Hostdevs    []HostdevXML    `xml:"hostdev"`
+		Controllers []ControllerXML `xml:"controller"`
+		Inputs      []BusDeviceXML  `xml:"input"`
+		Redirdevs   []BusDeviceXML  `xml:"redirdev"`
 	} `xml:"devices"`
 }
 
 // GenerateUSBXML generates libvirt USB hostdev XML from vendor and product IDs
 func GenerateUSBXML(vendorID, productID string) (string, error) {
+	return GenerateUSBXMLWithOptions(vendorID, productID, nil)
+}
+
+// GenerateUSBXMLWithOptions generates libvirt USB hostdev XML with optional settings (none if nil)
+func GenerateUSBXMLWithOptions(vendorID, productID string, opts *USBHostdevOptions) (string, error) {
 	// Validate hex format
-	if !isValidHexID(vendorID) || !isValidHexID(productID) {
+	if !IsValidHexID(vendorID) || !IsValidHexID(productID) {
 		return "", fmt.Errorf("invalid vendor or product ID format")
 	}
 
@@ -54,6 +158,73 @@ func GenerateUSBXML(vendorID, productID string) (string, error) {
 	}
 	hostdev.Source.Vendor.ID = vendorID
 	hostdev.Source.Product.ID = productID
+	if opts != nil {
+		if opts.Alias != "" && !IsValidUserAlias(opts.Alias) {
+			return "", fmt.Errorf("invalid device alias %q", opts.Alias)
+		}
+		hostdev.Source.Address = opts.Address
+		if opts.Alias != "" {
+			hostdev.Alias = &AliasXML{Name: opts.Alias}
+		}
+		if opts.Managed != nil {
+			hostdev.Managed = managedAttr(*opts.Managed)
+		}
+	}
+
+	output, err := xml.MarshalIndent(&hostdev, "", "    ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal XML: %w", err)
+	}
+
+	return `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + string(output), nil
+}
+
+// PCIHostdevXML represents the libvirt PCI hostdev XML structure
+type PCIHostdevXML struct {
+	XMLName xml.Name `xml:"hostdev"`
+	Mode    string   `xml:"mode,attr"`
+	Type    string   `xml:"type,attr"`
+	Managed string   `xml:"managed,attr"`
+	Source  struct {
+		Address struct {
+			Domain   string `xml:"domain,attr"`
+			Bus      string `xml:"bus,attr"`
+			Slot     string `xml:"slot,attr"`
+			Function string `xml:"function,attr"`
+		} `xml:"address"`
+	} `xml:"source"`
+}
+
+// PCIHostdevOptions are optional settings of a generated PCI hostdev
+// Managed sets the managed attribute, yes when nil: libvirt then unbinds the device from its host
+// driver (binding it to vfio-pci) on attach and gives it back on detach, which passthrough usually needs
+type PCIHostdevOptions struct {
+	Managed *bool
+}
+
+// pciAddressPattern matches a PCI address in domain:bus:slot.function form (e.g. 0000:01:00.0)
+var pciAddressPattern = regexp.MustCompile(`^([0-9a-fA-F]{4}):([0-9a-fA-F]{2}):([0-9a-fA-F]{2})\.([0-7])$`)
+
+// GeneratePCIXML generates libvirt PCI hostdev XML from a host address in domain:bus:slot.function form,
+// with optional settings (defaults if nil)
+func GeneratePCIXML(address string, opts *PCIHostdevOptions) (string, error) {
+	parts := pciAddressPattern.FindStringSubmatch(address)
+	if parts == nil {
+		return "", fmt.Errorf("invalid PCI address %q (expected domain:bus:slot.function like 0000:01:00.0)", address)
+	}
+
+	hostdev := PCIHostdevXML{
+		Mode:    "subsystem",
+		Type:    "pci",
+		Managed: managedAttr(true),
+	}
+	if opts != nil && opts.Managed != nil {
+		hostdev.Managed = managedAttr(*opts.Managed)
+	}
+	hostdev.Source.Address.Domain = "0x" + strings.ToLower(parts[1])
+	hostdev.Source.Address.Bus = "0x" + strings.ToLower(parts[2])
+	hostdev.Source.Address.Slot = "0x" + strings.ToLower(parts[3])
+	hostdev.Source.Address.Function = "0x" + parts[4]
 
 	output, err := xml.MarshalIndent(&hostdev, "", "    ")
 	if err != nil {
@@ -63,6 +234,86 @@ func GenerateUSBXML(vendorID, productID string) (string, error) {
 	return `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + string(output), nil
 }
 
+// MaxHostdevXMLSize bounds the size of a hostdev XML written by a user
+const MaxHostdevXMLSize = 8 << 10
+
+// rawHostdevChildren are the elements a hostdev written by a user may contain
+// (the guest address and boot order are what the generated XML lacks); others, such as <rom file>,
+// could point libvirt at host files
+var rawHostdevChildren = map[string]bool{"source": true, "address": true, "boot": true, "alias": true}
+
+// ParseUSBHostdevXML validates a libvirt hostdev XML written by a user and returns it parsed
+// It must be a single well-formed <hostdev mode="subsystem" type="usb"> element with valid vendor and
+// product IDs, without DTD (entities could make libvirt read host files) or namespaced elements
+func ParseUSBHostdevXML(raw string) (*USBHostdevXML, error) {
+	if len(raw) > MaxHostdevXMLSize {
+		return nil, fmt.Errorf("hostdev XML is larger than %d bytes", MaxHostdevXMLSize)
+	}
+
+	decoder := xml.NewDecoder(strings.NewReader(raw))
+	depth, roots := 0, 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("malformed XML: %w", err)
+		}
+		switch t := token.(type) {
+		case xml.Directive:
+			return nil, errors.New("DOCTYPE and entity declarations are not allowed")
+		case xml.ProcInst:
+			if t.Target != "xml" {
+				return nil, fmt.Errorf("processing instruction <?%s?> is not allowed", t.Target)
+			}
+		case xml.StartElement:
+			if t.Name.Space != "" {
+				return nil, fmt.Errorf("namespaced element <%s:%s> is not allowed", t.Name.Space, t.Name.Local)
+			}
+			switch {
+			case depth == 0 && roots > 0:
+				return nil, errors.New("only one hostdev element is allowed")
+			case depth == 0 && t.Name.Local != "hostdev":
+				return nil, fmt.Errorf("root element must be hostdev, not %s", t.Name.Local)
+			case depth == 1 && !rawHostdevChildren[t.Name.Local]:
+				return nil, fmt.Errorf("element <%s> is not allowed in a USB hostdev", t.Name.Local)
+			}
+			if depth == 0 {
+				roots++
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		case xml.CharData:
+			if depth == 0 && strings.TrimSpace(string(t)) != "" {
+				return nil, errors.New("text outside the hostdev element is not allowed")
+			}
+		}
+	}
+	if roots == 0 {
+		return nil, errors.New("no hostdev element")
+	}
+
+	var hostdev USBHostdevXML
+	if err := xml.Unmarshal([]byte(raw), &hostdev); err != nil {
+		return nil, fmt.Errorf("invalid hostdev: %w", err)
+	}
+	if hostdev.Mode != "subsystem" || hostdev.Type != "usb" {
+		return nil, errors.New(`hostdev must have mode="subsystem" and type="usb"`)
+	}
+	if !IsValidHexID(hostdev.Source.Vendor.ID) || !IsValidHexID(hostdev.Source.Product.ID) {
+		return nil, errors.New("hostdev source must have valid vendor and product IDs")
+	}
+	if hostdev.Alias != nil && !IsValidUserAlias(hostdev.Alias.Name) {
+		return nil, fmt.Errorf("invalid device alias %q", hostdev.Alias.Name)
+	}
+	if hostdev.Managed != "" && hostdev.Managed != "yes" && hostdev.Managed != "no" {
+		return nil, fmt.Errorf(`hostdev managed must be "yes" or "no", not %q`, hostdev.Managed)
+	}
+	return &hostdev, nil
+}
+
 // ParseVMXML extracts attached USB devices from VM XML dump
 func ParseVMXML(vmXML string) ([]USBDevice, error) {
 	var vm VMXML
@@ -92,13 +343,24 @@ func ParseVMXML(vmXML string) ([]USBDevice, error) {
 			productID = strings.ToLower(strings.TrimPrefix(productID, "0x"))
 
 			// Validate the IDs are 4-digit hex values
-			if !isValidHexID(vendorID) || !isValidHexID(productID) {
+			if !IsValidHexID(vendorID) || !IsValidHexID(productID) {
 				continue
 			}
 
 			device := USBDevice{
 				VendorID:  vendorID,
 				ProductID: productID,
+				Managed:   hostdev.Managed == "yes",
+			}
+			if address := hostdev.Source.Address; address != nil {
+				bus, busErr := parseAddressNumber(address.Bus, 16)
+				dev, devErr := parseAddressNumber(address.Device, 16)
+				if busErr == nil && devErr == nil {
+					device.Address = &USBAddressXML{Bus: int(bus), Device: int(dev)}
+				}
+			}
+			if hostdev.Alias != nil {
+				device.Alias = hostdev.Alias.Name
 			}
 			devices = append(devices, device)
 		}
@@ -107,8 +369,96 @@ func ParseVMXML(vmXML string) ([]USBDevice, error) {
 	return devices, nil
 }
 
-// isValidHexID checks if a string is a valid hexadecimal ID (with or without 0x prefix)
-func isValidHexID(id string) bool {
+// parseAddressNumber parses a hostdev address attribute: hexadecimal with a 0x prefix, decimal otherwise
+// (strconv's base 0 would read zero-padded decimals such as bus='010' as octal)
+func parseAddressNumber(s string, bitSize int) (uint64, error) {
+	if hex, ok := strings.CutPrefix(strings.ToLower(s), "0x"); ok {
+		return strconv.ParseUint(hex, 16, bitSize)
+	}
+	return strconv.ParseUint(s, 10, bitSize)
+}
+
+// ParseVMPCIXML extracts attached PCI devices from VM XML dump
+func ParseVMPCIXML(vmXML string) ([]PCIDevice, error) {
+	var vm VMXML
+	var devices []PCIDevice
+
+	if err := xml.Unmarshal([]byte(vmXML), &vm); err != nil {
+		return nil, fmt.Errorf("failed to parse VM XML: %w", err)
+	}
+
+	for _, hostdev := range vm.Devices.Hostdevs {
+		if hostdev.Mode != "subsystem" || hostdev.Type != "pci" || hostdev.Source.Address == nil {
+			continue
+		}
+		address := *hostdev.Source.Address
+		if address.Domain == "" {
+			address.Domain = "0"
+		}
+		domain, err1 := parseAddressNumber(address.Domain, 16)
+		bus, err2 := parseAddressNumber(address.Bus, 8)
+		slot, err3 := parseAddressNumber(address.Slot, 8)
+		function, err4 := parseAddressNumber(address.Function, 8)
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+			continue
+		}
+
+		device := PCIDevice{
+			Address: fmt.Sprintf("%04x:%02x:%02x.%x", domain, bus, slot, function),
+			Managed: hostdev.Managed == "yes",
+		}
+		if hostdev.Alias != nil {
+			device.Alias = hostdev.Alias.Name
+		}
+		devices = append(devices, device)
+	}
+
+	return devices, nil
+}
+
+// CountFreeUSBPorts estimates the free USB ports of a VM from its XML dump
+// Only controllers that declare a ports attribute can be counted; known is false
+// if any USB controller leaves its port count to the hypervisor default
+func CountFreeUSBPorts(vmXML string) (free int, known bool, err error) {
+	var vm VMXML
+	if err := xml.Unmarshal([]byte(vmXML), &vm); err != nil {
+		return 0, false, fmt.Errorf("failed to parse VM XML: %w", err)
+	}
+
+	total := 0
+	for _, controller := range vm.Devices.Controllers {
+		if controller.Type != "usb" || controller.Model == "none" {
+			continue
+		}
+		ports, err := strconv.Atoi(controller.Ports)
+		if err != nil {
+			return 0, false, nil
+		}
+		total += ports
+	}
+
+	used := 0
+	for _, hostdev := range vm.Devices.Hostdevs {
+		if hostdev.Type == "usb" {
+			used++
+		}
+	}
+	for _, input := range vm.Devices.Inputs {
+		if input.Bus == "usb" {
+			used++
+		}
+	}
+	for _, redirdev := range vm.Devices.Redirdevs {
+		if redirdev.Bus == "usb" {
+			used++
+		}
+	}
+
+	return total - used, true, nil
+}
+
+// IsValidHexID checks if a string is a valid hexadecimal ID (with or without 0x prefix)
+func IsValidHexID(id string) bool {
 	id = strings.ToLower(strings.TrimSpace(id))
 	id = strings.TrimPrefix(id, "0x")
 	matched, _ := regexp.MatchString(`^[0-9a-f]{4}$`, id)
@@ -121,4 +471,3 @@ func normalizeHexID(id string) string {
 	id = strings.TrimPrefix(id, "0x")
 	return "0x" + id
 }
-
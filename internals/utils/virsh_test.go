@@ -0,0 +1,184 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseVMXMLWithPCIHostdev(t *testing.T) {
+	// A VFIO GPU next to a USB device: the PCI address is hexadecimal and must not break USB parsing
+	vmXML := `<domain type='kvm'>
+  <name>gaming</name>
+  <devices>
+    <hostdev mode='subsystem' type='pci' managed='yes'>
+      <source>
+        <address domain='0x0000' bus='0x01' slot='0x00' function='0x0'/>
+      </source>
+    </hostdev>
+    <hostdev mode='subsystem' type='usb' managed='no'>
+      <source>
+        <vendor id='0x046d'/>
+        <product id='0xc077'/>
+        <address bus='1' device='4'/>
+      </source>
+    </hostdev>
+  </devices>
+</domain>`
+
+	devices, err := ParseVMXML(vmXML)
+	if err != nil {
+		t.Fatalf("ParseVMXML() error = %v", err)
+	}
+	want := []USBDevice{{VendorID: "046d", ProductID: "c077", Address: &USBAddressXML{Bus: 1, Device: 4}}}
+	if !reflect.DeepEqual(devices, want) {
+		t.Errorf("ParseVMXML() = %+v, want %+v", devices, want)
+	}
+}
+
+func TestParseUSBHostdevXML(t *testing.T) {
+	valid := `<?xml version="1.0"?>
+<hostdev mode="subsystem" type="usb" managed="yes">
+  <source>
+    <vendor id="0x046D"/>
+    <product id="0xc52b"/>
+    <address bus="1" device="4"/>
+  </source>
+  <boot order="2"/>
+  <address type="usb" bus="0" port="3"/>
+  <alias name="ua-receiver"/>
+</hostdev>`
+	hostdev, err := ParseUSBHostdevXML(valid)
+	if err != nil {
+		t.Fatalf("ParseUSBHostdevXML(valid) = %v", err)
+	}
+	if hostdev.Source.Vendor.ID != "0x046D" || hostdev.Source.Product.ID != "0xc52b" ||
+		hostdev.Source.Address == nil || hostdev.Source.Address.Bus != 1 || hostdev.Source.Address.Device != 4 {
+		t.Errorf("hostdev = %+v, want 0x046D:0xc52b at 1:4", hostdev)
+	}
+
+	invalid := map[string]string{
+		"malformed":     `<hostdev mode="subsystem" type="usb"><source>`,
+		"empty":         ``,
+		"other root":    `<disk type="file"><source file="/etc/shadow"/></disk>`,
+		"pci":           `<hostdev mode="subsystem" type="pci"><source><vendor id="0x8086"/><product id="0x1234"/></source></hostdev>`,
+		"missing ids":   `<hostdev mode="subsystem" type="usb"><source><address bus="1" device="4"/></source></hostdev>`,
+		"doctype":       `<!DOCTYPE hostdev [<!ENTITY x SYSTEM "file:///etc/shadow">]><hostdev mode="subsystem" type="usb"><source><vendor id="0x046d"/><product id="&x;"/></source></hostdev>`,
+		"rom":           `<hostdev mode="subsystem" type="usb"><source><vendor id="0x046d"/><product id="0xc52b"/></source><rom file="/etc/shadow"/></hostdev>`,
+		"two roots":     `<hostdev mode="subsystem" type="usb"><source><vendor id="0x046d"/><product id="0xc52b"/></source></hostdev><hostdev/>`,
+		"namespace":     `<hostdev mode="subsystem" type="usb"><source><vendor id="0x046d"/><product id="0xc52b"/></source><qemu:arg xmlns:qemu="http://libvirt.org/schemas/domain/qemu/1.0"/></hostdev>`,
+		"stylesheet":    `<?xml-stylesheet href="x.xsl"?><hostdev mode="subsystem" type="usb"><source><vendor id="0x046d"/><product id="0xc52b"/></source></hostdev>`,
+		"invalid alias": `<hostdev mode="subsystem" type="usb"><source><vendor id="0x046d"/><product id="0xc52b"/></source><alias name="hostdev0"/></hostdev>`,
+		"trailing text": `<hostdev mode="subsystem" type="usb"><source><vendor id="0x046d"/><product id="0xc52b"/></source></hostdev>junk`,
+		"managed":       `<hostdev mode="subsystem" type="usb" managed="maybe"><source><vendor id="0x046d"/><product id="0xc52b"/></source></hostdev>`,
+	}
+	for name, raw := range invalid {
+		if _, err := ParseUSBHostdevXML(raw); err == nil {
+			t.Errorf("%s: ParseUSBHostdevXML() = nil error, want an error", name)
+		}
+	}
+}
+
+func TestGenerateUSBXMLManaged(t *testing.T) {
+	xml, err := GenerateUSBXML("046d", "c52b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(xml, "managed") {
+		t.Errorf("GenerateUSBXML() = %s, want no managed attribute by default", xml)
+	}
+
+	managed := true
+	xml, err = GenerateUSBXMLWithOptions("046d", "c52b", &USBHostdevOptions{Managed: &managed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(xml, `<hostdev mode="subsystem" type="usb" managed="yes">`) {
+		t.Errorf("GenerateUSBXMLWithOptions(managed) = %s, want managed=\"yes\"", xml)
+	}
+
+	devices, err := ParseVMXML(`<domain><devices>` + strings.TrimPrefix(xml, `<?xml version="1.0" encoding="UTF-8"?>`) + `</devices></domain>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || !devices[0].Managed {
+		t.Errorf("ParseVMXML() = %+v, want one managed device", devices)
+	}
+}
+
+func TestGeneratePCIXML(t *testing.T) {
+	xml, err := GeneratePCIXML("0000:0A:00.1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`managed="yes"`, `<address domain="0x0000" bus="0x0a" slot="0x00" function="0x1"></address>`} {
+		if !strings.Contains(xml, want) {
+			t.Errorf("GeneratePCIXML() = %s, want %s", xml, want)
+		}
+	}
+
+	devices, err := ParseVMPCIXML(`<domain><devices>` + strings.TrimPrefix(xml, `<?xml version="1.0" encoding="UTF-8"?>`) + `</devices></domain>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || devices[0].Address != "0000:0a:00.1" || !devices[0].Managed {
+		t.Errorf("ParseVMPCIXML() = %+v, want managed 0000:0a:00.1", devices)
+	}
+
+	unmanaged := false
+	xml, err = GeneratePCIXML("0000:01:00.0", &PCIHostdevOptions{Managed: &unmanaged})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(xml, `managed="no"`) {
+		t.Errorf("GeneratePCIXML(unmanaged) = %s, want managed=\"no\"", xml)
+	}
+
+	for _, address := range []string{"", "01:00.0", "0000:01:00.8", "0000:01:00:0"} {
+		if _, err := GeneratePCIXML(address, nil); err == nil {
+			t.Errorf("GeneratePCIXML(%q) = nil error, want an error", address)
+		}
+	}
+}
+
+func TestParseHostdevAddressBases(t *testing.T) {
+	// Zero-padded numbers are decimal, 0x-prefixed ones hexadecimal
+	vmXML := `<domain><devices>
+    <hostdev mode='subsystem' type='usb'>
+      <source><vendor id='0x046d'/><product id='0xc077'/><address bus='010' device='009'/></source>
+    </hostdev>
+    <hostdev mode='subsystem' type='usb'>
+      <source><vendor id='0x0781'/><product id='0x5583'/><address bus='0x0A' device='0x1f'/></source>
+    </hostdev>
+    <hostdev mode='subsystem' type='usb'>
+      <source><vendor id='0x1050'/><product id='0x0407'/><address bus='08x' device='1'/></source>
+    </hostdev>
+    <hostdev mode='subsystem' type='pci'>
+      <source><address domain='0' bus='010' slot='0x1f' function='1'/></source>
+    </hostdev>
+    <hostdev mode='subsystem' type='pci'>
+      <source><address domain='0x0000' bus='0x100' slot='0' function='0'/></source>
+    </hostdev>
+  </devices></domain>`
+
+	devices, err := ParseVMXML(vmXML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []USBDevice{
+		{VendorID: "046d", ProductID: "c077", Address: &USBAddressXML{Bus: 10, Device: 9}},
+		{VendorID: "0781", ProductID: "5583", Address: &USBAddressXML{Bus: 10, Device: 31}},
+		{VendorID: "1050", ProductID: "0407"},
+	}
+	if !reflect.DeepEqual(devices, want) {
+		t.Errorf("ParseVMXML() = %+v, want %+v", devices, want)
+	}
+
+	pciDevices, err := ParseVMPCIXML(vmXML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pciDevices) != 1 || pciDevices[0].Address != "0000:0a:1f.1" {
+		t.Errorf("ParseVMPCIXML() = %+v, want only 0000:0a:1f.1 (bus 0x100 is out of range)", pciDevices)
+	}
+}
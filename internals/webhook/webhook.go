@@ -0,0 +1,160 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// requestTimeout bounds a single delivery attempt
+	requestTimeout = 5 * time.Second
+	// maxAttempts is the number of delivery attempts (first try plus retries)
+	maxAttempts = 3
+	// retryDelay is the delay before the first retry, doubled for each further retry
+	retryDelay = 1 * time.Second
+	// SignatureHeader carries the HMAC-SHA256 of the body when WEBHOOK_SECRET is set
+	SignatureHeader = "X-Webhook-Signature-256"
+)
+
+// Payload formats selected with WEBHOOK_FORMAT
+const (
+	FormatRaw     = "raw"
+	FormatSlack   = "slack"
+	FormatDiscord = "discord"
+)
+
+// Device identifies the USB device of an event
+type Device struct {
+	VendorID    string `json:"vendorId"`
+	ProductID   string `json:"productId"`
+	Description string `json:"description,omitempty"`
+}
+
+// Event is the payload sent to the webhook on attach/detach
+type Event struct {
+	Action    string    `json:"action"`
+	VM        string    `json:"vm"`
+	Device    Device    `json:"device"`
+	Timestamp time.Time `json:"timestamp"`
+	Success   bool      `json:"success"`
+	Error     string    `json:"error,omitempty"`
+}
+
+var client = &http.Client{Timeout: requestTimeout}
+
+// Enabled reports whether a webhook URL is configured
+func Enabled() bool {
+	return os.Getenv("WEBHOOK_URL") != ""
+}
+
+// Send delivers an event to WEBHOOK_URL in the background
+// Does nothing if WEBHOOK_URL is not set; delivery failures are only logged
+func Send(event Event) {
+	url := os.Getenv("WEBHOOK_URL")
+	if url == "" {
+		return
+	}
+	secret := os.Getenv("WEBHOOK_SECRET")
+
+	body, err := formatPayload(event, os.Getenv("WEBHOOK_FORMAT"))
+	if err != nil {
+		log.Printf("Webhook: Failed to format event: %v", err)
+		return
+	}
+
+	go deliver(url, secret, body)
+}
+
+// formatPayload shapes the event for the given WEBHOOK_FORMAT (raw JSON by default)
+func formatPayload(event Event, format string) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", FormatRaw:
+		return json.Marshal(event)
+	case FormatSlack:
+		return json.Marshal(map[string]string{"text": Message(event)})
+	case FormatDiscord:
+		return json.Marshal(map[string]string{"content": Message(event)})
+	default:
+		return nil, fmt.Errorf("unknown WEBHOOK_FORMAT %q (expected raw, slack or discord)", format)
+	}
+}
+
+// Message returns a human readable chat message for the event
+func Message(event Event) string {
+	device := event.Device.Description
+	if device == "" {
+		device = event.Device.VendorID + ":" + event.Device.ProductID
+	}
+
+	switch {
+	case !event.Success:
+		message := fmt.Sprintf("⚠️ Failed to %s %s (VM %s)", event.Action, device, event.VM)
+		if event.Error != "" {
+			message += ": " + event.Error
+		}
+		return message
+	case event.Action == "attach":
+		return fmt.Sprintf("🔌 Attached %s to %s", device, event.VM)
+	case event.Action == "detach":
+		return fmt.Sprintf("⏏️ Detached %s from %s", device, event.VM)
+	default:
+		return fmt.Sprintf("%s %s on %s", event.Action, device, event.VM)
+	}
+}
+
+// deliver posts the body to url, retrying with backoff on failure
+func deliver(url, secret string, body []byte) {
+	delay := retryDelay
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		err := post(url, secret, body)
+		if err == nil {
+			return
+		}
+
+		log.Printf("Webhook: Delivery attempt %d/%d failed: %v", attempt, maxAttempts, err)
+		if attempt < maxAttempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	log.Printf("Webhook: Giving up delivery to %s", url)
+}
+
+// post sends a single signed request
+func post(url, secret string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(secret, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the hex-encoded HMAC-SHA256 of body using secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,93 @@
+package webhook
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPostSignsBody(t *testing.T) {
+	const secret = "s3cret"
+	body := []byte(`{"action":"attach"}`)
+
+	var gotSignature string
+	var gotBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSignature = r.Header.Get(SignatureHeader)
+		gotBody, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	if err := post(server.URL, secret, body); err != nil {
+		t.Fatalf("post() error = %v", err)
+	}
+
+	if string(gotBody) != string(body) {
+		t.Errorf("body = %s, want %s", gotBody, body)
+	}
+	if want := "sha256=" + Sign(secret, body); gotSignature != want {
+		t.Errorf("signature = %q, want %q", gotSignature, want)
+	}
+}
+
+func TestPostRejectsErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	if err := post(server.URL, "", []byte(`{}`)); err == nil {
+		t.Error("post() expected error for 500 response")
+	}
+}
+
+func TestFormatPayload(t *testing.T) {
+	event := Event{
+		Action:  "attach",
+		VM:      "win11-vm",
+		Device:  Device{VendorID: "046d", ProductID: "0825", Description: "Logitech Webcam"},
+		Success: true,
+	}
+
+	tests := []struct {
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{FormatSlack, `{"text":"🔌 Attached Logitech Webcam to win11-vm"}`, false},
+		{FormatDiscord, `{"content":"🔌 Attached Logitech Webcam to win11-vm"}`, false},
+		{"teams", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			got, err := formatPayload(event, tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("formatPayload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("formatPayload() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessage(t *testing.T) {
+	tests := []struct {
+		name  string
+		event Event
+		want  string
+	}{
+		{"detach", Event{Action: "detach", VM: "vm1", Device: Device{VendorID: "046d", ProductID: "c52b"}, Success: true}, "⏏️ Detached 046d:c52b from vm1"},
+		{"failure", Event{Action: "attach", VM: "vm1", Device: Device{Description: "Mouse"}, Error: "busy"}, "⚠️ Failed to attach Mouse (VM vm1): busy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Message(tt.event); got != tt.want {
+				t.Errorf("Message() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,18 +1,22 @@
 package main
 
 import (
+	"context"
 	"embed"
+	"flag"
 	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/Masterminds/sprig/v3"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/limiter"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/template/html/v2"
 	"github.com/joho/godotenv"
@@ -20,6 +24,22 @@ import (
 	"vfio_usb_passthrough/internals/db"
 	"vfio_usb_passthrough/internals/handlers"
 	"vfio_usb_passthrough/internals/middleware"
+	"vfio_usb_passthrough/internals/mqtt"
+	"vfio_usb_passthrough/internals/rpc"
+	"vfio_usb_passthrough/internals/tracing"
+	"vfio_usb_passthrough/internals/utils"
+)
+
+// shutdownTimeout bounds how long in-flight requests may take on shutdown
+const shutdownTimeout = 10 * time.Second
+
+// maxBodySize caps request bodies; API payloads are small JSON documents
+const maxBodySize = 64 * 1024
+
+// API rate limit: requests allowed per window per client IP
+const (
+	apiRateLimitMax    = 20
+	apiRateLimitWindow = 1 * time.Minute
 )
 
 //go:embed assets/dist/*
@@ -28,11 +48,24 @@ var assetsFS embed.FS
 //go:embed views
 var viewsFS embed.FS
 
+//go:embed assets/public
+var publicFS embed.FS
+
+// publicFiles are served at the site root with their content types
+var publicFiles = map[string]string{
+	"favicon.ico":          "image/x-icon",
+	"favicon.svg":          "image/svg+xml",
+	"manifest.webmanifest": "application/manifest+json",
+}
+
 func init() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 	log.SetPrefix("vfio_usb_passthrough: ")
 	log.SetOutput(os.Stdout)
+}
 
+// loadEnv loads the .env file when ENV is set to dev
+func loadEnv() {
 	// if ENV is set to dev use godotenv
 	env := os.Getenv("ENV")
 	env = strings.ToLower(env)
@@ -47,11 +80,35 @@ func init() {
 }
 
 func main() {
+	showCapabilities := flag.Bool("print-capabilities", false, "print a JSON description of this build and exit")
+	flag.Parse()
+	if *showCapabilities {
+		if err := printCapabilities(os.Stdout); err != nil {
+			log.Fatalf("Failed to print capabilities: %v", err)
+		}
+		return
+	}
+
+	loadEnv()
+	handlers.SetVersion(version)
+	handlers.SetRevision(buildRevision())
+
+	// Export traces over OTLP (optional)
+	shutdownTracing, err := tracing.Init(version)
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+
 	// Initialize database
 	if err := db.InitDB(); err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
+	// Connect to the MQTT broker (optional)
+	if err := mqtt.Connect(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+
 	// Determine environment
 	env := os.Getenv("ENV")
 	env = strings.ToLower(env)
@@ -65,6 +122,7 @@ func main() {
 		// Development mode: use filesystem for hot reload
 		engine = html.New("./views", ".html")
 		engine.Debug(true)
+		assetsFSSub = os.DirFS("./assets/dist")
 		log.Println("Running in development mode: using filesystem")
 	} else {
 		// Production mode: use embedded filesystem
@@ -84,105 +142,321 @@ func main() {
 
 	engine.AddFuncMap(sprig.FuncMap())
 
-	// Create app
+	// Create app (handlers.Render drops the layout for HTMX partial requests)
 	app := fiber.New(fiber.Config{
 		Views:       engine,
-		ViewsLayout: "layouts/base",
+		ViewsLayout: handlers.DefaultLayout,
+		BodyLimit:   maxBodySize,
+		// Request values (VM names, IDs) are kept after the handler returns, e.g. by
+		// attachment tracking and async webhooks, so they must not alias fasthttp buffers
+		Immutable: true,
+		// Unexpected errors and recovered panics are answered with a JSON 500 and the request ID
+		ErrorHandler: middleware.ErrorHandler,
 	})
 
-	// add a middleware to log the request
-	app.Use(logger.New())
+	// Trace each request (a no-op unless an OTLP endpoint is configured)
+	app.Use(tracing.Middleware())
 
-	// Initialize and apply IP filter middleware
-	ipFilter, err := middleware.NewIPFilterMiddleware()
+	// Log each request with its request ID, as text or as JSON lines (LOG_FORMAT)
+	accessLogConfig, err := middleware.AccessLogConfig()
 	if err != nil {
-		log.Fatalf("Failed to initialize IP filter middleware: %v", err)
+		log.Fatalf("Failed to configure access logging: %v", err)
 	}
-	app.Use(ipFilter)
+	app.Use(middleware.RequestID())
+	app.Use(logger.New(accessLogConfig))
 
-	// Static files
-	if isDev {
-		// Development mode: serve from filesystem
-		app.Static("/assets", "./assets/dist")
-	} else {
-		// Production mode: serve from embedded filesystem
-		app.Get("/assets/*", func(c *fiber.Ctx) error {
-			// Get the file path after /assets/
-			path := strings.TrimPrefix(c.Path(), "/assets/")
-			// Remove leading slash if present
-			path = strings.TrimPrefix(path, "/")
-
-			// Open file from embedded filesystem (already scoped to assets/dist)
-			file, err := assetsFSSub.Open(path)
-			if err != nil {
-				return c.Status(fiber.StatusNotFound).SendString("File not found")
-			}
-			defer file.Close()
+	// A panic in a handler is logged with its stack and answered with a 500 instead of crashing the server
+	app.Use(middleware.Recover())
 
-			stat, err := file.Stat()
-			if err != nil {
-				return c.Status(fiber.StatusInternalServerError).SendString("Failed to stat file")
-			}
+	// libvirt connections selectable with ?host= (local qemu:///system by default)
+	if err := handlers.LoadHosts(); err != nil {
+		log.Fatalf("Failed to load libvirt hosts: %v", err)
+	}
 
-			// Set content type based on file extension
-			contentType := "application/octet-stream"
-			if strings.HasSuffix(path, ".js") {
-				contentType = "application/javascript"
-			} else if strings.HasSuffix(path, ".css") {
-				contentType = "text/css"
-			} else if strings.HasSuffix(path, ".map") {
-				contentType = "application/json"
+	// Optionally wait for libvirt, which may start after this service at boot, before
+	// networks are detected from it
+	libvirtWait, err := utils.GetIntervalEnv(handlers.WaitForLibvirtEnv)
+	if err != nil {
+		log.Fatalf("Failed to parse libvirt wait: %v", err)
+	}
+	failOnLibvirtTimeout, err := handlers.WaitForLibvirtFailOnTimeout()
+	if err != nil {
+		log.Fatalf("Failed to parse libvirt wait: %v", err)
+	}
+	if libvirtWait > 0 {
+		if err := handlers.WaitForLibvirt(libvirtWait); err != nil {
+			if failOnLibvirtTimeout {
+				log.Fatalf("Failed to reach libvirt: %v", err)
 			}
+			log.Printf("Warning: %v, starting anyway", err)
+		}
+	}
+	// Report early when the service user may not use libvirt, which otherwise only shows as empty VM lists
+	handlers.CheckLibvirtAccess()
+
+	// Initialize and apply IP filter middleware (allowed networks are reloaded on SIGHUP)
+	ipFilter, err := middleware.NewIPFilter()
+	if err != nil {
+		log.Fatalf("Failed to initialize IP filter middleware: %v", err)
+	}
+	ipFilter.ReloadOnSIGHUP()
+
+	// Optionally keep auto-detected networks in sync with libvirt
+	refreshInterval, err := middleware.GetNetworkRefreshInterval()
+	if err != nil {
+		log.Fatalf("Failed to parse network refresh interval: %v", err)
+	}
+	ipFilter.StartAutoRefresh(refreshInterval)
+
+	// Load the USB devices hidden from device lists
+	if err := handlers.LoadUSBHideFilter(); err != nil {
+		log.Fatalf("Failed to load USB device filter: %v", err)
+	}
+	if err := handlers.LoadDeviceNames(); err != nil {
+		log.Fatalf("Failed to load device names: %v", err)
+	}
+
+	// Optionally re-attach declared devices that dropped off running VMs
+	reconcileInterval, err := utils.GetIntervalEnv(handlers.ReconcileIntervalEnv)
+	if err != nil {
+		log.Fatalf("Failed to parse reconcile interval: %v", err)
+	}
+	handlers.StartReconciler(reconcileInterval)
+
+	// Forget recorded attachments of devices detached outside this tool
+	handlers.StartAttachmentPruner()
+
+	// Optionally fill in missing favorite descriptions from usb.ids and connected devices
+	enrichInterval, err := utils.GetIntervalEnv(handlers.FavoritesEnrichIntervalEnv)
+	if err != nil {
+		log.Fatalf("Failed to parse favorites enrichment interval: %v", err)
+	}
+	handlers.StartFavoritesEnricher(enrichInterval)
+
+	// Optionally prune old audit log entries (keeps everything by default)
+	auditRetentionDays, err := utils.GetNonNegativeIntEnv(db.AuditRetentionDaysEnv)
+	if err != nil {
+		log.Fatalf("Failed to parse audit retention: %v", err)
+	}
+	db.StartAuditPruner(auditRetentionDays)
+
+	// Recent attach and detach events kept in memory for /api/events/recent
+	eventLogSize, err := utils.GetNonNegativeIntEnv(handlers.EventLogSizeEnv)
+	if err != nil {
+		log.Fatalf("Failed to parse event log size: %v", err)
+	}
+	handlers.SetEventLogSize(eventLogSize)
+
+	// Optional cap on the USB devices attached to each VM (unlimited by default)
+	maxDevicesPerVM, err := utils.GetNonNegativeIntEnv(handlers.MaxDevicesPerVMEnv)
+	if err != nil {
+		log.Fatalf("Failed to parse device limit: %v", err)
+	}
+	handlers.SetMaxDevicesPerVM(maxDevicesPerVM)
+
+	// Polling interval suggested to clients in the devices state (5s by default)
+	devicesPollInterval, err := utils.GetIntervalEnv(handlers.DevicesPollIntervalEnv)
+	if err != nil {
+		log.Fatalf("Failed to parse devices poll interval: %v", err)
+	}
+	handlers.SetDevicesPollInterval(devicesPollInterval)
+
+	// SameSite and Secure attributes of the theme cookie (Lax, and Secure over TLS, by default)
+	if err := handlers.ConfigureThemeCookie(); err != nil {
+		log.Fatalf("Failed to configure theme cookie: %v", err)
+	}
 
+	// Favicon and manifest are registered ahead of the IP filter: browsers request them on their own
+	// and they are public, so they should neither be blocked nor clutter the security logs
+	for name, contentType := range publicFiles {
+		data, err := publicFS.ReadFile("assets/public/" + name)
+		if err != nil {
+			log.Fatalf("Failed to read embedded %s: %v", name, err)
+		}
+		app.Get("/"+name, func(c *fiber.Ctx) error {
 			c.Set(fiber.HeaderContentType, contentType)
-			return c.SendStream(file, int(stat.Size()))
+			c.Set(fiber.HeaderCacheControl, "public, max-age=86400")
+			return c.Send(data)
 		})
 	}
 
+	app.Use(ipFilter.Handler())
+
+	// Static files, served the same way from the filesystem (development) or the embedded build (production)
+	assetCache, err := handlers.AssetCacheEnabled(!isDev)
+	if err != nil {
+		log.Fatalf("Failed to parse asset cache setting: %v", err)
+	}
+	app.Get("/assets/*", handlers.Assets(assetsFSSub, assetCache))
+
+	// Prometheus metrics (exempt from the IP filter by default, not rate limited)
+	app.Get("/metrics", handlers.GetMetrics)
+
 	// Theme toggle route
 	app.Post("/theme/toggle", handlers.ToggleTheme)
 
 	// API routes for USB passthrough with rate limiting
 	api := app.Group("/api")
 
+	// Allow cross-origin API calls from CORS_ORIGINS (preflights are answered before rate limiting)
+	if corsHandler := middleware.NewCORS(); corsHandler != nil {
+		api.Use(corsHandler)
+	}
+
+	// Connectivity check for the UI, answered before rate limiting and authentication
+	api.Get("/ping", handlers.Ping)
+
+	// Rate limit counters are kept in memory, or in Redis when REDIS_URL is set
+	rateLimitStorage, err := middleware.NewRateLimitStorage()
+	if err != nil {
+		log.Fatalf("Failed to initialize rate limit storage: %v", err)
+	}
+	rateLimitStorageName := "memory"
+	if rateLimitStorage != nil {
+		rateLimitStorageName = "redis"
+	}
+
 	// Apply rate limiting: 20 requests per minute per IP
-	api.Use(limiter.New(limiter.Config{
-		Max:        20,
-		Expiration: 1 * time.Minute,
-		KeyGenerator: func(c *fiber.Ctx) string {
-			return c.IP()
-		},
-		LimitReached: func(c *fiber.Ctx) error {
-			log.Printf("Rate limit exceeded for IP: %s", c.IP())
-			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
-				"error": "Rate limit exceeded. Please try again later.",
-			})
-		},
-	}))
+	api.Use(middleware.NewRateLimiter(apiRateLimitMax, apiRateLimitWindow, rateLimitStorage))
+
+	// Optional HTTP Basic auth (BASIC_AUTH_USER/BASIC_AUTH_PASS), after rate limiting to slow down guessing
+	basicAuth, err := middleware.NewBasicAuth()
+	if err != nil {
+		log.Fatalf("Invalid basic auth configuration: %v", err)
+	}
+	if basicAuth != nil {
+		api.Use(basicAuth)
+	}
+
+	// Select the libvirt connection (?host=) for all API routes
+	api.Use(handlers.ResolveHost)
 
+	api.Get("/capabilities", handlers.GetCapabilities)
+	api.Get("/system/info", handlers.GetSystemInfo)
+	api.Get("/hosts", handlers.GetHosts)
 	api.Get("/vms", handlers.ListRunningVMs)
 	// The following lines were causing compile errors due to missing handler functions.
 	// Ensure that the handlers are properly defined and imported in "internals/handlers".
 	api.Get("/usb-devices", handlers.ListUSBDevices)
+	api.Post("/usb-devices/refresh", handlers.RefreshUSBDevices)
+	api.Get("/usb-devices/:vendorId/:productId/history", handlers.GetDeviceHistory)
+	api.Get("/usb-devices/:vendorId/:productId/auto-target", handlers.GetAutoTarget)
+	api.Get("/usb-topology", handlers.GetUSBTopology)
 	api.Get("/vms/:vmName/devices", handlers.GetAttachedDevices)
-	api.Post("/vms/:vmName/attach", handlers.AttachDevice)
-	api.Post("/vms/:vmName/detach", handlers.DetachDevice)
+	api.Get("/vms/:vmName/config-diff", handlers.GetConfigDiff)
+	api.Get("/vms/:vmName/can-attach", handlers.CanAttachDevice)
+	api.Post("/vms/:vmName/attach", middleware.RequireJSON, handlers.AttachDevice)
+	api.Post("/vms/:vmName/attach-hub", middleware.RequireJSON, handlers.AttachHub)
+	api.Post("/vms/:vmName/attach-raw", middleware.RequireJSON, handlers.AttachRawDevice)
+	api.Post("/vms/:vmName/detach", middleware.RequireJSON, handlers.DetachDevice)
+	api.Delete("/vms/:vmName/devices", middleware.RequireJSON, handlers.DetachDevice)
+	api.Post("/vms/:vmName/apply", handlers.ApplyDevices)
+	api.Post("/handoff", middleware.RequireJSON, handlers.HandoffDevice)
+	api.Get("/vms/:vmName/desired", handlers.GetDesiredState)
+	api.Put("/vms/:vmName/desired", handlers.SetDesiredState)
+	api.Delete("/vms/:vmName/desired", handlers.ClearDesiredState)
 	api.Get("/devices-state", handlers.GetDevicesState)
+	api.Get("/inventory", handlers.GetInventory)
+	api.Get("/audit/export", handlers.ExportAudit)
+	api.Get("/events/recent", handlers.GetRecentEvents)
 
 	// Favorites routes
 	api.Get("/favorites", handlers.GetFavorites)
-	api.Post("/favorites", handlers.AddFavorite)
-	api.Delete("/favorites", handlers.RemoveFavorite)
+	api.Post("/favorites", middleware.RequireJSON, handlers.AddFavorite)
+	api.Post("/favorites/enrich", handlers.EnrichFavorites)
+	api.Put("/favorites", middleware.RequireJSON, handlers.EnsureFavorite)
+	api.Patch("/favorites", middleware.RequireJSON, handlers.UpdateFavorite)
+	api.Delete("/favorites", middleware.RequireJSON, handlers.RemoveFavorite)
 
-	// Auth routes (no middleware)
-
-	app.Get("/", handlers.GetIndex)
+	// Device aliases (friendly names usable as "deviceAlias" in attach/detach requests)
+	api.Get("/aliases", handlers.GetDeviceAliases)
+	api.Get("/aliases/:name", handlers.GetDeviceAlias)
+	api.Post("/aliases", middleware.RequireJSON, handlers.SetDeviceAlias)
+	api.Delete("/aliases/:name", handlers.RemoveDeviceAlias)
+	// Named groups of VMs (e.g. gaming, work), to query their attachments in one call
+	api.Get("/vm-groups", handlers.GetVMGroups)
+	api.Get("/vm-groups/:group", handlers.GetVMGroup)
+	api.Put("/vm-groups/:group", middleware.RequireJSON, handlers.SetVMGroup)
+	api.Delete("/vm-groups/:group", handlers.RemoveVMGroup)
+	api.Get("/vm-groups/:group/attachments", handlers.GetVMGroupAttachments)
 
-	// Start server with configurable bind address based on network interface
+	// Configurable bind address based on network interface
 	bindAddr, err := middleware.GetBindAddr()
 	if err != nil {
 		log.Fatalf("Failed to determine bind address: %v", err)
 	}
+
+	// Admin routes, behind the IP filter and restricted to loopback clients unless basic auth is enabled
+	admin := api.Group("/admin", middleware.NewAdminGuard(basicAuth != nil))
+	admin.Post("/reload-networks", handlers.ReloadNetworks(ipFilter))
+	admin.Get("/check-ip", handlers.CheckIP(ipFilter))
+	admin.Get("/inflight", handlers.GetInflightOperations)
+	admin.Get("/config", handlers.GetEffectiveConfig(ipFilter, handlers.ServerConfig{
+		BindAddr:         bindAddr,
+		RateLimitMax:     apiRateLimitMax,
+		RateLimitWindow:  apiRateLimitWindow,
+		RateLimitStorage: rateLimitStorageName,
+	}))
+
+	// Auth routes (no middleware)
+
+	app.Get("/", handlers.InjectPageData, handlers.GetIndex)
+
+	// Optional gRPC server on GRPC_PORT (same interface as the API)
+	grpcAddr, err := rpc.Addr(bindAddr)
+	if err != nil {
+		log.Fatalf("Failed to determine gRPC address: %v", err)
+	}
+	stopGRPC := func() {}
+	if grpcAddr != "" {
+		grpcServer, err := rpc.NewServer(ipFilter)
+		if err != nil {
+			log.Fatalf("Failed to initialize gRPC server: %v", err)
+		}
+		listener, err := net.Listen("tcp", grpcAddr)
+		if err != nil {
+			log.Fatalf("Failed to listen for gRPC on %s: %v", grpcAddr, err)
+		}
+		log.Printf("Starting gRPC server on %s", grpcAddr)
+		go func() {
+			if err := grpcServer.Serve(listener); err != nil {
+				log.Fatal(err)
+			}
+		}()
+		stopGRPC = grpcServer.GracefulStop
+	}
+
+	// Start server
 	log.Printf("Starting server on %s", bindAddr)
-	log.Fatal(app.Listen(bindAddr))
+	go func() {
+		if err := app.Listen(bindAddr); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	// Wait for a termination signal, then shut down gracefully
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	sig := <-quit
+	log.Printf("Received %s, shutting down", sig)
+
+	if err := app.ShutdownWithTimeout(shutdownTimeout); err != nil {
+		log.Printf("Error during server shutdown: %v", err)
+	}
+	stopGRPC()
+
+	// Optionally detach the devices attached during this run
+	if strings.EqualFold(os.Getenv("DETACH_ON_SHUTDOWN"), "true") {
+		handlers.DetachTrackedDevices()
+	}
+
+	mqtt.Disconnect()
+
+	// Flush the spans still buffered
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("Error flushing traces: %v", err)
+	}
+	log.Println("Server stopped")
 }